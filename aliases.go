@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// aliasesHelp documents the format of the files given by -aliases
+const aliasesHelp = `
+With -aliases custom mnemonics are compiled like the keywords they map
+to. The file is either a JSON object, if its name ends in .json, or a
+TOML file with one alias per line:
+
+	bne = "jmp_nz"
+	mov = "store"
+`
+
+// readAliases reads a file mapping custom mnemonics to keywords, which
+// is empty if no path is given
+func readAliases(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	if filepath.Ext(path) == ".json" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		aliases := map[string]string{}
+		if err = json.Unmarshal(data, &aliases); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return aliases, nil
+	}
+
+	tables, err := readTOML(path)
+	if err != nil {
+		return nil, err
+	}
+	for table := range tables {
+		if table != "" {
+			return nil, fmt.Errorf("%s: unexpected table [%s]", path, table)
+		}
+	}
+	return tables[""], nil
+}
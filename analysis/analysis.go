@@ -0,0 +1,848 @@
+// Package analysis implements an abstract interpreter for compiled
+// programs of the register instruction set.
+//
+// Rather than running a program on concrete values it tracks what is
+// known about every register at every reachable instruction: a
+// constant, an integer interval, a string, or nothing at all. This is
+// enough to prove properties which hold for every execution, e.g. that
+// a POKE can never modify the code, or that a conditional jump is
+// always taken.
+//
+// Instructions whose inputs are all constant are evaluated by a scratch
+// CPU, using the semantics shared with the interpreter (see
+// cpu.Semantics), so the analysis can't drift from it for them.
+package analysis
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"vm/cpu"
+	"vm/disasm"
+	"vm/header"
+	"vm/opcode"
+)
+
+// maxVisits is the number of times the state at an address may grow
+// before its intervals are widened
+const maxVisits = 8
+
+// Finding is a fact proven about an instruction
+type Finding struct {
+	Instruction disasm.Instruction
+	Message     string
+
+	// Fails is set if the instruction always fails
+	Fails bool
+}
+
+// Span is a range of addresses, End is exclusive
+type Span struct {
+	Start, End int
+}
+
+// Report is the result of analyzing a program
+type Report struct {
+	// CodeSize is the size of the program in bytes
+	CodeSize int
+
+	// Reachable contains the instructions which may be executed,
+	// ordered by address
+	Reachable []disasm.Instruction
+
+	// Unreachable contains the bytes of the program which are never
+	// executed, which is either dead code or data
+	Unreachable []Span
+
+	// Findings are ordered by address
+	Findings []Finding
+
+	// Incomplete is set if the program jumps to addresses outside of
+	// its code. What runs there isn't known and may reach any part of
+	// the program, so Unreachable is left empty.
+	Incomplete bool
+
+	// Entry is the address at which the execution starts
+	Entry int
+
+	// Edges are the possible transfers of control between the
+	// reachable instructions, ordered by address
+	Edges []Edge
+}
+
+// EdgeKind describes how control is transferred along an Edge
+type EdgeKind int
+
+const (
+	// EdgeNext continues with the following instruction, including the
+	// return site after a call
+	EdgeNext EdgeKind = iota
+
+	// EdgeJump is an unconditional jump
+	EdgeJump
+
+	// EdgeTaken is a conditional jump which is taken
+	EdgeTaken
+
+	// EdgeNotTaken continues after a conditional jump which isn't taken
+	EdgeNotTaken
+
+	// EdgeCall calls a subroutine, or registers an exit hook
+	EdgeCall
+)
+
+// Edge is a possible transfer of control from the instruction at From
+// to the address To, which may be outside of the code
+type Edge struct {
+	From, To int
+	Kind     EdgeKind
+}
+
+type analyzer struct {
+	code []byte
+	top  int // largest integer of the word size
+
+	wordSize int
+	instrs   map[int]disasm.Instruction
+	states   map[int]state
+	visits   map[int]int
+	failures map[int]error // decode errors and instructions which always fail
+	outside  map[int]int   // jumps to addresses outside of the code, by address
+	edges    map[Edge]bool
+	work     []int
+	entry    int
+
+	// RET is assumed to return to any call site
+	returnSites map[int]bool
+	retState    state
+	returned    bool
+
+	// scratch evaluates instructions with constant inputs
+	scratch *cpu.CPU
+
+	// symbols are the labels of the program, the possible targets of
+	// calls to unknown addresses
+	symbols map[string]int
+}
+
+// Analyze analyzes the given program, described by its header
+func Analyze(h *header.Header, code []byte) (*Report, error) {
+	if h.Features&header.FeatStackISA != 0 {
+		return nil, fmt.Errorf("only programs of the register instruction set can be analyzed")
+	}
+	if h.Features&header.FeatSignedInts != 0 {
+		return nil, fmt.Errorf("only programs using unsigned integers can be analyzed")
+	}
+	if h.Registers > header.DefaultNumRegisters {
+		return nil, fmt.Errorf("only programs using at most %d registers can be analyzed", header.DefaultNumRegisters)
+	}
+
+	scratch := cpu.NewCPU()
+	if err := scratch.SetWordSize(h.WordSize); err != nil {
+		return nil, err
+	}
+	scratch.SetStringPool(h.Strings)
+
+	wordSize := scratch.WordSize()
+
+	a := &analyzer{
+		code:        code,
+		wordSize:    wordSize,
+		top:         1<<wordSize - 1,
+		instrs:      make(map[int]disasm.Instruction),
+		states:      make(map[int]state),
+		visits:      make(map[int]int),
+		failures:    make(map[int]error),
+		outside:     make(map[int]int),
+		edges:       make(map[Edge]bool),
+		entry:       h.Entry,
+		returnSites: make(map[int]bool),
+		scratch:     scratch,
+		symbols:     h.Symbols,
+	}
+
+	// registers start out as integer zero
+	var initial state
+	for i := range initial.regs {
+		initial.regs[i] = Const(0)
+	}
+	initial.z = FlagClear
+	a.flow(h.Entry, initial)
+
+	for len(a.work) > 0 {
+		addr := a.work[len(a.work)-1]
+		a.work = a.work[:len(a.work)-1]
+		a.visit(addr)
+	}
+
+	return a.report(), nil
+}
+
+// jump flows to the target of the jump or call at addr. Targets outside
+// of the code aren't followed, as the memory there may have been
+// written by the program, but recorded as unknown. The end of the code
+// is treated like falling off the end, e.g. for a label after the
+// last instruction.
+func (a *analyzer) jump(addr, target int, kind EdgeKind, s state) {
+	a.edges[Edge{From: addr, To: target, Kind: kind}] = true
+	if target < 0 || target > len(a.code) {
+		a.outside[addr] = target
+		return
+	}
+	a.flow(target, s)
+}
+
+// flow merges the given state into the state at addr, and queues addr
+// if its state changed
+func (a *analyzer) flow(addr int, s state) {
+	if addr < 0 || addr >= len(a.code) {
+		// outside of the program memory is zero, i.e. EXIT
+		return
+	}
+
+	prev, seen := a.states[addr]
+	next := s
+	if seen {
+		next = prev.join(s)
+		if next == prev {
+			return
+		}
+		a.visits[addr]++
+		if a.visits[addr] > maxVisits {
+			next = prev.widen(next, a.top)
+		}
+	}
+
+	a.states[addr] = next
+	a.work = append(a.work, addr)
+}
+
+// visit propagates the state at addr to the successors of its instruction
+func (a *analyzer) visit(addr int) {
+	delete(a.failures, addr)
+
+	ins, err := disasm.Decode(a.code, addr, a.wordSize)
+	if err != nil {
+		a.failures[addr] = err
+		return
+	}
+	a.instrs[addr] = ins
+
+	in := a.states[addr]
+	next := addr + ins.Size
+
+	switch ins.Opcode {
+	case opcode.EXIT, opcode.EXIT_IMM, opcode.EXIT_REG, opcode.ABORT:
+		return
+
+	case opcode.JMP:
+		a.jump(addr, ins.Imm, EdgeJump, in)
+		return
+
+	case opcode.JMP_Z, opcode.JMP_NZ:
+		taken := FlagSet
+		if ins.Opcode == opcode.JMP_NZ {
+			taken = FlagClear
+		}
+		if in.z == FlagUnknown || in.z == taken {
+			s := in
+			s.z = taken
+			a.jump(addr, ins.Imm, EdgeTaken, s)
+		}
+		if in.z != taken {
+			s := in
+			s.z = FlagClear
+			if taken == FlagClear {
+				s.z = FlagSet
+			}
+			a.jump(addr, next, EdgeNotTaken, s)
+		}
+		return
+
+	case opcode.JMP_S, opcode.JMP_NS:
+		// the sign flag isn't tracked, so both ways are possible
+		a.jump(addr, ins.Imm, EdgeTaken, in)
+		a.jump(addr, next, EdgeNotTaken, in)
+		return
+
+	case opcode.CALL:
+		a.jump(addr, ins.Imm, EdgeCall, in)
+		a.edges[Edge{From: addr, To: next, Kind: EdgeNext}] = true
+		a.returnSites[next] = true
+		if a.returned {
+			a.flow(next, a.retState)
+		}
+		return
+
+	case opcode.CALL_REG:
+		var target Value
+		switch reg := ins.Regs[0]; {
+		case reg == header.RegSP || reg == header.RegIP:
+			// the pseudo-registers aren't tracked
+		case reg >= header.DefaultNumRegisters:
+			a.failures[addr] = fmt.Errorf("register [%d] is out of range", reg)
+			return
+		default:
+			target = in.regs[reg]
+		}
+		if target.Kind == Int && target.IsConst() {
+			a.jump(addr, target.Lo, EdgeCall, in)
+		} else {
+			// any label may be the target of a function pointer
+			for _, label := range a.symbols {
+				a.jump(addr, label, EdgeCall, in)
+			}
+		}
+		a.edges[Edge{From: addr, To: next, Kind: EdgeNext}] = true
+		a.returnSites[next] = true
+		if a.returned {
+			a.flow(next, a.retState)
+		}
+		return
+
+	case opcode.RET:
+		if a.returned {
+			a.retState = a.retState.join(in)
+		} else {
+			a.retState = in
+			a.returned = true
+		}
+		for site := range a.returnSites {
+			a.flow(site, a.retState)
+		}
+		return
+
+	case opcode.TRAP:
+		if ins.Imm == cpu.TrapAtExit && in.regs[0].Kind == Int && in.regs[0].Lo == in.regs[0].Hi {
+			// the hook runs at exit, when nothing is known
+			a.jump(addr, in.regs[0].Lo, EdgeCall, state{})
+		}
+		if ins.Imm == cpu.TrapOnInterrupt && in.regs[1].Kind == Int && in.regs[1].IsConst() {
+			// the handler may interrupt any instruction
+			a.jump(addr, in.regs[1].Lo, EdgeCall, state{})
+		}
+	}
+
+	out, err := a.transfer(ins, in)
+	if err != nil {
+		a.failures[addr] = err
+		return
+	}
+	a.edges[Edge{From: addr, To: next, Kind: EdgeNext}] = true
+	a.flow(next, out)
+}
+
+// foldable contains the instructions which are evaluated with the
+// semantics of the CPU if their inputs are constant
+var foldable = map[int]bool{}
+
+func init() {
+	for _, op := range []int{
+		opcode.ADD, opcode.SUB, opcode.MUL, opcode.DIV, opcode.MOD,
+		opcode.AND, opcode.OR, opcode.XOR, opcode.INC, opcode.DEC,
+		opcode.INT_TO_STR, opcode.STR_TO_INT, opcode.CONCAT, opcode.STR_POOL,
+		opcode.ORD, opcode.CHR, opcode.MULH, opcode.DIVMOD, opcode.SPLIT_COUNT,
+		opcode.SHL, opcode.SHR, opcode.SHL_IMM, opcode.SHR_IMM,
+		opcode.ADD_IMM, opcode.SUB_IMM, opcode.MUL_IMM, opcode.DIV_IMM,
+		opcode.MOD_IMM, opcode.AND_IMM, opcode.OR_IMM, opcode.XOR_IMM,
+	} {
+		foldable[op] = true
+	}
+}
+
+// registerForms maps the math operations taking a constant to their
+// variants taking a second operand register
+var registerForms = map[int]int{
+	opcode.ADD_IMM: opcode.ADD,
+	opcode.SUB_IMM: opcode.SUB,
+	opcode.MUL_IMM: opcode.MUL,
+	opcode.DIV_IMM: opcode.DIV,
+	opcode.MOD_IMM: opcode.MOD,
+	opcode.AND_IMM: opcode.AND,
+	opcode.OR_IMM:  opcode.OR,
+	opcode.XOR_IMM: opcode.XOR,
+}
+
+// inputs returns the registers read by a foldable instruction
+func inputs(ins disasm.Instruction) []int {
+	switch {
+	case ins.Opcode == opcode.STR_POOL:
+		return nil
+	case ins.Opcode == opcode.DIVMOD:
+		return ins.Regs[2:]
+	case len(ins.Regs) == 1:
+		// e.g. INC both reads and writes its register
+		return ins.Regs
+	default:
+		return ins.Regs[1:]
+	}
+}
+
+// transfer returns the state after executing the instruction, which
+// doesn't change the control flow, in the given state. An error is
+// returned if the instruction is known to fail.
+func (a *analyzer) transfer(ins disasm.Instruction, in state) (state, error) {
+	if slices.ContainsFunc(ins.Regs, func(r int) bool { return r == header.RegSP || r == header.RegIP }) {
+		// the pseudo-registers aren't tracked, so the registers the
+		// instruction may store into become unknown
+		out := in
+		for _, r := range ins.Regs {
+			if r < header.DefaultNumRegisters {
+				out.regs[r] = Value{}
+			}
+		}
+		out.z = FlagUnknown
+		return out, nil
+	}
+
+	for _, r := range ins.Regs {
+		if r >= header.DefaultNumRegisters {
+			return in, fmt.Errorf("register [%d] is out of range", r)
+		}
+	}
+
+	if foldable[ins.Opcode] {
+		constant := true
+		for _, r := range inputs(ins) {
+			constant = constant && in.regs[r].IsConst()
+		}
+		if constant {
+			return a.eval(ins, in)
+		}
+	}
+
+	out := in
+	r := ins.Regs
+	switch ins.Opcode {
+	case opcode.INT_STORE:
+		out.regs[r[0]] = Const(min(ins.Imm, a.top))
+	case opcode.STR_STORE:
+		out.regs[r[0]] = StrConst(ins.Str)
+	case opcode.INT_TO_STR, opcode.CONCAT:
+		out.regs[r[0]] = Value{Kind: Str}
+	case opcode.REG_STORE:
+		out.regs[r[0]] = in.regs[r[1]]
+	case opcode.CMOV_Z, opcode.CMOV_NZ:
+		moved := FlagSet
+		if ins.Opcode == opcode.CMOV_NZ {
+			moved = FlagClear
+		}
+		switch in.z {
+		case moved:
+			out.regs[r[0]] = in.regs[r[1]]
+		case FlagUnknown:
+			out.regs[r[0]] = join(in.regs[r[0]], in.regs[r[1]])
+		}
+	case opcode.INT_RAND:
+		out.regs[r[0]] = Range(0, a.top-1)
+	case opcode.RAND_RANGE:
+		out.regs[r[0]] = Range(0, max(a.asInt(in.regs[r[1]]).Hi-1, 0))
+	case opcode.STR_TO_INT, opcode.TIME:
+		out.regs[r[0]] = Range(0, a.top)
+	case opcode.READ_INT:
+		// the register is unchanged if no integer was read
+		out.regs[r[0]] = join(in.regs[r[0]], Range(0, a.top))
+		out.z = FlagUnknown
+	case opcode.POP, opcode.LOAD_LOCAL:
+		// the stack holds values of any type
+		out.regs[r[0]] = Value{}
+	case opcode.SP_GET:
+		out.regs[r[0]] = Range(0, min(cpu.RAMStackTop, a.top))
+	case opcode.PEEK, opcode.ORD:
+		out.regs[r[0]] = Range(0, 0xff)
+	case opcode.PEEK16:
+		out.regs[r[0]] = Range(0, min(0xffff, a.top))
+	case opcode.PEEK_STR:
+		out.regs[r[0]] = Value{Kind: Str}
+	case opcode.MEM_FIND:
+		// the register is unchanged if nothing is found
+		out.regs[r[0]] = join(in.regs[r[0]], Range(0, min(cpu.MemSize-1, a.top)))
+		out.z = FlagUnknown
+	case opcode.CHR:
+		out.regs[r[0]] = Value{Kind: Str}
+	case opcode.SPLIT_COUNT:
+		out.regs[r[0]] = Range(1, a.top)
+	case opcode.SPLIT_PART:
+		// the register is unchanged if there is no such part
+		out.regs[r[0]] = join(in.regs[r[0]], Value{Kind: Str})
+		out.z = FlagUnknown
+
+	case opcode.ADD, opcode.SUB, opcode.MUL, opcode.DIV, opcode.MOD,
+		opcode.AND, opcode.OR, opcode.XOR, opcode.SHL, opcode.SHR:
+		out.regs[r[0]], out.z = a.arithmetic(ins.Opcode, a.asInt(in.regs[r[1]]), a.asInt(in.regs[r[2]]), in.z)
+	case opcode.ADC, opcode.SBC:
+		// the carry flag isn't tracked
+		out.regs[r[0]] = Range(0, a.top)
+	case opcode.MULH:
+		out.regs[r[0]] = Range(0, a.top)
+	case opcode.ADD_PAIR, opcode.SUB_PAIR, opcode.MUL_PAIR, opcode.DIV_PAIR:
+		if lower := max(r[0], r[1], r[2]) + 1; lower >= header.DefaultNumRegisters {
+			return in, fmt.Errorf("register [%d] is out of range", lower)
+		}
+		out.regs[r[0]] = Range(0, a.top)
+		out.regs[r[0]+1] = Range(0, a.top)
+		out.z = FlagUnknown
+	case opcode.DIVMOD:
+		x, y := a.asInt(in.regs[r[2]]), a.asInt(in.regs[r[3]])
+		out.regs[r[0]], _ = a.arithmetic(opcode.DIV, x, y, in.z)
+		out.regs[r[1]], _ = a.arithmetic(opcode.MOD, x, y, in.z)
+	case opcode.SHL_IMM:
+		out.regs[r[0]], out.z = a.arithmetic(opcode.SHL, a.asInt(in.regs[r[1]]), Const(ins.Imm), in.z)
+	case opcode.SHR_IMM:
+		out.regs[r[0]], out.z = a.arithmetic(opcode.SHR, a.asInt(in.regs[r[1]]), Const(ins.Imm), in.z)
+	case opcode.ADD_IMM, opcode.SUB_IMM, opcode.MUL_IMM, opcode.DIV_IMM,
+		opcode.MOD_IMM, opcode.AND_IMM, opcode.OR_IMM, opcode.XOR_IMM:
+		imm := Const(min(ins.Imm, a.top))
+		out.regs[r[0]], out.z = a.arithmetic(registerForms[ins.Opcode], a.asInt(in.regs[r[1]]), imm, in.z)
+
+	case opcode.INC:
+		v := a.asInt(in.regs[r[0]])
+		if v.Hi < a.top {
+			out.regs[r[0]] = Range(v.Lo+1, v.Hi+1)
+			out.z = FlagClear
+		} else {
+			out.regs[r[0]] = Range(0, a.top)
+			out.z = FlagUnknown
+		}
+	case opcode.DEC:
+		v := a.asInt(in.regs[r[0]])
+		switch {
+		case v.Lo > 1:
+			out.regs[r[0]] = Range(v.Lo-1, v.Hi-1)
+			out.z = FlagClear
+		case v.Lo == 1:
+			out.regs[r[0]] = Range(0, v.Hi-1)
+			out.z = FlagUnknown
+		default:
+			out.regs[r[0]] = Range(0, a.top)
+			out.z = FlagUnknown
+		}
+
+	case opcode.CMP_INT:
+		out.z = cmpInt(in.regs[r[0]], ins.Imm)
+	case opcode.CMP_STR:
+		out.z = cmpStr(in.regs[r[0]], ins.Str)
+	case opcode.CMP_REG:
+		out.z = cmpReg(in.regs[r[0]], in.regs[r[1]])
+	case opcode.IS_INT:
+		out.z = isKind(in.regs[r[0]], Int)
+	case opcode.IS_STR:
+		out.z = isKind(in.regs[r[0]], Str)
+	case opcode.IS_FLOAT:
+		out.z = isKind(in.regs[r[0]], Float)
+
+	case opcode.FLOAT_STORE, opcode.INT_TO_FLOAT:
+		out.regs[r[0]] = Value{Kind: Float}
+	case opcode.FADD, opcode.FSUB, opcode.FMUL, opcode.FDIV:
+		out.regs[r[0]] = Value{Kind: Float}
+		out.z = FlagUnknown
+	case opcode.FLOAT_TO_INT:
+		out.regs[r[0]] = Range(0, a.top)
+
+	case opcode.HASH_NEW:
+		out.regs[r[0]] = Value{Kind: Hash}
+	case opcode.HASH_GET:
+		// the value is unchanged if the key is missing
+		out.regs[r[0]] = Value{}
+		out.z = FlagUnknown
+	case opcode.HASH_KEYS:
+		out.regs[r[0]] = Value{Kind: Str}
+		out.z = FlagUnknown
+
+	case opcode.ALLOC:
+		out.regs[r[0]] = Range(0, a.top)
+
+	case opcode.TRAP:
+		// traps may change any register
+		out = state{}
+	}
+	return out, nil
+}
+
+// eval evaluates an instruction with constant inputs on the scratch CPU
+func (a *analyzer) eval(ins disasm.Instruction, in state) (state, error) {
+	c := a.scratch
+	for _, r := range ins.Regs {
+		reg, _ := c.Reg(r)
+		switch v := in.regs[r]; v.Kind {
+		case Int:
+			reg.SetInt(v.Lo)
+		case Str:
+			reg.SetStr(v.S)
+		}
+	}
+	for i := 0; i < ins.Size; i++ {
+		c.Store(ins.Addr+i, a.code[ins.Addr+i])
+	}
+	c.SetIP(ins.Addr)
+	c.SetZero(false)
+
+	if _, err := c.Step(); err != nil {
+		return in, errors.Unwrap(err)
+	}
+
+	out := in
+	for _, r := range ins.Regs {
+		reg, _ := c.Reg(r)
+		if reg.Type() == "int" {
+			v, _ := reg.GetInt()
+			out.regs[r] = Const(v)
+		} else {
+			v, _ := reg.GetStr()
+			out.regs[r] = StrConst(v)
+		}
+	}
+
+	switch ins.Opcode {
+	case opcode.SUB, opcode.SUB_IMM:
+		// SUB only ever sets the flag
+		if c.Zero() {
+			out.z = FlagSet
+		}
+	case opcode.INC, opcode.DEC:
+		out.z = flagOf(c.Zero())
+	}
+	return out, nil
+}
+
+// asInt returns the value as an integer: an instruction reading an
+// integer fails on anything else, so only integers matter afterwards
+func (a *analyzer) asInt(v Value) Value {
+	if v.Kind == Int {
+		return v
+	}
+	return Range(0, a.top)
+}
+
+// clamp limits a bound to the range registers can hold
+func (a *analyzer) clamp(v int) int {
+	return max(0, min(v, a.top))
+}
+
+// arithmetic returns the result of a math instruction on two integer
+// intervals, along with the zero flag
+func (a *analyzer) arithmetic(op int, x, y Value, z Flag) (Value, Flag) {
+	switch op {
+	case opcode.ADD:
+		return Range(a.clamp(x.Lo+y.Lo), a.clamp(x.Hi+y.Hi)), z
+	case opcode.SUB:
+		// SUB sets the flag if the result is zero or less
+		switch {
+		case x.Hi-y.Lo <= 0:
+			z = FlagSet
+		case x.Lo-y.Hi <= 0 && z != FlagSet:
+			z = FlagUnknown
+		}
+		return Range(a.clamp(x.Lo-y.Hi), a.clamp(x.Hi-y.Lo)), z
+	case opcode.MUL:
+		return Range(a.clamp(x.Lo*y.Lo), a.clamp(x.Hi*y.Hi)), z
+	case opcode.DIV:
+		if y.Lo > 0 {
+			return Range(x.Lo/y.Hi, x.Hi/y.Lo), z
+		}
+		return Range(0, x.Hi), z
+	case opcode.MOD:
+		if y.Lo > 0 {
+			return Range(0, min(x.Hi, y.Hi-1)), z
+		}
+		return Range(0, x.Hi), z
+	case opcode.AND:
+		return Range(0, min(x.Hi, y.Hi)), z
+	case opcode.SHL:
+		if y.Hi < 64 && x.Hi<<y.Hi <= a.top {
+			return Range(x.Lo<<y.Lo, x.Hi<<y.Hi), z
+		}
+		return Range(0, a.top), z
+	case opcode.SHR:
+		return Range(x.Lo>>y.Hi, x.Hi>>y.Lo), z
+	default:
+		// OR and XOR don't set bits above the highest of their inputs
+		bits := 1
+		for bits <= max(x.Hi, y.Hi) {
+			bits <<= 1
+		}
+		return Range(0, min(bits-1, a.top)), z
+	}
+}
+
+func cmpInt(v Value, imm int) Flag {
+	switch v.Kind {
+	case Str, Float:
+		return FlagClear
+	case Int:
+		if imm < v.Lo || imm > v.Hi {
+			return FlagClear
+		}
+		if v.Lo == v.Hi {
+			return FlagSet
+		}
+	}
+	return FlagUnknown
+}
+
+func cmpStr(v Value, s string) Flag {
+	switch {
+	case v.Kind == Int, v.Kind == Float:
+		return FlagClear
+	case v.Kind == Str && v.Known:
+		return flagOf(v.S == s)
+	}
+	return FlagUnknown
+}
+
+func cmpReg(x, y Value) Flag {
+	switch {
+	case x.Kind == Int && y.Kind == Int:
+		if x.Hi < y.Lo || y.Hi < x.Lo {
+			return FlagClear
+		}
+		if x.IsConst() && y.IsConst() {
+			return FlagSet
+		}
+	case x.Kind == Str && y.Kind == Str && x.Known && y.Known:
+		return flagOf(x.S == y.S)
+	}
+	return FlagUnknown
+}
+
+func isKind(v Value, kind Kind) Flag {
+	if v.Kind == Unknown {
+		return FlagUnknown
+	}
+	return flagOf(v.Kind == kind)
+}
+
+// report collects the results once the states reached a fixed point
+func (a *analyzer) report() *Report {
+	r := &Report{CodeSize: len(a.code), Entry: a.entry}
+
+	for e := range a.edges {
+		r.Edges = append(r.Edges, e)
+	}
+	sort.Slice(r.Edges, func(i, j int) bool {
+		x, y := r.Edges[i], r.Edges[j]
+		if x.From != y.From {
+			return x.From < y.From
+		}
+		if x.Kind != y.Kind {
+			return x.Kind < y.Kind
+		}
+		return x.To < y.To
+	})
+
+	covered := make([]bool, len(a.code))
+	for addr, ins := range a.instrs {
+		r.Reachable = append(r.Reachable, ins)
+		for i := 0; i < ins.Size; i++ {
+			covered[addr+i] = true
+		}
+	}
+	sort.Slice(r.Reachable, func(i, j int) bool { return r.Reachable[i].Addr < r.Reachable[j].Addr })
+
+	r.Incomplete = len(a.outside) > 0
+	for start := 0; start < len(covered) && !r.Incomplete; {
+		if covered[start] {
+			start++
+			continue
+		}
+		end := start
+		for end < len(covered) && !covered[end] {
+			end++
+		}
+		r.Unreachable = append(r.Unreachable, Span{start, end})
+		start = end
+	}
+
+	for _, ins := range r.Reachable {
+		if msg := a.check(ins, a.states[ins.Addr]); msg != "" {
+			r.Findings = append(r.Findings, Finding{Instruction: ins, Message: msg})
+		}
+	}
+	for addr, target := range a.outside {
+		r.Findings = append(r.Findings, Finding{
+			Instruction: a.instrs[addr],
+			Message:     fmt.Sprintf("unknown target 0x%04x outside of the code, it isn't analyzed", target),
+		})
+	}
+	for addr, err := range a.failures {
+		ins, ok := a.instrs[addr]
+		if !ok {
+			ins = disasm.Instruction{Addr: addr, Opcode: int(a.code[addr])}
+		}
+		r.Findings = append(r.Findings, Finding{Instruction: ins, Message: "always fails: " + err.Error(), Fails: true})
+	}
+	sort.SliceStable(r.Findings, func(i, j int) bool {
+		return r.Findings[i].Instruction.Addr < r.Findings[j].Instruction.Addr
+	})
+	return r
+}
+
+// check returns what is proven about an instruction in the given state
+func (a *analyzer) check(ins disasm.Instruction, in state) string {
+	if _, failed := a.failures[ins.Addr]; failed {
+		return ""
+	}
+
+	switch ins.Opcode {
+	case opcode.JMP_Z, opcode.JMP_NZ:
+		taken := FlagSet
+		if ins.Opcode == opcode.JMP_NZ {
+			taken = FlagClear
+		}
+		switch in.z {
+		case taken:
+			return "branch is always taken"
+		case FlagUnknown:
+			return ""
+		default:
+			return "branch is never taken"
+		}
+
+	case opcode.POKE:
+		addr := a.asInt(in.regs[ins.Regs[1]])
+		return a.checkWrite(addr.Lo, addr.Hi, 1, 1, addr)
+	case opcode.POKE16:
+		addr := a.asInt(in.regs[ins.Regs[1]])
+		return a.checkWrite(addr.Lo, addr.Hi, 2, 2, addr)
+	case opcode.POKE_STR:
+		addr := a.asInt(in.regs[ins.Regs[1]])
+		if str := in.regs[ins.Regs[0]]; str.Kind == Str && str.IsConst() {
+			return a.checkWrite(addr.Lo, addr.Hi, len(str.S)+1, len(str.S)+1, addr)
+		}
+		return a.checkWrite(addr.Lo, addr.Hi, 1, cpu.MemSize, addr)
+
+	case opcode.MEM_CPY:
+		dst := a.asInt(in.regs[ins.Regs[0]])
+		length := a.asInt(in.regs[ins.Regs[2]])
+		if length.Hi == 0 {
+			return "copies nothing"
+		}
+		return a.checkWrite(dst.Lo, dst.Hi, length.Lo, length.Hi, dst)
+
+	case opcode.TRAP:
+		if ins.Imm == cpu.TrapAtExit && !(in.regs[0].Kind == Int && in.regs[0].IsConst()) {
+			return "exit hook address is unknown, its code isn't analyzed"
+		}
+		if ins.Imm == cpu.TrapOnInterrupt && !(in.regs[1].Kind == Int && in.regs[1].IsConst()) {
+			return "interrupt handler address is unknown, its code isn't analyzed"
+		}
+	}
+	return ""
+}
+
+// checkWrite classifies a write of minLen to maxLen bytes starting
+// anywhere from lo to hi against the code of the program
+func (a *analyzer) checkWrite(lo, hi, minLen, maxLen int, addr Value) string {
+	code := len(a.code)
+	last := hi + maxLen - 1
+
+	switch {
+	case lo >= code && last < cpu.MemSize:
+		return fmt.Sprintf("never writes the code (address %s)", addr)
+	case last < code && minLen > 0:
+		return fmt.Sprintf("always writes the code (address %s)", addr)
+	default:
+		return fmt.Sprintf("may write the code (address %s)", addr)
+	}
+}
@@ -0,0 +1,150 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"vm/disasm"
+	"vm/opcode"
+)
+
+// conditions names the flag condition of a conditional jump when it is
+// taken, and when it isn't
+var conditions = map[int][2]string{
+	opcode.JMP_Z:  {"z", "nz"},
+	opcode.JMP_NZ: {"nz", "z"},
+	opcode.JMP_S:  {"s", "ns"},
+	opcode.JMP_NS: {"ns", "s"},
+}
+
+// block is a basic block of reachable instructions, which are only
+// entered at the first one and only left after the last one
+type block struct {
+	start  int
+	instrs []disasm.Instruction
+}
+
+// WriteDOT writes the control flow graph of the reachable code in the
+// DOT format of Graphviz. Every basic block is a node, labeled by the
+// labels defined at its start, which are looked up in symbols.
+// Conditional jumps are labeled by the flag condition, and calls are
+// dotted.
+func (r *Report) WriteDOT(w io.Writer, symbols map[string]int) error {
+	blocks := r.blocks(symbols)
+	starts := make(map[int]bool, len(blocks))
+	for _, b := range blocks {
+		starts[b.start] = true
+	}
+
+	names := map[int][]string{}
+	for name, addr := range symbols {
+		names[addr] = append(names[addr], ":"+name)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("digraph program {\n")
+	sb.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+
+	for _, b := range blocks {
+		var label strings.Builder
+		labels := names[b.start]
+		sort.Strings(labels)
+		if b.start == r.Entry {
+			labels = append([]string{"(entry)"}, labels...)
+		}
+		for _, name := range labels {
+			label.WriteString(name + `\l`)
+		}
+		for _, ins := range b.instrs {
+			label.WriteString(escape(ins.String()) + `\l`)
+		}
+		fmt.Fprintf(&sb, "\tb%04x [label=\"%s\"];\n", b.start, label.String())
+	}
+
+	extra := map[string]string{}
+	node := func(addr int) string {
+		switch {
+		case starts[addr]:
+			return fmt.Sprintf("b%04x", addr)
+		case addr == r.CodeSize:
+			extra["end"] = "end [label=\"end of code\", shape=oval];"
+			return "end"
+		default:
+			id := fmt.Sprintf("x%04x", addr)
+			extra[id] = fmt.Sprintf("%s [label=\"0x%04x (unknown)\", style=dashed];", id, addr)
+			return id
+		}
+	}
+
+	for _, b := range blocks {
+		last := b.instrs[len(b.instrs)-1]
+		for _, e := range r.Edges {
+			if e.From != last.Addr {
+				continue
+			}
+
+			attrs := ""
+			switch e.Kind {
+			case EdgeTaken:
+				attrs = fmt.Sprintf(" [label=\"%s\"]", conditions[last.Opcode][0])
+			case EdgeNotTaken:
+				attrs = fmt.Sprintf(" [label=\"%s\"]", conditions[last.Opcode][1])
+			case EdgeCall:
+				attrs = " [style=dotted]"
+			}
+			fmt.Fprintf(&sb, "\tb%04x -> %s%s;\n", b.start, node(e.To), attrs)
+		}
+	}
+
+	ids := make([]string, 0, len(extra))
+	for id := range extra {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		fmt.Fprintf(&sb, "\t%s\n", extra[id])
+	}
+
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// blocks splits the reachable instructions into basic blocks. A block
+// starts at the entry point, at labels, and at instructions which are
+// reached other than by the previous instruction alone.
+func (r *Report) blocks(symbols map[string]int) []block {
+	leaders := map[int]bool{r.Entry: true}
+	for _, addr := range symbols {
+		leaders[addr] = true
+	}
+
+	out := map[int]int{} // number of edges leaving an instruction
+	for _, e := range r.Edges {
+		out[e.From]++
+	}
+	in := map[int]int{} // number of edges entering an instruction
+	for _, e := range r.Edges {
+		in[e.To]++
+		if e.Kind != EdgeNext || out[e.From] > 1 {
+			leaders[e.To] = true
+		}
+	}
+
+	var blocks []block
+	for i, ins := range r.Reachable {
+		adjacent := i > 0 && r.Reachable[i-1].Addr+r.Reachable[i-1].Size == ins.Addr
+		if len(blocks) == 0 || !adjacent || leaders[ins.Addr] || in[ins.Addr] != 1 {
+			blocks = append(blocks, block{start: ins.Addr})
+		}
+		b := &blocks[len(blocks)-1]
+		b.instrs = append(b.instrs, ins)
+	}
+	return blocks
+}
+
+// escape quotes text for a DOT string
+func escape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(text)
+}
@@ -0,0 +1,166 @@
+package analysis
+
+import (
+	"fmt"
+	"vm/header"
+)
+
+// Kind is the type of the value of a register as far as it is known
+type Kind int
+
+const (
+	// Unknown values may be of either type
+	Unknown Kind = iota
+
+	// Int values are integers within an interval
+	Int
+
+	// Str values are strings, possibly of a known content
+	Str
+
+	// Float values are floating-point numbers, which aren't tracked
+	Float
+
+	// Hash values are hashes, whose contents aren't tracked
+	Hash
+)
+
+// Value is the abstract value of a register: either unknown, an integer
+// interval, which is a constant if both bounds are equal, a string, or
+// a float.
+type Value struct {
+	Kind Kind
+
+	// Lo and Hi are the bounds of integers, both inclusive
+	Lo, Hi int
+
+	// S is the content of strings if Known is set
+	S     string
+	Known bool
+}
+
+// Const returns the integer constant v
+func Const(v int) Value {
+	return Value{Kind: Int, Lo: v, Hi: v}
+}
+
+// Range returns an integer in the interval [lo, hi]
+func Range(lo, hi int) Value {
+	return Value{Kind: Int, Lo: lo, Hi: hi}
+}
+
+// StrConst returns the string constant s
+func StrConst(s string) Value {
+	return Value{Kind: Str, S: s, Known: true}
+}
+
+// IsConst returns true if the value is exactly known
+func (v Value) IsConst() bool {
+	return (v.Kind == Int && v.Lo == v.Hi) || (v.Kind == Str && v.Known)
+}
+
+func (v Value) String() string {
+	switch v.Kind {
+	case Int:
+		if v.Lo == v.Hi {
+			return fmt.Sprintf("0x%04x", v.Lo)
+		}
+		return fmt.Sprintf("0x%04x-0x%04x", v.Lo, v.Hi)
+	case Str:
+		if v.Known {
+			return fmt.Sprintf("%q", v.S)
+		}
+		return "string"
+	case Float:
+		return "float"
+	case Hash:
+		return "hash"
+	default:
+		return "unknown"
+	}
+}
+
+// join returns the smallest value covering both values
+func join(a, b Value) Value {
+	if a.Kind != b.Kind {
+		return Value{}
+	}
+	switch a.Kind {
+	case Int:
+		return Range(min(a.Lo, b.Lo), max(a.Hi, b.Hi))
+	case Str:
+		if a.Known && b.Known && a.S == b.S {
+			return a
+		}
+		return Value{Kind: Str}
+	case Float, Hash:
+		return a
+	}
+	return Value{}
+}
+
+// widen extends the bounds of an interval which keeps growing to the
+// full range, so loops reach a fixed point quickly
+func widen(prev, next Value, top int) Value {
+	if prev.Kind != Int || next.Kind != Int {
+		return next
+	}
+	if next.Lo < prev.Lo {
+		next.Lo = 0
+	}
+	if next.Hi > prev.Hi {
+		next.Hi = top
+	}
+	return next
+}
+
+// Flag is the abstract value of the zero flag
+type Flag int
+
+const (
+	// FlagUnknown means the flag may be either set or clear
+	FlagUnknown Flag = iota
+
+	// FlagSet means the flag is set
+	FlagSet
+
+	// FlagClear means the flag is clear
+	FlagClear
+)
+
+// flagOf returns the flag for a known condition
+func flagOf(set bool) Flag {
+	if set {
+		return FlagSet
+	}
+	return FlagClear
+}
+
+func joinFlag(a, b Flag) Flag {
+	if a == b {
+		return a
+	}
+	return FlagUnknown
+}
+
+// state is the abstract state of the machine at an address
+type state struct {
+	regs [header.DefaultNumRegisters]Value
+	z    Flag
+}
+
+func (s state) join(o state) state {
+	for i := range s.regs {
+		s.regs[i] = join(s.regs[i], o.regs[i])
+	}
+	s.z = joinFlag(s.z, o.z)
+	return s
+}
+
+func (s state) widen(next state, top int) state {
+	for i := range s.regs {
+		s.regs[i] = widen(s.regs[i], next.regs[i], top)
+	}
+	s.z = next.z
+	return s
+}
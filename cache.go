@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"vm/compiler"
+	"vm/header"
+)
+
+// cacheHelp documents -cache-dir in the help of the subcommands having it
+const cacheHelp = `
+With -cache-dir compiled programs are kept in the given directory, keyed
+by the hashes of the source, its directory, the compile flags and the
+VM binary, and a source which didn't change since is loaded from there
+instead of being compiled again, which speeds up running a large number
+of programs repeatedly. Files included via incbin are checked for changes
+too. Warnings are only printed when a program is actually compiled.
+The directory can be shared by projects and deleted at any time; set it
+once for all subcommands in ~/.vmrc, e.g. cache-dir = "/tmp/vm-cache".
+`
+
+// compileCache keeps compiled programs keyed by the hash of their source
+// and the compile options, see cacheHelp. A nil cache is disabled.
+type compileCache struct {
+	dir string
+
+	// fsys is the file system included files are read from, the host's
+	// if nil
+	fsys fs.FS
+}
+
+// cacheEntry is a compiled program in the cache
+type cacheEntry struct {
+	// Included are the files included by the source with the hashes
+	// of their contents when it was compiled
+	Included map[string]string `json:"included,omitempty"`
+
+	// Program is the bytecode prefixed by its header, as written by
+	// compile
+	Program []byte `json:"program"`
+}
+
+// newCompileCache returns the cache in the given directory, or nil if
+// no directory is given
+func newCompileCache(dir string, fsys fs.FS) *compileCache {
+	if dir == "" {
+		return nil
+	}
+	return &compileCache{dir: dir, fsys: fsys}
+}
+
+// key returns the key of the program compiled with the given options
+// from the given source in the given directory, which included files
+// are resolved against, so the same source in different directories
+// gets different keys
+func (cc *compileCache) key(dir, options string, source []byte) string {
+	if cc == nil {
+		return ""
+	}
+	if cc.fsys == nil {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00", binaryHash(), dir, options, len(source))
+	h.Write(source)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lookup returns the cached program of the given key prefixed by its
+// header, unless there is none or the files it included changed since
+func (cc *compileCache) lookup(key string) ([]byte, bool) {
+	if cc == nil {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(cc.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err = json.Unmarshal(data, &entry); err == nil {
+		_, _, err = header.Decode(entry.Program)
+	}
+	if err != nil {
+		debugf("ignoring invalid cache entry %s: %s", cc.path(key), err)
+		return nil, false
+	}
+
+	for path, hash := range entry.Included {
+		if h, err := cc.hashFile(path); err != nil || h != hash {
+			return nil, false
+		}
+	}
+	return entry.Program, true
+}
+
+// store adds the program compiled by the given compiler to the cache
+func (cc *compileCache) store(key string, c *compiler.Compiler) error {
+	if cc == nil {
+		return nil
+	}
+
+	h, err := c.Header().Encode()
+	if err != nil {
+		return err
+	}
+	entry := cacheEntry{Program: append(h, c.Output()...)}
+	for _, path := range c.Included() {
+		if entry.Included == nil {
+			entry.Included = map[string]string{}
+		}
+		if entry.Included[path], err = cc.hashFile(path); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(cc.dir, 0755); err != nil {
+		return err
+	}
+
+	// programs run concurrently mustn't read a partly written entry
+	tmp, err := os.CreateTemp(cc.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cc.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// path returns the path of the cache entry of the given key
+func (cc *compileCache) path(key string) string {
+	return filepath.Join(cc.dir, key+".json")
+}
+
+// hashFile hashes the contents of an included file
+func (cc *compileCache) hashFile(path string) (string, error) {
+	var data []byte
+	var err error
+	if cc.fsys != nil {
+		data, err = fs.ReadFile(cc.fsys, filepath.ToSlash(path))
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+var (
+	binaryHashOnce sync.Once
+	binaryHashSum  string
+)
+
+// binaryHash returns the hash of the running VM binary, so programs
+// cached by other versions of the compiler are compiled again. It is
+// the version if the binary can't be read.
+func binaryHash() string {
+	binaryHashOnce.Do(func() {
+		binaryHashSum = version
+		path, err := os.Executable()
+		if err != nil {
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+
+		h := sha256.New()
+		if _, err = io.Copy(h, f); err == nil {
+			binaryHashSum = hex.EncodeToString(h.Sum(nil))
+		}
+	})
+	return binaryHashSum
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/subcommands"
+)
+
+func TestCompileCache(t *testing.T) {
+	root := t.TempDir()
+	r := &runCmd{}
+	r.SetFlags(flag.NewFlagSet("run", flag.ContinueOnError))
+	cache := newCompileCache(filepath.Join(root, "cache"), nil)
+	const options = "options"
+	const source = "incbin \"d.bin\"\nexit\n"
+
+	// the steps share the cache, each one compiles a source after
+	// writing the given files
+	steps := []struct {
+		name    string
+		files   map[string]string
+		file    string
+		wantHit bool
+		want    string
+	}{
+		{"first compile", map[string]string{"a/p.in": source, "a/d.bin": "first"}, "a/p.in", false, "first"},
+		{"unchanged", nil, "a/p.in", true, "first"},
+		{"source edited", map[string]string{"a/p.in": "# edited\n" + source}, "a/p.in", false, "first"},
+		{"edited source unchanged", nil, "a/p.in", true, "first"},
+		{"included file changed", map[string]string{"a/d.bin": "changed"}, "a/p.in", false, "changed"},
+		{"same source in another directory", map[string]string{"b/p.in": source, "b/d.bin": "second"}, "b/p.in", false, "second"},
+	}
+
+	for _, step := range steps {
+		for name, data := range step.files {
+			path := filepath.Join(root, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		file := filepath.Join(root, step.file)
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, hit := cache.lookup(cache.key(filepath.Dir(file), options, src))
+		if hit != step.wantHit {
+			t.Errorf("%s: cache hit %t, want %t", step.name, hit, step.wantHit)
+		}
+
+		_, code, status := r.compile(file, src, cache, options, nil)
+		if status != subcommands.ExitSuccess {
+			t.Fatalf("%s: compiling failed with status %d", step.name, status)
+		}
+		if !bytes.Contains(code, []byte(step.want)) {
+			t.Errorf("%s: the program % x doesn't embed %q", step.name, code, step.want)
+		}
+	}
+}
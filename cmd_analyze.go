@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"os"
+	"vm/analysis"
+	"vm/header"
+)
+
+type analyzeCmd struct {
+	verbose bool
+	dot     string
+}
+
+func (*analyzeCmd) Name() string { return "analyze" }
+
+func (*analyzeCmd) Synopsis() string { return "Analyze a compiled program without running it." }
+
+func (*analyzeCmd) Usage() string {
+	return `analyze:
+Analyze the given compiled program by tracking what is known about the
+registers at every reachable instruction, without running it.
+
+The report lists the code which is never reached, conditional jumps
+which are always or never taken, whether memory writes can modify the
+code, and instructions which always fail.
+
+With -dot the control flow graph of the reachable code is written to the
+given file in the DOT format of Graphviz, e.g. to render it via
+"dot -Tsvg out.dot > out.svg". Every basic block is a node named by its
+labels, conditional jumps are labeled by their condition and calls are
+dotted.
+`
+}
+
+func (a *analyzeCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&a.verbose, "v", false, "also list the reachable instructions")
+	f.StringVar(&a.dot, "dot", "", "write the control flow graph in DOT format to this file")
+}
+
+func (a *analyzeCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if a.dot != "" && f.NArg() != 1 {
+		fmt.Println("-dot requires a single program")
+		return subcommands.ExitUsageError
+	}
+
+	for _, file := range f.Args() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Printf("error reading %s: %s\n", file, err.Error())
+			return exitIO
+		}
+
+		h, code, err := header.Decode(data)
+		if err != nil {
+			fmt.Printf("error reading header of %s: %s\n", file, err.Error())
+			return subcommands.ExitFailure
+		}
+
+		report, err := analysis.Analyze(h, code)
+		if err != nil {
+			fmt.Printf("error analyzing %s: %s\n", file, err.Error())
+			return subcommands.ExitFailure
+		}
+
+		if a.dot != "" {
+			if err = writeDOT(a.dot, report, h.Symbols); err != nil {
+				fmt.Printf("error writing %s: %s\n", a.dot, err.Error())
+				return exitIO
+			}
+		}
+
+		fmt.Printf("%s:\n", file)
+		fmt.Printf("  reachable:   %d instructions\n", len(report.Reachable))
+		if report.Incomplete {
+			fmt.Println("  unreachable: unknown, the program jumps outside of its code")
+		}
+		for _, span := range report.Unreachable {
+			if span.End-span.Start == 1 {
+				fmt.Printf("  unreachable: %s (1 byte)\n", header.Locate(h.Symbols, span.Start))
+				continue
+			}
+			fmt.Printf("  unreachable: %s-%04x (%d bytes)\n",
+				header.Locate(h.Symbols, span.Start), span.End-1, span.End-span.Start)
+		}
+		for _, finding := range report.Findings {
+			fmt.Printf("  %s %s: %s\n",
+				header.Locate(h.Symbols, finding.Instruction.Addr), finding.Instruction, finding.Message)
+		}
+
+		if a.verbose {
+			fmt.Println("  instructions:")
+			for _, ins := range report.Reachable {
+				fmt.Printf("    %s %s\n", header.Locate(h.Symbols, ins.Addr), ins)
+			}
+		}
+	}
+	return subcommands.ExitSuccess
+}
+
+// writeDOT writes the control flow graph of the analyzed program to the
+// named file
+func writeDOT(path string, report *analysis.Report, symbols map[string]int) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = report.WriteDOT(out, symbols); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
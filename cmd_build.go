@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"vm/compiler"
+	"vm/lexer"
+)
+
+// buildCacheFile is the name of the file recording the hashes of the
+// built programs, in the directory of the project
+const buildCacheFile = ".vmbuild.json"
+
+type buildCmd struct {
+	wordSize int
+	isa      string
+	pool     bool
+	signed   bool
+	strip    bool
+	force    bool
+	aliases  string
+}
+
+// buildEntry records how a program was last built
+type buildEntry struct {
+	// Hash covers the options, the source and the included files
+	Hash string `json:"hash"`
+
+	// Included are the files included by the source, relative to the
+	// project directory
+	Included []string `json:"included,omitempty"`
+
+	// Output is the hash of the written bytecode
+	Output string `json:"output"`
+}
+
+// buildCache maps the sources, relative to the project directory, to
+// how they were last built
+type buildCache map[string]buildEntry
+
+func (*buildCmd) Name() string { return "build" }
+
+func (*buildCmd) Synopsis() string { return "Compile all programs of a project." }
+
+func (*buildCmd) Usage() string {
+	return `build [flags] dir|manifest:
+Compile every program of a project, skipping the ones which are up to date.
+
+The project is either a directory, in which every .in file is compiled,
+or a manifest listing the source files one per line, relative to the
+directory of the manifest. Empty lines and lines starting with # are
+ignored.
+
+A program is up to date if its source, the files it includes via
+incbin, the build flags and its .raw output have the same content
+hashes as when it was last built. The hashes are recorded in
+` + buildCacheFile + ` in the project directory; -force rebuilds everything.
+` + aliasesHelp
+}
+
+func (b *buildCmd) SetFlags(f *flag.FlagSet) {
+	f.IntVar(&b.wordSize, "word-size", 16, "size of the machine word in bits: 8, 16 or 32")
+	f.StringVar(&b.isa, "isa", "register", "instruction set to compile for: register or stack")
+	f.BoolVar(&b.pool, "string-pool", true, "store string literals once in the string pool rather than inline")
+	f.BoolVar(&b.signed, "signed", false, "use signed integer registers, allowing negative integers")
+	f.BoolVar(&b.strip, "strip-symbols", false, "leave the labels out of the header")
+	f.BoolVar(&b.force, "force", false, "rebuild every program, even if it is up to date")
+	f.StringVar(&b.aliases, "aliases", "", "file mapping custom mnemonics to keywords")
+}
+
+func (b *buildCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if f.NArg() != 1 {
+		fmt.Println("usage: build [flags] dir|manifest")
+		return subcommands.ExitUsageError
+	}
+
+	root, sources, err := projectSources(f.Arg(0))
+	if err != nil {
+		fmt.Println("error reading project:", err)
+		return exitIO
+	}
+
+	cachePath := filepath.Join(root, buildCacheFile)
+	cache, err := loadBuildCache(cachePath)
+	if err != nil {
+		fmt.Println("error reading build cache:", err)
+		return exitIO
+	}
+
+	aliases, err := readAliases(b.aliases)
+	if err != nil {
+		fmt.Println("error reading aliases:", err)
+		return exitIO
+	}
+
+	options := fmt.Sprintf("isa=%s word-size=%d string-pool=%t signed=%t strip-symbols=%t aliases=%v",
+		b.isa, b.wordSize, b.pool, b.signed, b.strip, aliases)
+
+	status := subcommands.ExitSuccess
+	built, skipped := 0, 0
+	for _, source := range sources {
+		key, _ := filepath.Rel(root, source)
+		output := strings.TrimSuffix(source, filepath.Ext(source)) + ".raw"
+
+		if !b.force && cache.upToDate(root, key, options, output) {
+			verbosef("%s is up to date", source)
+			skipped++
+			continue
+		}
+
+		var entry buildEntry
+		entry, status = b.build(root, source, options, output, aliases)
+		if status != subcommands.ExitSuccess {
+			delete(cache, key)
+			break
+		}
+		cache[key] = entry
+		built++
+	}
+
+	if err = cache.save(cachePath); err != nil {
+		fmt.Println("error writing build cache:", err)
+		return exitIO
+	}
+	if status == subcommands.ExitSuccess {
+		infof("built %d programs, %d up to date", built, skipped)
+	}
+	return status
+}
+
+// build compiles the source into the output file
+func (b *buildCmd) build(root, source, options, output string, aliases map[string]string) (buildEntry, subcommands.ExitStatus) {
+	input, err := os.Open(source)
+	if err != nil {
+		fmt.Printf("error reading %s: %s\n", source, err.Error())
+		return buildEntry{}, exitIO
+	}
+	defer input.Close()
+
+	l := lexer.NewReader(input)
+	c := compiler.New(l)
+	if err = c.SetISA(b.isa); err != nil {
+		fmt.Println("error:", err)
+		return buildEntry{}, subcommands.ExitUsageError
+	}
+	if err = c.SetWordSize(b.wordSize); err != nil {
+		fmt.Println("error:", err)
+		return buildEntry{}, subcommands.ExitUsageError
+	}
+	if err = c.SetSigned(b.signed); err != nil {
+		fmt.Println("error:", err)
+		return buildEntry{}, subcommands.ExitUsageError
+	}
+	if err = c.SetAliases(aliases); err != nil {
+		fmt.Println("error:", err)
+		return buildEntry{}, subcommands.ExitUsageError
+	}
+	c.SetStringPool(b.pool)
+	c.SetStripSymbols(b.strip)
+	c.SetBaseDir(filepath.Dir(source))
+	c.SetWarnings(logWriter(levelInfo))
+	verbosef("compiling %s", source)
+	err = c.Compile()
+
+	if lerr := l.Err(); lerr != nil {
+		fmt.Printf("error reading %s: %s\n", source, lerr.Error())
+		return buildEntry{}, exitIO
+	}
+	if err != nil {
+		fmt.Printf("error compiling %s: %s\n", source, err.Error())
+		return buildEntry{}, exitCompile
+	}
+
+	if err = c.WriteFile(output); err != nil {
+		fmt.Println("error writing output file:", err)
+		return buildEntry{}, exitStatus(err, exitCompile)
+	}
+
+	entry := buildEntry{}
+	for _, path := range c.Included() {
+		if rel, err := filepath.Rel(root, path); err == nil {
+			path = rel
+		}
+		entry.Included = append(entry.Included, path)
+	}
+	if entry.Hash, err = hashInputs(root, options, source, entry.Included); err == nil {
+		entry.Output, err = hashFiles(output)
+	}
+	if err != nil {
+		fmt.Println("error hashing build inputs:", err)
+		return buildEntry{}, exitIO
+	}
+	return entry, subcommands.ExitSuccess
+}
+
+// projectSources returns the directory of the project and the source
+// files in it, given either the directory or a manifest
+func projectSources(path string) (string, []string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var sources []string
+	if info.IsDir() {
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && filepath.Ext(p) == ".in" {
+				sources = append(sources, p)
+			}
+			return err
+		})
+		sort.Strings(sources)
+		return path, sources, err
+	}
+
+	manifest, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer manifest.Close()
+
+	root := filepath.Dir(path)
+	scanner := bufio.NewScanner(manifest)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sources = append(sources, filepath.Join(root, line))
+	}
+	return root, sources, scanner.Err()
+}
+
+// upToDate returns true if the output of the source, which is given
+// relative to the project directory, was built from the same inputs and
+// wasn't changed since
+func (bc buildCache) upToDate(root, key, options, output string) bool {
+	entry, ok := bc[key]
+	if !ok {
+		return false
+	}
+	hash, err := hashInputs(root, options, filepath.Join(root, key), entry.Included)
+	if err != nil || hash != entry.Hash {
+		return false
+	}
+	out, err := hashFiles(output)
+	return err == nil && out == entry.Output
+}
+
+// hashInputs hashes the build options, the source and the included files
+func hashInputs(root, options, source string, included []string) (string, error) {
+	paths := []string{source}
+	for _, path := range included {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		paths = append(paths, path)
+	}
+
+	hash, err := hashFiles(paths...)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(options + "\x00" + hash))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// hashFiles hashes the contents of the given files
+func hashFiles(paths ...string) (string, error) {
+	h := sha256.New()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%d\x00", len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadBuildCache reads the build cache, which is empty if the project
+// wasn't built before
+func loadBuildCache(path string) (buildCache, error) {
+	cache := buildCache{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cache, nil
+}
+
+// save writes the build cache
+func (bc buildCache) save(path string) error {
+	data, err := json.MarshalIndent(bc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
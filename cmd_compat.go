@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"os"
+	"strings"
+	"time"
+	"vm/compat"
+)
+
+type compatCmd struct {
+	old     string
+	new     string
+	allow   string
+	stdin   string
+	timeout time.Duration
+}
+
+func (*compatCmd) Name() string { return "compat" }
+
+func (*compatCmd) Synopsis() string { return "Compare the behavior of programs on two VM binaries." }
+
+func (*compatCmd) Usage() string {
+	return `compat -old path/to/vm [flags] program.in|dir...:
+Run every given program, and the *.in files of the given directories,
+with the run subcommand of two binaries of the virtual machine, and
+report the programs whose output or exit status differs. This protects
+the semantics of programs while the interpreter changes, e.g. by
+comparing the binary of the last release with the current one.
+
+The output includes the errors printed by run. The new binary is the
+running one unless -new is given.
+`
+}
+
+func (c *compatCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.old, "old", "", "binary of the previous version")
+	f.StringVar(&c.new, "new", "", "binary of the new version, the running one by default")
+	f.StringVar(&c.allow, "allow", "none", "comma-separated capabilities the programs may use (system, file, net, all or none)")
+	f.StringVar(&c.stdin, "stdin", "", "file with the input of every program")
+	f.DurationVar(&c.timeout, "timeout", time.Second, "stop each program after this time")
+}
+
+func (c *compatCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if c.old == "" || f.NArg() == 0 {
+		fmt.Println("usage: compat -old path/to/vm [flags] program.in|dir...")
+		return subcommands.ExitUsageError
+	}
+
+	newBinary := c.new
+	if newBinary == "" {
+		self, err := os.Executable()
+		if err != nil {
+			fmt.Println("error locating the running binary:", err)
+			return subcommands.ExitFailure
+		}
+		newBinary = self
+	}
+
+	var stdin []byte
+	if c.stdin != "" {
+		var err error
+		if stdin, err = os.ReadFile(c.stdin); err != nil {
+			fmt.Printf("error reading %s: %s\n", c.stdin, err.Error())
+			return exitIO
+		}
+	}
+
+	programs, err := compat.Corpus(f.Args())
+	if err != nil {
+		fmt.Println("error reading the corpus:", err)
+		return exitStatus(err, subcommands.ExitFailure)
+	}
+
+	// the binaries enforce the timeout themselves, the limit of the
+	// process only catches binaries ignoring it
+	args := []string{"-allow", c.allow, "-timeout", c.timeout.String()}
+	engine := func(path string) compat.Binary {
+		return compat.Binary{Path: path, Args: args, Stdin: stdin, Timeout: 2*c.timeout + time.Second}
+	}
+
+	divergences, err := compat.Compare(programs, engine(c.old), engine(newBinary))
+	if err != nil {
+		fmt.Println("error running the binaries:", err)
+		return subcommands.ExitFailure
+	}
+
+	for _, d := range divergences {
+		fmt.Printf("%s: diverges\n", d.Program)
+		if d.Old.ExitCode != d.New.ExitCode {
+			fmt.Printf("\texit status %d -> %d\n", d.Old.ExitCode, d.New.ExitCode)
+		}
+		if len(d.Diff) > 0 {
+			fmt.Printf("\t%s\n", strings.Join(d.Diff, "\n\t"))
+		}
+	}
+	infof("%d of %d programs diverge", len(divergences), len(programs))
+
+	if len(divergences) > 0 {
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
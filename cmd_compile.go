@@ -5,14 +5,33 @@ import (
 	"flag"
 	"fmt"
 	"github.com/google/subcommands"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 	"vm/compiler"
+	"vm/header"
 	"vm/lexer"
 )
 
-type compileCmd struct{}
+type compileCmd struct {
+	wordSize int
+	regs     int
+	isa      string
+	pool     bool
+	maxSize  int
+	signed   bool
+	obfusc   bool
+	strip    bool
+	aliases  string
+	strict   bool
+	out      string
+	outDir   string
+	cacheDir string
+}
 
 func (*compileCmd) Name() string { return "compile" }
 
@@ -21,29 +40,246 @@ func (*compileCmd) Synopsis() string { return "Compile a simple VM program." }
 func (*compileCmd) Usage() string {
 	return `compile:
 compile the given input file into bytecode.
-`
+
+With -max-size the compilation fails if the bytecode and the string
+pool together exceed the given number of bytes, and the size of every
+labeled section of the program is listed.
+
+With -obfuscate the parts of the program starting at labels are
+shuffled and the labels renamed, which makes the program harder to
+follow in a disassembly. The original names are written to a .map file
+next to the output, to decode the locations of runtime errors.
+With -strip-symbols no labels are written to the header at all.
+
+The bytecode is written next to the source, with the extension .raw,
+unless -o names the output file. With several inputs {name} in it is
+replaced by the name of each source without its extension, e.g.
+-o {name}.bin. With -out-dir the output files are written to the given
+directory, which is created if needed, instead of next to the sources.
+
+Programs compiled with -obfuscate or -max-size are never taken from the
+cache of -cache-dir, see below.
+` + strictHelp + aliasesHelp + cacheHelp
+}
+
+func (cc *compileCmd) SetFlags(f *flag.FlagSet) {
+	f.IntVar(&cc.wordSize, "word-size", 16, "size of the machine word in bits: 8, 16 or 32")
+	f.IntVar(&cc.regs, "registers", header.DefaultNumRegisters, "number of registers of the CPU the program is compiled for, 1 to 254")
+	f.StringVar(&cc.isa, "isa", "register", "instruction set to compile for: register or stack")
+	f.BoolVar(&cc.pool, "string-pool", true, "store string literals once in the string pool rather than inline")
+	f.BoolVar(&cc.signed, "signed", false, "use signed integer registers, allowing negative integers")
+	f.BoolVar(&cc.obfusc, "obfuscate", false, "shuffle the code between labels and rename the labels")
+	f.BoolVar(&cc.strip, "strip-symbols", false, "leave the labels out of the header")
+	f.StringVar(&cc.aliases, "aliases", "", "file mapping custom mnemonics to keywords")
+	f.BoolVar(&cc.strict, "strict", false, "treat warnings as errors and verify the program")
+	f.IntVar(&cc.maxSize, "max-size", 0, "fail if the program is larger than this many bytes, unlimited when zero")
+	f.StringVar(&cc.out, "o", "", "output file, {name} being replaced by the name of the source, see the usage")
+	f.StringVar(&cc.outDir, "out-dir", "", "directory the output files are written to, next to the sources if empty")
+	f.StringVar(&cc.cacheDir, "cache-dir", "", "directory compiled programs are cached in, see the usage, disabled when empty")
 }
 
-func (*compileCmd) SetFlags(f *flag.FlagSet) {}
+func (cc *compileCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if f.NArg() > 1 && cc.out != "" && !strings.Contains(cc.out, "{name}") {
+		errorf("error: -o needs {name} with several inputs, e.g. -o {name}.bin")
+		return subcommands.ExitUsageError
+	}
+
+	// no output may overwrite another one
+	sources := map[string]string{}
+	for _, file := range f.Args() {
+		out := cc.outputPath(file)
+		if other, ok := sources[out]; ok {
+			errorf("error: %s and %s would both be compiled to %s", other, file, out)
+			return subcommands.ExitUsageError
+		}
+		sources[out] = file
+	}
+
+	aliases, err := readAliases(cc.aliases)
+	if err != nil {
+		errorf("error reading aliases: %s", err)
+		return exitIO
+	}
+
+	var cache *compileCache
+	if !cc.obfusc && cc.maxSize == 0 {
+		cache = newCompileCache(cc.cacheDir, nil)
+	}
+	options := fmt.Sprintf("isa=%s word-size=%d registers=%d string-pool=%t signed=%t strip-symbols=%t strict=%t aliases=%v",
+		cc.isa, cc.wordSize, cc.regs, cc.pool, cc.signed, cc.strip, cc.strict, aliases)
 
-func (*compileCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
 	for _, file := range f.Args() {
-		input, err := os.ReadFile(file)
+		out := cc.outputPath(file)
+
+		source, err := os.ReadFile(file)
 		if err != nil {
-			fmt.Printf("error reading %s: %s", file, err.Error())
-			return subcommands.ExitFailure
+			errorf("error reading %s: %s", file, err.Error())
+			return exitIO
+		}
+
+		key := cache.key(filepath.Dir(file), options, source)
+		if program, ok := cache.lookup(key); ok {
+			verbosef("%s is unchanged, using the cached program", file)
+			if err = os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+				errorf("error creating the output directory: %s", err)
+				return exitIO
+			}
+			if err = os.WriteFile(out, program, 0644); err != nil {
+				errorf("error writing output file: %s", err)
+				return exitStatus(err, exitCompile)
+			}
+			_, code, _ := header.Decode(program)
+			infof("Generated bytecode is %d bytes long", len(code))
+			verbosef("wrote %s", out)
+			continue
+		}
+
+		c := compiler.New(lexer.New(string(source)))
+		if err = c.SetISA(cc.isa); err != nil {
+			errorf("error: %s", err)
+			return subcommands.ExitUsageError
+		}
+		if err = c.SetWordSize(cc.wordSize); err != nil {
+			errorf("error: %s", err)
+			return subcommands.ExitUsageError
+		}
+		if err = c.SetNumRegisters(cc.regs); err != nil {
+			errorf("error: %s", err)
+			return subcommands.ExitUsageError
+		}
+		if err = c.SetSigned(cc.signed); err != nil {
+			errorf("error: %s", err)
+			return subcommands.ExitUsageError
+		}
+		if err = c.SetAliases(aliases); err != nil {
+			errorf("error: %s", err)
+			return subcommands.ExitUsageError
+		}
+		c.SetStringPool(cc.pool)
+		c.SetStripSymbols(cc.strip)
+		c.SetStrict(cc.strict)
+		c.SetBaseDir(filepath.Dir(file))
+		c.SetWarnings(logWriter(levelInfo))
+		verbosef("compiling %s", file)
+		if err = c.Compile(); err != nil {
+			errorf("error compiling %s: %s", file, err.Error())
+			return exitCompile
 		}
 
-		l := lexer.New(string(input))
+		var original map[string]string
+		if cc.obfusc {
+			for _, label := range c.LabelsUsedAsValues() {
+				if cc.strict {
+					errorf("error: %s uses the address of :%s as a value, computing other addresses from it breaks once obfuscated", file, label)
+					return exitCompile
+				}
+				infof("warning: %s uses the address of :%s as a value, computing other addresses from it breaks once obfuscated", file, label)
+			}
+			original, err = c.Obfuscate(rand.New(rand.NewSource(time.Now().UnixNano())))
+			if err != nil {
+				errorf("error: %s", err)
+				return subcommands.ExitUsageError
+			}
+		}
+
+		if cc.maxSize > 0 {
+			if size := len(c.Output()) + c.PoolSize(); size > cc.maxSize {
+				errorf("error: %s is %d bytes long, which exceeds the budget of %d bytes by %d",
+					file, size, cc.maxSize, size-cc.maxSize)
+				for _, section := range c.Sections() {
+					errorf("  %04x %6d bytes  %s", section.Start, section.Size, originalNames(section.Name, original))
+				}
+				if c.PoolSize() > 0 {
+					errorf("       %6d bytes  (string pool)", c.PoolSize())
+				}
+				return exitCompile
+			}
+		}
 
-		c := compiler.New(l)
-		c.Compile()
+		if cc.strict {
+			if err = verify(c.Header(), c.Output()); err != nil {
+				errorf("error verifying %s: %s", file, err.Error())
+				return exitCompile
+			}
+		}
 
-		// remove original extension
-		name := strings.TrimSuffix(file, filepath.Ext(file))
+		if err = os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+			errorf("error creating the output directory: %s", err)
+			return exitIO
+		}
+
+		if original != nil && !cc.strip {
+			mapFile := strings.TrimSuffix(out, filepath.Ext(out)) + ".map"
+			if err = writeSymbolMap(mapFile, original); err != nil {
+				errorf("error writing symbol map: %s", err)
+				return exitIO
+			}
+		}
 
-		// add new extension and write
-		c.WriteFile(name + ".raw")
+		if err = c.WriteFile(out); err != nil {
+			errorf("error writing output file: %s", err)
+			return exitStatus(err, exitCompile)
+		}
+		infof("Generated bytecode is %d bytes long", len(c.Output()))
+		verbosef("wrote %s", out)
+
+		if err = cache.store(key, c); err != nil {
+			infof("warning: caching the program of %s: %s", file, err)
+		}
 	}
 	return subcommands.ExitSuccess
 }
+
+// outputPath returns the path the bytecode compiled from the given
+// source is written to, see -o and -out-dir
+func (cc *compileCmd) outputPath(file string) string {
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+
+	out := strings.TrimSuffix(file, filepath.Ext(file)) + ".raw"
+	switch {
+	case cc.out != "":
+		out = strings.ReplaceAll(cc.out, "{name}", name)
+	case cc.outDir != "":
+		out = name + ".raw"
+	}
+
+	if cc.outDir != "" && !filepath.IsAbs(out) {
+		out = filepath.Join(cc.outDir, out)
+	}
+	return out
+}
+
+// originalNames replaces the renamed labels in the name of a section,
+// see compiler.Section, by their original names
+func originalNames(name string, original map[string]string) string {
+	if original == nil {
+		return name
+	}
+	names := strings.Split(name, ", ")
+	for i, n := range names {
+		if o, ok := original[n]; ok {
+			names[i] = o
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// writeSymbolMap writes the original names of renamed labels, one
+// "renamed original" pair per line
+func writeSymbolMap(path string, original map[string]string) error {
+	names := make([]string, 0, len(original))
+	for renamed := range original {
+		names = append(names, renamed)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := strconv.Atoi(names[i][1:])
+		b, _ := strconv.Atoi(names[j][1:])
+		return a < b
+	})
+
+	var sb strings.Builder
+	for _, renamed := range names {
+		fmt.Fprintf(&sb, "%s %s\n", renamed, original[renamed])
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0644)
+}
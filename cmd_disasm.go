@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"os"
+	"sort"
+	"strings"
+	"vm/disasm"
+	"vm/header"
+)
+
+type disasmCmd struct {
+	xref bool
+}
+
+// disasmBank is the code of a memory bank, bank zero being the program
+type disasmBank struct {
+	num    int
+	base   int
+	code   []byte
+	instrs []disasm.Instruction
+	data   []int
+}
+
+func (*disasmCmd) Name() string { return "disasm" }
+
+func (*disasmCmd) Synopsis() string { return "Disassemble compiled programs." }
+
+func (*disasmCmd) Usage() string {
+	return `disasm [-xref] file...:
+List the instructions of the given compiled programs, preceded by the
+labels recorded in their headers, followed by the code placed into
+memory banks. The bytecode is decoded from its first byte on, so bytes
+which don't start an instruction are listed as data, while data which
+happens to look like instructions is listed as such.
+
+With -xref every label, and every address jumped to, called or stored
+in a register, is listed with the instructions referencing it instead,
+which helps to find the way around programs without their source. An
+integer is only taken for an address if it is the one of a label or of
+a jump or call, so programs compiled with -strip-symbols show fewer.
+Only programs of the register instruction set can be disassembled.
+`
+}
+
+func (d *disasmCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&d.xref, "xref", false, "list the references of every label and address instead of the instructions")
+}
+
+func (d *disasmCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if f.NArg() == 0 {
+		errorf("usage: disasm [-xref] file...")
+		return subcommands.ExitUsageError
+	}
+
+	for _, file := range f.Args() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			errorf("error reading %s: %s", file, err)
+			return exitIO
+		}
+		h, code, err := header.Decode(data)
+		if err != nil {
+			errorf("error reading header of %s: %s", file, err)
+			return exitIO
+		}
+		if h.Features&header.FeatStackISA != 0 {
+			errorf("error: %s: only programs of the register instruction set can be disassembled", file)
+			return subcommands.ExitFailure
+		}
+
+		banks := []disasmBank{disassembleBank(0, 0, code, h.WordSize)}
+		nums := make([]int, 0, len(h.Banks))
+		for n := range h.Banks {
+			nums = append(nums, n)
+		}
+		sort.Ints(nums)
+		for _, n := range nums {
+			banks = append(banks, disassembleBank(n, header.BankStart, h.Banks[n], h.WordSize))
+		}
+
+		fmt.Printf("%s:\n", file)
+		if d.xref {
+			printXRefs(banks, h.Symbols)
+		} else {
+			printListing(banks, h.Symbols)
+		}
+	}
+	return subcommands.ExitSuccess
+}
+
+// disassembleBank disassembles the code of a bank loaded at base
+func disassembleBank(num, base int, code []byte, wordSize int) disasmBank {
+	instrs, data := disasm.Disassemble(code, base, wordSize)
+	return disasmBank{num: num, base: base, code: code, instrs: instrs, data: data}
+}
+
+// labelsAt maps addresses to the labels at them, sorted by name
+func labelsAt(symbols map[string]int) map[int][]string {
+	at := map[int][]string{}
+	for name, addr := range symbols {
+		at[addr] = append(at[addr], name)
+	}
+	for _, names := range at {
+		sort.Strings(names)
+	}
+	return at
+}
+
+// printListing prints the instructions and data of the banks, with the
+// labels at their addresses
+func printListing(banks []disasmBank, symbols map[string]int) {
+	labels := labelsAt(symbols)
+
+	for _, b := range banks {
+		if b.num != 0 {
+			fmt.Printf("\n.bank %d\n", b.num)
+		}
+
+		// instructions and data bytes in the order of their addresses
+		lines := map[int]string{}
+		for _, ins := range b.instrs {
+			line := ins.String()
+			if _, ok := ins.Reference(); ok && len(labels[ins.Imm]) > 0 {
+				line += "  ; :" + strings.Join(labels[ins.Imm], ", :")
+			}
+			lines[ins.Addr] = line
+		}
+		for i := 0; i < len(b.data); {
+			// runs of up to 8 consecutive bytes share a line
+			j := i + 1
+			for j < len(b.data) && j-i < 8 && b.data[j] == b.data[j-1]+1 && labels[b.data[j]] == nil {
+				j++
+			}
+			bytes := make([]string, 0, j-i)
+			for _, addr := range b.data[i:j] {
+				bytes = append(bytes, fmt.Sprintf("0x%02x", b.code[addr-b.base]))
+			}
+			lines[b.data[i]] = "data " + strings.Join(bytes, ", ")
+			i = j
+		}
+
+		addrs := make([]int, 0, len(lines))
+		for addr := range lines {
+			addrs = append(addrs, addr)
+		}
+		sort.Ints(addrs)
+		for _, addr := range addrs {
+			for _, name := range labels[addr] {
+				fmt.Printf(":%s\n", name)
+			}
+			fmt.Printf("  %04x  %s\n", addr, lines[addr])
+		}
+	}
+}
+
+// printXRefs prints every label and referenced address of the banks
+// with the instructions referencing it
+func printXRefs(banks []disasmBank, symbols map[string]int) {
+	labels := labelsAt(symbols)
+
+	type ref struct {
+		bank int
+		disasm.Ref
+	}
+	refs := map[int][]ref{}
+	for _, b := range banks {
+		for addr, rs := range disasm.XRefs(b.instrs, symbols) {
+			for _, r := range rs {
+				refs[addr] = append(refs[addr], ref{bank: b.num, Ref: r})
+			}
+		}
+	}
+
+	addrs := make([]int, 0, len(refs)+len(labels))
+	for addr := range refs {
+		addrs = append(addrs, addr)
+	}
+	for addr := range labels {
+		if refs[addr] == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Ints(addrs)
+
+	for _, addr := range addrs {
+		name := ""
+		if names := labels[addr]; len(names) > 0 {
+			name = " :" + strings.Join(names, ", :")
+		}
+		if len(refs[addr]) == 0 {
+			fmt.Printf("  %04x%s  (no references)\n", addr, name)
+			continue
+		}
+		fmt.Printf("  %04x%s\n", addr, name)
+		for _, r := range refs[addr] {
+			bank := ""
+			if r.bank != 0 {
+				bank = fmt.Sprintf(" in bank %d", r.bank)
+			}
+			fmt.Printf("      %04x  %-32s %s%s\n", r.Instruction.Addr, r.Instruction, r.Kind, bank)
+		}
+	}
+}
@@ -26,16 +26,22 @@ func (*dumpCmd) SetFlags(f *flag.FlagSet) {}
 
 func (*dumpCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
 	for _, file := range f.Args() {
-		input, err := os.ReadFile(file)
+		input, err := os.Open(file)
 		if err != nil {
 			fmt.Printf("error reading %s: %s", file, err.Error())
-			return subcommands.ExitFailure
+			return exitIO
 		}
 
-		l := lexer.New(string(input))
+		l := lexer.NewReader(input)
 
 		c := compiler.New(l)
 		c.Dump()
+		input.Close()
+
+		if err = l.Err(); err != nil {
+			fmt.Printf("error reading %s: %s\n", file, err.Error())
+			return exitIO
+		}
 	}
 	return subcommands.ExitSuccess
 }
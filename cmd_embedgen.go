@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"github.com/google/subcommands"
+	"os"
+	"vm/embedgen"
+)
+
+type embedgenCmd struct {
+	pkg      string
+	out      string
+	wordSize int
+	isa      string
+	pool     bool
+	signed   bool
+	aliases  string
+}
+
+func (*embedgenCmd) Name() string { return "embedgen" }
+
+func (*embedgenCmd) Synopsis() string { return "Compile programs into Go source embedding them." }
+
+func (*embedgenCmd) Usage() string {
+	return `embedgen [flags] file...:
+Compile the given programs into Go source declaring each of them as a
+byte slice, which vm.Exec runs, and a map of its labels to their
+addresses, so Go applications ship their guest programs without
+compiling them at runtime or reading files.
+
+The names are derived from the files, e.g. Hello and HelloSymbols for
+hello.in. The package is the one go generate runs in unless -package
+is given, so a Go file of the package can contain
+
+  //go:generate go run vm embedgen -o programs.go hello.in
+
+The source is written to -o, or to STDOUT if it is empty.
+` + aliasesHelp
+}
+
+func (e *embedgenCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&e.pkg, "package", os.Getenv("GOPACKAGE"), "package of the generated source, the one of go generate by default")
+	f.StringVar(&e.out, "o", "", "output file, STDOUT if empty")
+	f.IntVar(&e.wordSize, "word-size", 16, "size of the machine word in bits: 8, 16 or 32")
+	f.StringVar(&e.isa, "isa", "register", "instruction set to compile for: register or stack")
+	f.BoolVar(&e.pool, "string-pool", true, "store string literals once in the string pool rather than inline")
+	f.BoolVar(&e.signed, "signed", false, "use signed integer registers, allowing negative integers")
+	f.StringVar(&e.aliases, "aliases", "", "file mapping custom mnemonics to keywords")
+}
+
+func (e *embedgenCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if f.NArg() == 0 {
+		errorf("usage: embedgen [flags] file...")
+		return subcommands.ExitUsageError
+	}
+	if e.pkg == "" {
+		errorf("error: -package is needed outside of go generate")
+		return subcommands.ExitUsageError
+	}
+
+	aliases, err := readAliases(e.aliases)
+	if err != nil {
+		errorf("error reading aliases: %s", err)
+		return exitIO
+	}
+	opts := embedgen.Options{ISA: e.isa, WordSize: e.wordSize, Signed: e.signed, StringPool: e.pool, Aliases: aliases}
+
+	var programs []embedgen.Program
+	for _, file := range f.Args() {
+		verbosef("compiling %s", file)
+		p, err := embedgen.Compile(file, opts)
+		if err != nil {
+			errorf("error: %s", err)
+			return exitCompile
+		}
+		programs = append(programs, p)
+	}
+
+	var src bytes.Buffer
+	if err = embedgen.Generate(&src, e.pkg, programs); err != nil {
+		errorf("error: %s", err)
+		return exitCompile
+	}
+
+	if e.out == "" {
+		os.Stdout.Write(src.Bytes())
+		return subcommands.ExitSuccess
+	}
+	if err = os.WriteFile(e.out, src.Bytes(), 0644); err != nil {
+		errorf("error writing %s: %s", e.out, err)
+		return exitIO
+	}
+	verbosef("wrote %d programs to %s", len(programs), e.out)
+	return subcommands.ExitSuccess
+}
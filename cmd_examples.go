@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"embed"
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// examplesFS contains the example programs, and the files they include
+//
+//go:embed examples/*.in examples/*.txt
+var examplesFS embed.FS
+
+type examplesCmd struct{}
+
+func (*examplesCmd) Name() string { return "examples" }
+
+func (*examplesCmd) Synopsis() string { return "List, show and run the bundled examples." }
+
+func (*examplesCmd) Usage() string {
+	return `examples [list]
+examples show <name>
+examples run <name> [run flags]:
+The example programs are built into the binary, so they can be explored
+without a checkout of the sources.
+
+list shows the name and the description of every example, show prints
+its source, and run compiles and runs it like the run subcommand. The
+flags the example needs, e.g. -isa stack, are applied automatically;
+further flags of run may follow the name.
+`
+}
+
+func (*examplesCmd) SetFlags(f *flag.FlagSet) {}
+
+func (*examplesCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...any) subcommands.ExitStatus {
+	action := f.Arg(0)
+	if action == "" {
+		action = "list"
+	}
+
+	if action == "list" {
+		if f.NArg() > 1 {
+			fmt.Println("usage: examples list")
+			return subcommands.ExitUsageError
+		}
+		return listExamples()
+	}
+
+	if f.NArg() < 2 || (action == "show" && f.NArg() != 2) {
+		fmt.Printf("usage: examples %s <name>\n", action)
+		return subcommands.ExitUsageError
+	}
+
+	file := path.Join("examples", f.Arg(1)+".in")
+	src, err := examplesFS.ReadFile(file)
+	if err != nil {
+		fmt.Printf("unknown example %s, see \"examples list\"\n", f.Arg(1))
+		return subcommands.ExitUsageError
+	}
+
+	switch action {
+	case "show":
+		fmt.Print(string(src))
+		return subcommands.ExitSuccess
+	case "run":
+		return runExample(ctx, file, string(src), f.Args()[2:], args...)
+	default:
+		fmt.Printf("unknown action %s, expected list, show or run\n", action)
+		return subcommands.ExitUsageError
+	}
+}
+
+// listExamples prints the name and the description of every example
+func listExamples() subcommands.ExitStatus {
+	files, err := fs.Glob(examplesFS, "examples/*.in")
+	if err != nil {
+		fmt.Println("error listing examples:", err)
+		return subcommands.ExitFailure
+	}
+
+	width := 0
+	for _, file := range files {
+		width = max(width, len(exampleName(file)))
+	}
+	for _, file := range files {
+		src, err := examplesFS.ReadFile(file)
+		if err != nil {
+			fmt.Println("error reading example:", err)
+			return subcommands.ExitFailure
+		}
+		about, _ := exampleHeader(string(src))
+		fmt.Printf("%-*s  %s\n", width, exampleName(file), about)
+	}
+	return subcommands.ExitSuccess
+}
+
+// runExample runs the example with the run subcommand, reading it from
+// the embedded files
+func runExample(ctx context.Context, file, src string, extra []string, args ...any) subcommands.ExitStatus {
+	_, flags := exampleHeader(src)
+
+	r := &runCmd{fsys: examplesFS}
+	f := flag.NewFlagSet("run", flag.ContinueOnError)
+	r.SetFlags(f)
+	if err := settings.apply("run", f); err != nil {
+		fmt.Println("error reading configuration:", err)
+		return subcommands.ExitUsageError
+	}
+	if err := f.Parse(append(append(flags, extra...), file)); err != nil {
+		return subcommands.ExitUsageError
+	}
+	return r.Execute(ctx, f, args...)
+}
+
+// exampleName returns the name of the example in the given file, e.g.
+// "hello" for "examples/hello.in"
+func exampleName(file string) string {
+	return strings.TrimSuffix(path.Base(file), ".in")
+}
+
+// exampleHeader returns the first sentence of the "About" section of the
+// comment at the top of an example, and the flags used to run it in the
+// "Usage" section, e.g. "-isa stack" for
+//
+//	#  go run . run -isa stack ./examples/stack_machine.in
+func exampleHeader(src string) (string, []string) {
+	var about []string
+	var flags []string
+	section := ""
+	paragraph := true // still in the first paragraph of the section
+
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		text := strings.TrimSpace(strings.TrimPrefix(line, "#"))
+
+		switch {
+		case strings.HasSuffix(text, ":") && !strings.Contains(text, " "):
+			section = text
+		case section == "About:" && text == "":
+			paragraph = len(about) == 0
+		case section == "About:" && paragraph:
+			about = append(about, text)
+		case section == "Usage:" && flags == nil && strings.Contains(text, "go run . run "):
+			fields := strings.Fields(text[strings.Index(text, "go run . run ")+len("go run . run "):])
+			flags = []string{}
+			for _, field := range fields {
+				// the flags precede the path of the example
+				if strings.HasPrefix(field, "./") {
+					break
+				}
+				flags = append(flags, field)
+			}
+		}
+	}
+	sentence := strings.Join(about, " ")
+	if end := strings.Index(sentence, ". "); end >= 0 {
+		sentence = sentence[:end+1]
+	}
+	return sentence, flags
+}
@@ -5,10 +5,34 @@ import (
 	"flag"
 	"fmt"
 	"github.com/google/subcommands"
+	"time"
 	"vm/cpu"
+	"vm/header"
+	"vm/taint"
 )
 
-type executeCmd struct{}
+type executeCmd struct {
+	allow     string
+	regs      int
+	dryRun    bool
+	selfCheck bool
+	taint     bool
+	watchdog  int
+	abort     bool
+	timeout   time.Duration
+	memory    int
+	clock     string
+	seed      int64
+	signals   string
+	timer     string
+	ports     string
+	provider  string
+	ramStack  bool
+	output    int
+	truncate  bool
+	protect   bool
+	layout    bool
+}
 
 func (*executeCmd) Name() string { return "execute" }
 
@@ -17,23 +41,254 @@ func (*executeCmd) Synopsis() string { return "Execute a compiled program." }
 func (*executeCmd) Usage() string {
 	return `execute:
 Execute the bytecode contained in the given input file.
-`
+
+With -dry-run side effects on the host, such as executing binaries via
+SYSTEM, are reported instead of being performed.
+
+With -selfcheck no file is needed: a built-in program exercising every
+opcode is run and its behavior compared to the expected results, which
+is a quick smoke test of the interpreter.
+
+With -taint data read from input traps is tracked while the program
+runs, and a warning is printed whenever it reaches the command of
+SYSTEM or is written into the code via POKE or MEM_CPY.
+
+With -watchdog a warning is printed when the program keeps returning to
+the same state without any I/O, which means it is stuck in a loop.
+-watchdog-abort stops the program instead.
+
+With -max-memory the program is stopped once its strings, hashes and
+stacks use more than the given number of bytes of host memory. The
+peak usage is reported with -v.
+
+With -max-output the output of the program, STDOUT and STDERR together,
+is truncated after the given number of bytes and a marker is printed in
+place of the rest, protecting the reader against programs printing
+endlessly. The program keeps running without printing anything, unless
+-max-output-abort stops it.
+
+With -clock the program reads the time from a fake clock starting at
+the given RFC 3339 time, e.g. 2024-01-01T00:00:00Z, which advances by
+a millisecond on every reading, so INT_RAND and TIME return the same
+numbers on every run.
+
+With -seed the random numbers of "rand" are drawn from a stream with
+the given seed instead of one seeded from the clock, so they are the
+same on every run without faking the time.
+
+With -signals the host signals SIGHUP, SIGUSR1 and SIGUSR2 raise
+interrupts of the program, e.g. -signals hup=0,usr1=1, whose handlers
+are registered via trap 7, so long-running programs can reload or shut
+down themselves. A handler runs between two instructions like a called
+subroutine, and the flags are restored once it returns.
+
+With -ram-stack the stack lives at the top of the memory of the program
+instead of the host, so its entries can be inspected via PEEK, it
+overflows once it reaches 4 KiB, and only integers can be pushed.
+Programs using SP_GET or SP_SET always run with the stack in RAM.
+
+With -protect-code the memory the program is loaded into is read-only:
+POKE, POKE16, POKE_STR and MEM_CPY writing into it stop the program
+with an error rather than silently overwriting the code, e.g. via a
+wrong address. Data declared in the program is read-only too.
+
+A program ending with an exit code, e.g. "exit 1" or "exit #1", makes
+the command exit with it, and later programs aren't run.
+` + layoutHelp + timerHelp + portsHelp + trapProviderHelp
 }
 
-func (*executeCmd) SetFlags(f *flag.FlagSet) {}
+func (e *executeCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&e.allow, "allow", "all", "comma-separated capabilities the program may use (system, file, net, all or none)")
+	f.IntVar(&e.regs, "registers", header.DefaultNumRegisters, "number of registers of the CPU, 1 to 254")
+	f.BoolVar(&e.dryRun, "dry-run", false, "report side effects instead of performing them")
+	f.BoolVar(&e.selfCheck, "selfcheck", false, "check the behavior of every opcode and exit")
+	f.BoolVar(&e.taint, "taint", false, "report input data reaching SYSTEM or code memory")
+	f.IntVar(&e.watchdog, "watchdog", 0, "report a probable infinite loop once a state repeats this often, disabled when zero")
+	f.BoolVar(&e.abort, "watchdog-abort", false, "stop the program when the watchdog reports a loop")
+	f.DurationVar(&e.timeout, "timeout", 0, "stop the program after this time, e.g. 2s, unlimited when zero")
+	f.IntVar(&e.memory, "max-memory", 0, "stop the program using more bytes of host memory for strings and stacks, unlimited when zero")
+	f.IntVar(&e.output, "max-output", 0, "truncate the output of the program after this many bytes, unlimited when zero")
+	f.BoolVar(&e.truncate, "max-output-abort", false, "stop the program when its output is truncated")
+	f.StringVar(&e.clock, "clock", "", "RFC 3339 start time of a fake clock making the program deterministic")
+	f.Int64Var(&e.seed, "seed", 0, "seed of the random numbers, making them the same on every run, from the clock when zero")
+	f.StringVar(&e.signals, "signals", "", "host signals raising interrupts of the program, e.g. hup=0,usr1=1")
+	f.StringVar(&e.timer, "timer", "", "raise an interrupt periodically, e.g. 0=1000 instructions or 0=10ms, see the usage")
+	f.StringVar(&e.ports, "ports", "", "named pipes or Unix sockets connected to ports of the program, e.g. 0=/tmp/vm.fifo,1=unix:/run/app.sock")
+	f.StringVar(&e.provider, "trap-provider", "", "command implementing additional traps, see the usage")
+	f.BoolVar(&e.ramStack, "ram-stack", false, "keep the stack in the memory of the program, see the usage")
+	f.BoolVar(&e.protect, "protect-code", false, "stop the program when it writes into its own code")
+	f.BoolVar(&e.layout, "memory-layout", false, "print how the program used the memory once it ended")
+}
+
+func (e *executeCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	allowed, err := header.ParseCapabilities(e.allow)
+	if err != nil {
+		errorf("error parsing -allow: %s", err)
+		return subcommands.ExitUsageError
+	}
+	clock, err := parseClock(e.clock)
+	if err != nil {
+		errorf("error parsing -clock: %s", err)
+		return subcommands.ExitUsageError
+	}
+	signals, err := parseSignals(e.signals)
+	if err != nil {
+		errorf("error parsing -signals: %s", err)
+		return subcommands.ExitUsageError
+	}
+	timer, err := parseTimer(e.timer)
+	if err != nil {
+		errorf("error parsing -timer: %s", err)
+		return subcommands.ExitUsageError
+	}
+	ports, err := parsePorts(e.ports)
+	if err != nil {
+		errorf("error parsing -ports: %s", err)
+		return subcommands.ExitUsageError
+	}
+
+	if e.selfCheck {
+		return runSelfChecks()
+	}
 
-func (*executeCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
 	for _, file := range f.Args() {
 		c := cpu.NewCPU()
+		if err := c.SetNumRegisters(e.regs); err != nil {
+			errorf("error: %s", err)
+			return subcommands.ExitUsageError
+		}
+		c.SetAllowedCapabilities(allowed)
+		c.SetDryRun(e.dryRun)
+		c.SetMemoryLimit(e.memory)
+		c.SetOutputLimit(e.output, e.truncate)
+		c.SetClock(clock)
+		if e.seed != 0 {
+			c.SetSeed(e.seed)
+		}
+		if e.taint {
+			trackTaint(c)
+		}
+		if e.watchdog > 0 {
+			watchLoops(c, e.watchdog, e.abort)
+		}
 
-		if err := c.ReadFile(file); err != nil {
-			fmt.Println("error reading file:", err)
+		var opts []cpu.LoadOption
+		if e.protect {
+			opts = append(opts, cpu.ReadOnlyCode())
 		}
+		if err := c.ReadFile(file, opts...); err != nil {
+			errorf("error reading file: %s", err)
+			return exitStatus(err, subcommands.ExitFailure)
+		}
+		if e.ramStack {
+			if c.StackISA() {
+				errorf("error: -ram-stack requires the register instruction set")
+				return subcommands.ExitUsageError
+			}
+			c.SetRAMStack(true)
+		}
+		verbosef("executing %s", file)
+		debugf("%s: %d bytes of code, %d-bit words, stack ISA %t, signed %t, stack in RAM %t",
+			file, c.CodeSize(), c.WordSize(), c.StackISA(), c.Signed(), c.RAMStack())
 
-		if err := c.Run(); err != nil {
-			fmt.Println("error running file:", err)
+		disconnect, err := connectPorts(c, ports)
+		if err != nil {
+			errorf("error connecting ports: %s", err)
+			return exitIO
+		}
+		stopProvider, err := startTrapProvider(c, e.provider)
+		if err != nil {
+			disconnect()
+			errorf("error starting the trap provider: %s", err)
+			return exitIO
+		}
+		stopTimer, err := startTimer(c, timer)
+		if err != nil {
+			disconnect()
+			stopProvider()
+			errorf("error starting the timer: %s", err)
 			return subcommands.ExitFailure
 		}
+		cancel := limitTime(c, e.timeout)
+		stop := forwardSignals(c, signals)
+		err = c.Run()
+		stop()
+		stopTimer()
+		cancel()
+		disconnect()
+		stopProvider()
+		verbosef("%s: peak memory usage %d bytes", file, c.PeakMemoryUsage())
+		if e.layout {
+			printLayout(file, c.MemoryLayout())
+		}
+		if c.OutputTruncated() {
+			infof("%s: output truncated after %d bytes", file, e.output)
+		}
+		if err != nil {
+			errorf("error running file: %s", err)
+			return exitStatus(err, exitRuntime)
+		}
+		if code := c.ExitCode(); code != 0 {
+			verbosef("%s: exit code %d", file, code)
+			return subcommands.ExitStatus(code)
+		}
+	}
+	return subcommands.ExitSuccess
+}
+
+// trackTaint prints a warning whenever data read from input traps
+// reaches a sensitive operation of the program running on c
+func trackTaint(c *cpu.CPU) {
+	c.AddObserver(taint.New(func(r taint.Report) {
+		errorf("[taint] %s %s: %s", c.Locate(r.IP), r.Instruction, r.Message)
+	}))
+}
+
+// watchLoops prints a warning whenever the program running on c is
+// probably stuck in an infinite loop, or stops it if abort is set
+func watchLoops(c *cpu.CPU, limit int, abort bool) {
+	c.AddObserver(cpu.NewWatchdog(limit, abort, func(msg string) {
+		errorf("[watchdog] %s", msg)
+	}))
+}
+
+// parseClock returns the clock given by the -clock flag: the clock of the
+// host if the value is empty, otherwise a fake clock starting at the
+// given time, advancing by a millisecond on every reading
+func parseClock(value string) (cpu.Clock, error) {
+	if value == "" {
+		return nil, nil
+	}
+	start, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return cpu.NewFakeClock(start, time.Millisecond), nil
+}
+
+// limitTime stops the program running on c after the given time,
+// unless it is zero. The returned function releases the timer.
+func limitTime(c *cpu.CPU, timeout time.Duration) context.CancelFunc {
+	if timeout <= 0 {
+		return func() {}
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	c.SetContext(ctx)
+	return cancel
+}
+
+// runSelfChecks runs the built-in opcode checks and reports failures
+func runSelfChecks() subcommands.ExitStatus {
+	failures := cpu.RunSelfChecks()
+	for _, failure := range failures {
+		fmt.Println("FAIL", failure)
+	}
+
+	if len(failures) > 0 {
+		fmt.Printf("%d of the self-checks failed\n", len(failures))
+		return subcommands.ExitFailure
+	}
+
+	fmt.Printf("all %d self-checks passed\n", len(cpu.SelfChecks))
 	return subcommands.ExitSuccess
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+	"vm/fuzzgen"
+)
+
+type fuzzgenCmd struct {
+	size  int
+	subs  int
+	seed  int64
+	count int
+}
+
+func (*fuzzgenCmd) Name() string { return "fuzzgen" }
+
+func (*fuzzgenCmd) Synopsis() string { return "Generate random programs for stress testing." }
+
+func (*fuzzgenCmd) Usage() string {
+	return `fuzzgen [directory]:
+Generate random, but structurally valid, programs which always compile
+and terminate with EXIT.
+
+Without a directory a single program is written to STDOUT, otherwise
+-count programs are written to the directory as fuzz_NNNN.in.
+`
+}
+
+func (f *fuzzgenCmd) SetFlags(fs *flag.FlagSet) {
+	fs.IntVar(&f.size, "size", 50, "number of instructions in the main program and in every subroutine")
+	fs.IntVar(&f.subs, "subs", 3, "number of subroutines")
+	fs.Int64Var(&f.seed, "seed", 0, "random seed, the current time is used when zero")
+	fs.IntVar(&f.count, "count", 1, "number of programs to write to the directory")
+}
+
+func (f *fuzzgenCmd) Execute(_ context.Context, fs *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	seed := f.seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	r := rand.New(rand.NewSource(seed))
+	opts := fuzzgen.Options{Instructions: f.size, Subroutines: f.subs}
+
+	if fs.NArg() == 0 {
+		fmt.Print(fuzzgen.Generate(r, opts))
+		return subcommands.ExitSuccess
+	}
+
+	dir := fs.Arg(0)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Printf("error creating %s: %s\n", dir, err.Error())
+		return exitIO
+	}
+
+	for i := 0; i < f.count; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("fuzz_%04d.in", i))
+		if err := os.WriteFile(path, []byte(fuzzgen.Generate(r, opts)), 0644); err != nil {
+			fmt.Printf("error writing %s: %s\n", path, err.Error())
+			return exitIO
+		}
+	}
+	infof("Generated %d programs in %s using seed %d", f.count, dir, seed)
+	return subcommands.ExitSuccess
+}
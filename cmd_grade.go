@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"os"
+	"time"
+	"vm/grade"
+	"vm/header"
+)
+
+type gradeCmd struct {
+	allow   string
+	timeout time.Duration
+	output  int
+	norm    grade.Normalization
+}
+
+func (*gradeCmd) Name() string { return "grade" }
+
+func (*gradeCmd) Synopsis() string { return "Grade a compiled program against test cases." }
+
+func (*gradeCmd) Usage() string {
+	return `grade [flags] program.raw cases-dir:
+Run the given compiled program against every test case in the directory,
+each on a fresh CPU, and print a JSON report of the passed cases.
+
+Every case NAME consists of the files NAME.expected, the output the
+program must produce, NAME.input, read by the input trap, and the
+optional NAME.limits overriding the limits given via flags, one per
+line, e.g. "timeout 2s", "allow system" or "max_output 4096". A program
+printing more than the output limit is stopped and fails the case.
+
+The output has to match the expected output exactly, unless relaxed by
+-trim-space, -ignore-case or -numeric. With -numeric unprefixed numbers
+printed by the program are read as hexadecimal, the format of print_int,
+and those of the expected output as decimal, unless changed via
+-output-radix and -expected-radix. Failed cases include the lines which
+differ.
+`
+}
+
+func (g *gradeCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&g.allow, "allow", "none", "comma-separated capabilities the program may use (system, file, net, all or none)")
+	f.DurationVar(&g.timeout, "timeout", grade.DefaultLimits.Timeout, "maximum run time of the program per case")
+	f.IntVar(&g.output, "max-output", grade.DefaultLimits.MaxOutput, "maximum number of bytes the program may print per case, unlimited when zero")
+	f.BoolVar(&g.norm.TrimSpace, "trim-space", false, "ignore trailing whitespace and trailing empty lines")
+	f.BoolVar(&g.norm.IgnoreCase, "ignore-case", false, "compare the output case-insensitively")
+	f.BoolVar(&g.norm.Numeric, "numeric", false, "compare numbers by their value, e.g. 0x1f and 31")
+	f.IntVar(&g.norm.OutputRadix, "output-radix", 16, "radix of unprefixed numbers in the program output, print_int prints hexadecimal")
+	f.IntVar(&g.norm.ExpectedRadix, "expected-radix", 10, "radix of unprefixed numbers in the expected output")
+}
+
+func (g *gradeCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if f.NArg() != 2 {
+		fmt.Println("usage: grade [flags] program.raw cases-dir")
+		return subcommands.ExitUsageError
+	}
+
+	allowed, err := header.ParseCapabilities(g.allow)
+	if err != nil {
+		fmt.Println("error parsing -allow:", err)
+		return subcommands.ExitUsageError
+	}
+
+	for _, radix := range []int{g.norm.OutputRadix, g.norm.ExpectedRadix} {
+		if radix < 2 || radix > 16 {
+			fmt.Printf("invalid radix %d, it must be between 2 and 16\n", radix)
+			return subcommands.ExitUsageError
+		}
+	}
+
+	cases, err := grade.LoadCases(f.Arg(1), grade.Limits{Timeout: g.timeout, Allowed: allowed, MaxOutput: g.output})
+	if err != nil {
+		fmt.Println("error loading test cases:", err)
+		return exitStatus(err, subcommands.ExitFailure)
+	}
+
+	report := grade.Grade(f.Arg(0), cases, g.norm)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(report); err != nil {
+		fmt.Println("error writing report:", err)
+		return exitIO
+	}
+
+	if report.Passed < report.Total {
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
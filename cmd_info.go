@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"os"
+	"sort"
+	"vm/cpu"
+	"vm/header"
+)
+
+type infoCmd struct{}
+
+func (*infoCmd) Name() string { return "info" }
+
+func (*infoCmd) Synopsis() string { return "Show information about a compiled program." }
+
+func (*infoCmd) Usage() string {
+	return `info:
+Show the metadata, size, entry point and required capabilities of the
+given compiled program, without running it. The memory the code takes
+up is drawn as a bar, one character per KiB, see -memory-layout of run,
+followed by the size of the code and data placed into memory banks.
+`
+}
+
+func (*infoCmd) SetFlags(f *flag.FlagSet) {}
+
+func (*infoCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	for _, file := range f.Args() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Printf("error reading %s: %s", file, err.Error())
+			return exitIO
+		}
+
+		h, code, err := header.Decode(data)
+		if err != nil {
+			fmt.Printf("error reading header of %s: %s\n", file, err.Error())
+			return subcommands.ExitFailure
+		}
+
+		fmt.Printf("%s:\n", file)
+		if h.Version == 0 {
+			fmt.Printf("  header:       none (raw bytecode)\n")
+		} else {
+			fmt.Printf("  header:       version %d\n", h.Version)
+		}
+
+		keys := make([]string, 0, len(h.Meta))
+		for key := range h.Meta {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("  %-13s %s\n", key+":", h.Meta[key])
+		}
+
+		fmt.Printf("  size:         %d bytes\n", len(code))
+		fmt.Printf("  memory:       %s\n", layoutBar(cpu.MemoryLayout{Code: cpu.Region{End: len(code)}}))
+		banks := make([]int, 0, len(h.Banks))
+		for bank := range h.Banks {
+			banks = append(banks, bank)
+		}
+		sort.Ints(banks)
+		for _, bank := range banks {
+			fmt.Printf("  bank %-8s %d bytes at %04x\n", fmt.Sprintf("%d:", bank), len(h.Banks[bank]), header.BankStart)
+		}
+		fmt.Printf("  entry point:  %04x\n", h.Entry)
+		fmt.Printf("  word size:    %d bits\n", h.WordSize)
+		if h.Registers > 0 {
+			fmt.Printf("  registers:    %d used\n", h.Registers)
+		}
+		fmt.Printf("  capabilities: %s\n", h.Capabilities)
+		fmt.Printf("  features:     %s\n", h.Features)
+		if err = h.CheckFeatures(); err != nil {
+			fmt.Printf("  warning:      %s\n", err.Error())
+		}
+	}
+	return subcommands.ExitSuccess
+}
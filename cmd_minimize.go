@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"os"
+	"strings"
+	"time"
+	"vm/header"
+	"vm/minimize"
+	"vm/vm"
+)
+
+type minimizeCmd struct {
+	errorText  string
+	outputText string
+	stdin      string
+	out        string
+	allow      string
+	isa        string
+	wordSize   int
+	signed     bool
+	timeout    time.Duration
+}
+
+func (*minimizeCmd) Name() string { return "minimize" }
+
+func (*minimizeCmd) Synopsis() string { return "Reduce a failing program to a minimal reproducer." }
+
+func (*minimizeCmd) Usage() string {
+	return `minimize [flags] program.in:
+Remove lines of the given source program as long as it still fails the
+same way, and print the smallest program found.
+
+The program fails if it compiles but stops with a runtime error, which
+must contain the text given by -error, if any. With -output it fails if
+its output contains the given text instead, e.g. to reduce a program
+printing a wrong result. Timeouts are runtime errors, so -error timeout
+reduces programs which hang.
+
+Every candidate runs on a fresh CPU with the given input, without any
+capabilities unless -allow is used.
+`
+}
+
+func (m *minimizeCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&m.errorText, "error", "", "text the runtime error must contain")
+	f.StringVar(&m.outputText, "output", "", "text the output must contain, instead of a runtime error")
+	f.StringVar(&m.stdin, "stdin", "", "file with the input of the program")
+	f.StringVar(&m.out, "o", "", "file to write the minimized program to, STDOUT by default")
+	f.StringVar(&m.allow, "allow", "none", "comma-separated capabilities the program may use (system, file, net, all or none)")
+	f.StringVar(&m.isa, "isa", "register", "instruction set to compile for: register or stack")
+	f.IntVar(&m.wordSize, "word-size", 16, "size of the machine word in bits: 8, 16 or 32")
+	f.BoolVar(&m.signed, "signed", false, "use signed integer registers, allowing negative integers")
+	f.DurationVar(&m.timeout, "timeout", time.Second, "stop each candidate after this time")
+}
+
+func (m *minimizeCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if f.NArg() != 1 {
+		fmt.Println("usage: minimize [flags] program.in")
+		return subcommands.ExitUsageError
+	}
+	if m.errorText != "" && m.outputText != "" {
+		fmt.Println("error: -error and -output can't be used together")
+		return subcommands.ExitUsageError
+	}
+
+	allowed, err := header.ParseCapabilities(m.allow)
+	if err != nil {
+		fmt.Println("error parsing -allow:", err)
+		return subcommands.ExitUsageError
+	}
+
+	src, err := os.ReadFile(f.Arg(0))
+	if err != nil {
+		fmt.Printf("error reading %s: %s\n", f.Arg(0), err.Error())
+		return exitIO
+	}
+	var stdin []byte
+	if m.stdin != "" {
+		if stdin, err = os.ReadFile(m.stdin); err != nil {
+			fmt.Printf("error reading %s: %s\n", m.stdin, err.Error())
+			return exitIO
+		}
+	}
+
+	opts := []vm.Option{
+		vm.WithTimeout(m.timeout), vm.WithCapabilities(allowed),
+		vm.WithISA(m.isa), vm.WithWordSize(m.wordSize), vm.WithSigned(m.signed),
+	}
+	fails := func(lines []string) bool {
+		stdout, _, result, err := vm.Eval(strings.Join(lines, "\n"), string(stdin), opts...)
+		switch {
+		case !result.Compiled:
+			return false
+		case m.outputText != "":
+			return strings.Contains(stdout, m.outputText)
+		default:
+			return err != nil && strings.Contains(err.Error(), m.errorText)
+		}
+	}
+
+	lines := strings.Split(strings.TrimRight(string(src), "\n"), "\n")
+	if !fails(lines) {
+		fmt.Printf("%s doesn't fail the given way, there is nothing to minimize\n", f.Arg(0))
+		return subcommands.ExitFailure
+	}
+
+	minimal, tests := minimize.Lines(lines, fails)
+	infof("reduced %d lines to %d in %d runs", len(lines), len(minimal), tests)
+
+	result := strings.Join(minimal, "\n") + "\n"
+	if m.out == "" {
+		fmt.Print(result)
+		return subcommands.ExitSuccess
+	}
+	if err = os.WriteFile(m.out, []byte(result), 0644); err != nil {
+		fmt.Println("error writing output file:", err)
+		return exitIO
+	}
+	return subcommands.ExitSuccess
+}
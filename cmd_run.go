@@ -5,13 +5,50 @@ import (
 	"flag"
 	"fmt"
 	"github.com/google/subcommands"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"time"
 	"vm/compiler"
 	"vm/cpu"
+	"vm/header"
 	"vm/lexer"
 )
 
-type runCmd struct{}
+type runCmd struct {
+	allow    string
+	shared   bool
+	wordSize int
+	regs     int
+	isa      string
+	taint    bool
+	signed   bool
+	watchdog int
+	abort    bool
+	timeout  time.Duration
+	memory   int
+	clock    string
+	seed     int64
+	signals  string
+	timer    string
+	ports    string
+	provider string
+	aliases  string
+	strict   bool
+	ramStack bool
+	output   int
+	truncate bool
+	cacheDir string
+	overflow bool
+	matrix   bool
+	protect  bool
+	layout   bool
+
+	// fsys is the file system the programs are read from, the host's
+	// if nil, e.g. the examples embedded in the binary
+	fsys fs.FS
+}
 
 func (*runCmd) Name() string { return "run" }
 
@@ -20,31 +57,354 @@ func (*runCmd) Synopsis() string { return "Run the given source program." }
 func (*runCmd) Usage() string {
 	return `run:
 Run subcommand compiles the given source program and then executes it immediately.
-`
+
+When several programs are given each one runs on a fresh CPU, unless
+-shared-state is used, in which case they run one after another on the
+same CPU keeping registers, the stack and memory.
+
+With -taint data read from input traps is tracked while the program
+runs, and a warning is printed whenever it reaches the command of
+SYSTEM or is written into the code via POKE or MEM_CPY.
+
+With -watchdog a warning is printed when the program keeps returning to
+the same state without any I/O, which means it is stuck in a loop.
+-watchdog-abort stops the program instead.
+
+With -max-memory the program is stopped once its strings, hashes and
+stacks use more than the given number of bytes of host memory. The
+peak usage is reported with -v.
+
+With -max-output the output of the program, STDOUT and STDERR together,
+is truncated after the given number of bytes and a marker is printed in
+place of the rest, protecting the reader against programs printing
+endlessly. The program keeps running without printing anything, unless
+-max-output-abort stops it.
+
+With -clock the program reads the time from a fake clock starting at
+the given RFC 3339 time, e.g. 2024-01-01T00:00:00Z, which advances by
+a millisecond on every reading, so INT_RAND and TIME return the same
+numbers on every run.
+
+With -seed the random numbers of "rand" are drawn from a stream with
+the given seed instead of one seeded from the clock, so they are the
+same on every run without faking the time.
+
+With -signals the host signals SIGHUP, SIGUSR1 and SIGUSR2 raise
+interrupts of the program, e.g. -signals hup=0,usr1=1, whose handlers
+are registered via trap 7, so long-running programs can reload or shut
+down themselves. A handler runs between two instructions like a called
+subroutine, and the flags are restored once it returns.
+
+With -ram-stack the stack lives at the top of the memory of the program
+instead of the host, so its entries can be inspected via PEEK, it
+overflows once it reaches 4 KiB, and only integers can be pushed.
+Programs using SP_GET or SP_SET always run with the stack in RAM.
+
+With -protect-code the memory the program is loaded into is read-only:
+POKE, POKE16, POKE_STR and MEM_CPY writing into it stop the program
+with an error rather than silently overwriting the code, e.g. via a
+wrong address. Data declared in the program is read-only too.
+
+A program ending with an exit code, e.g. "exit 1" or "exit #1", makes
+the command exit with it, and later programs aren't run.
+` + layoutHelp + matrixHelp + timerHelp + portsHelp + trapProviderHelp + strictHelp + aliasesHelp + cacheHelp
+}
+
+func (r *runCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&r.allow, "allow", "all", "comma-separated capabilities the program may use (system, file, net, all or none)")
+	f.BoolVar(&r.shared, "shared-state", false, "run all the given programs on the same CPU")
+	f.IntVar(&r.wordSize, "word-size", 16, "size of the machine word in bits: 8, 16 or 32")
+	f.IntVar(&r.regs, "registers", header.DefaultNumRegisters, "number of registers of the CPU, 1 to 254")
+	f.StringVar(&r.isa, "isa", "register", "instruction set to compile for: register or stack")
+	f.BoolVar(&r.signed, "signed", false, "use signed integer registers, allowing negative integers")
+	f.BoolVar(&r.taint, "taint", false, "report input data reaching SYSTEM or code memory")
+	f.IntVar(&r.watchdog, "watchdog", 0, "report a probable infinite loop once a state repeats this often, disabled when zero")
+	f.BoolVar(&r.abort, "watchdog-abort", false, "stop the program when the watchdog reports a loop")
+	f.DurationVar(&r.timeout, "timeout", 0, "stop each program after this time, e.g. 2s, unlimited when zero")
+	f.IntVar(&r.memory, "max-memory", 0, "stop a program using more bytes of host memory for strings and stacks, unlimited when zero")
+	f.IntVar(&r.output, "max-output", 0, "truncate the output of each program after this many bytes, unlimited when zero")
+	f.BoolVar(&r.truncate, "max-output-abort", false, "stop the program when its output is truncated")
+	f.StringVar(&r.clock, "clock", "", "RFC 3339 start time of a fake clock making the program deterministic")
+	f.Int64Var(&r.seed, "seed", 0, "seed of the random numbers, making them the same on every run, from the clock when zero")
+	f.StringVar(&r.signals, "signals", "", "host signals raising interrupts of the program, e.g. hup=0,usr1=1")
+	f.StringVar(&r.timer, "timer", "", "raise an interrupt periodically, e.g. 0=1000 instructions or 0=10ms, see the usage")
+	f.StringVar(&r.ports, "ports", "", "named pipes or Unix sockets connected to ports of the program, e.g. 0=/tmp/vm.fifo,1=unix:/run/app.sock")
+	f.StringVar(&r.provider, "trap-provider", "", "command implementing additional traps, see the usage")
+	f.StringVar(&r.aliases, "aliases", "", "file mapping custom mnemonics to keywords")
+	f.BoolVar(&r.strict, "strict", false, "treat warnings as errors, verify the program and limit its time")
+	f.BoolVar(&r.ramStack, "ram-stack", false, "keep the stack in the memory of the program, see the usage")
+	f.BoolVar(&r.protect, "protect-code", false, "stop the program when it writes into its own code")
+	f.BoolVar(&r.layout, "memory-layout", false, "print how the program used the memory once it ended")
+	f.BoolVar(&r.overflow, "fault-on-overflow", false, "stop the program when an integer doesn't fit in the word, rather than clamping it")
+	f.BoolVar(&r.matrix, "matrix", false, "run each program with and without -fault-on-overflow and report where they differ")
+	f.StringVar(&r.cacheDir, "cache-dir", "", "directory compiled programs are cached in, see the usage, disabled when empty")
 }
 
-func (*runCmd) SetFlags(f *flag.FlagSet) {}
+func (r *runCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	allowed, err := header.ParseCapabilities(r.allow)
+	if err != nil {
+		errorf("error parsing -allow: %s", err)
+		return subcommands.ExitUsageError
+	}
+	clock, err := parseClock(r.clock)
+	if err != nil {
+		errorf("error parsing -clock: %s", err)
+		return subcommands.ExitUsageError
+	}
+	signals, err := parseSignals(r.signals)
+	if err != nil {
+		errorf("error parsing -signals: %s", err)
+		return subcommands.ExitUsageError
+	}
+	timer, err := parseTimer(r.timer)
+	if err != nil {
+		errorf("error parsing -timer: %s", err)
+		return subcommands.ExitUsageError
+	}
+	ports, err := parsePorts(r.ports)
+	if err != nil {
+		errorf("error parsing -ports: %s", err)
+		return subcommands.ExitUsageError
+	}
+
+	if r.regs < 1 || r.regs > header.MaxNumRegisters {
+		errorf("error: -registers must be 1 to %d", header.MaxNumRegisters)
+		return subcommands.ExitUsageError
+	}
+
+	if r.ramStack && r.isa == "stack" {
+		errorf("error: -ram-stack requires the register instruction set")
+		return subcommands.ExitUsageError
+	}
+
+	var input []byte
+	if r.matrix {
+		if r.shared || r.overflow || len(ports) > 0 || len(signals) > 0 || r.provider != "" {
+			errorf("error: -matrix can't be combined with -shared-state, -fault-on-overflow, -ports, -signals or -trap-provider")
+			return subcommands.ExitUsageError
+		}
+		if input, err = io.ReadAll(os.Stdin); err != nil {
+			errorf("error reading STDIN: %s", err)
+			return exitIO
+		}
+	}
+
+	if r.strict && r.timeout == 0 {
+		r.timeout = strictTimeout
+	}
+
+	aliases, err := readAliases(r.aliases)
+	if err != nil {
+		errorf("error reading aliases: %s", err)
+		return exitIO
+	}
+
+	cache := newCompileCache(r.cacheDir, r.fsys)
+	options := fmt.Sprintf("isa=%s word-size=%d registers=%d signed=%t strict=%t aliases=%v",
+		r.isa, r.wordSize, r.regs, r.signed, r.strict, aliases)
+
+	var c *cpu.CPU
 
-func (*runCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
 	for _, file := range f.Args() {
-		input, err := os.ReadFile(file)
+		var source []byte
+		if r.fsys != nil {
+			source, err = fs.ReadFile(r.fsys, file)
+		} else {
+			source, err = os.ReadFile(file)
+		}
 		if err != nil {
-			fmt.Printf("error reading %s: %s", file, err.Error())
-			return subcommands.ExitFailure
+			errorf("error reading %s: %s", file, err.Error())
+			return exitIO
+		}
+
+		h, code, status := r.compile(file, source, cache, options, aliases)
+		if status != subcommands.ExitSuccess {
+			return status
 		}
 
-		l := lexer.New(string(input))
+		if r.matrix {
+			if status := r.runMatrix(file, h, code, allowed, clock, timer, input); status != subcommands.ExitSuccess {
+				return status
+			}
+			continue
+		}
 
-		comp := compiler.New(l)
-		comp.Compile()
+		fresh := c == nil || !r.shared
+		if fresh {
+			c = r.newCPU(allowed, clock)
+		}
+		if status := r.load(c, file, h, code, fresh); status != subcommands.ExitSuccess {
+			return status
+		}
 
-		c := cpu.NewCPU()
-		c.LoadBytes(comp.Output())
+		verbosef("running %s", file)
+		debugf("%s: %d bytes of code, %d-bit words, stack ISA %t, signed %t, stack in RAM %t, shared state %t",
+			file, c.CodeSize(), c.WordSize(), c.StackISA(), c.Signed(), c.RAMStack(), !fresh)
 
-		if err = c.Run(); err != nil {
-			fmt.Println("error running file:", err)
+		disconnect, err := connectPorts(c, ports)
+		if err != nil {
+			errorf("error connecting ports: %s", err)
+			return exitIO
+		}
+		stopProvider, err := startTrapProvider(c, r.provider)
+		if err != nil {
+			disconnect()
+			errorf("error starting the trap provider: %s", err)
+			return exitIO
+		}
+		stopTimer, err := startTimer(c, timer)
+		if err != nil {
+			disconnect()
+			stopProvider()
+			errorf("error starting the timer: %s", err)
 			return subcommands.ExitFailure
 		}
+		cancel := limitTime(c, r.timeout)
+		stop := forwardSignals(c, signals)
+		err = c.Run()
+		stop()
+		stopTimer()
+		cancel()
+		disconnect()
+		stopProvider()
+		verbosef("%s: peak memory usage %d bytes", file, c.PeakMemoryUsage())
+		if r.layout {
+			printLayout(file, c.MemoryLayout())
+		}
+		if c.OutputTruncated() {
+			infof("%s: output truncated after %d bytes", file, r.output)
+		}
+		if err != nil {
+			errorf("error running file: %s", err)
+			return exitStatus(err, exitRuntime)
+		}
+		if code := c.ExitCode(); code != 0 {
+			verbosef("%s: exit code %d", file, code)
+			return subcommands.ExitStatus(code)
+		}
 	}
 	return subcommands.ExitSuccess
 }
+
+// newCPU returns a fresh CPU set up as given by the flags
+func (r *runCmd) newCPU(allowed header.Capability, clock cpu.Clock) *cpu.CPU {
+	c := cpu.NewCPU()
+	// checked by Execute
+	_ = c.SetNumRegisters(r.regs)
+	c.SetAllowedCapabilities(allowed)
+	c.SetMemoryLimit(r.memory)
+	c.SetOutputLimit(r.output, r.truncate)
+	c.SetClock(clock)
+	c.SetFaultOnOverflow(r.overflow)
+	if r.seed != 0 {
+		c.SetSeed(r.seed)
+	}
+	if r.taint {
+		trackTaint(c)
+	}
+	if r.watchdog > 0 {
+		watchLoops(c, r.watchdog, r.abort)
+	}
+	return c
+}
+
+// load loads the compiled program into the CPU, keeping the state of
+// the previous program unless the CPU is fresh
+func (r *runCmd) load(c *cpu.CPU, file string, h *header.Header, code []byte, fresh bool) subcommands.ExitStatus {
+	if err := c.CheckCapabilities(h.Capabilities); err != nil {
+		errorf("refusing to run %s: %s", file, err.Error())
+		return exitPolicy
+	}
+
+	if err := c.SetWordSize(h.WordSize); err != nil {
+		errorf("error: %s", err)
+		return subcommands.ExitFailure
+	}
+	if err := c.CheckRegisters(h.Registers); err != nil {
+		errorf("refusing to run %s: %s", file, err)
+		return subcommands.ExitFailure
+	}
+
+	c.SetStackISA(r.isa == "stack")
+	c.SetSigned(r.signed)
+	if ramStack := r.ramStack || h.Features&header.FeatRAMStack != 0; ramStack != c.RAMStack() {
+		c.SetRAMStack(ramStack)
+	}
+
+	var opts []cpu.LoadOption
+	if r.protect {
+		opts = append(opts, cpu.ReadOnlyCode())
+	}
+
+	var err error
+	if fresh {
+		err = c.LoadBytes(code, opts...)
+	} else {
+		err = c.LoadBytesKeepState(code, opts...)
+	}
+	if err != nil {
+		errorf("error loading %s: %s", file, err.Error())
+		return exitCompile
+	}
+	c.SetSymbols(h.Symbols)
+	c.SetStringPool(h.Strings)
+	c.SetBanks(h.Banks)
+	return subcommands.ExitSuccess
+}
+
+// compile compiles the source of the given file, unless the program
+// compiled from it is in the cache, returning its header and bytecode
+func (r *runCmd) compile(file string, source []byte, cache *compileCache, options string, aliases map[string]string) (*header.Header, []byte, subcommands.ExitStatus) {
+	key := cache.key(filepath.Dir(file), options, source)
+	if program, ok := cache.lookup(key); ok {
+		verbosef("%s is unchanged, using the cached program", file)
+		h, code, err := header.Decode(program)
+		if err != nil {
+			errorf("error reading the cached program of %s: %s", file, err)
+			return nil, nil, exitIO
+		}
+		return h, code, subcommands.ExitSuccess
+	}
+
+	comp := compiler.New(lexer.New(string(source)))
+	if err := comp.SetISA(r.isa); err != nil {
+		errorf("error: %s", err)
+		return nil, nil, subcommands.ExitUsageError
+	}
+	if err := comp.SetWordSize(r.wordSize); err != nil {
+		errorf("error: %s", err)
+		return nil, nil, subcommands.ExitUsageError
+	}
+	if err := comp.SetNumRegisters(r.regs); err != nil {
+		errorf("error: %s", err)
+		return nil, nil, subcommands.ExitUsageError
+	}
+	if err := comp.SetSigned(r.signed); err != nil {
+		errorf("error: %s", err)
+		return nil, nil, subcommands.ExitUsageError
+	}
+	if err := comp.SetAliases(aliases); err != nil {
+		errorf("error: %s", err)
+		return nil, nil, subcommands.ExitUsageError
+	}
+	comp.SetStrict(r.strict)
+	comp.SetBaseDir(filepath.Dir(file))
+	comp.SetIncludeFS(r.fsys)
+	comp.SetWarnings(logWriter(levelInfo))
+	verbosef("compiling %s", file)
+	if err := comp.Compile(); err != nil {
+		errorf("error compiling %s: %s", file, err.Error())
+		return nil, nil, exitCompile
+	}
+	if r.strict {
+		if err := verify(comp.Header(), comp.Output()); err != nil {
+			errorf("error verifying %s: %s", file, err.Error())
+			return nil, nil, exitCompile
+		}
+	}
+
+	if err := cache.store(key, comp); err != nil {
+		infof("warning: caching the program of %s: %s", file, err)
+	}
+	return comp.Header(), comp.Output(), subcommands.ExitSuccess
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"vm/header"
+	"vm/opcode"
+	"vm/stats"
+)
+
+type statsCmd struct {
+	top int
+}
+
+func (*statsCmd) Name() string { return "stats" }
+
+func (*statsCmd) Synopsis() string { return "Show opcode usage statistics of compiled programs." }
+
+func (*statsCmd) Usage() string {
+	return `stats [flags] dir|program.raw ...:
+Show how often every opcode and trap is used across the given compiled
+programs, and in how many of them, together with their average size.
+Directories are searched for .raw files recursively.
+
+The instructions are the reachable ones, as found by analyze, so data
+embedded in a program isn't counted. Programs which can't be analyzed
+are decoded from the start instead. Programs of the stack-machine
+instruction set are skipped.
+`
+}
+
+func (s *statsCmd) SetFlags(f *flag.FlagSet) {
+	f.IntVar(&s.top, "top", 0, "only list this many of the most used opcodes, all when zero")
+}
+
+func (s *statsCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if f.NArg() == 0 {
+		fmt.Println("usage: stats [flags] dir|program.raw ...")
+		return subcommands.ExitUsageError
+	}
+
+	var files []string
+	for _, arg := range f.Args() {
+		err := filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && (path == arg || filepath.Ext(path) == ".raw") {
+				files = append(files, path)
+			}
+			return err
+		})
+		if err != nil {
+			fmt.Println("error reading programs:", err)
+			return exitIO
+		}
+	}
+
+	st := stats.New()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Printf("error reading %s: %s\n", file, err.Error())
+			return exitIO
+		}
+
+		h, code, err := header.Decode(data)
+		if err == nil {
+			err = st.Add(h, code)
+		}
+		if err != nil {
+			infof("skipping %s: %s", file, err.Error())
+		}
+	}
+
+	if st.Programs == 0 {
+		fmt.Println("no programs found")
+		return subcommands.ExitFailure
+	}
+
+	fmt.Printf("programs:     %d\n", st.Programs)
+	fmt.Printf("size:         %.1f bytes on average, %d-%d\n", st.AverageSize(), st.MinSize, st.MaxSize)
+	fmt.Printf("instructions: %d\n", st.Instructions)
+
+	fmt.Println("opcodes:")
+	for i, op := range stats.ByUses(st.Opcodes) {
+		if s.top > 0 && i == s.top {
+			break
+		}
+		c := st.Opcodes[op]
+		fmt.Printf("  %-13s %6d %5.1f%%  in %d programs\n", opcode.NewOpcode(byte(op)).String(),
+			c.Uses, 100*float64(c.Uses)/float64(st.Instructions), c.Programs)
+	}
+
+	if len(st.Traps) > 0 {
+		fmt.Println("traps:")
+		for _, num := range stats.ByUses(st.Traps) {
+			c := st.Traps[num]
+			fmt.Printf("  0x%04x %6d  in %d programs\n", num, c.Uses, c.Programs)
+		}
+	}
+	return subcommands.ExitSuccess
+}
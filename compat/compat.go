@@ -0,0 +1,138 @@
+// Package compat runs a corpus of programs on two engines, e.g. the
+// binaries of two versions of the virtual machine, and reports the
+// programs which behave differently, to catch changes of the semantics.
+package compat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+	"vm/grade"
+)
+
+// Behavior is what a program did when it ran
+type Behavior struct {
+	// Output is everything the engine printed, including its errors
+	Output string
+
+	// ExitCode is the exit status of the engine
+	ExitCode int
+}
+
+// Engine runs a source program and returns its behavior. An error means
+// the engine itself failed, not the program.
+type Engine interface {
+	Run(program string) (Behavior, error)
+}
+
+// Binary is an engine running programs via the run subcommand of a
+// binary of the virtual machine
+type Binary struct {
+	// Path is the path of the binary
+	Path string
+
+	// Args are passed to the run subcommand before the program, e.g.
+	// "-allow none"
+	Args []string
+
+	// Stdin is the input of every program
+	Stdin []byte
+
+	// Timeout limits the run time of the binary, unlimited when zero
+	Timeout time.Duration
+}
+
+// Run runs the program with the binary
+func (b Binary) Run(program string) (Behavior, error) {
+	ctx := context.Background()
+	if b.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Timeout)
+		defer cancel()
+	}
+
+	args := append(append([]string{"run"}, b.Args...), program)
+	cmd := exec.CommandContext(ctx, b.Path, args...)
+	cmd.Stdin = bytes.NewReader(b.Stdin)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return Behavior{}, ctx.Err()
+	case errors.As(err, &exitErr):
+		return Behavior{Output: out.String(), ExitCode: exitErr.ExitCode()}, nil
+	case err != nil:
+		return Behavior{}, err
+	}
+	return Behavior{Output: out.String()}, nil
+}
+
+// Divergence is a program which behaved differently on the two engines
+type Divergence struct {
+	Program  string
+	Old, New Behavior
+
+	// Diff lists the lines of the output which differ, see grade.Diff
+	Diff []string
+}
+
+// Compare runs every program on both engines and returns the programs
+// which behave differently, in the order given. It stops at the first
+// failure of an engine.
+func Compare(programs []string, old, new Engine) ([]Divergence, error) {
+	var divergences []Divergence
+	for _, program := range programs {
+		before, err := old.Run(program)
+		if err != nil {
+			return divergences, err
+		}
+		after, err := new.Run(program)
+		if err != nil {
+			return divergences, err
+		}
+
+		if before != after {
+			divergences = append(divergences, Divergence{
+				Program: program,
+				Old:     before,
+				New:     after,
+				Diff:    grade.Diff(before.Output, after.Output),
+			})
+		}
+	}
+	return divergences, nil
+}
+
+// Corpus expands the given paths to the programs to compare: files are
+// used as they are, directories contribute their *.in files, sorted by
+// name.
+func Corpus(paths []string) ([]string, error) {
+	var programs []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			programs = append(programs, path)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(path, "*.in"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		programs = append(programs, matches...)
+	}
+	return programs, nil
+}
@@ -0,0 +1,57 @@
+package compiler
+
+import (
+	"fmt"
+	"vm/token"
+)
+
+// SetAliases sets custom mnemonics which are compiled like the keywords
+// they map to, e.g. "bne" for "jmp_nz" or "mov" for "store", so people
+// used to other assemblers can keep their vocabulary. Aliases can't
+// shadow keywords.
+func (c *Compiler) SetAliases(aliases map[string]string) error {
+	for alias, keyword := range aliases {
+		if !isIdentifier(alias) {
+			return fmt.Errorf("alias %q isn't a valid mnemonic", alias)
+		}
+		if token.LookupIdentifier(alias) != token.IDENT {
+			return fmt.Errorf("alias %q shadows a keyword", alias)
+		}
+		if token.LookupIdentifier(keyword) == token.IDENT {
+			return fmt.Errorf("alias %q maps to %q, which isn't a keyword", alias, keyword)
+		}
+	}
+	c.aliases = aliases
+
+	// the first two tokens were read before the aliases were known
+	c.token = c.resolveAlias(c.token)
+	c.peekToken = c.resolveAlias(c.peekToken)
+	return nil
+}
+
+// resolveAlias replaces an alias by the keyword it maps to
+func (c *Compiler) resolveAlias(tok token.Token) token.Token {
+	if tok.Type != token.IDENT {
+		return tok
+	}
+	if keyword, ok := c.aliases[tok.Literal]; ok {
+		tok.Literal = keyword
+		tok.Type = token.LookupIdentifier(keyword)
+	}
+	return tok
+}
+
+// isIdentifier returns true for names the lexer reads as a single
+// identifier, e.g. "bne" but not "#1" or "b ne"
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, ch := range name {
+		letter := ch == '_' || ch == '.' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+		if !letter && (i == 0 || ch < '0' || ch > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package compiler
+
+import (
+	"strconv"
+	"vm/header"
+	"vm/token"
+)
+
+// bank is the output of the code and data placed into a memory bank by
+// the .bank directive
+type bank struct {
+	bytecode []byte
+	fixups   map[int]string
+	widths   map[int]int
+}
+
+// bankOp places the code and data following it into the memory bank
+// with the given number, until the next .bank directive. Its labels are
+// addresses in the window at header.BankStart, so the program runs the
+// code after selecting the bank via BANK_SELECT. Bank zero is the
+// program itself.
+// e.g. .bank 1
+func (c *Compiler) bankOp() {
+	if !c.checkNextToken(token.INT) {
+		return
+	}
+	n, err := strconv.ParseInt(c.token.Literal, 0, 64)
+	if err != nil || n < 0 || n >= header.NumBanks {
+		c.errorf("invalid bank %s, expected 0 to %d", c.token.Literal, header.NumBanks-1)
+	}
+	c.selectBank(int(n))
+}
+
+// selectBank directs the output to the bank with the given number
+func (c *Compiler) selectBank(n int) {
+	if n == c.bank {
+		return
+	}
+	if c.banks == nil {
+		c.banks = map[int]*bank{}
+	}
+	c.banks[c.bank] = &bank{bytecode: c.bytecode, fixups: c.fixups, widths: c.widths}
+
+	next, ok := c.banks[n]
+	if !ok {
+		next = &bank{fixups: map[int]string{}, widths: map[int]int{}}
+	}
+	c.bytecode, c.fixups, c.widths = next.bytecode, next.fixups, next.widths
+	c.bank = n
+}
+
+// here returns the address the next byte of output is loaded at
+func (c *Compiler) here() int {
+	if c.bank != 0 {
+		return header.BankStart + len(c.bytecode)
+	}
+	return len(c.bytecode)
+}
+
+// fixupBanks patches the addresses of the labels into the banks, and
+// checks the banks fit the window and the program stays out of it
+func (c *Compiler) fixupBanks() {
+	c.selectBank(0)
+	used := false
+	for n, b := range c.banks {
+		if n == 0 || len(b.bytecode) == 0 {
+			continue
+		}
+		used = true
+		if len(b.bytecode) > header.BankSize {
+			c.errorf("bank %d is %d bytes long, the maximum is %d", n, len(b.bytecode), header.BankSize)
+		}
+
+		c.selectBank(n)
+		c.fixup()
+		c.selectBank(0)
+	}
+
+	if used && len(c.bytecode) > header.BankStart {
+		c.errorf("program is %d bytes long and reaches into the banked window at %04x", len(c.bytecode), header.BankStart)
+	}
+}
+
+// bankContents returns the code and data placed into the banks other
+// than zero
+func (c *Compiler) bankContents() map[int][]byte {
+	var contents map[int][]byte
+	for n, b := range c.banks {
+		if n == 0 || len(b.bytecode) == 0 {
+			continue
+		}
+		if contents == nil {
+			contents = map[int][]byte{}
+		}
+		contents[n] = b.bytecode
+	}
+	return contents
+}
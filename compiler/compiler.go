@@ -47,28 +47,66 @@
 package compiler
 
 import (
+	"encoding/binary"
 	"fmt"
+	"io"
+	"io/fs"
+	"math"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"vm/header"
 	"vm/lexer"
 	"vm/opcode"
 	"vm/token"
 )
 
 type Compiler struct {
-	lexer     *lexer.Lexer
+	tokens    *lexer.Stream
 	token     token.Token // current token
 	peekToken token.Token // next token
 	bytecode  []byte
 	labels    map[string]int
 	fixups    map[int]string
+	meta      map[string]string // values of the ".meta" directives
+	caps      header.Capability // sensitive features used by the program
+	wordSize  int               // size of the machine word in bits
+	registers int               // number of registers of the CPU, see SetNumRegisters
+	usedRegs  int               // number of registers the program uses, its highest plus one
+	widths    map[int]int       // width of fixups which aren't two bytes wide
+	stackISA  bool              // target the stack-machine instruction set
+	signed    bool              // registers hold signed integers
+	baseDir   string            // directory relative paths of included files are resolved against
+	included  []string          // paths of the files included by incbin
+	includeFS fs.FS             // file system included files are read from, the host's if nil
+	floats    bool              // the program uses floating-point numbers
+	constants map[string]int    // values which can be used like label addresses, e.g. "text_len"
+	dataLabel string            // label directly preceding the current data block, if any
+	usePool   bool              // store string literals in the string pool
+	pool      []byte            // the string pool, see header.Header.Strings
+	poolIndex map[string]int    // offsets of the strings in the pool
+	noSymbols bool              // leave the labels out of the header
+	warnings  io.Writer         // where warnings are written
+	aliases   map[string]string // custom mnemonics mapped to keywords, see SetAliases
+	strict    bool              // treat warnings as errors
+	ramStack  bool              // the program uses the stack pointer, so the stack lives in RAM
+	bank      int               // memory bank the output is placed into, see bankOp
+	banks     map[int]*bank     // output of the banks not currently selected
 }
 
 func New(l *lexer.Lexer) *Compiler {
-	c := &Compiler{lexer: l}
+	c := &Compiler{tokens: lexer.NewStream(l)}
 	c.labels = make(map[string]int)
 	c.fixups = make(map[int]string)
+	c.meta = make(map[string]string)
+	c.constants = make(map[string]int)
+	c.poolIndex = make(map[string]int)
+	c.usePool = true
+	c.widths = make(map[int]int)
+	c.wordSize = header.DefaultWordSize
+	c.registers = header.DefaultNumRegisters
+	c.warnings = os.Stderr
 
 	// prime the pump
 	c.nextToken()
@@ -80,7 +118,7 @@ func New(l *lexer.Lexer) *Compiler {
 // nextToken gets the next token from the lexer stream
 func (c *Compiler) nextToken() {
 	c.token = c.peekToken
-	c.peekToken = c.lexer.NextToken()
+	c.peekToken = c.resolveAlias(c.tokens.NextToken())
 }
 
 // isRegister returns true if the given string is a register ID (e.g. "#1")
@@ -88,35 +126,59 @@ func (c *Compiler) isRegister(input string) bool {
 	return strings.HasPrefix(input, "#")
 }
 
-// getRegister converts a register string to an integer (e.g. "#2" to 2)
+// getRegister converts a register string to an integer (e.g. "#2" to 2).
+// The pseudo-registers #sp and #ip are converted to header.RegSP and
+// header.RegIP, #sp moving the stack into RAM like sp_get.
 func (c *Compiler) getRegister(input string) byte {
+	switch input {
+	case "#sp":
+		c.ramStack = true
+		return header.RegSP
+	case "#ip":
+		return header.RegIP
+	}
+
 	num := strings.TrimPrefix(input, "#")
 	i, err := strconv.Atoi(num)
 	if err != nil {
-		panic(err)
+		c.errorf("invalid register: %s", input)
 	}
 
-	if 0 <= i && i < 15 {
+	if 0 <= i && i < c.registers {
+		c.usedRegs = max(c.usedRegs, i+1)
 		return byte(i)
 	}
 
-	fmt.Printf("register is out of bounds: #%s\n", input)
-	os.Exit(1)
+	c.errorf("register %s is out of range, valid registers are #0 to #%d", input, c.registers-1)
 	return 0
 }
 
 // Compile processes the stream of tokens from the lexer and builds
-// up the bytecode program
-func (c *Compiler) Compile() {
+// up the bytecode program, it returns the first error found
+func (c *Compiler) Compile() (err error) {
+	defer recoverError(&err)
+
+	if c.stackISA {
+		c.compileStack()
+		c.fixup()
+		return
+	}
+
 	// Tokens are processed until the end of the stream (EOF).
 	// During this process bytecode is generated.
 	for c.token.Type != token.EOF {
+		// a data block ends with the first instruction which isn't data
+		if c.token.Type != token.LABEL && c.token.Type != token.DATA && c.token.Type != token.INCBIN {
+			c.dataLabel = ""
+		}
+
 		switch c.token.Type {
 		case token.LABEL:
 			// remove the ":" prefix from the label
 			label := strings.TrimPrefix(c.token.Literal, ":")
 			// the label points to the current point in our bytecode
-			c.labels[label] = len(c.bytecode)
+			c.labels[label] = c.here()
+			c.dataLabel = label
 		case token.ADD:
 			c.mathOp(opcode.ADD)
 		case token.SUB:
@@ -131,6 +193,50 @@ func (c *Compiler) Compile() {
 			c.mathOp(opcode.OR)
 		case token.XOR:
 			c.mathOp(opcode.XOR)
+		case token.MOD:
+			c.mathOp(opcode.MOD)
+		case token.ADC:
+			c.mathOp(opcode.ADC)
+		case token.SBC:
+			c.mathOp(opcode.SBC)
+		case token.MULH:
+			c.mathOp(opcode.MULH)
+		case token.DIVMOD:
+			c.registersOp(opcode.DIVMOD, 4)
+		case token.ADD_PAIR:
+			c.pairOp(opcode.ADD_PAIR)
+		case token.SUB_PAIR:
+			c.pairOp(opcode.SUB_PAIR)
+		case token.MUL_PAIR:
+			c.pairOp(opcode.MUL_PAIR)
+		case token.DIV_PAIR:
+			c.pairOp(opcode.DIV_PAIR)
+		case token.HASH_NEW:
+			c.registersOp(opcode.HASH_NEW, 1)
+		case token.HASH_SET:
+			c.registersOp(opcode.HASH_SET, 3)
+		case token.HASH_GET:
+			c.registersOp(opcode.HASH_GET, 3)
+		case token.HASH_DEL:
+			c.registersOp(opcode.HASH_DEL, 2)
+		case token.HASH_KEYS:
+			c.registersOp(opcode.HASH_KEYS, 2)
+		case token.ALLOC:
+			c.registersOp(opcode.ALLOC, 2)
+		case token.FREE:
+			c.registersOp(opcode.FREE, 1)
+		case token.FADD:
+			c.floatMathOp(opcode.FADD)
+		case token.FSUB:
+			c.floatMathOp(opcode.FSUB)
+		case token.FMUL:
+			c.floatMathOp(opcode.FMUL)
+		case token.FDIV:
+			c.floatMathOp(opcode.FDIV)
+		case token.SHL:
+			c.shiftOp(opcode.SHL, opcode.SHL_IMM)
+		case token.SHR:
+			c.shiftOp(opcode.SHR, opcode.SHR_IMM)
 		case token.INC:
 			c.incOp()
 		case token.DEC:
@@ -139,16 +245,32 @@ func (c *Compiler) Compile() {
 			c.callOp()
 		case token.RET:
 			c.retOp()
+		case token.ENTER:
+			c.enterOp()
+		case token.LEAVE:
+			c.bytecode = append(c.bytecode, byte(opcode.LEAVE))
 		case token.JMP:
 			c.jumpOp(opcode.JMP)
 		case token.JMP_Z:
 			c.jumpOp(opcode.JMP_Z)
 		case token.JMP_NZ:
 			c.jumpOp(opcode.JMP_NZ)
+		case token.JMP_S:
+			c.jumpOp(opcode.JMP_S)
+		case token.JMP_NS:
+			c.jumpOp(opcode.JMP_NS)
 		case token.PUSH:
 			c.pushOp()
 		case token.POP:
 			c.popOp()
+		case token.SP_GET:
+			c.stackPointerOp(opcode.SP_GET)
+		case token.SP_SET:
+			c.stackPointerOp(opcode.SP_SET)
+		case token.LOAD_LOCAL:
+			c.localOp(opcode.LOAD_LOCAL)
+		case token.STORE_LOCAL:
+			c.localOp(opcode.STORE_LOCAL)
 		case token.IS_INT:
 			c.isIntOp()
 		case token.IS_STR:
@@ -157,56 +279,250 @@ func (c *Compiler) Compile() {
 			c.intToStrOp()
 		case token.STR_TO_INT:
 			c.strToIntOp()
+		case token.ORD:
+			c.convertOp(opcode.ORD)
+		case token.CHR:
+			c.convertOp(opcode.CHR)
+		case token.IS_FLOAT:
+			c.floatOp(opcode.IS_FLOAT)
+		case token.INT_TO_FLOAT:
+			c.floatOp(opcode.INT_TO_FLOAT)
+		case token.FLOAT_TO_INT:
+			c.floatOp(opcode.FLOAT_TO_INT)
 		case token.CMP:
 			c.cmpOp()
 		case token.STORE:
 			c.storeOp()
+		case token.CMOV_Z:
+			c.cmovOp(opcode.CMOV_Z)
+		case token.CMOV_NZ:
+			c.cmovOp(opcode.CMOV_NZ)
 		case token.PRINT_INT:
 			c.printIntOp()
 		case token.PRINT_STR:
 			c.printStrOp()
+		case token.PRINT_FLOAT:
+			c.floatOp(opcode.FLOAT_PRINT)
+		case token.PRINT_ERR:
+			c.registersOp(opcode.PRINT_ERR, 1)
+		case token.PRINT_CHAR:
+			c.registersOp(opcode.PRINT_CHAR, 1)
 		case token.PEEK:
 			c.peekOp()
 		case token.POKE:
 			c.pokeOp()
+		case token.PEEK16:
+			c.registersOp(opcode.PEEK16, 2)
+		case token.POKE16:
+			c.registersOp(opcode.POKE16, 2)
+		case token.PEEK_STR:
+			c.registersOp(opcode.PEEK_STR, 2)
+		case token.POKE_STR:
+			c.registersOp(opcode.POKE_STR, 2)
+		case token.MEM_FIND:
+			c.registersOp(opcode.MEM_FIND, 4)
 		case token.CONCAT:
 			c.concatOp()
+		case token.SPLIT_COUNT:
+			c.registersOp(opcode.SPLIT_COUNT, 3)
+		case token.SPLIT_PART:
+			c.registersOp(opcode.SPLIT_PART, 4)
 		case token.DATA:
 			c.dataOp()
+			c.defineDataLength()
+		case token.INCBIN:
+			c.incbinOp()
+			c.defineDataLength()
 		case token.EXIT:
 			c.exitOp()
 		case token.MEM_CPY:
 			c.memCpyOp()
 		case token.NOP:
 			c.nopOp()
+		case token.DUMP:
+			c.dumpOp()
 		case token.RAND:
 			c.randOp()
+		case token.TIME:
+			c.timeOp()
+		case token.READ_INT:
+			c.registersOp(opcode.READ_INT, 1)
 		case token.SYSTEM:
 			c.systemOp()
+		case token.ABORT:
+			c.abortOp()
 		case token.TRAP:
 			c.trapOp()
+		case token.META:
+			c.metaOp()
+		case token.BANK:
+			c.bankOp()
+		case token.BANK_SELECT:
+			c.registersOp(opcode.BANK_SELECT, 1)
 		default:
-			fmt.Printf("unhandled token: type -> %s, literal -> %v\n", c.token.Type, c.token.Literal)
+			c.warnf("unhandled token: type -> %s, literal -> %v", c.token.Type, c.token.Literal)
 		}
+
 		c.nextToken()
 	}
 
+	c.fixupBanks()
+	c.fixup()
+	return nil
+}
+
+// defineDataLength defines the "<label>_len" constant holding the length
+// of the data block following a label, e.g. "text_len" for
+//
+//	:text
+//	    data "banana"
+//	    data 0x00
+//
+// so loops over embedded data don't need to hard-code lengths.
+// The constant is used like a label, e.g. "store #1, text_len".
+func (c *Compiler) defineDataLength() {
+	if c.dataLabel == "" {
+		return
+	}
+
+	name := c.dataLabel + "_len"
+
+	// an explicit label with the same name wins
+	if _, ok := c.labels[name]; ok {
+		return
+	}
+	c.constants[name] = c.here() - c.labels[c.dataLabel]
+}
+
+// fixup patches the addresses of the labels into the bytecode
+func (c *Compiler) fixup() {
 	for addr, name := range c.fixups {
-		value := c.labels[name]
-		if value == 0 {
-			fmt.Printf("Possible use of undefined label '%s'\n", name)
+		value, ok := c.labels[name]
+		if !ok {
+			value, ok = c.constants[name]
+		}
+		if !ok {
+			c.warnf("Use of undefined label '%s'", name)
 		}
 
+		c.patch(addr, name, value)
+	}
+}
+
+// patch writes the value of the named label or constant at the given
+// address of the bytecode
+func (c *Compiler) patch(addr int, name string, value int) {
+	width, ok := c.widths[addr]
+	if !ok {
 		p1 := value % 256
 		p2 := value / 256
 
 		c.bytecode[addr] = byte(p1)
 		c.bytecode[addr+1] = byte(p2)
+		return
+	}
+
+	if value > wordMax(c.wordSize) {
+		c.errorf("address of label '%s' (%d) doesn't fit in a %d-bit word", name, value, c.wordSize)
+	}
+	for i := 0; i < width; i++ {
+		c.bytecode[addr+i] = byte(value >> (8 * i))
+	}
+}
+
+// SetWordSize sets the size of the machine word in bits: 8, 16 or 32.
+// Immediate integer operands are encoded using the word size,
+// which is recorded in the header so the CPU uses the same one.
+func (c *Compiler) SetWordSize(bits int) error {
+	switch bits {
+	case 8, 16, 32:
+		c.wordSize = bits
+		return nil
+	default:
+		return fmt.Errorf("unsupported word size: %d bits", bits)
+	}
+}
+
+// legacyRegisters is the number of registers of older runtimes. Programs
+// using more are marked with header.FeatRegisters, so these refuse them.
+const legacyRegisters = 15
+
+// SetNumRegisters sets the number of registers of the CPU the program
+// is compiled for, header.DefaultNumRegisters by default, which register
+// operands are validated against. The number of registers the program
+// uses is recorded in the header, so CPUs with fewer refuse to load it.
+func (c *Compiler) SetNumRegisters(n int) error {
+	if n < 1 || n > header.MaxNumRegisters {
+		return fmt.Errorf("unsupported number of registers: %d, valid are 1 to %d", n, header.MaxNumRegisters)
+	}
+	c.registers = n
+	return nil
+}
+
+// SetSigned sets whether the program uses signed integers, which allows
+// negative immediate integers, e.g. "store #1, -5". The CPU uses signed
+// registers for such programs as recorded in the header.
+func (c *Compiler) SetSigned(enabled bool) error {
+	if enabled && c.stackISA {
+		return fmt.Errorf("signed integers require the register instruction set")
+	}
+	c.signed = enabled
+	return nil
+}
+
+// wordMax returns the largest integer of a word with the given size
+func wordMax(bits int) int {
+	return 1<<bits - 1
+}
+
+// emitWord appends an immediate integer operand using the word size
+// e.g. the value of "store #1, 300". Signed programs may use negative
+// integers, which are encoded as two's complement.
+func (c *Compiler) emitWord(literal string) {
+	lo := int64(0)
+	if c.signed {
+		lo = -(1 << (c.wordSize - 1))
+	}
+
+	v, err := strconv.ParseInt(literal, 0, 64)
+	if err != nil || v < lo || v > int64(wordMax(c.wordSize)) {
+		c.errorf("integer %s doesn't fit in a %d-bit word", literal, c.wordSize)
+	}
+
+	for i := 0; i < c.wordSize/8; i++ {
+		c.bytecode = append(c.bytecode, byte(v>>(8*i)))
 	}
 }
 
-// mathOp handles math operations: add, sub, mul, div, and, or and xor
+// emitWordLabel appends a placeholder for the address of the given label
+// as an immediate integer operand, which is fixed up at the end
+func (c *Compiler) emitWordLabel(name string) {
+	// record that a fixup is needed here
+	c.fixups[len(c.bytecode)] = name
+	c.widths[len(c.bytecode)] = c.wordSize / 8
+
+	for i := 0; i < c.wordSize/8; i++ {
+		c.bytecode = append(c.bytecode, byte(0))
+	}
+}
+
+// immediates maps math operations to their variants taking a constant
+// instead of the second operand register
+var immediates = map[int]int{
+	opcode.ADD: opcode.ADD_IMM,
+	opcode.SUB: opcode.SUB_IMM,
+	opcode.MUL: opcode.MUL_IMM,
+	opcode.DIV: opcode.DIV_IMM,
+	opcode.MOD: opcode.MOD_IMM,
+	opcode.AND: opcode.AND_IMM,
+	opcode.OR:  opcode.OR_IMM,
+	opcode.XOR: opcode.XOR_IMM,
+}
+
+// mathOp handles math operations: add, sub, adc, sbc, mul, mulh, div, mod, and, or and xor
 // e.g. xor #0, #1, #2
+// All but adc, sbc and mulh also take an integer as the last operand,
+// e.g. add #0, #0, 5
 func (c *Compiler) mathOp(op int) {
 	// check if the next token is an identifier
 	// token = XOR
@@ -235,6 +551,16 @@ func (c *Compiler) mathOp(op int) {
 	}
 
 	// token = ","
+	if immOp, ok := immediates[op]; ok && c.isNextToken(token.INT) {
+		c.nextToken()
+
+		// token = "5"
+		c.bytecode = append(c.bytecode, byte(immOp))
+		c.bytecode = append(c.bytecode, res)
+		c.bytecode = append(c.bytecode, a)
+		c.emitWord(c.token.Literal)
+		return
+	}
 	if !c.checkNextToken(token.IDENT) {
 		return
 	}
@@ -248,6 +574,131 @@ func (c *Compiler) mathOp(op int) {
 	c.bytecode = append(c.bytecode, b)
 }
 
+// registersOp handles instructions taking the given number of registers,
+// e.g. divmod #0, #1, #2, #3 or hash_get #0, #1, #2
+func (c *Compiler) registersOp(op int, count int) {
+	c.bytecode = append(c.bytecode, byte(op))
+	for i := 0; i < count; i++ {
+		if i > 0 && !c.checkNextToken(token.COMMA) {
+			return
+		}
+		if !c.checkNextToken(token.IDENT) {
+			return
+		}
+		c.bytecode = append(c.bytecode, c.getRegister(c.token.Literal))
+	}
+}
+
+// pairOp inserts an operation on double words held by register pairs,
+// each given by its register holding the upper word, which is followed
+// by the one holding the lower word
+// e.g. add_pair #1, #3, #5 stores the sum of #3:#4 and #5:#6 in #1:#2
+func (c *Compiler) pairOp(op int) {
+	c.registersOp(op, 3)
+
+	for _, reg := range c.bytecode[len(c.bytecode)-3:] {
+		if reg >= header.RegSP {
+			c.errorf("the pseudo-registers #sp and #ip can't hold a pair")
+		}
+		// the register of the lower word must exist too
+		c.getRegister(fmt.Sprintf("#%d", reg+1))
+	}
+}
+
+// convertOp handles conversions between characters and their codes,
+// which store the result in another register: ord and chr
+// e.g. ord #0, #1
+func (c *Compiler) convertOp(op int) {
+	if !c.checkNextToken(token.IDENT) {
+		return
+	}
+	// token = "#0"
+	// dst is the register the result is stored in
+	dst := c.getRegister(c.token.Literal)
+
+	if !c.checkNextToken(token.COMMA) {
+		return
+	}
+
+	if !c.checkNextToken(token.IDENT) {
+		return
+	}
+	// token = "#1"
+	src := c.getRegister(c.token.Literal)
+
+	c.bytecode = append(c.bytecode, byte(op))
+	c.bytecode = append(c.bytecode, dst)
+	c.bytecode = append(c.bytecode, src)
+}
+
+// floatMathOp handles floating-point math operations: fadd, fsub, fmul
+// and fdiv, e.g. fadd #0, #1, #2
+func (c *Compiler) floatMathOp(op int) {
+	c.floats = true
+	c.mathOp(op)
+}
+
+// floatOp handles the floating-point instructions taking a single
+// register: print_float, is_float, int_to_float and float_to_int
+func (c *Compiler) floatOp(op int) {
+	c.floats = true
+	if !c.checkNextToken(token.IDENT) {
+		return
+	}
+
+	c.bytecode = append(c.bytecode, byte(op))
+	c.bytecode = append(c.bytecode, c.getRegister(c.token.Literal))
+}
+
+// shiftOp handles shifts by the number of bits in a register, or by a
+// constant number of bits
+// e.g. shl #0, #1, #2
+// e.g. shr #0, #1, 8
+func (c *Compiler) shiftOp(op int, immOp int) {
+	if !c.checkNextToken(token.IDENT) {
+		return
+	}
+
+	// result
+	res := c.getRegister(c.token.Literal)
+
+	if !c.checkNextToken(token.COMMA) {
+		return
+	}
+	if !c.checkNextToken(token.IDENT) {
+		return
+	}
+
+	a := c.getRegister(c.token.Literal)
+
+	if !c.checkNextToken(token.COMMA) {
+		return
+	}
+	c.nextToken()
+
+	switch c.token.Type {
+	case token.IDENT:
+		b := c.getRegister(c.token.Literal)
+
+		c.bytecode = append(c.bytecode, byte(op))
+		c.bytecode = append(c.bytecode, res)
+		c.bytecode = append(c.bytecode, a)
+		c.bytecode = append(c.bytecode, b)
+	case token.INT:
+		bits, err := strconv.ParseInt(c.token.Literal, 0, 64)
+		if err != nil || bits < 0 || bits > 0xff {
+			c.errorf("shift of %s bits is out of range", c.token.Literal)
+		}
+
+		c.bytecode = append(c.bytecode, byte(immOp))
+		c.bytecode = append(c.bytecode, res)
+		c.bytecode = append(c.bytecode, a)
+		c.bytecode = append(c.bytecode, byte(bits))
+	default:
+		c.errorf("invalid shift amount: %v", c.token)
+	}
+}
+
 // incOp increments the contents of the given register
 // e.g. inc #1
 func (c *Compiler) incOp() {
@@ -276,14 +727,22 @@ func (c *Compiler) decOp() {
 	c.bytecode = append(c.bytecode, reg)
 }
 
-// callOp generates a call instruction
+// callOp generates a call instruction to a label or an address,
+// e.g. "call print", or to the address in a register, e.g. "call #2"
 func (c *Compiler) callOp() {
-	// add the call instruction
-	c.bytecode = append(c.bytecode, byte(opcode.CALL))
-
 	// advance to the target
 	c.nextToken()
 
+	// a call to the address in a register, e.g. "call #2"
+	if c.token.Type == token.IDENT && c.isRegister(c.token.Literal) {
+		c.bytecode = append(c.bytecode, byte(opcode.CALL_REG))
+		c.bytecode = append(c.bytecode, c.getRegister(c.token.Literal))
+		return
+	}
+
+	// add the call instruction
+	c.bytecode = append(c.bytecode, byte(opcode.CALL))
+
 	// the call might be to an absolute target or a label
 	switch c.token.Type {
 	case token.INT:
@@ -312,6 +771,22 @@ func (c *Compiler) retOp() {
 	c.bytecode = append(c.bytecode, byte(opcode.RET))
 }
 
+// enterOp sets up a stack frame with the given number of local values
+// e.g. enter 2
+func (c *Compiler) enterOp() {
+	if !c.checkNextToken(token.INT) {
+		return
+	}
+	size, err := strconv.ParseInt(c.token.Literal, 0, 64)
+	if err != nil || size < 0 || size > 0xffff {
+		c.errorf("invalid frame size: %s", c.token.Literal)
+	}
+
+	c.bytecode = append(c.bytecode, byte(opcode.ENTER))
+	c.bytecode = append(c.bytecode, byte(size%256))
+	c.bytecode = append(c.bytecode, byte(size/256))
+}
+
 // jumpOp inserts a direct jump
 func (c *Compiler) jumpOp(op int) {
 	// add the jump
@@ -367,6 +842,36 @@ func (c *Compiler) popOp() {
 	c.bytecode = append(c.bytecode, reg)
 }
 
+// stackPointerOp handles the instructions reading and moving the stack
+// pointer, which exists only if the stack lives in RAM: sp_get and
+// sp_set, e.g. sp_get #0
+func (c *Compiler) stackPointerOp(op int) {
+	c.ramStack = true
+	c.registersOp(op, 1)
+}
+
+// localOp handles the instructions accessing the stack relative to the
+// frame pointer: load_local and store_local, e.g. load_local #0, 1 or
+// load_local #0, -3 for the last argument pushed before the call
+func (c *Compiler) localOp(op int) {
+	if !c.checkNextToken(token.IDENT) {
+		return
+	}
+	reg := c.getRegister(c.token.Literal)
+	if !c.checkNextToken(token.COMMA) {
+		return
+	}
+	if !c.checkNextToken(token.INT) {
+		return
+	}
+	offset, err := strconv.ParseInt(c.token.Literal, 0, 64)
+	if err != nil || offset < -0x8000 || offset > 0x7fff {
+		c.errorf("invalid offset from the frame pointer: %s", c.token.Literal)
+	}
+
+	c.bytecode = append(c.bytecode, byte(op), reg, byte(offset), byte(offset>>8))
+}
+
 // isIntOp tests if a register contains an integer
 func (c *Compiler) isIntOp() {
 	// check if the next token is an identifier
@@ -441,13 +946,7 @@ func (c *Compiler) cmpOp() {
 	case token.INT:
 		c.bytecode = append(c.bytecode, byte(opcode.CMP_INT))
 		c.bytecode = append(c.bytecode, reg)
-
-		i, _ := strconv.ParseInt(c.token.Literal, 0, 64)
-		len1 := i % 256
-		len2 := i / 256
-
-		c.bytecode = append(c.bytecode, byte(len1))
-		c.bytecode = append(c.bytecode, byte(len2))
+		c.emitWord(c.token.Literal)
 	case token.STR:
 		c.bytecode = append(c.bytecode, byte(opcode.CMP_STR))
 		c.bytecode = append(c.bytecode, reg)
@@ -475,18 +974,12 @@ func (c *Compiler) cmpOp() {
 			c.bytecode = append(c.bytecode, byte(opcode.CMP_INT))
 			c.bytecode = append(c.bytecode, reg)
 
-			// record that a fixup is needed here
-			c.fixups[len(c.bytecode)] = c.token.Literal
-
-			// Output two temporary numbers.
-			// Later those bytes will be filled with the label address,
+			// Output temporary bytes, later filled with the label address,
 			// which is the bytecode slice index (c.labels[label] = len(c.bytecode).
-			c.bytecode = append(c.bytecode, byte(0))
-			c.bytecode = append(c.bytecode, byte(0))
+			c.emitWordLabel(c.token.Literal)
 		}
 	default:
-		fmt.Printf("ERROR: invalid value to compare: %v\n", c.token)
-		os.Exit(1)
+		c.errorf("invalid value to compare: %v", c.token)
 	}
 }
 
@@ -508,14 +1001,30 @@ func (c *Compiler) storeOp() {
 	case token.INT:
 		c.bytecode = append(c.bytecode, byte(opcode.INT_STORE))
 		c.bytecode = append(c.bytecode, reg)
+		c.emitWord(c.token.Literal)
+	case token.FLOAT:
+		v, err := strconv.ParseFloat(c.token.Literal, 64)
+		if err != nil {
+			c.errorf("invalid floating-point number: %s", c.token.Literal)
+		}
+		c.floats = true
 
-		i, _ := strconv.ParseInt(c.token.Literal, 0, 64)
-		len1 := i % 256
-		len2 := i / 256
-
-		c.bytecode = append(c.bytecode, byte(len1))
-		c.bytecode = append(c.bytecode, byte(len2))
+		// FLOAT_STORE $REG followed by the 8 bytes of the float
+		c.bytecode = append(c.bytecode, byte(opcode.FLOAT_STORE))
+		c.bytecode = append(c.bytecode, reg)
+		c.bytecode = binary.LittleEndian.AppendUint64(c.bytecode, math.Float64bits(v))
 	case token.STR:
+		if c.usePool {
+			// STR_POOL $REG $OFF1 $OFF2
+			c.bytecode = append(c.bytecode, byte(opcode.STR_POOL))
+			c.bytecode = append(c.bytecode, reg)
+
+			offset := c.poolString(c.token.Literal)
+			c.bytecode = append(c.bytecode, byte(offset%256))
+			c.bytecode = append(c.bytecode, byte(offset/256))
+			break
+		}
+
 		c.bytecode = append(c.bytecode, byte(opcode.STR_STORE))
 		c.bytecode = append(c.bytecode, reg)
 
@@ -541,18 +1050,12 @@ func (c *Compiler) storeOp() {
 			c.bytecode = append(c.bytecode, byte(opcode.INT_STORE))
 			c.bytecode = append(c.bytecode, reg)
 
-			// record that a fixup is needed here
-			c.fixups[len(c.bytecode)] = c.token.Literal
-
-			// Output two temporary numbers.
-			// Later those bytes will be filled with the label address,
+			// Output temporary bytes, later filled with the label address,
 			// which is the bytecode slice index (c.labels[label] = len(c.bytecode).
-			c.bytecode = append(c.bytecode, byte(0))
-			c.bytecode = append(c.bytecode, byte(0))
+			c.emitWordLabel(c.token.Literal)
 		}
 	default:
-		fmt.Printf("ERROR: invalid value to store: %v\n", c.token)
-		os.Exit(1)
+		c.errorf("invalid value to store: %v", c.token)
 	}
 }
 
@@ -576,6 +1079,31 @@ func (c *Compiler) printStrOp() {
 	c.bytecode = append(c.bytecode, c.getRegister(c.token.Literal))
 }
 
+// cmovOp copies a register into another depending on the Z-flag,
+// e.g. cmov_z #1, #2
+func (c *Compiler) cmovOp(op int) {
+	if !c.checkNextToken(token.IDENT) {
+		return
+	}
+	// token = "#1"
+	// dst is the register the value is copied to
+	dst := c.getRegister(c.token.Literal)
+
+	if !c.checkNextToken(token.COMMA) {
+		return
+	}
+
+	if !c.checkNextToken(token.IDENT) {
+		return
+	}
+	// token = "#2"
+	src := c.getRegister(c.token.Literal)
+
+	c.bytecode = append(c.bytecode, byte(op))
+	c.bytecode = append(c.bytecode, dst)
+	c.bytecode = append(c.bytecode, src)
+}
+
 // peekOp reads the contents of a memory address and stores in a register
 // e.g. peek #0, #1
 func (c *Compiler) peekOp() {
@@ -702,9 +1230,101 @@ func (c *Compiler) dataOp() {
 	}
 }
 
-// exitOp terminates the interpreter
+// SetBaseDir sets the directory relative paths of included files are
+// resolved against, usually the directory of the source file.
+// By default they are relative to the working directory.
+func (c *Compiler) SetBaseDir(dir string) {
+	c.baseDir = dir
+}
+
+// SetIncludeFS sets the file system included files are read from, e.g.
+// to compile programs embedded in the binary. The base directory is
+// resolved within it. By default the files of the host are read.
+func (c *Compiler) SetIncludeFS(fsys fs.FS) {
+	c.includeFS = fsys
+}
+
+// Included returns the paths of the files included by the program, in
+// the order they were included, e.g. to rebuild it when they change
+func (c *Compiler) Included() []string {
+	return c.included
+}
+
+// incbinOp embeds the contents of a host file into the output,
+// optionally starting at an offset and limited to a length
+// e.g. incbin "sprite.bin", 16, 32
+func (c *Compiler) incbinOp() {
+	if !c.checkNextToken(token.STR) {
+		return
+	}
+
+	path := c.token.Literal
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.baseDir, path)
+	}
+
+	var data []byte
+	var err error
+	if c.includeFS != nil {
+		data, err = fs.ReadFile(c.includeFS, filepath.ToSlash(path))
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		c.errorf("error including %s: %s", c.token.Literal, err.Error())
+	}
+	c.included = append(c.included, path)
+
+	offset, length := 0, len(data)
+
+	// optional offset
+	if c.isNextToken(token.COMMA) {
+		c.nextToken()
+		if !c.checkNextToken(token.INT) {
+			return
+		}
+		i, _ := strconv.ParseInt(c.token.Literal, 0, 64)
+		offset = int(i)
+		length = len(data) - offset
+
+		// optional length
+		if c.isNextToken(token.COMMA) {
+			c.nextToken()
+			if !c.checkNextToken(token.INT) {
+				return
+			}
+			i, _ = strconv.ParseInt(c.token.Literal, 0, 64)
+			length = int(i)
+		}
+	}
+
+	if offset < 0 || offset > len(data) || length < 0 || offset+length > len(data) {
+		c.errorf("error including %s: offset %d and length %d are out of range for %d bytes",
+			c.token.Literal, offset, length, len(data))
+	}
+
+	c.bytecode = append(c.bytecode, data[offset:offset+length]...)
+}
+
+// exitOp terminates the interpreter, optionally with an exit code
+// e.g. exit, exit 1 or exit #1
 func (c *Compiler) exitOp() {
-	c.bytecode = append(c.bytecode, byte(opcode.EXIT))
+	switch {
+	case c.isNextToken(token.INT):
+		c.nextToken()
+		code, err := strconv.ParseInt(c.token.Literal, 0, 64)
+		if err != nil || code < 0 || code > 0xff {
+			c.errorf("exit code %s is out of range, it must be between 0 and 255", c.token.Literal)
+		}
+		c.bytecode = append(c.bytecode, byte(opcode.EXIT_IMM))
+		c.bytecode = append(c.bytecode, byte(code))
+	case c.isNextToken(token.IDENT) && c.isRegister(c.peekToken.Literal):
+		c.nextToken()
+		c.bytecode = append(c.bytecode, byte(opcode.EXIT_REG))
+		c.bytecode = append(c.bytecode, c.getRegister(c.token.Literal))
+	default:
+		c.bytecode = append(c.bytecode, byte(opcode.EXIT))
+	}
 }
 
 // memCpyOp inserts a memory copy
@@ -739,7 +1359,40 @@ func (c *Compiler) nopOp() {
 	c.bytecode = append(c.bytecode, byte(opcode.NOP))
 }
 
-// randOp returns a random value
+// dumpOp prints the state of the CPU
+func (c *Compiler) dumpOp() {
+	c.bytecode = append(c.bytecode, byte(opcode.DUMP))
+}
+
+// timeOp stores the time in a register, selected by the second operand:
+// 0 the Unix time in seconds, 1 the milliseconds since the start and 2
+// the number of executed instructions
+// e.g. time #1, 1
+func (c *Compiler) timeOp() {
+	if !c.checkNextToken(token.IDENT) {
+		return
+	}
+	reg := c.getRegister(c.token.Literal)
+
+	if !c.checkNextToken(token.COMMA) {
+		return
+	}
+	if !c.checkNextToken(token.INT) {
+		return
+	}
+	clock, err := strconv.ParseInt(c.token.Literal, 0, 64)
+	if err != nil || clock < 0 || clock > 2 {
+		c.errorf("invalid clock %s, expected 0 for the Unix time, 1 for the elapsed milliseconds or 2 for the executed instructions", c.token.Literal)
+	}
+
+	c.bytecode = append(c.bytecode, byte(opcode.TIME))
+	c.bytecode = append(c.bytecode, reg)
+	c.bytecode = append(c.bytecode, byte(clock))
+}
+
+// randOp returns a random value, optionally below the value of a
+// second register
+// e.g. rand #1 or rand #1, #2
 func (c *Compiler) randOp() {
 	// check if the next token is an identifier
 	if !c.checkNextToken(token.IDENT) {
@@ -748,8 +1401,20 @@ func (c *Compiler) randOp() {
 
 	reg := c.getRegister(c.token.Literal)
 
-	c.bytecode = append(c.bytecode, byte(opcode.INT_RAND))
+	if !c.isNextToken(token.COMMA) {
+		c.bytecode = append(c.bytecode, byte(opcode.INT_RAND))
+		c.bytecode = append(c.bytecode, reg)
+		return
+	}
+	c.nextToken()
+
+	if !c.checkNextToken(token.IDENT) {
+		return
+	}
+
+	c.bytecode = append(c.bytecode, byte(opcode.RAND_RANGE))
 	c.bytecode = append(c.bytecode, reg)
+	c.bytecode = append(c.bytecode, c.getRegister(c.token.Literal))
 }
 
 // systemOp runs the string command in the given register
@@ -761,10 +1426,27 @@ func (c *Compiler) systemOp() {
 
 	reg := c.getRegister(c.token.Literal)
 
+	c.caps |= header.CapSystem
+
 	c.bytecode = append(c.bytecode, byte(opcode.SYSTEM))
 	c.bytecode = append(c.bytecode, reg)
 }
 
+// abortOp terminates the program with the string in the given register
+// as the error message
+// e.g. abort #1
+func (c *Compiler) abortOp() {
+	// check if the next token is an identifier
+	if !c.checkNextToken(token.IDENT) {
+		return
+	}
+
+	reg := c.getRegister(c.token.Literal)
+
+	c.bytecode = append(c.bytecode, byte(opcode.ABORT))
+	c.bytecode = append(c.bytecode, reg)
+}
+
 // trapOp inserts an interrupt call/trap
 func (c *Compiler) trapOp() {
 	// advance to the target
@@ -778,14 +1460,30 @@ func (c *Compiler) trapOp() {
 		len1 := addr % 256
 		len2 := addr / 256
 
+		c.caps |= header.TrapCapabilities[int(addr)]
+
 		c.bytecode = append(c.bytecode, byte(opcode.TRAP))
 		c.bytecode = append(c.bytecode, byte(len1))
 		c.bytecode = append(c.bytecode, byte(len2))
 	default:
-		fmt.Println("Fail!")
+		c.warnf("invalid trap number: %v", c.token)
 	}
 }
 
+// metaOp records program metadata which is stored in the header
+// e.g. .meta name "calc"
+func (c *Compiler) metaOp() {
+	if !c.checkNextToken(token.IDENT) {
+		return
+	}
+	key := c.token.Literal
+
+	if !c.checkNextToken(token.STR) {
+		return
+	}
+	c.meta[key] = c.token.Literal
+}
+
 // check next token is t
 // success: return true and forward token
 // failure: return false and print error
@@ -804,29 +1502,105 @@ func (c *Compiler) isNextToken(t token.Type) bool {
 }
 
 func (c *Compiler) nextError(t token.Type) {
-	fmt.Printf("expected next token to be %s, got %s instead\n", t, c.peekToken.Type)
-	os.Exit(1)
+	c.errorf("expected next token to be %s, got %s instead", t, c.peekToken.Type)
 }
 
 // Dump processes the stream of tokens from the lexer and shows the structure
 // of the program
 func (c *Compiler) Dump() {
 	for c.token.Type != token.EOF {
-		fmt.Printf("token: type -> %s, literal -> %s\n", c.token.Type, c.token.Literal)
+		fmt.Printf("token: type -> %s, literal -> %s, position -> %d:%d\n",
+			c.token.Type, c.token.Literal, c.token.Line, c.token.Column)
 		c.nextToken()
 	}
 }
 
+// Labels returns the addresses of the labels defined by the program,
+// which can be used as debug info
+func (c *Compiler) Labels() map[string]int {
+	return c.labels
+}
+
 // Output returns the bytecode of the compiled program
 func (c *Compiler) Output() []byte {
 	return c.bytecode
 }
 
-// WriteFile outputs our generated bytecode to the named file
-func (c *Compiler) WriteFile(path string) {
-	fmt.Printf("Generated bytecode is %d bytes long\n", len(c.bytecode))
-	if err := os.WriteFile(path, c.bytecode, 0644); err != nil {
-		fmt.Printf("Error writing output file: %s\n", err.Error())
-		os.Exit(1)
+// SetStringPool sets whether string literals are stored in the string
+// pool, which is the default, or inline in the bytecode. The pool stores
+// each distinct literal once, so storing the same string repeatedly
+// only costs a reference.
+func (c *Compiler) SetStringPool(enabled bool) {
+	c.usePool = enabled
+}
+
+// poolString returns the offset of the given string in the string pool,
+// adding it if it isn't there yet
+func (c *Compiler) poolString(s string) int {
+	if offset, ok := c.poolIndex[s]; ok {
+		return offset
+	}
+
+	offset := len(c.pool)
+	if offset+2+len(s) > 0xffff {
+		c.errorf("string pool overflow storing %q", s)
+	}
+
+	c.pool = append(c.pool, byte(len(s)%256), byte(len(s)/256))
+	c.pool = append(c.pool, s...)
+	c.poolIndex[s] = offset
+	return offset
+}
+
+// Header returns the header describing the compiled program
+func (c *Compiler) Header() *header.Header {
+	h := header.New()
+	h.Capabilities = c.caps
+	h.WordSize = c.wordSize
+	if c.wordSize != header.DefaultWordSize {
+		h.Features |= header.FeatWordSize
+	}
+	h.Registers = c.usedRegs
+	if c.usedRegs > legacyRegisters {
+		h.Features |= header.FeatRegisters
+	}
+	if c.stackISA {
+		h.Features |= header.FeatStackISA
+	}
+	if c.signed {
+		h.Features |= header.FeatSignedInts
+	}
+	if c.floats {
+		h.Features |= header.FeatFloat
+	}
+	if c.ramStack {
+		h.Features |= header.FeatRAMStack
+	}
+	if len(c.pool) > 0 {
+		h.Features |= header.FeatStringPool
+		h.Strings = c.pool
+	}
+	if banks := c.bankContents(); banks != nil {
+		h.Features |= header.FeatBanks
+		h.Banks = banks
+	}
+	for key, value := range c.meta {
+		h.Meta[key] = value
+	}
+	if !c.noSymbols {
+		for name, addr := range c.labels {
+			h.Symbols[name] = addr
+		}
+	}
+	return h
+}
+
+// WriteFile outputs our generated bytecode, prefixed by its header,
+// to the named file
+func (c *Compiler) WriteFile(path string) error {
+	h, err := c.Header().Encode()
+	if err != nil {
+		return err
 	}
+	return os.WriteFile(path, append(h, c.bytecode...), 0644)
 }
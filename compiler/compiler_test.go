@@ -0,0 +1,207 @@
+package compiler
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"vm/cpu"
+	"vm/header"
+	"vm/lexer"
+)
+
+// compile compiles the given source, discarding the warnings
+func compile(src string) (*Compiler, error) {
+	c := New(lexer.New(src))
+	c.SetWarnings(io.Discard)
+	return c, c.Compile()
+}
+
+func TestRegisterBounds(t *testing.T) {
+	last := header.DefaultNumRegisters - 1
+
+	c, err := compile(fmt.Sprintf("store #%d, 7\nexit\n", last))
+	if err != nil {
+		t.Fatalf("the last register was rejected: %s", err)
+	}
+	// the CPU has every register the compiler accepts
+	vm := cpu.NewCPU()
+	if err = vm.LoadBytes(c.Output()); err != nil {
+		t.Fatal(err)
+	}
+	if err = vm.Run(); err != nil {
+		t.Fatalf("running: %s", err)
+	}
+
+	_, err = compile(fmt.Sprintf("store #%d, 7\n", last+1))
+	want := fmt.Sprintf("register #%d is out of range, valid registers are #0 to #%d", last+1, last)
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("error %v, want %q", err, want)
+	}
+}
+
+func TestNumRegisters(t *testing.T) {
+	c := New(lexer.New("store #40, 7\nexit\n"))
+	c.SetWarnings(io.Discard)
+	if err := c.SetNumRegisters(64); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Compile(); err != nil {
+		t.Fatalf("register #40 of 64 was rejected: %s", err)
+	}
+
+	h := c.Header()
+	if h.Registers != 41 || h.Features&header.FeatRegisters == 0 {
+		t.Errorf("header records %d registers and features %s, want 41 and REGISTERS", h.Registers, h.Features)
+	}
+
+	// a CPU with fewer registers refuses the program
+	vm := cpu.NewCPU()
+	if err := vm.CheckRegisters(h.Registers); err == nil {
+		t.Error("a CPU with the default number of registers accepted the program")
+	}
+	if err := vm.SetNumRegisters(64); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.CheckRegisters(h.Registers); err != nil {
+		t.Error(err)
+	}
+	if err := vm.LoadBytes(c.Output()); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Run(); err != nil {
+		t.Fatalf("running: %s", err)
+	}
+}
+
+func TestRegisterPairBounds(t *testing.T) {
+	last := header.DefaultNumRegisters - 1
+
+	if _, err := compile(fmt.Sprintf("add_pair #%d, #0, #2\n", last-1)); err != nil {
+		t.Errorf("the last register pair was rejected: %s", err)
+	}
+	// the lower word of the pair would be beyond the last register
+	if _, err := compile(fmt.Sprintf("add_pair #%d, #0, #2\n", last)); err == nil {
+		t.Error("a pair beyond the last register was accepted")
+	}
+}
+
+func TestBanks(t *testing.T) {
+	c, err := compile(`
+    store #1, 1
+    bank_select #1
+    call far
+    exit
+.bank 1
+:far
+    store #2, 7
+    jmp back
+:back
+    ret
+.bank 0
+:after
+    exit
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// labels of the bank are addresses in the window, patched into it
+	labels := c.Labels()
+	if labels["far"] != header.BankStart || labels["after"] != len(c.Output())-1 {
+		t.Errorf("far is at %04x and after at %04x", labels["far"], labels["after"])
+	}
+	h := c.Header()
+	bank := h.Banks[1]
+	if h.Features&header.FeatBanks == 0 || len(bank) != 8 {
+		t.Fatalf("bank 1 is % x", bank)
+	}
+	if back := int(bank[5]) + int(bank[6])<<8; back != labels["back"] {
+		t.Errorf("jump to %04x, want %04x", back, labels["back"])
+	}
+
+	vm := cpu.NewCPU()
+	if err = vm.LoadBytes(c.Output()); err != nil {
+		t.Fatal(err)
+	}
+	vm.SetBanks(h.Banks)
+	if err = vm.Run(); err != nil {
+		t.Fatalf("running: %s", err)
+	}
+	r, _ := vm.Reg(2)
+	if v, err := r.GetInt(); err != nil || v != 7 {
+		t.Errorf("#2 = %d, want the value stored in the bank", v)
+	}
+
+	if _, err = compile(".bank 16\n"); err == nil {
+		t.Error("a bank out of range was accepted")
+	}
+}
+
+func TestPseudoRegisters(t *testing.T) {
+	c, err := compile(`
+    store #1, 5
+    push #1
+    push #1
+    store #1, #sp
+    add #sp, #sp, 4
+    store #2, #sp
+:here
+    store #3, #ip
+    exit
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Header().Features&header.FeatRAMStack == 0 {
+		t.Error("#sp didn't move the stack into RAM")
+	}
+
+	vm := cpu.NewCPU()
+	vm.SetRAMStack(true)
+	if err = vm.LoadBytes(c.Output()); err != nil {
+		t.Fatal(err)
+	}
+	if err = vm.Run(); err != nil {
+		t.Fatalf("running: %s", err)
+	}
+	for reg, want := range map[int]int{1: cpu.RAMStackTop - 8, 2: cpu.RAMStackTop - 4, 3: c.Labels()["here"]} {
+		r, _ := vm.Reg(reg)
+		if v, err := r.GetInt(); err != nil || v != want {
+			t.Errorf("#%d = %04x, want %04x", reg, v, want)
+		}
+	}
+
+	// #ip is read-only
+	c, err = compile("store #ip, 5\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm = cpu.NewCPU()
+	if err = vm.LoadBytes(c.Output()); err != nil {
+		t.Fatal(err)
+	}
+	if err = vm.Run(); err == nil || !strings.Contains(err.Error(), "#ip is read-only") {
+		t.Errorf("storing into #ip: %v", err)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		want header.Capability
+	}{
+		{"trap 0x00\nexit\n", 0},
+		{fmt.Sprintf("trap %d\nexit\n", cpu.TrapPortRead), header.CapFile | header.CapNet},
+		{fmt.Sprintf("trap %d\nexit\n", cpu.TrapPortWrite), header.CapFile | header.CapNet},
+		{"store #1, \"true\"\nsystem #1\nexit\n", header.CapSystem},
+	} {
+		c, err := compile(tc.src)
+		if err != nil {
+			t.Fatalf("%q: %s", tc.src, err)
+		}
+		if got := c.Header().Capabilities; got != tc.want {
+			t.Errorf("%q requires %s, want %s", tc.src, got, tc.want)
+		}
+	}
+}
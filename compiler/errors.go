@@ -0,0 +1,53 @@
+package compiler
+
+import (
+	"fmt"
+	"io"
+)
+
+// compileError aborts the compilation. It is raised by errorf, deep
+// inside the parsing of an instruction, and returned by Compile.
+type compileError struct {
+	err error
+}
+
+// errorf aborts the compilation with the given error
+func (c *Compiler) errorf(format string, args ...any) {
+	panic(compileError{fmt.Errorf(format, args...)})
+}
+
+// recoverError stores the error raised by errorf in err, other panics
+// are passed on. It must be deferred by the exported methods calling
+// errorf.
+func recoverError(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	ce, ok := r.(compileError)
+	if !ok {
+		panic(r)
+	}
+	*err = ce.err
+}
+
+// warnf reports a problem which doesn't stop the compilation, unless
+// warnings are treated as errors
+func (c *Compiler) warnf(format string, args ...any) {
+	if c.strict {
+		c.errorf(format, args...)
+	}
+	fmt.Fprintf(c.warnings, format+"\n", args...)
+}
+
+// SetStrict sets whether warnings are treated as errors, e.g. the use
+// of an undefined label fails the compilation
+func (c *Compiler) SetStrict(enabled bool) {
+	c.strict = enabled
+}
+
+// SetWarnings sets where warnings, e.g. about undefined labels, are
+// written, os.Stderr by default
+func (c *Compiler) SetWarnings(w io.Writer) {
+	c.warnings = w
+}
@@ -0,0 +1,212 @@
+package compiler
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"vm/disasm"
+	"vm/opcode"
+)
+
+// block is a part of the program starting at one or more labels, which
+// is moved as a whole by Obfuscate
+type block struct {
+	start, end int
+}
+
+// Obfuscate shuffles the parts of the compiled program which start at
+// labels, and renames the labels to meaningless names, which makes the
+// program harder to follow in a disassembly. It returns the original
+// name of every renamed label, e.g. to decode the locations reported
+// by runtime errors.
+//
+// The code before the first label stays in place as it is the entry
+// point. Parts which would fall through to the next one get a jump to
+// it appended, or an EXIT if they ended the program.
+// References to labels are updated, but programs using absolute
+// addresses, or computing addresses across labels, e.g. "store #1, a"
+// followed by "add #1, #1, #2" to reach a later label, won't work,
+// see LabelsUsedAsValues.
+func (c *Compiler) Obfuscate(rng *rand.Rand) (_ map[string]string, err error) {
+	defer recoverError(&err)
+
+	if c.stackISA {
+		return nil, fmt.Errorf("only programs of the register instruction set can be obfuscated")
+	}
+	if c.bankContents() != nil {
+		return nil, fmt.Errorf("programs placing code into memory banks can't be obfuscated")
+	}
+
+	blocks := c.blocks()
+	if len(blocks) > 2 {
+		middle := blocks[1:]
+		rng.Shuffle(len(middle), func(i, j int) { middle[i], middle[j] = middle[j], middle[i] })
+	}
+
+	// lay out the blocks in their new order
+	var out []byte
+	newStart := map[int]int{}
+	jumps := map[int]int{} // address of a jump target -> old address
+	for _, b := range blocks {
+		newStart[b.start] = len(out)
+		out = append(out, c.bytecode[b.start:b.end]...)
+
+		if !c.fallsThrough(b) {
+			continue
+		}
+		if b.end == len(c.bytecode) {
+			out = append(out, byte(opcode.EXIT))
+		} else {
+			jumps[len(out)+1] = b.end
+			out = append(out, byte(opcode.JMP), 0, 0)
+		}
+	}
+
+	relocate := func(addr int) int {
+		if addr >= len(c.bytecode) {
+			// labels at the end of the program stay there
+			return len(out) + addr - len(c.bytecode)
+		}
+		for _, b := range blocks {
+			if addr >= b.start && addr < b.end {
+				return newStart[b.start] + addr - b.start
+			}
+		}
+		return addr
+	}
+
+	fixups := map[int]string{}
+	widths := map[int]int{}
+	for addr, name := range c.fixups {
+		fixups[relocate(addr)] = name
+		if width, ok := c.widths[addr]; ok {
+			widths[relocate(addr)] = width
+		}
+	}
+	labels := map[string]int{}
+	for name, addr := range c.labels {
+		labels[name] = relocate(addr)
+	}
+
+	c.bytecode, c.fixups, c.widths, c.labels = out, fixups, widths, labels
+	for addr, name := range c.fixups {
+		value, ok := c.labels[name]
+		if !ok {
+			value = c.constants[name]
+		}
+		c.patch(addr, name, value)
+	}
+	for addr, target := range jumps {
+		c.patch(addr, "", relocate(target))
+	}
+
+	return c.renameLabels(), nil
+}
+
+// blocks splits the bytecode at the labels, keeping the code before the
+// first label as the first block
+func (c *Compiler) blocks() []block {
+	starts := map[int]bool{0: true}
+	for _, addr := range c.labels {
+		if addr < len(c.bytecode) {
+			starts[addr] = true
+		}
+	}
+
+	sorted := make([]int, 0, len(starts))
+	for addr := range starts {
+		sorted = append(sorted, addr)
+	}
+	sort.Ints(sorted)
+
+	blocks := make([]block, len(sorted))
+	for i, start := range sorted {
+		end := len(c.bytecode)
+		if i+1 < len(sorted) {
+			end = sorted[i+1]
+		}
+		blocks[i] = block{start: start, end: end}
+	}
+	return blocks
+}
+
+// fallsThrough returns false if the last instruction of the block never
+// continues with the next one. Blocks which can't be decoded, e.g.
+// because they contain data, are assumed to fall through, unless they
+// start at a data label.
+func (c *Compiler) fallsThrough(b block) bool {
+	for name, addr := range c.labels {
+		if _, data := c.constants[name+"_len"]; data && addr == b.start {
+			return false
+		}
+	}
+
+	last := -1
+	for addr := b.start; addr < b.end; {
+		ins, err := disasm.Decode(c.bytecode[:b.end], addr, c.wordSize)
+		if err != nil {
+			return true
+		}
+		last = ins.Opcode
+		addr += ins.Size
+	}
+
+	switch last {
+	case opcode.EXIT, opcode.JMP, opcode.RET, opcode.ABORT:
+		return false
+	}
+	return true
+}
+
+// renameLabels replaces the names of the labels by "L0", "L1", etc.
+// in the order of their addresses, and returns the original names
+func (c *Compiler) renameLabels() map[string]string {
+	names := make([]string, 0, len(c.labels))
+	for name := range c.labels {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := c.labels[names[i]], c.labels[names[j]]
+		return a < b || (a == b && names[i] < names[j])
+	})
+
+	original := map[string]string{}
+	labels := map[string]int{}
+	for i, name := range names {
+		renamed := fmt.Sprintf("L%d", i)
+		original[renamed] = name
+		labels[renamed] = c.labels[name]
+	}
+	c.labels = labels
+	return original
+}
+
+// LabelsUsedAsValues returns the labels whose addresses are used as
+// values rather than jump targets, e.g. by "store #1, label", sorted by
+// name. Obfuscate updates these references too, but breaks programs
+// computing other addresses from them, so callers should warn about
+// them.
+func (c *Compiler) LabelsUsedAsValues() []string {
+	seen := map[string]bool{}
+	for addr, name := range c.fixups {
+		if _, value := c.widths[addr]; !value {
+			continue
+		}
+		if _, label := c.labels[name]; label {
+			seen[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetStripSymbols sets whether the labels are left out of the header,
+// which is debug info the CPU only uses to report runtime errors
+func (c *Compiler) SetStripSymbols(enabled bool) {
+	c.noSymbols = enabled
+}
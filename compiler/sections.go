@@ -0,0 +1,61 @@
+package compiler
+
+import (
+	"sort"
+	"strings"
+)
+
+// Section is a part of the compiled program, starting at a label and
+// ending at the next one
+type Section struct {
+	// Name is the label, or several labels defined at the same address
+	// joined by commas. Code before the first label is named "(start)".
+	Name string
+
+	// Start is the address of the section
+	Start int
+
+	// Size is the number of bytes of the section
+	Size int
+}
+
+// Sections splits the bytecode of the compiled program at its labels,
+// which shows where the bytes of a program are spent
+func (c *Compiler) Sections() []Section {
+	byAddr := map[int][]string{}
+	for name, addr := range c.labels {
+		byAddr[addr] = append(byAddr[addr], name)
+	}
+
+	starts := []int{}
+	for addr := range byAddr {
+		if addr < len(c.bytecode) {
+			starts = append(starts, addr)
+		}
+	}
+	sort.Ints(starts)
+
+	var sections []Section
+	if len(starts) == 0 || starts[0] > 0 {
+		sections = append(sections, Section{Name: "(start)"})
+	}
+	for _, addr := range starts {
+		names := byAddr[addr]
+		sort.Strings(names)
+		sections = append(sections, Section{Name: strings.Join(names, ", "), Start: addr})
+	}
+
+	for i := range sections {
+		end := len(c.bytecode)
+		if i+1 < len(sections) {
+			end = sections[i+1].Start
+		}
+		sections[i].Size = end - sections[i].Start
+	}
+	return sections
+}
+
+// PoolSize returns the number of bytes of the string pool
+func (c *Compiler) PoolSize() int {
+	return len(c.pool)
+}
@@ -0,0 +1,87 @@
+package compiler
+
+import (
+	"fmt"
+	"vm/opcode"
+	"vm/token"
+)
+
+// SetISA selects the instruction set the program is compiled for:
+// "register" (the default) or "stack".
+func (c *Compiler) SetISA(name string) error {
+	switch name {
+	case "register":
+		c.stackISA = false
+	case "stack":
+		c.stackISA = true
+	default:
+		return fmt.Errorf("unknown instruction set: %s", name)
+	}
+	return nil
+}
+
+// compileStack compiles the program for the stack-machine instruction set.
+//
+// Values live on the stack instead of in registers, e.g.
+//
+//	push 2
+//	push 3
+//	add
+//	print_int
+func (c *Compiler) compileStack() {
+	for c.token.Type != token.EOF {
+		switch c.token.Type {
+		case token.LABEL:
+			c.labels[c.token.Literal[1:]] = len(c.bytecode)
+		case token.PUSH:
+			c.stackPushOp()
+		case token.POP:
+			c.bytecode = append(c.bytecode, byte(opcode.STACK_POP))
+		case token.DUP:
+			c.bytecode = append(c.bytecode, byte(opcode.STACK_DUP))
+		case token.SWAP:
+			c.bytecode = append(c.bytecode, byte(opcode.STACK_SWAP))
+		case token.ADD:
+			c.bytecode = append(c.bytecode, byte(opcode.STACK_ADD))
+		case token.SUB:
+			c.bytecode = append(c.bytecode, byte(opcode.STACK_SUB))
+		case token.MUL:
+			c.bytecode = append(c.bytecode, byte(opcode.STACK_MUL))
+		case token.DIV:
+			c.bytecode = append(c.bytecode, byte(opcode.STACK_DIV))
+		case token.JMP:
+			c.jumpOp(opcode.STACK_JMP)
+		case token.JMP_Z:
+			c.jumpOp(opcode.STACK_JMP_Z)
+		case token.JMP_NZ:
+			c.jumpOp(opcode.STACK_JMP_NZ)
+		case token.PRINT_INT:
+			c.bytecode = append(c.bytecode, byte(opcode.STACK_PRINT))
+		case token.EXIT:
+			c.bytecode = append(c.bytecode, byte(opcode.STACK_EXIT))
+		case token.DATA:
+			c.dataOp()
+		case token.META:
+			c.metaOp()
+		default:
+			c.errorf("%s is not available in the stack instruction set", c.token.Literal)
+		}
+		c.nextToken()
+	}
+}
+
+// stackPushOp pushes a constant or the address of a label
+// e.g. push 42
+func (c *Compiler) stackPushOp() {
+	c.bytecode = append(c.bytecode, byte(opcode.STACK_PUSH))
+
+	c.nextToken()
+	switch c.token.Type {
+	case token.INT:
+		c.emitWord(c.token.Literal)
+	case token.IDENT:
+		c.emitWordLabel(c.token.Literal)
+	default:
+		c.errorf("invalid value to push: %v", c.token)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// configHelp documents the configuration files in the top level help
+const configHelp = `
+Configuration:
+	Defaults for flags are read from ~/.vmrc and then ./vm.toml, whose
+	values win. Both are TOML files: keys before any table apply to every
+	subcommand with such a flag, keys in a table named after a subcommand
+	only to it, e.g.
+
+		timeout = "2s"
+
+		[run]
+		allow = "none"
+
+	Environment variables named VM_ and the flag, e.g. VM_TIMEOUT=5s or
+	VM_WORD_SIZE=32, override the files. Flags given on the command line
+	override both.
+`
+
+// envPrefix starts the names of the environment variables setting flags
+const envPrefix = "VM_"
+
+// config contains the defaults for flags, by the subcommand they apply
+// to, or "" for the ones applying to every subcommand
+type config map[string]map[string]string
+
+// settings are the defaults read at startup
+var settings = config{}
+
+// configFiles returns the configuration files in the order they are read
+func configFiles() []string {
+	var files []string
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".vmrc"))
+	}
+	return append(files, "vm.toml")
+}
+
+// loadConfig reads the configuration files which exist and checks that
+// every value is valid for a flag of the given subcommands
+func loadConfig(global *flag.FlagSet, cmds []subcommands.Command) (config, error) {
+	cfg := config{}
+	for _, path := range configFiles() {
+		tables, err := readTOML(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for table, values := range tables {
+			if cfg[table] == nil {
+				cfg[table] = map[string]string{}
+			}
+			for key, value := range values {
+				cfg[table][key] = value
+			}
+		}
+	}
+	return cfg, cfg.check(global, cmds)
+}
+
+// check applies the configuration to fresh flags of every subcommand,
+// reporting values which aren't valid and names which aren't flags
+func (cfg config) check(global *flag.FlagSet, cmds []subcommands.Command) error {
+	used := map[string]bool{}
+	for key := range cfg[""] {
+		if global.Lookup(key) != nil {
+			used[key] = true
+		}
+	}
+
+	for _, cmd := range cmds {
+		f := flag.NewFlagSet(cmd.Name(), flag.ContinueOnError)
+		cmd.SetFlags(f)
+		if err := cfg.apply(cmd.Name(), f); err != nil {
+			return err
+		}
+		for key := range cfg[""] {
+			if f.Lookup(key) != nil {
+				used[key] = true
+			}
+		}
+		for key := range cfg[cmd.Name()] {
+			if f.Lookup(key) == nil {
+				return fmt.Errorf("[%s] %s: %s has no such flag", cmd.Name(), key, cmd.Name())
+			}
+		}
+	}
+
+	for table := range cfg {
+		if table != "" && !hasCommand(cmds, table) {
+			return fmt.Errorf("[%s]: unknown subcommand", table)
+		}
+	}
+	for key := range cfg[""] {
+		if !used[key] {
+			return fmt.Errorf("%s: no subcommand has such a flag", key)
+		}
+	}
+	return nil
+}
+
+// apply sets the defaults of the flags of the named subcommand, or of
+// the global flags if name is empty. Values of the environment override
+// the ones of the files.
+func (cfg config) apply(name string, f *flag.FlagSet) error {
+	var err error
+	f.VisitAll(func(fl *flag.Flag) {
+		value, ok := cfg[""][fl.Name]
+		if v, found := cfg[name][fl.Name]; found && name != "" {
+			value, ok = v, true
+		}
+		env := envPrefix + strings.ToUpper(strings.ReplaceAll(fl.Name, "-", "_"))
+		if v, found := os.LookupEnv(env); found {
+			value, ok = v, true
+		}
+		if !ok || err != nil {
+			return
+		}
+
+		if serr := fl.Value.Set(value); serr != nil {
+			err = fmt.Errorf("invalid default %q for -%s of %s: %w", value, fl.Name, commandName(name), serr)
+			return
+		}
+		// shown as the default by the help of the subcommand
+		fl.DefValue = value
+	})
+	return err
+}
+
+// commandName names the subcommand of the given flags in messages
+func commandName(name string) string {
+	if name == "" {
+		return "every subcommand"
+	}
+	return name
+}
+
+// hasCommand returns true if one of the subcommands has the given name
+func hasCommand(cmds []subcommands.Command, name string) bool {
+	for _, cmd := range cmds {
+		if cmd.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// configured wraps a subcommand, so its flags default to the settings
+type configured struct {
+	subcommands.Command
+}
+
+func (c configured) SetFlags(f *flag.FlagSet) {
+	c.Command.SetFlags(f)
+	// the settings were checked when they were loaded
+	_ = settings.apply(c.Name(), f)
+}
+
+// explainConfig adds the documentation of the configuration files to
+// the top level help
+func explainConfig(cdr *subcommands.Commander) {
+	explain := cdr.Explain
+	cdr.Explain = func(w io.Writer) {
+		explain(w)
+		fmt.Fprint(w, configHelp)
+	}
+}
+
+// readTOML reads the keys of a TOML file consisting of simple values,
+// by the table they are in, or "" for the ones preceding every table.
+// Strings are unquoted, other values are kept as they are, e.g. 2 or
+// true.
+func readTOML(path string) (map[string]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := map[string]map[string]string{"": {}}
+	table := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for num := 1; scanner.Scan(); num++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if tables[table] == nil {
+				tables[table] = map[string]string{}
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, num)
+		}
+		value = strings.TrimSpace(value)
+		rest := ""
+		if strings.HasPrefix(value, `"`) {
+			quoted, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid string %s", path, num, value)
+			}
+			rest = strings.TrimSpace(value[len(quoted):])
+			value, _ = strconv.Unquote(quoted)
+		} else if end := strings.Index(value, "#"); end >= 0 {
+			value = strings.TrimSpace(value[:end])
+		}
+		if rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("%s:%d: unexpected %s after the value", path, num, rest)
+		}
+		tables[table][strings.TrimSpace(key)] = value
+	}
+	return tables, scanner.Err()
+}
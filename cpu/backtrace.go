@@ -0,0 +1,68 @@
+package cpu
+
+import (
+	"fmt"
+	"strings"
+	"vm/header"
+)
+
+// RuntimeError is returned by Run when the program fails.
+// It records the address of the failing instruction along with the call
+// sites of the subroutines which haven't returned yet.
+type RuntimeError struct {
+	// Err is the underlying error
+	Err error
+
+	// IP is the address of the instruction which failed
+	IP int
+
+	// Backtrace contains the pending call sites, the innermost first
+	Backtrace []int
+
+	// symbols is used to resolve addresses to labels
+	symbols map[string]int
+}
+
+func (e *RuntimeError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s at IP %s", e.Err, e.location(e.IP)))
+
+	if len(e.Backtrace) > 0 {
+		sb.WriteString("\nbacktrace:")
+		for _, addr := range e.Backtrace {
+			sb.WriteString(fmt.Sprintf("\n  called from %s", e.location(addr)))
+		}
+	}
+	return sb.String()
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// location formats the given address, along with the closest label at or
+// before it when debug info is available (e.g. "0029 (check+0x21)").
+func (e *RuntimeError) location(addr int) string {
+	return header.Locate(e.symbols, addr)
+}
+
+// SetSymbols sets the debug info used to resolve addresses in runtime
+// errors to labels, e.g. the labels of the compiler.
+func (c *CPU) SetSymbols(symbols map[string]int) {
+	c.symbols = symbols
+}
+
+// runtimeError wraps an error raised by the instruction at the given IP.
+func (c *CPU) runtimeError(err error, ip int) error {
+	backtrace := make([]int, 0, len(c.calls))
+	for i := len(c.calls) - 1; i >= 0; i-- {
+		backtrace = append(backtrace, c.calls[i])
+	}
+
+	return &RuntimeError{
+		Err:       err,
+		IP:        ip,
+		Backtrace: backtrace,
+		symbols:   c.symbols,
+	}
+}
@@ -0,0 +1,80 @@
+package cpu
+
+import (
+	"fmt"
+	"slices"
+	"vm/header"
+)
+
+// SetBanks sets the initial contents of the memory banks other than zero,
+// e.g. those of header.Header.Banks, and selects bank zero. Banks which
+// aren't given keep their contents, which are zeroed when a program is
+// loaded.
+func (c *CPU) SetBanks(banks map[int][]byte) {
+	c.selectBank(0)
+	for n, data := range banks {
+		if n <= 0 || n >= header.NumBanks {
+			continue
+		}
+		c.banks[n] = make([]byte, header.BankSize)
+		copy(c.banks[n], data)
+	}
+}
+
+// Bank returns the number of the memory bank selected via BANK_SELECT
+func (c *CPU) Bank() int {
+	return c.bank
+}
+
+// selectBank swaps the contents of the banked window of memory for the
+// ones of the bank with the given number
+func (c *CPU) selectBank(n int) {
+	if n == c.bank {
+		return
+	}
+
+	window := c.mem[header.BankStart : header.BankStart+header.BankSize]
+	if c.banks[c.bank] == nil {
+		c.banks[c.bank] = make([]byte, header.BankSize)
+	}
+	copy(c.banks[c.bank], window)
+	if c.banks[n] == nil {
+		clear(window)
+	} else {
+		copy(window, c.banks[n])
+	}
+
+	c.bank = n
+	c.literals.invalidate(header.BankStart, header.BankStart+header.BankSize)
+}
+
+// cloneBanks returns a copy of the contents of the banks not selected
+func (c *CPU) cloneBanks() [header.NumBanks][]byte {
+	var banks [header.NumBanks][]byte
+	for n, data := range c.banks {
+		banks[n] = slices.Clone(data)
+	}
+	return banks
+}
+
+// execBankSelect switches the banked window of memory to the bank whose
+// number is in a register. Execution continues right after the
+// instruction, in the new bank if it is inside of the window.
+func (c *CPU) execBankSelect() (bool, error) {
+	c.ip++
+	reg, err := fetchReg(c)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := reg.GetInt()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 || n >= header.NumBanks {
+		return false, fmt.Errorf("bank %d is out of range, valid banks are 0 to %d", n, header.NumBanks-1)
+	}
+
+	c.selectBank(n)
+	return true, nil
+}
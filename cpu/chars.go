@@ -0,0 +1,102 @@
+package cpu
+
+import "fmt"
+
+// charCode returns the character code in register #0, which must be a
+// byte as returned by ORD
+func (c *CPU) charCode() (byte, error) {
+	code, err := c.regs[0].GetInt()
+	if err != nil {
+		return 0, err
+	}
+	if code < 0 || code > 0xff {
+		return 0, fmt.Errorf("character code %d is out of range, it must be a byte", code)
+	}
+	return byte(code), nil
+}
+
+// classify sets register #0 to one if the character code in it is in
+// a class, and to zero otherwise. The zero flag is set like by the
+// arithmetic instructions, i.e. if the character isn't in the class.
+func (c *CPU) classify(in func(ch byte) bool) error {
+	ch, err := c.charCode()
+	if err != nil {
+		return err
+	}
+	result := 0
+	if in(ch) {
+		result = 1
+	}
+	c.regs[0].SetInt(result)
+	c.SetZero(result == 0)
+	return nil
+}
+
+// IsDigitTrap tests if a character is an ASCII digit, 0-9.
+//
+// Input: the character code in register #0.
+//
+// Output: sets register #0 with one if it is a digit, or zero and the
+// zero flag if it isn't.
+func IsDigitTrap(c *CPU, num int) error {
+	return c.classify(func(ch byte) bool { return '0' <= ch && ch <= '9' })
+}
+
+// IsAlphaTrap tests if a character is an ASCII letter, a-z or A-Z.
+//
+// Input: the character code in register #0.
+//
+// Output: sets register #0 with one if it is a letter, or zero and the
+// zero flag if it isn't.
+func IsAlphaTrap(c *CPU, num int) error {
+	return c.classify(func(ch byte) bool { return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' })
+}
+
+// IsSpaceTrap tests if a character is ASCII white space: a space, tab,
+// newline, carriage return, vertical tab or form feed.
+//
+// Input: the character code in register #0.
+//
+// Output: sets register #0 with one if it is white space, or zero and
+// the zero flag if it isn't.
+func IsSpaceTrap(c *CPU, num int) error {
+	return c.classify(func(ch byte) bool {
+		return ch == ' ' || '\t' <= ch && ch <= '\r'
+	})
+}
+
+// ToUpperTrap converts a character to upper case, other characters
+// than the ASCII letters a-z are kept.
+//
+// Input: the character code in register #0.
+//
+// Output: sets register #0 with the converted character code.
+func ToUpperTrap(c *CPU, num int) error {
+	ch, err := c.charCode()
+	if err != nil {
+		return err
+	}
+	if 'a' <= ch && ch <= 'z' {
+		ch -= 'a' - 'A'
+	}
+	c.regs[0].SetInt(int(ch))
+	return nil
+}
+
+// ToLowerTrap converts a character to lower case, other characters
+// than the ASCII letters A-Z are kept.
+//
+// Input: the character code in register #0.
+//
+// Output: sets register #0 with the converted character code.
+func ToLowerTrap(c *CPU, num int) error {
+	ch, err := c.charCode()
+	if err != nil {
+		return err
+	}
+	if 'A' <= ch && ch <= 'Z' {
+		ch += 'a' - 'A'
+	}
+	c.regs[0].SetInt(int(ch))
+	return nil
+}
@@ -0,0 +1,48 @@
+package cpu
+
+// Checkpoint returns the state saved by the CHECKPOINT trap under the
+// given name, e.g. to inspect the state of a solver from the host
+func (c *CPU) Checkpoint(name string) (*CPU, bool) {
+	snapshot, ok := c.checkpoints[name]
+	return snapshot, ok
+}
+
+// saveCheckpoint saves the current state under the given name, replacing
+// an earlier checkpoint with the same name
+func (c *CPU) saveCheckpoint(name string) {
+	snapshot := c.Clone()
+	snapshot.checkpoints = nil
+	if c.checkpoints == nil {
+		c.checkpoints = map[string]*CPU{}
+	}
+	c.checkpoints[name] = snapshot
+}
+
+// restoreCheckpoint sets the registers, flags, memory and its banks,
+// heap, instruction pointer and stacks back to the checkpoint with the
+// given name. The checkpoints themselves, the I/O and the observers are
+// kept.
+func (c *CPU) restoreCheckpoint(name string) bool {
+	snapshot, ok := c.checkpoints[name]
+	if !ok {
+		return false
+	}
+
+	// the snapshot is copied, so it can be restored again
+	restored := snapshot.Clone()
+	c.regs = restored.regs
+	c.flags = restored.flags
+	c.mem = restored.mem
+	c.bank = restored.bank
+	c.banks = restored.banks
+	c.literals = literalCache{}
+	c.ip = restored.ip
+	c.stack = restored.stack
+	c.sp = restored.sp
+	c.fp = restored.fp
+	c.calls = restored.calls
+	c.exitHooks = restored.exitHooks
+	c.heap = restored.heap
+	c.interrupted = restored.interrupted
+	return true
+}
@@ -0,0 +1,28 @@
+package cpu
+
+import "testing"
+
+func TestRestoreHashesOnTheStack(t *testing.T) {
+	c := NewCPU()
+	h := &HashObject{Values: map[string]Object{}}
+	h.set("a", &IntObject{Value: 1})
+	c.regs[0].SetHash(h)
+	c.stack.PushObject(h)
+
+	c.saveCheckpoint("start")
+	h.set("a", &IntObject{Value: 2})
+	h.set("b", &IntObject{Value: 3})
+	c.restoreCheckpoint("start")
+
+	reg, ok := c.regs[0].obj.(*HashObject)
+	if !ok {
+		t.Fatalf("#0 holds a %s after the restore", c.regs[0].obj.Type())
+	}
+	top, _ := c.stack.PopObject()
+	if top != reg {
+		t.Error("#0 and the stack refer to different hashes after the restore")
+	}
+	if got := reg.String(); got != (&HashObject{Values: map[string]Object{"a": &IntObject{Value: 1}}}).String() {
+		t.Errorf("the hash is %s after the restore", got)
+	}
+}
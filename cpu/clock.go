@@ -0,0 +1,61 @@
+package cpu
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time to the CPU, e.g. to seed INT_RAND, so tests and
+// replays can run programs depending on the time deterministically
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the clock of the host, which is used by default
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FakeClock is a clock which starts at a given time and moves forward
+// by a fixed step every time it is read, so consecutive readings differ
+// but are the same on every run
+type FakeClock struct {
+	mu   sync.Mutex
+	now  time.Time
+	step time.Duration
+}
+
+// NewFakeClock returns a clock starting at the given time, which
+// advances by step on every reading
+func NewFakeClock(start time.Time, step time.Duration) *FakeClock {
+	return &FakeClock{now: start, step: step}
+}
+
+// Now returns the current time of the clock, then advances it
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now
+	f.now = f.now.Add(f.step)
+	return now
+}
+
+// Advance moves the clock forward by the given duration
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+}
+
+// SetClock sets the clock the CPU reads the time from. nil selects the
+// clock of the host, the default.
+func (c *CPU) SetClock(clock Clock) {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	c.clock = clock
+}
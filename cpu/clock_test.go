@@ -0,0 +1,30 @@
+package cpu
+
+import (
+	"testing"
+	"time"
+	"vm/opcode"
+)
+
+func TestFakeClockMakesIntRandDeterministic(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	code := program(ins(opcode.INT_RAND, 0), ins(opcode.INT_RAND, 1))
+
+	run := func() (int, int) {
+		c := NewCPU()
+		c.SetClock(NewFakeClock(start, time.Millisecond))
+		if err := c.execute(code...); err != nil {
+			t.Fatal(err)
+		}
+		return c.intReg(0), c.intReg(1)
+	}
+
+	a0, a1 := run()
+	b0, b1 := run()
+	if a0 != b0 || a1 != b1 {
+		t.Errorf("runs differ: %d, %d and %d, %d", a0, a1, b0, b1)
+	}
+	if a0 == a1 {
+		t.Errorf("consecutive numbers are both %d", a0)
+	}
+}
@@ -0,0 +1,56 @@
+package cpu
+
+import "vm/opcode"
+
+// branchSizes are the sizes of the conditional jumps, so one was taken
+// if the IP ends up elsewhere than right after it, and zero for the
+// instructions which always branch
+var branchSizes = map[int]int{
+	opcode.JMP:      0,
+	opcode.JMP_Z:    3,
+	opcode.JMP_NZ:   3,
+	opcode.JMP_S:    3,
+	opcode.JMP_NS:   3,
+	opcode.CALL:     0,
+	opcode.CALL_REG: 0,
+	opcode.RET:      0,
+}
+
+// stackBranchSizes are the branchSizes of the stack-machine instruction
+// set
+var stackBranchSizes = map[int]int{
+	opcode.STACK_JMP:    0,
+	opcode.STACK_JMP_Z:  3,
+	opcode.STACK_JMP_NZ: 3,
+}
+
+// countBranch counts the instruction with the given opcode at ip as a
+// branch taken, unless it is a conditional jump which left the IP right
+// after it
+func (c *CPU) countBranch(op byte, ip int) {
+	sizes := branchSizes
+	if c.stackISA {
+		sizes = stackBranchSizes
+	}
+	if size, ok := sizes[int(op)]; ok && (size == 0 || c.ip != ip+size) {
+		c.branches++
+	}
+}
+
+// PerfCountersTrap reads the performance counters of the program, so
+// benchmarks can report their own results. Like the instructions clock
+// of TIME the counts wrap around beyond a word, and they start at zero
+// when the program is loaded.
+//
+// Input: none.
+//
+// Output: sets register #0 with the number of instructions executed,
+// register #1 with the number of branches taken, i.e. jumps whose
+// condition held, calls and returns, and register #2 with the number of
+// traps invoked. The TRAP reading them isn't counted yet.
+func PerfCountersTrap(c *CPU, num int) error {
+	c.regs[0].SetInt(toWord(c, c.executed))
+	c.regs[1].SetInt(toWord(c, c.branches))
+	c.regs[2].SetInt(toWord(c, c.trapCalls))
+	return nil
+}
@@ -4,1054 +4,876 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
 	"os/exec"
-	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+	"vm/header"
 	"vm/opcode"
 )
 
-// maxMemSize maximum available memory (RAM)
-const maxMemSize = 0xffff
+// MemSize maximum available memory (RAM)
+const MemSize = 0xffff
+
+// ErrTooLarge is the error of programs which don't fit in memory
+var ErrTooLarge = errors.New("program is too large for memory")
+
+const (
+	// exitHookReturn is the return address pushed when an exit hook is called.
+	// It can't be a real address, so a hook returning is easy to spot in a
+	// backtrace.
+	exitHookReturn = -1
+
+	// maxExitHookSteps limits the number of instructions a single exit hook
+	// may execute, since hooks also run after the context has been cancelled.
+	maxExitHookSteps = 0xffff
+)
 
 type Flags struct {
 	// zero flag
 	z bool
+
+	// sign flag, set if a result was negative
+	s bool
+
+	// carry flag, set if an addition didn't fit in the word
+	// or a subtraction needed to borrow
+	c bool
+
+	// overflow flag, set if the result of a signed addition or
+	// subtraction didn't fit in the word
+	v bool
 }
 
 // CPU is the virtual machine's state
 type CPU struct {
-	// registers
-	regs [15]*Register
+	// registers, header.DefaultNumRegisters unless set via
+	// SetNumRegisters
+	regs []*Register
 
 	flags Flags
 
 	// mem is memory (RAM) where the program is loaded.
-	// Loaded program size shouldn't exceed maxMemSize-1,
+	// Loaded program size shouldn't exceed MemSize-1,
 	// so the last memory byte will always be a "0" and the program can terminate
 	// since "0" is the EXIT opcode.
-	mem [maxMemSize]byte
+	mem [MemSize]byte
 
 	// instruction pointer
 	ip int
 
-	stack *Stack
-
-	// context is used by callers to implement timeouts
-	ctx context.Context
-
-	// STDIN is an input reader used for the input trap
-	STDIN *bufio.Reader
-
-	// STDOUT is the writer used for output
-	STDOUT *bufio.Writer
-}
-
-func NewCPU() *CPU {
-	cpu := &CPU{ctx: context.Background()}
-	cpu.Reset()
-
-	// allow reading from STDIN
-	cpu.STDIN = bufio.NewReader(os.Stdin)
-
-	// set standard output for STDOUT
-	cpu.STDOUT = bufio.NewWriter(os.Stdout)
-
-	return cpu
-}
-
-// Reset sets the CPU into its initial state by setting registers, IP
-// and stack back to zero values.
-func (c *CPU) Reset() {
-	// reset registers
-	for i := 0; i < len(c.regs); i++ {
-		c.regs[i] = NewRegister()
-	}
-
-	// reset instruction pointer
-	c.ip = 0
-
-	// reset stack
-	c.stack = NewStack()
-}
-
-// ReadFile reads the program (bytecode) from the named file into RAM.
-// NOTE: The CPU state is reset prior to the load.
-func (c *CPU) ReadFile(path string) error {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return fmt.Errorf("failed to read file: %s - %s", path, err.Error())
-	}
-
-	if len(data) >= maxMemSize {
-		return fmt.Errorf(
-			"program is too large for memory: RAM size => %d bytes, program size => %d bytes",
-			maxMemSize, len(data))
-	}
-
-	c.LoadBytes(data)
-	return nil
-}
-
-// LoadBytes loads the given program into RAM.
-// NOTE: The CPU state is reset prior to the load.
-func (c *CPU) LoadBytes(data []byte) {
-	c.Reset()
-
-	if len(data) >= maxMemSize {
-		fmt.Printf(
-			"program is too large for memory: RAM size => %d bytes, program size => %d bytes\n",
-			maxMemSize, len(data))
-	}
+	// current is the address of the instruction being executed, which
+	// #ip reads
+	current int
 
-	// copy contents of file to our memory
-	copy(c.mem[:], data)
-}
+	// pseudo contains the pseudo-registers used by the current
+	// instruction, see Reg
+	pseudo []pseudoRegister
 
-// readInt reads a two byte number from the current IP.
-// i.e this reads two bytes and returns a 16-bit value to the caller,
-// skipping over both bytes in the IP.
-func (c *CPU) readInt() int {
-	// remainder
-	r := int(c.mem[c.ip])
-	c.ip++
-	// quotient
-	q := int(c.mem[c.ip])
-	c.ip++
-	return r + q*256
-}
+	// wordSize is the size of the machine word in bits
+	wordSize int
 
-// readStr reads a string from the IP position.
-// String is prefixed by its lengths (16-bit value contained in two bytes).
-func (c *CPU) readStr() (string, error) {
-	// read the length of the string
-	strLen := c.readInt()
-
-	// can't read beyond RAM but wrap-around will be allowed
-	if strLen >= maxMemSize {
-		return "", fmt.Errorf(
-			"string is too large for memory: RAM size => %d bytes, string size => %d bytes",
-			maxMemSize, strLen)
-	}
+	// signed is set if registers hold signed integers
+	signed bool
 
-	// build the string
-	ip := c.ip
-	str := ""
-	for i := 0; i < strLen; i++ {
-		tmpIP := ip + i
-		// wrap around
-		if tmpIP == maxMemSize {
-			tmpIP = 0
-		}
-		str += string(c.mem[tmpIP])
-	}
+	// overflow records integers which didn't fit in a register, nil
+	// unless faulting on overflows, see SetFaultOnOverflow
+	overflow *overflow
 
-	// move the IP over the length of the string
-	c.ip += strLen
+	// stackISA selects the stack-machine instruction set
+	stackISA bool
 
-	return str, nil
-}
+	stack *Stack
 
-// Run launches the interpreter.
-// It does not terminate until an EXIT instruction.
-func (c *CPU) Run() error {
-	run := true
-	for run {
-		if c.ip >= maxMemSize {
-			return fmt.Errorf("reading beyond RAM")
-		}
+	// ramStack keeps the stack in memory instead of stack, see SetRAMStack
+	ramStack bool
 
-		op := opcode.NewOpcode(c.mem[c.ip])
+	// sp is the stack pointer, the address of the topmost entry of the
+	// stack in RAM
+	sp int
 
-		debugPrintf("%04x %02x [%s]\n", c.ip, op.Value(), op.String())
+	// fp is the frame pointer, the stack size after ENTER saved the
+	// previous frame pointer, or zero outside of frames
+	fp int
 
-		// Test context at every iteration.
-		// This is a little slow and inefficient, but allows the execution to be time limited.
-		select {
-		case <-c.ctx.Done():
-			return fmt.Errorf("timeout during execution")
-		default:
-			// nop
-		}
+	// calls contains the addresses of the CALL instructions which haven't
+	// returned yet. It is kept apart from the stack, which the program
+	// is free to modify, and is used to report a backtrace.
+	calls []int
 
-		switch int(op.Value()) {
-		case opcode.EXIT:
-			run = false
+	// dryRun reports side effects instead of performing them
+	dryRun bool
 
-		case opcode.INT_STORE:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
-			}
-
-			c.ip++
-			val := c.readInt()
-			c.regs[reg].SetInt(val)
-
-		case opcode.INT_PRINT:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
-			}
+	// allowed contains the sensitive features the program may use
+	allowed header.Capability
 
-			val, err := c.regs[reg].GetInt()
-			if err != nil {
-				return err
-			}
-			if val < 256 {
-				_, err = c.STDOUT.WriteString(fmt.Sprintf("%02x", val))
-				if err != nil {
-					return err
-				}
-			} else {
-				_, err = c.STDOUT.WriteString(fmt.Sprintf("%04x", val))
-				if err != nil {
-					return err
-				}
-			}
+	// symbols maps labels to addresses, used to report runtime errors
+	symbols map[string]int
 
-			if err = c.STDOUT.Flush(); err != nil {
-				return err
-			}
+	// readOnly is the memory of a program loaded with ReadOnlyCode
+	readOnly codeRange
 
-			// next instruction
-			c.ip++
+	// codeSize is the size of the loaded program, including the offset
+	// it is loaded at, codeStart
+	codeSize, codeStart int
 
-		case opcode.INT_TO_STR:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
-			}
+	// pool is the string pool of the program, see SetStringPool
+	pool []byte
 
-			i, err := c.regs[reg].GetInt()
-			if err != nil {
-				return err
-			}
+	// exitHooks contains the addresses of subroutines registered
+	// via the ATEXIT trap
+	exitHooks []int
 
-			// change from int to string
-			c.regs[reg].SetStr(fmt.Sprintf("%d", i))
+	// checkpoints contains the states saved by the CHECKPOINT trap
+	checkpoints map[string]*CPU
 
-			// next instruction
-			c.ip++
+	// heap manages the memory allocated by ALLOC
+	heap heap
 
-		case opcode.INT_RAND:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
-			}
+	// literals caches the decoded string operands of CMP_STR
+	literals literalCache
 
-			r := rand.New(rand.NewSource(time.Now().UnixNano()))
-			c.regs[reg].SetInt(r.Intn(maxMemSize))
-			c.ip++
+	// memoryLimit is the number of host bytes strings, hashes and the
+	// stacks may use, unlimited if zero
+	memoryLimit int
 
-		case opcode.JMP:
-			c.ip++
-			addr := c.readInt()
-			c.ip = addr
+	// peakMemory is the largest number of host bytes used so far
+	peakMemory int
 
-		case opcode.JMP_Z:
-			c.ip++
-			addr := c.readInt()
-			if c.flags.z {
-				c.ip = addr
-			}
+	// memoryPass numbers the calls of MemoryUsage, see countHash
+	memoryPass int
 
-		case opcode.JMP_NZ:
-			c.ip++
-			addr := c.readInt()
-			if !c.flags.z {
-				c.ip = addr
-			}
+	// layout records how the program uses the memory, see MemoryLayout
+	layout layout
 
-		case opcode.ADD:
-			c.ip++
-			// result
-			res := c.mem[c.ip]
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", res)
-			}
+	// outputLimit is the number of bytes the program may print,
+	// unlimited if zero, see SetOutputLimit
+	outputLimit int
 
-			c.ip++
-			a := c.mem[c.ip]
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", a)
-			}
+	// outputAbort stops the program once it exceeds the output limit
+	outputAbort bool
 
-			c.ip++
-			b := c.mem[c.ip]
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", b)
-			}
+	// printed is the number of bytes printed so far
+	printed int
 
-			c.ip++
+	// truncated is set once output was dropped due to the output limit
+	truncated bool
 
-			aVal, err := c.regs[a].GetInt()
-			if err != nil {
-				return err
-			}
-			bVal, err := c.regs[b].GetInt()
-			if err != nil {
-				return err
-			}
-			c.regs[res].SetInt(aVal + bVal)
-
-		case opcode.SUB:
-			c.ip++
-			// result
-			res := c.mem[c.ip]
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", res)
-			}
+	// observers are notified about every executed instruction
+	observers []Observer
 
-			c.ip++
-			a := c.mem[c.ip]
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", a)
-			}
+	// context is used by callers to implement timeouts
+	ctx context.Context
 
-			c.ip++
-			b := c.mem[c.ip]
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", b)
-			}
+	// clock tells the time, e.g. to seed INT_RAND
+	clock Clock
 
-			c.ip++
+	// rng is the stream of random numbers, see SetSeed. It is created
+	// on first use and shared by clones, like the clock.
+	rng *rand.Rand
 
-			aVal, err := c.regs[a].GetInt()
-			if err != nil {
-				return err
-			}
-			bVal, err := c.regs[b].GetInt()
-			if err != nil {
-				return err
-			}
-			c.regs[res].SetInt(aVal - bVal)
+	// seed seeds rng if seeded is set, otherwise it is seeded from the
+	// clock
+	seed   int64
+	seeded bool
 
-			// Set the zero flag if the result was zero or less.
-			// Used during iteration (see examples/concat.in).
-			resVal, err := c.regs[res].GetInt()
-			if err != nil {
-				return err
-			}
-			if resVal <= 0 {
-				c.flags.z = true
-			}
+	// handlers maps interrupt vectors to the addresses of their handlers
+	handlers map[int]int
 
-		case opcode.MUL:
-			c.ip++
-			// result
-			res := c.mem[c.ip]
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", res)
-			}
+	// pending has a bit set for every raised interrupt vector, it is
+	// accessed atomically
+	pending uint32
 
-			c.ip++
-			a := c.mem[c.ip]
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", a)
-			}
+	// interrupted records the programs interrupted by running handlers
+	interrupted []interruptFrame
 
-			c.ip++
-			b := c.mem[c.ip]
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", b)
-			}
+	// timer raises an interrupt periodically, see SetTimer
+	timer timer
 
-			c.ip++
+	// exitCode is the exit code of the program, see ExitCode
+	exitCode int
 
-			aVal, err := c.regs[a].GetInt()
-			if err != nil {
-				return err
-			}
-			bVal, err := c.regs[b].GetInt()
-			if err != nil {
-				return err
-			}
-			c.regs[res].SetInt(aVal * bVal)
-
-		case opcode.DIV:
-			c.ip++
-			// result
-			res := c.mem[c.ip]
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", res)
-			}
+	// started is the time Run was first called after loading the
+	// program, see TIME
+	started time.Time
 
-			c.ip++
-			a := c.mem[c.ip]
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", a)
-			}
+	// executed is the number of instructions executed since loading the
+	// program
+	executed int
 
-			c.ip++
-			b := c.mem[c.ip]
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", b)
-			}
+	// branches and trapCalls are the numbers of branches taken and of
+	// traps invoked since loading the program, see PerfCountersTrap
+	branches, trapCalls int
 
-			c.ip++
+	// ports are the connections to the host set up via ConnectPort
+	ports map[int]*port
 
-			aVal, err := c.regs[a].GetInt()
-			if err != nil {
-				return err
-			}
-			bVal, err := c.regs[b].GetInt()
-			if err != nil {
-				return err
-			}
-
-			if bVal == 0 {
-				return fmt.Errorf("devision by zero")
-			}
-
-			c.regs[res].SetInt(aVal / bVal)
-
-		case opcode.INC:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
-			}
+	// devices are the memory-mapped devices set up via MapDevice
+	devices []mappedDevice
 
-			i, err := c.regs[reg].GetInt()
-			if err != nil {
-				return err
-			}
+	// bank is the memory bank selected via BANK_SELECT, and banks keep
+	// the contents of the others, nil while they are zeroed
+	bank  int
+	banks [header.NumBanks][]byte
 
-			// if the value equals maximum memory size it will wrap around
-			if i == maxMemSize {
-				i = 0
-			} else {
-				i++
-			}
+	// traps are the trap functions set up for this CPU only, e.g. by
+	// AddTrapProvider, which take precedence over TRAPS
+	traps map[int]TrapFunction
 
-			c.flags.z = i == 0
-
-			c.regs[reg].SetInt(i)
+	// STDIN is an input reader used for the input trap
+	STDIN *bufio.Reader
 
-			c.ip++
+	// STDOUT is the writer used for output
+	STDOUT *bufio.Writer
 
-		case opcode.DEC:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
-			}
+	// STDERR is the writer used for the error output of the program,
+	// e.g. by PRINT_ERR, kept apart from STDOUT
+	STDERR *bufio.Writer
+}
 
-			i, err := c.regs[reg].GetInt()
-			if err != nil {
-				return err
-			}
+func NewCPU() *CPU {
+	cpu := &CPU{ctx: context.Background(), clock: systemClock{}, allowed: header.CapAll, wordSize: defaultWordSize}
+	cpu.regs = make([]*Register, header.DefaultNumRegisters)
+	cpu.Reset()
 
-			// if the value equals zero it will wrap around
-			if i == 0 {
-				i = maxMemSize
-			} else {
-				i--
-			}
+	// allow reading from STDIN
+	cpu.STDIN = bufio.NewReader(os.Stdin)
 
-			c.flags.z = i == 0
+	// set standard output for STDOUT
+	cpu.STDOUT = bufio.NewWriter(os.Stdout)
 
-			c.regs[reg].SetInt(i)
+	// set standard error for STDERR
+	cpu.STDERR = bufio.NewWriter(os.Stderr)
 
-			c.ip++
+	return cpu
+}
 
-		case opcode.AND:
-			c.ip++
-			// result
-			res := c.mem[c.ip]
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", res)
-			}
+// Reset sets the CPU into its initial state by setting registers, IP
+// and stack back to zero values.
+func (c *CPU) Reset() {
+	// reset registers
+	c.resetRegisters()
 
-			c.ip++
-			a := c.mem[c.ip]
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", a)
-			}
+	// reset instruction pointer
+	c.ip = 0
 
-			c.ip++
-			b := c.mem[c.ip]
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", b)
-			}
+	// reset stack
+	c.stack = NewStack()
+	c.sp = RAMStackTop
+	c.fp = 0
+	c.calls = nil
 
-			c.ip++
+	// forget registered exit hooks
+	c.exitHooks = nil
 
-			aVal, err := c.regs[a].GetInt()
-			if err != nil {
-				return err
-			}
-			bVal, err := c.regs[b].GetInt()
-			if err != nil {
-				return err
-			}
-			c.regs[res].SetInt(aVal & bVal)
-
-		case opcode.OR:
-			c.ip++
-			// result
-			res := c.mem[c.ip]
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", res)
-			}
+	// forget saved checkpoints
+	c.checkpoints = nil
 
-			c.ip++
-			a := c.mem[c.ip]
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", a)
-			}
+	// release the heap
+	c.heap = heap{}
 
-			c.ip++
-			b := c.mem[c.ip]
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", b)
-			}
+	// forget the memory usage
+	c.peakMemory = 0
+	c.layout = layout{}
 
-			c.ip++
+	// forget the printed output
+	c.printed = 0
+	c.truncated = false
 
-			aVal, err := c.regs[a].GetInt()
-			if err != nil {
-				return err
-			}
-			bVal, err := c.regs[b].GetInt()
-			if err != nil {
-				return err
-			}
-			c.regs[res].SetInt(aVal | bVal)
-
-		case opcode.XOR:
-			c.ip++
-			// result
-			res := c.mem[c.ip]
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", res)
-			}
+	// forget the exit code
+	c.exitCode = 0
 
-			c.ip++
-			a := c.mem[c.ip]
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", a)
-			}
+	// restart the random numbers
+	c.rng = nil
 
-			c.ip++
-			b := c.mem[c.ip]
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", b)
-			}
+	// restart the clocks of TIME
+	c.started = time.Time{}
+	c.executed = 0
+	c.branches, c.trapCalls = 0, 0
 
-			c.ip++
+	// forget interrupt handlers and pending interrupts
+	c.handlers = nil
+	atomic.StoreUint32(&c.pending, 0)
+	c.interrupted = nil
 
-			aVal, err := c.regs[a].GetInt()
-			if err != nil {
-				return err
-			}
-			bVal, err := c.regs[b].GetInt()
-			if err != nil {
-				return err
-			}
-			c.regs[res].SetInt(aVal ^ bVal)
-
-		case opcode.STR_STORE:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
-			}
+	// restart the timer
+	c.timer.left = c.timer.every
+}
 
-			c.ip++
-			str, err := c.readStr()
-			if err != nil {
-				return err
-			}
+// ReadFile reads the program (bytecode) from the named file into RAM.
+// If the program has a header its symbols and entry point are used.
+// The options apply like for LoadBytes.
+// NOTE: The CPU state is reset prior to the load.
+func (c *CPU) ReadFile(path string, opts ...LoadOption) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %s - %w", path, err)
+	}
 
-			c.regs[reg].SetStr(str)
+	h, code, err := header.Decode(data)
+	if err != nil {
+		return fmt.Errorf("failed to read header: %s - %s", path, err.Error())
+	}
 
-		case opcode.STR_PRINT:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
+	if err = h.CheckFeatures(); err != nil {
+		return fmt.Errorf("refusing to load %s: %w", path, err)
+	}
 
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
-			}
+	if err = c.CheckCapabilities(h.Capabilities); err != nil {
+		return fmt.Errorf("refusing to load %s: %w", path, err)
+	}
 
-			str, err := c.regs[reg].GetStr()
-			if err != nil {
-				return err
-			}
+	if err = c.SetWordSize(h.WordSize); err != nil {
+		return fmt.Errorf("refusing to load %s: %w", path, err)
+	}
 
-			_, err = c.STDOUT.WriteString(str)
-			if err != nil {
-				return err
-			}
+	if err = c.CheckRegisters(h.Registers); err != nil {
+		return fmt.Errorf("refusing to load %s: %w", path, err)
+	}
 
-			if err = c.STDOUT.Flush(); err != nil {
-				return err
-			}
+	c.SetStackISA(h.Features&header.FeatStackISA != 0)
+	c.SetSigned(h.Features&header.FeatSignedInts != 0)
+	c.SetRAMStack(h.Features&header.FeatRAMStack != 0)
 
-			// next instruction
-			c.ip++
+	if err = c.LoadBytes(code, opts...); err != nil {
+		return fmt.Errorf("refusing to load %s: %w", path, err)
+	}
+	c.SetSymbols(h.Symbols)
+	c.SetStringPool(h.Strings)
+	c.SetBanks(h.Banks)
+	c.ip = h.Entry
+	return nil
+}
 
-		case opcode.CONCAT:
-			c.ip++
-			// result
-			res := c.mem[c.ip]
-			if int(res) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", res)
-			}
+// LoadBytes loads the given program into RAM at address zero.
+// The options, e.g. ReadOnlyCode, apply until the next program is loaded.
+// NOTE: The CPU state is reset prior to the load, and any symbols
+// of a previously loaded program are forgotten.
+func (c *CPU) LoadBytes(data []byte, opts ...LoadOption) error {
+	return c.LoadBytesAt(data, 0, opts...)
+}
 
-			c.ip++
-			a := c.mem[c.ip]
-			if int(a) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", a)
-			}
+// LoadBytesAt loads the given program into RAM at the given offset,
+// where the execution starts. The rest of the memory is zeroed, and the
+// program is too large if it doesn't end before the last byte of RAM.
+// NOTE: The CPU state is reset prior to the load, and any symbols
+// of a previously loaded program are forgotten.
+func (c *CPU) LoadBytesAt(data []byte, offset int, opts ...LoadOption) error {
+	if err := checkFits(data, offset); err != nil {
+		return err
+	}
 
-			c.ip++
-			b := c.mem[c.ip]
-			if int(b) >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", b)
-			}
+	c.Reset()
+	c.symbols = nil
+	c.pool = nil
+	c.mem = [MemSize]byte{}
+	c.literals = literalCache{}
+	c.bank = 0
+	c.banks = [header.NumBanks][]byte{}
 
-			c.ip++
+	// copy contents of file to our memory
+	copy(c.mem[offset:], data)
+	c.codeSize = offset + len(data)
+	c.codeStart = offset
+	c.ip = offset
+	c.applyLoadOptions(offset, offset+len(data), opts)
+	return nil
+}
 
-			aVal, err := c.regs[a].GetStr()
-			if err != nil {
-				return err
-			}
-			bVal, err := c.regs[b].GetStr()
-			if err != nil {
-				return err
-			}
-			c.regs[res].SetStr(aVal + bVal)
-
-		case opcode.SYSTEM:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
-			}
+// LoadBytesKeepState loads the given program into RAM without resetting
+// the CPU, so registers, the stack and memory not covered by the program
+// are preserved from the previous run. Execution restarts at address zero.
+func (c *CPU) LoadBytesKeepState(data []byte, opts ...LoadOption) error {
+	if err := checkFits(data, 0); err != nil {
+		return err
+	}
 
-			str, err := c.regs[reg].GetStr()
-			if err != nil {
-				return err
-			}
+	copy(c.mem[:], data)
+	c.codeSize = len(data)
+	c.codeStart = 0
+	c.literals.invalidate(0, len(data))
 
-			toExec := splitCommand(str)
-			cmd := exec.Command(toExec[0], toExec[1:]...)
+	c.ip = 0
+	c.calls = nil
+	c.symbols = nil
+	c.pool = nil
+	c.applyLoadOptions(0, len(data), opts)
+	return nil
+}
 
-			var (
-				out *bytes.Buffer
-				er  *bytes.Buffer
-			)
-			cmd.Stdout = out
-			cmd.Stderr = er
+// LoadReader loads the program read from r into RAM at address zero,
+// like LoadBytes. Reading stops as soon as the program is known to be
+// too large, so r may be endless.
+func (c *CPU) LoadReader(r io.Reader, opts ...LoadOption) error {
+	data, err := io.ReadAll(io.LimitReader(r, MemSize))
+	if err != nil {
+		return fmt.Errorf("failed to read program: %w", err)
+	}
+	if len(data) >= MemSize {
+		return fmt.Errorf("%w: RAM size => %d bytes, program size => more than %d bytes",
+			ErrTooLarge, MemSize, MemSize-1)
+	}
+	return c.LoadBytes(data, opts...)
+}
 
-			if err = cmd.Run(); err != nil {
-				return fmt.Errorf("error invoking system (%s): %s", str, err)
-			}
+// checkFits returns ErrTooLarge unless the program loaded at offset
+// ends before the last byte of RAM, which stays zero so the program
+// terminates: "0" is the EXIT opcode.
+func checkFits(data []byte, offset int) error {
+	if offset < 0 || offset >= MemSize {
+		return fmt.Errorf("offset 0x%x is outside of memory", offset)
+	}
+	if offset+len(data) >= MemSize {
+		return fmt.Errorf("%w: RAM size => %d bytes, program size => %d bytes at offset 0x%x",
+			ErrTooLarge, MemSize, len(data), offset)
+	}
+	return nil
+}
 
-			// stdout
-			fmt.Printf("%s\n", out.String())
+// readInt reads a two byte number from the current IP.
+// i.e this reads two bytes and returns a 16-bit value to the caller,
+// skipping over both bytes in the IP.
+func (c *CPU) readInt() int {
+	return fetchInt(c)
+}
 
-			// stderr, if non-empty
-			if len(er.String()) > 0 {
-				fmt.Printf("%s\n", er.String())
-			}
+// readWord reads an immediate integer operand from the current IP.
+// Its width depends on the word size, e.g. four bytes for 32-bit words,
+// and it is stored least significant byte first like readInt.
+func (c *CPU) readWord() int {
+	return fetchWord(c)
+}
 
-		case opcode.STR_TO_INT:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
-			}
+// Run launches the interpreter.
+// It does not terminate until an EXIT instruction.
+func (c *CPU) Run() error {
+	if c.started.IsZero() {
+		c.started = c.clock.Now()
+	}
 
-			s, err := c.regs[reg].GetStr()
-			if err != nil {
+	for {
+		// Test context at every iteration.
+		// This is a little slow and inefficient, but allows the execution to be time limited.
+		select {
+		case <-c.ctx.Done():
+			timeout := c.runtimeError(ErrTimeout, c.ip)
+			if err := c.runExitHooks(); err != nil {
 				return err
 			}
+			return timeout
+		default:
+			// nop
+		}
 
-			i, err := strconv.Atoi(s)
-			if err != nil {
-				return fmt.Errorf("failed to convert string (%s) to int: %s", s, err)
-			}
-
-			c.regs[reg].SetInt(i)
-
-			// next instruction
-			c.ip++
-
-		case opcode.CMP_INT:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
-			}
-
-			c.ip++
-			val := c.readInt()
+		if err := c.serveInterrupt(); err != nil {
+			return c.runtimeError(err, c.ip)
+		}
 
-			c.flags.z = false
+		ip := c.ip
+		run, err := c.step()
+		if err != nil {
+			return c.runtimeError(err, ip)
+		}
+		if !run {
+			return c.runExitHooks()
+		}
+		c.tick()
+	}
+}
 
-			if c.regs[reg].Type() == "int" {
-				regVal, err := c.regs[reg].GetInt()
-				if err != nil {
-					return err
-				}
-				if regVal == val {
-					c.flags.z = true
-				}
-			}
+// runExitHooks calls the subroutines registered via the ATEXIT trap,
+// the most recently registered one first.
+// Each hook runs until it returns or executes EXIT.
+func (c *CPU) runExitHooks() error {
+	for len(c.exitHooks) > 0 {
+		last := len(c.exitHooks) - 1
+		addr := c.exitHooks[last]
+		c.exitHooks = c.exitHooks[:last]
+
+		if err := c.Push(exitHookReturn); err != nil {
+			return c.runtimeError(err, c.ip)
+		}
+		depth := len(c.calls)
+		c.calls = append(c.calls, c.ip)
+		c.ip = addr
 
-		case opcode.CMP_STR:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
+		// the hook returned once its call is gone, as the stack in RAM
+		// doesn't hand exitHookReturn back as is with unsigned 32-bit
+		// words. The stack-machine RET doesn't record calls, so the IP
+		// is checked too.
+		for steps := 0; len(c.calls) > depth && c.ip != exitHookReturn; steps++ {
+			if steps >= maxExitHookSteps {
+				return c.runtimeError(fmt.Errorf("exit hook at %04x did not return", addr), c.ip)
 			}
 
-			c.ip++
-			val, err := c.readStr()
+			ip := c.ip
+			run, err := c.step()
 			if err != nil {
-				return err
-			}
-
-			c.flags.z = false
-
-			if c.regs[reg].Type() == "str" {
-				regVal, err := c.regs[reg].GetStr()
-				if err != nil {
-					return err
-				}
-				if regVal == val {
-					c.flags.z = true
-				}
-			}
-
-		case opcode.CMP_REG:
-			c.ip++
-			reg1 := int(c.mem[c.ip])
-			if reg1 >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg1)
+				return c.runtimeError(err, ip)
 			}
-
-			c.ip++
-			reg2 := int(c.mem[c.ip])
-			if reg2 >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg2)
+			if !run {
+				break
 			}
+		}
+	}
+	return nil
+}
 
-			c.flags.z = false
-
-			switch c.regs[reg1].Type() {
-			case "int":
-				a, err := c.regs[reg1].GetInt()
-				if err != nil {
-					return err
-				}
-				b, err := c.regs[reg2].GetInt()
-				if err != nil {
-					return err
-				}
-				if a == b {
-					c.flags.z = true
-				}
-			case "str":
-				a, err := c.regs[reg1].GetStr()
-				if err != nil {
-					return err
-				}
-				b, err := c.regs[reg2].GetStr()
-				if err != nil {
-					return err
-				}
-				if a == b {
-					c.flags.z = true
-				}
-			}
-
-			// next instruction
-			c.ip++
-
-		case opcode.IS_INT:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
-			}
-
-			c.ip++
-
-			if c.regs[reg].Type() == "int" {
-				c.flags.z = true
-			} else {
-				c.flags.z = false
-			}
-
-		case opcode.IS_STR:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
-			}
-
-			c.ip++
-
-			if c.regs[reg].Type() == "str" {
-				c.flags.z = true
-			} else {
-				c.flags.z = false
-			}
+// Step executes the single instruction at the current IP, which, combined
+// with Clone and Diff, shows exactly what an instruction changed.
+// It returns false once an EXIT instruction has been executed.
+// Unlike Run, it neither checks the context nor runs the exit hooks.
+func (c *CPU) Step() (bool, error) {
+	ip := c.ip
+	run, err := c.step()
+	if err != nil {
+		return false, c.runtimeError(err, ip)
+	}
+	return run, nil
+}
 
-		case opcode.NOP:
-			c.ip++
+// step executes the single instruction at the current IP, notifying
+// the observers.
+// It returns false once an EXIT instruction has been executed.
+func (c *CPU) step() (bool, error) {
+	if c.ip < 0 || c.ip >= MemSize {
+		return false, fmt.Errorf("reading beyond RAM")
+	}
 
-		case opcode.REG_STORE:
-			c.ip++
-			dst := int(c.mem[c.ip])
-			if dst >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", dst)
-			}
+	ip := c.ip
+	for _, o := range c.observers {
+		if err := o.Before(c, ip); err != nil {
+			return false, err
+		}
+	}
 
-			c.ip++
-			src := int(c.mem[c.ip])
-			if src >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", src)
-			}
+	if c.overflow != nil {
+		c.overflow.hit = false
+	}
+	op := c.mem[ip]
+	run, err := c.dispatch()
+	if err == nil {
+		err = c.checkOverflow()
+	}
+	if err != nil {
+		return false, err
+	}
+	c.executed++
+	c.countBranch(op, ip)
+	if err := c.accountMemory(); err != nil {
+		return false, err
+	}
 
-			if c.regs[src].Type() == "int" {
-				val, err := c.regs[src].GetInt()
-				if err != nil {
-					return err
-				}
-				c.regs[dst].SetInt(val)
-			} else if c.regs[src].Type() == "str" {
-				val, err := c.regs[src].GetStr()
-				if err != nil {
-					return err
-				}
-				c.regs[dst].SetStr(val)
-			} else {
-				return fmt.Errorf("invalid register type")
-			}
+	for _, o := range c.observers {
+		if err := o.After(c, ip); err != nil {
+			return false, err
+		}
+	}
+	return run, nil
+}
 
-			// next instruction
-			c.ip++
+// dispatch executes the single instruction at the current IP
+func (c *CPU) dispatch() (bool, error) {
+	if c.stackISA {
+		return c.stepStack()
+	}
 
-		case opcode.PEEK:
-			c.ip++
-			reg1 := int(c.mem[c.ip])
-			if reg1 >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg1)
-			}
+	op := opcode.NewOpcode(c.mem[c.ip])
+	c.current = c.ip
 
-			c.ip++
-			reg2 := int(c.mem[c.ip])
-			if reg2 >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg2)
-			}
+	debugPrintf("%04x %02x [%s]\n", c.ip, op.Value(), op.String())
 
-			// get the address from the reg2 register
-			addr, err := c.regs[reg2].GetInt()
-			if err != nil {
-				return err
-			}
-			if addr >= maxMemSize {
-				return fmt.Errorf("address [%d] is out of range", addr)
-			}
+	var run bool
+	var err error
+	if fn := hostInstructions[int(op.Value())]; fn != nil {
+		run, err = fn(c)
+	} else if fn := Semantics[op.Value()]; fn != nil {
+		run, err = fn(c)
+	} else {
+		return false, fmt.Errorf("unknown opcode %02x", op.Value())
+	}
+	if len(c.pseudo) > 0 {
+		if err == nil {
+			err = c.writePseudoRegisters()
+		}
+		c.pseudo = nil
+	}
+	if err != nil || !run {
+		return run, err
+	}
 
-			// store the contents of the given address
-			c.regs[reg1].SetInt(int(c.mem[addr]))
-			c.ip++
+	// ensure that instruction pointer wraps around
+	if c.ip > MemSize {
+		c.ip = 0
+	}
 
-		case opcode.POKE:
-			c.ip++
-			reg1 := int(c.mem[c.ip])
-			if reg1 >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg1)
-			}
+	return true, nil
+}
 
-			c.ip++
-			reg2 := int(c.mem[c.ip])
-			if reg2 >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg2)
-			}
+// hostInstructions contains the instructions which need more than the
+// State the shared Semantics operate on, e.g. access to the host or to
+// the debug info of the CPU. They take precedence over Semantics.
+var hostInstructions map[int]func(c *CPU) (bool, error)
+
+func init() {
+	hostInstructions = map[int]func(c *CPU) (bool, error){
+		opcode.INT_RAND:   (*CPU).execIntRand,
+		opcode.RAND_RANGE: (*CPU).execRandRange,
+		opcode.TIME:       (*CPU).execTime,
+		opcode.READ_INT:   (*CPU).execReadInt,
+		opcode.EXIT_IMM:   (*CPU).execExitImm,
+		opcode.EXIT_REG:   (*CPU).execExitReg,
+		opcode.SYSTEM:     (*CPU).execSystem,
+		opcode.STR_POOL:   (*CPU).execStrPool,
+		opcode.CMP_STR:    (*CPU).execCmpStr,
+		opcode.DUMP:       (*CPU).execDump,
+		opcode.CALL:       (*CPU).execCall,
+		opcode.CALL_REG:   (*CPU).execCallReg,
+		opcode.RET:        (*CPU).execRet,
+		opcode.ENTER:      (*CPU).execEnter,
+		opcode.LEAVE:      (*CPU).execLeave,
+		opcode.SP_GET:     (*CPU).execSPGet,
+		opcode.SP_SET:     (*CPU).execSPSet,
+
+		opcode.LOAD_LOCAL:  (*CPU).execLoadLocal,
+		opcode.STORE_LOCAL: (*CPU).execStoreLocal,
+		opcode.TRAP:        (*CPU).execTrap,
+		opcode.BANK_SELECT: (*CPU).execBankSelect,
+	}
+}
 
-			// reg1 contains value which will be stored to memory (RAM)
-			val, err := c.regs[reg1].GetInt()
-			if err != nil {
-				return err
-			}
-			if val >= maxMemSize {
-				return fmt.Errorf("value [%d] is out of range", val)
-			}
+// execSystem executes the host binary named in a string register
+func (c *CPU) execSystem() (bool, error) {
+	c.ip++
+	reg, err := fetchReg(c)
+	if err != nil {
+		return false, err
+	}
 
-			// reg2 contains memory address (bytecode index) where value from reg1 will be stored
-			addr, err := c.regs[reg2].GetInt()
-			if err != nil {
-				return err
-			}
-			if addr >= maxMemSize {
-				return fmt.Errorf("address [%d] is out of range", addr)
-			}
+	// programs without a header weren't checked at load
+	if err := c.CheckCapabilities(header.CapSystem); err != nil {
+		return false, err
+	}
 
-			c.mem[addr] = byte(val)
+	str, err := reg.GetStr()
+	if err != nil {
+		return false, err
+	}
 
-			// next instruction
-			c.ip++
+	toExec := splitCommand(str)
+	if len(toExec) == 0 {
+		return false, fmt.Errorf("error invoking system: empty command")
+	}
 
-		case opcode.MEM_CPY:
-			c.ip++
-			dst := int(c.mem[c.ip])
-			if dst >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", dst)
-			}
+	if c.dryRun {
+		return true, c.wouldDo("execute %q", toExec)
+	}
 
-			c.ip++
-			src := int(c.mem[c.ip])
-			if src >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", src)
-			}
+	cmd := exec.Command(toExec[0], toExec[1:]...)
 
-			c.ip++
-			lng := int(c.mem[c.ip])
-			if lng >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", lng)
-			}
+	var (
+		out bytes.Buffer
+		er  bytes.Buffer
+	)
+	cmd.Stdout = &out
+	cmd.Stderr = &er
 
-			dstAddr, err := c.regs[dst].GetInt()
-			if err != nil {
-				return err
-			}
+	if err = cmd.Run(); err != nil {
+		return false, fmt.Errorf("error invoking system (%s): %s", str, err)
+	}
 
-			srcAddr, err := c.regs[src].GetInt()
-			if err != nil {
-				return err
-			}
+	// stdout
+	if err = c.Print(out.String() + "\n"); err != nil {
+		return false, err
+	}
 
-			length, err := c.regs[lng].GetInt()
-			if err != nil {
-				return err
-			}
+	// stderr, if non-empty
+	if len(er.String()) > 0 {
+		return true, c.PrintErr(er.String() + "\n")
+	}
+	return true, nil
+}
 
-			i := 0
-			for i < length {
-				if dstAddr >= maxMemSize {
-					dstAddr = 0
-				}
-				if srcAddr >= maxMemSize {
-					srcAddr = 0
-				}
-				c.mem[dstAddr] = c.mem[srcAddr]
-				dstAddr++
-				srcAddr++
-				i++
-			}
+// execStrPool stores a string from the string pool in a register
+func (c *CPU) execStrPool() (bool, error) {
+	c.ip++
+	reg, err := fetchReg(c)
+	if err != nil {
+		return false, err
+	}
 
-			// next instruction
-			c.ip++
+	str, err := c.poolString(fetchInt(c))
+	if err != nil {
+		return false, err
+	}
 
-		case opcode.PUSH:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
-			}
+	reg.SetStr(str)
+	return true, nil
+}
 
-			c.ip++
+// execDump prints the state of the CPU, showing the IP of the instruction
+func (c *CPU) execDump() (bool, error) {
+	var sb strings.Builder
+	if err := c.Dump(&sb); err != nil {
+		return false, err
+	}
+	if err := c.Print(sb.String()); err != nil {
+		return false, err
+	}
+	c.ip++
+	return true, nil
+}
 
-			val, err := c.regs[reg].GetInt()
-			if err != nil {
-				return err
-			}
+// execCall calls a subroutine, remembering the call site for backtraces
+func (c *CPU) execCall() (bool, error) {
+	c.calls = append(c.calls, c.ip)
+	return execCall(c)
+}
 
-			c.stack.Push(val)
+// execCallReg calls the subroutine at the address in a register,
+// recording the call for backtraces
+func (c *CPU) execCallReg() (bool, error) {
+	c.calls = append(c.calls, c.ip)
+	return execCallReg(c)
+}
 
-		case opcode.POP:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-			if reg >= len(c.regs) {
-				return fmt.Errorf("register [%d] is out of range", reg)
-			}
+// execRet returns from a subroutine
+func (c *CPU) execRet() (bool, error) {
+	run, err := execRet(c)
+	if err == nil && len(c.calls) > 0 {
+		c.calls = c.calls[:len(c.calls)-1]
+		c.returned()
+	}
+	return run, err
+}
 
-			c.ip++
+// execEnter sets up a stack frame: it saves the frame pointer on the
+// stack, points it at the top of the stack, and pushes as many zeros as
+// the frame has local values
+func (c *CPU) execEnter() (bool, error) {
+	c.ip++
+	size := fetchInt(c)
 
-			// ensure that the stack isn't empty
-			if c.stack.Empty() {
-				return fmt.Errorf("stackunderflow")
-			}
+	if err := c.Push(c.fp); err != nil {
+		return false, err
+	}
+	c.fp = c.stackSize()
+	for i := 0; i < size; i++ {
+		if err := c.Push(0); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
 
-			// store the value from the stack in the register
-			val, _ := c.stack.Pop()
-			c.regs[reg].SetInt(val)
+// execLeave tears down the frame set up by ENTER: anything pushed since
+// is discarded and the previous frame pointer is restored, so a
+// following RET finds the return address even if the subroutine left
+// values on the stack
+func (c *CPU) execLeave() (bool, error) {
+	c.ip++
+	if c.fp == 0 {
+		return false, fmt.Errorf("LEAVE without a frame set up by ENTER")
+	}
+	if c.fp > c.stackSize() {
+		return false, fmt.Errorf("the stack was popped below the frame")
+	}
 
-		case opcode.CALL:
-			c.ip++
+	c.truncateStack(c.fp)
+	fp, err := c.Pop()
+	if err != nil {
+		return false, err
+	}
+	c.fp = fp
+	return true, nil
+}
 
-			addr := c.readInt()
+// local returns the index of the stack entry at the offset from the
+// frame pointer, which is read from the instruction: the local values
+// reserved by ENTER start at zero, while the saved frame pointer, the
+// return address and the arguments pushed before the call are at -1, -2
+// and below
+func (c *CPU) local(name string) (int, error) {
+	offset := int(int16(fetchInt(c)))
+	if c.fp == 0 {
+		return 0, fmt.Errorf("%s without a frame set up by ENTER", name)
+	}
 
-			// push current IP to the stack
-			c.stack.Push(c.ip)
+	i := c.fp + offset
+	if i < 0 || i >= c.stackSize() {
+		return 0, fmt.Errorf("%s at offset %d is outside of the stack of %d entries", name, offset, c.stackSize())
+	}
+	return i, nil
+}
 
-			// jump to the call address
-			c.ip = addr
-		case opcode.RET:
-			// ensure that the stack isn't empty
-			if c.stack.Empty() {
-				return fmt.Errorf("stackunderflow")
-			}
+// execLoadLocal stores a stack entry relative to the frame pointer in a
+// register
+func (c *CPU) execLoadLocal() (bool, error) {
+	c.ip++
+	reg, err := fetchReg(c)
+	if err != nil {
+		return false, err
+	}
+	i, err := c.local("LOAD_LOCAL")
+	if err != nil {
+		return false, err
+	}
 
-			addr, _ := c.stack.Pop()
+	reg.setObject(c.stackEntry(i))
+	return true, nil
+}
 
-			// jump
-			c.ip = addr
+// execStoreLocal stores a register in a stack entry relative to the
+// frame pointer, hashes by reference like PUSH
+func (c *CPU) execStoreLocal() (bool, error) {
+	c.ip++
+	reg, err := fetchReg(c)
+	if err != nil {
+		return false, err
+	}
+	i, err := c.local("STORE_LOCAL")
+	if err != nil {
+		return false, err
+	}
 
-		case opcode.TRAP:
-			c.ip++
+	if err = c.setStackEntry(i, reg.obj); err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-			num := c.readInt()
+// execTrap invokes a trap function
+func (c *CPU) execTrap() (bool, error) {
+	c.ip++
+	num := fetchInt(c)
 
-			if num < 0 || num >= maxMemSize {
-				return fmt.Errorf("invalid trap number: %d", num)
-			}
+	if num < 0 || num >= MemSize {
+		return false, fmt.Errorf("invalid trap number: %d", num)
+	}
 
-			fn := TRAPS[num]
-			if fn != nil {
-				if err := fn(c, num); err != nil {
-					return err
-				}
-			}
+	if err := c.CheckCapabilities(header.TrapCapabilities[num]); err != nil {
+		return false, err
+	}
 
-		default:
-			return fmt.Errorf("unknown opcode %02x at IP %04x", op.Value(), c.ip)
-		}
+	// traps giving access to sensitive features aren't invoked
+	if c.dryRun && header.TrapCapabilities[num] != 0 {
+		return true, c.wouldDo("invoke trap 0x%04x (%s)", num, header.TrapCapabilities[num])
+	}
 
-		// ensure that instruction pointer wraps around
-		if c.ip > maxMemSize {
-			c.ip = 0
+	fn := TRAPS[num]
+	if own, ok := c.traps[num]; ok {
+		fn = own
+	}
+	if fn != nil {
+		if err := fn(c, num); err != nil {
+			return false, err
 		}
 	}
-
-	return nil
+	c.trapCalls++
+	return true, nil
 }
@@ -0,0 +1,110 @@
+package cpu
+
+import (
+	"fmt"
+	"vm/header"
+)
+
+// IOStart is the lowest address of the window of memory reserved for
+// memory-mapped devices, see MapDevice, which ends right below the heap
+const IOStart = HeapStart - IOSize
+
+// IOSize is the number of bytes of the window reserved for devices
+const IOSize = 0x100
+
+// reservedWindows are the windows of memory switched by BANK_SELECT and
+// reserved for devices, which the heap and the stack in RAM stay out of,
+// as their memory doesn't stay in place
+var reservedWindows = []block{{header.BankStart, header.BankSize}, {IOStart, IOSize}}
+
+// reservedWindow returns the reserved window overlapping the size bytes
+// at the given address, if any
+func reservedWindow(addr, size int) (block, bool) {
+	for _, w := range reservedWindows {
+		if addr < w.addr+w.size && w.addr < addr+size {
+			return w, true
+		}
+	}
+	return block{}, false
+}
+
+// Device is a memory-mapped device provided by the host, e.g. a display,
+// a keyboard or a timer. PEEK, PEEK16, PEEK_STR, MEM_FIND and the source
+// of MEM_CPY read its bytes, POKE, POKE16, POKE_STR and the destination
+// of MEM_CPY write them, one byte at a time in the order of the
+// addresses. The offset is relative to the start of the device.
+type Device interface {
+	// ReadByteAt returns the byte at the given offset, which may change
+	// between reads, e.g. for a status register
+	ReadByteAt(offset int) (byte, error)
+
+	// WriteByteAt sets the byte at the given offset
+	WriteByteAt(offset int, v byte) error
+}
+
+// mappedDevice is a device mapped into the memory from start up to, but
+// excluding, end
+type mappedDevice struct {
+	start, end int
+	dev        Device
+}
+
+// MapDevice maps the device into the size bytes of memory starting at
+// the given address, which must be inside of the window from IOStart to
+// IOStart+IOSize-1 and not overlap another device. Addresses of the
+// window no device is mapped at are plain memory. Devices stay mapped
+// when the CPU is reset or another program is loaded.
+func (c *CPU) MapDevice(start, size int, d Device) error {
+	end := start + size
+	if size <= 0 || start < IOStart || end > IOStart+IOSize {
+		return fmt.Errorf("device of %d bytes at %04x is outside of the I/O window at %04x-%04x",
+			size, start, IOStart, IOStart+IOSize-1)
+	}
+	for _, m := range c.devices {
+		if start < m.end && m.start < end {
+			return fmt.Errorf("device at %04x-%04x overlaps the one at %04x-%04x",
+				start, end-1, m.start, m.end-1)
+		}
+	}
+	c.devices = append(c.devices, mappedDevice{start: start, end: end, dev: d})
+	return nil
+}
+
+// device returns the device mapped at the address, if any
+func (c *CPU) device(addr int) (mappedDevice, bool) {
+	if addr < IOStart || addr >= IOStart+IOSize {
+		return mappedDevice{}, false
+	}
+	for _, m := range c.devices {
+		if addr >= m.start && addr < m.end {
+			return m, true
+		}
+	}
+	return mappedDevice{}, false
+}
+
+// read reads the byte at the address from the device mapped there
+func (m mappedDevice) read(addr int) (byte, error) {
+	v, err := m.dev.ReadByteAt(addr - m.start)
+	if err != nil {
+		return 0, fmt.Errorf("reading the device at %04x: %w", addr, err)
+	}
+	return v, nil
+}
+
+// writeDevice writes the byte at the address to the device mapped
+// there, or reports it in dry-run mode
+func (c *CPU) writeDevice(m mappedDevice, addr int, v byte) error {
+	if c.dryRun {
+		return c.wouldDo("write %02x to the device at %04x", v, addr)
+	}
+	return m.write(addr, v)
+}
+
+// write writes the byte at the address to the device mapped there
+func (m mappedDevice) write(addr int, v byte) error {
+	if err := m.dev.WriteByteAt(addr-m.start, v); err != nil {
+		return fmt.Errorf("writing the device at %04x: %w", addr, err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package cpu
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"vm/opcode"
+)
+
+// testDevice counts the reads of its first byte, and records the writes
+type testDevice struct {
+	reads   int
+	written []byte
+	fail    error
+}
+
+func (d *testDevice) ReadByteAt(offset int) (byte, error) {
+	if offset == 0 {
+		d.reads++
+		return byte(d.reads), d.fail
+	}
+	return byte(0x10 + offset), d.fail
+}
+
+func (d *testDevice) WriteByteAt(offset int, v byte) error {
+	d.written = append(d.written, byte(offset), v)
+	return d.fail
+}
+
+func TestMapDevice(t *testing.T) {
+	c := NewCPU()
+	d := &testDevice{}
+	if err := c.MapDevice(IOStart+0x10, 4, d); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		start, size int
+	}{
+		{"below the window", IOStart - 1, 2},
+		{"beyond the window", IOStart + IOSize - 1, 2},
+		{"empty", IOStart, 0},
+		{"overlapping", IOStart + 0x13, 1},
+	} {
+		if err := c.MapDevice(tc.start, tc.size, d); err == nil {
+			t.Errorf("mapping a device %s succeeded", tc.name)
+		}
+	}
+
+	// PEEK reads the device, POKE16 and MEM_CPY write it, the memory
+	// around it is untouched
+	addr := IOStart + 0x10
+	code := program(
+		ins(opcode.INT_STORE, 1), le16(addr),
+		ins(opcode.PEEK, 0, 1),
+		ins(opcode.PEEK, 0, 1),
+		ins(opcode.INT_STORE, 2), le16(0xbbaa),
+		ins(opcode.INT_STORE, 3), le16(addr+2),
+		ins(opcode.POKE16, 2, 3),
+		ins(opcode.INT_STORE, 4), le16(0x100),
+		ins(opcode.INT_STORE, 5), le16(2),
+		ins(opcode.MEM_CPY, 4, 1, 5),
+	)
+	if err := c.execute(code...); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.intReg(0); got != 2 {
+		t.Errorf("PEEK read %d, want the second read of the device", got)
+	}
+	if c.mem[0x100] != 3 || c.mem[0x101] != 0x11 {
+		t.Errorf("MEM_CPY copied %02x %02x from the device", c.mem[0x100], c.mem[0x101])
+	}
+	if want := []byte{2, 0xaa, 3, 0xbb}; string(d.written) != string(want) {
+		t.Errorf("device was written % x, want % x", d.written, want)
+	}
+	if c.mem[addr+2] != 0 || c.mem[addr+3] != 0 {
+		t.Errorf("writes to the device reached the memory")
+	}
+
+	// addresses of the window without a device are plain memory
+	if err := c.Store(IOStart, 0x42); err != nil || c.mem[IOStart] != 0x42 {
+		t.Errorf("storing next to the device: %v", err)
+	}
+
+	// errors of the device stop the program
+	d.fail = errors.New("broken")
+	c.ip = 0
+	if err := c.execute(code...); !errors.Is(err, d.fail) {
+		t.Errorf("got %v, want the error of the device", err)
+	}
+}
+
+func TestDeviceWritesInDryRun(t *testing.T) {
+	var out strings.Builder
+	c := NewCPU()
+	c.STDOUT = bufio.NewWriter(&out)
+	c.SetDryRun(true)
+	d := &testDevice{}
+	addr := IOStart + 0x10
+	if err := c.MapDevice(addr, 2, d); err != nil {
+		t.Fatal(err)
+	}
+
+	code := program(
+		ins(opcode.INT_STORE, 0), le16(0x42),
+		ins(opcode.INT_STORE, 1), le16(addr),
+		ins(opcode.POKE, 0, 1),
+	)
+	if err := c.execute(code...); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.written) != 0 {
+		t.Errorf("the device was written % x", d.written)
+	}
+	if got, want := out.String(), fmt.Sprintf("[dry-run] would write 42 to the device at %04x\n", addr); got != want {
+		t.Errorf("output %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,227 @@
+package cpu
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"sync/atomic"
+)
+
+// ChangeKind identifies which part of the CPU state a Change refers to
+type ChangeKind int
+
+const (
+	// RegisterChange is a change of the type or the value of a register
+	RegisterChange ChangeKind = iota
+
+	// FlagChange is a change of a flag
+	FlagChange
+
+	// IPChange is a change of the instruction pointer
+	IPChange
+
+	// MemoryChange is a change of a contiguous range of memory
+	MemoryChange
+
+	// StackChange is a change of a single stack entry, including
+	// entries which were pushed or popped
+	StackChange
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case RegisterChange:
+		return "register"
+	case FlagChange:
+		return "flag"
+	case IPChange:
+		return "ip"
+	case MemoryChange:
+		return "memory"
+	case StackChange:
+		return "stack"
+	default:
+		return "unknown"
+	}
+}
+
+// Change describes a single difference between two CPU states
+type Change struct {
+	Kind ChangeKind
+
+	// Index is the register number, the start address of a memory range,
+	// or the position of a stack entry counted from the bottom.
+	// It is unused for flags and the IP.
+	Index int
+
+	// Name is the name of the changed flag
+	Name string
+
+	// Before and After hold the values in both states:
+	// an Object for registers, a bool for flags, an int for the IP,
+	// a []byte for memory, and an Object for stack entries.
+	// A stack entry which exists in one state only is nil in the other.
+	Before any
+	After  any
+}
+
+func (ch Change) String() string {
+	switch ch.Kind {
+	case RegisterChange:
+		return fmt.Sprintf("#%d: %s -> %s", ch.Index, formatObject(ch.Before), formatObject(ch.After))
+	case FlagChange:
+		return fmt.Sprintf("%s: %v -> %v", ch.Name, ch.Before, ch.After)
+	case IPChange:
+		return fmt.Sprintf("ip: %04x -> %04x", ch.Before, ch.After)
+	case MemoryChange:
+		return fmt.Sprintf("mem[%04x]: % x -> % x", ch.Index, ch.Before, ch.After)
+	case StackChange:
+		return fmt.Sprintf("stack[%d]: %s -> %s", ch.Index, formatStackEntry(ch.Before), formatStackEntry(ch.After))
+	default:
+		return "unknown change"
+	}
+}
+
+// Clone returns a copy of the CPU state which can later be compared
+// with Diff. The copy shares the I/O and the context with the original.
+// Hashes are copied too, registers and stack entries referring to the
+// same hash still do in the copy.
+func (c *CPU) Clone() *CPU {
+	clone := *c
+
+	hashes := map[*HashObject]*HashObject{}
+	copyHash := func(h *HashObject) *HashObject {
+		if hashes[h] == nil {
+			hashes[h] = &HashObject{Values: maps.Clone(h.Values), usage: h.usage}
+		}
+		return hashes[h]
+	}
+
+	clone.regs = make([]*Register, len(c.regs))
+	for i, r := range c.regs {
+		obj := r.obj
+		if h, ok := obj.(*HashObject); ok {
+			obj = copyHash(h)
+		}
+		clone.regs[i] = &Register{obj: obj, min: r.min, max: r.max, overflow: r.overflow}
+	}
+
+	clone.stack = c.stack.clone(copyHash)
+	clone.calls = append([]int(nil), c.calls...)
+	clone.exitHooks = append([]int(nil), c.exitHooks...)
+	clone.checkpoints = maps.Clone(c.checkpoints)
+	clone.heap = c.heap.clone()
+	clone.banks = c.cloneBanks()
+	clone.literals = literalCache{}
+	clone.handlers = maps.Clone(c.handlers)
+	clone.pending = atomic.LoadUint32(&c.pending)
+	clone.interrupted = append([]interruptFrame(nil), c.interrupted...)
+	clone.observers = nil
+
+	return &clone
+}
+
+// Diff returns the changes between two CPU states, e.g. a Clone taken
+// before an instruction and the CPU after it.
+// Contiguous changed bytes of memory are reported as a single range.
+func Diff(before, after *CPU) []Change {
+	var changes []Change
+
+	for i := range before.regs {
+		a, b := before.regs[i].obj, after.regs[i].obj
+		if !sameObject(a, b) {
+			changes = append(changes, Change{Kind: RegisterChange, Index: i, Before: a, After: b})
+		}
+	}
+
+	if before.flags.z != after.flags.z {
+		changes = append(changes, Change{Kind: FlagChange, Name: "z", Before: before.flags.z, After: after.flags.z})
+	}
+	if before.flags.s != after.flags.s {
+		changes = append(changes, Change{Kind: FlagChange, Name: "s", Before: before.flags.s, After: after.flags.s})
+	}
+	if before.flags.c != after.flags.c {
+		changes = append(changes, Change{Kind: FlagChange, Name: "c", Before: before.flags.c, After: after.flags.c})
+	}
+	if before.flags.v != after.flags.v {
+		changes = append(changes, Change{Kind: FlagChange, Name: "v", Before: before.flags.v, After: after.flags.v})
+	}
+
+	if before.ip != after.ip {
+		changes = append(changes, Change{Kind: IPChange, Before: before.ip, After: after.ip})
+	}
+
+	for addr := 0; addr < MemSize; addr++ {
+		if before.mem[addr] == after.mem[addr] {
+			continue
+		}
+
+		start := addr
+		for addr < MemSize && before.mem[addr] != after.mem[addr] {
+			addr++
+		}
+		changes = append(changes, Change{
+			Kind:   MemoryChange,
+			Index:  start,
+			Before: bytes.Clone(before.mem[start:addr]),
+			After:  bytes.Clone(after.mem[start:addr]),
+		})
+	}
+
+	b, a := before.stackEntries(), after.stackEntries()
+	for i := 0; i < max(len(b), len(a)); i++ {
+		var bv, av Object
+		if i < len(b) {
+			bv = b[i]
+		}
+		if i < len(a) {
+			av = a[i]
+		}
+		if bv == nil || av == nil || !sameObject(bv, av) {
+			changes = append(changes, Change{Kind: StackChange, Index: i, Before: bv, After: av})
+		}
+	}
+
+	return changes
+}
+
+// sameObject compares the type and the value of two register objects
+func sameObject(a, b Object) bool {
+	switch av := a.(type) {
+	case *IntObject:
+		bv, ok := b.(*IntObject)
+		return ok && av.Value == bv.Value
+	case *StrObject:
+		bv, ok := b.(*StrObject)
+		return ok && av.Value == bv.Value
+	case *FloatObject:
+		bv, ok := b.(*FloatObject)
+		return ok && av.Value == bv.Value
+	case *HashObject:
+		bv, ok := b.(*HashObject)
+		return ok && av.String() == bv.String()
+	}
+	return false
+}
+
+func formatObject(v any) string {
+	switch o := v.(type) {
+	case *IntObject:
+		return fmt.Sprintf("int(%d)", o.Value)
+	case *StrObject:
+		return fmt.Sprintf("str(%q)", o.Value)
+	case *FloatObject:
+		return fmt.Sprintf("float(%g)", o.Value)
+	case *HashObject:
+		return fmt.Sprintf("hash(%s)", o)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func formatStackEntry(v any) string {
+	obj, ok := v.(Object)
+	if !ok {
+		return "(none)"
+	}
+	return formatEntry(obj)
+}
@@ -0,0 +1,24 @@
+package cpu
+
+import "fmt"
+
+// SetDryRun enables or disables the dry-run mode.
+//
+// In dry-run mode side effects on the host, e.g. executing binaries via
+// SYSTEM, calling traps which require a capability or writing to mapped
+// devices, are reported on STDOUT as "would do X" instead of being
+// performed. This lets users inspect what an untrusted program intends
+// before granting capabilities, so the capability policy isn't enforced
+// either.
+func (c *CPU) SetDryRun(enabled bool) {
+	c.dryRun = enabled
+}
+
+// wouldDo reports a side effect which was skipped in dry-run mode
+func (c *CPU) wouldDo(format string, args ...any) error {
+	_, err := c.STDOUT.WriteString(fmt.Sprintf("[dry-run] would "+format+"\n", args...))
+	if err != nil {
+		return err
+	}
+	return c.STDOUT.Flush()
+}
@@ -0,0 +1,86 @@
+package cpu
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Dump writes the state of the CPU to w: every register with its type
+// and value, the flags, the instruction pointer and the stack, top
+// first. The DUMP instruction uses it to let programs inspect
+// themselves without a debugger attached.
+func (c *CPU) Dump(w io.Writer) error {
+	var sb strings.Builder
+
+	sb.WriteString("registers:\n")
+	for i, reg := range c.regs {
+		switch obj := reg.obj.(type) {
+		case *IntObject:
+			fmt.Fprintf(&sb, "  #%-2d int 0x%04x (%d)\n", i, obj.Value&wordMax(c.wordSize), obj.Value)
+		case *StrObject:
+			fmt.Fprintf(&sb, "  #%-2d str %q\n", i, obj.Value)
+		case *FloatObject:
+			fmt.Fprintf(&sb, "  #%-2d float %g\n", i, obj.Value)
+		case *HashObject:
+			fmt.Fprintf(&sb, "  #%-2d hash %s\n", i, obj)
+		}
+	}
+
+	fmt.Fprintf(&sb, "flags: z=%t s=%t c=%t v=%t\n", c.flags.z, c.flags.s, c.flags.c, c.flags.v)
+	fmt.Fprintf(&sb, "ip: %04x\n", c.ip)
+	fmt.Fprintf(&sb, "fp: %04x\n", c.fp)
+
+	sb.WriteString("stack:")
+	entries := c.stackEntries()
+	if len(entries) == 0 {
+		sb.WriteString(" empty")
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		fmt.Fprintf(&sb, " %s", formatEntry(entries[i]))
+	}
+	sb.WriteString("\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// hexDump writes length bytes of memory starting at addr to w,
+// formatted like the output of xxd: sixteen bytes per line, prefixed
+// by their address and followed by their printable characters.
+func (c *CPU) hexDump(w io.Writer, addr, length int) error {
+	if addr < 0 || length < 0 || addr+length > MemSize {
+		return fmt.Errorf("memory range %04x+%d is out of bounds", addr, length)
+	}
+
+	var sb strings.Builder
+	for line := addr; line < addr+length; line += 16 {
+		end := min(line+16, addr+length)
+		data := c.mem[line:end]
+
+		fmt.Fprintf(&sb, "%08x: ", line)
+		for i := 0; i < 16; i++ {
+			if i < len(data) {
+				fmt.Fprintf(&sb, "%02x", data[i])
+			} else {
+				sb.WriteString("  ")
+			}
+			if i%2 == 1 && i != 15 {
+				sb.WriteString(" ")
+			}
+		}
+
+		sb.WriteString("  ")
+		for _, b := range data {
+			if b >= 0x20 && b < 0x7f {
+				sb.WriteByte(b)
+			} else {
+				sb.WriteByte('.')
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,38 @@
+package cpu
+
+import "fmt"
+
+// ExitCode returns the exit code the program ended with, set by EXIT
+// with an operand, e.g. "exit 1". It is zero if the program ended with
+// a plain EXIT, which keeps the exit code of an earlier EXIT, so exit
+// hooks don't reset it.
+func (c *CPU) ExitCode() int {
+	return c.exitCode
+}
+
+// execExitImm terminates the program with the exit code following it
+func (c *CPU) execExitImm() (bool, error) {
+	c.ip++
+	c.exitCode = int(fetch(c))
+	return false, nil
+}
+
+// execExitReg terminates the program with the exit code in a register,
+// which must be between 0 and 255 like the exit codes of processes
+func (c *CPU) execExitReg() (bool, error) {
+	c.ip++
+	reg, err := fetchReg(c)
+	if err != nil {
+		return false, err
+	}
+	code, err := reg.GetInt()
+	if err != nil {
+		return false, err
+	}
+	if code < 0 || code > 0xff {
+		return false, fmt.Errorf("exit code %d is out of range, it must be between 0 and 255", code)
+	}
+
+	c.exitCode = code
+	return false, nil
+}
@@ -0,0 +1,38 @@
+package cpu
+
+import (
+	"encoding/binary"
+	"testing"
+	"vm/opcode"
+)
+
+func TestExitHooksWithRAMStack(t *testing.T) {
+	for _, bits := range []int{16, 32} {
+		c := NewCPU()
+		if err := c.SetWordSize(bits); err != nil {
+			t.Fatal(err)
+		}
+		c.SetRAMStack(true)
+
+		// register the hook at 0x10, which counts its calls in #1
+		code := program(
+			ins(opcode.INT_STORE, 0), binary.LittleEndian.AppendUint32(nil, 0x10)[:bits/8],
+			ins(opcode.TRAP), le16(TrapAtExit),
+			ins(opcode.EXIT),
+		)
+		for len(code) < 0x10 {
+			code = append(code, byte(opcode.NOP))
+		}
+		code = program(code, ins(opcode.INC, 1), ins(opcode.RET))
+
+		if err := c.LoadBytes(code); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Run(); err != nil {
+			t.Fatalf("%d-bit words: %s", bits, err)
+		}
+		if n, _ := c.regs[1].GetInt(); n != 1 {
+			t.Errorf("%d-bit words: the hook ran %d times", bits, n)
+		}
+	}
+}
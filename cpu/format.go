@@ -0,0 +1,61 @@
+package cpu
+
+import (
+	"strconv"
+	"strings"
+)
+
+// groupDigits returns the decimal digits of a non-negative integer
+// grouped by thousands, e.g. "1,234,567"
+func groupDigits(n int) string {
+	digits := strconv.Itoa(n)
+	var sb strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(d)
+	}
+	return sb.String()
+}
+
+// GroupDigitsTrap converts an integer to a decimal string with its
+// digits grouped by thousands, e.g. 1234567 to "1,234,567".
+//
+// Input: the integer in register #0.
+//
+// Output: sets register #0 with the string.
+func GroupDigitsTrap(c *CPU, num int) error {
+	n, err := c.regs[0].GetInt()
+	if err != nil {
+		return err
+	}
+
+	sign := ""
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	c.regs[0].SetStr(sign + groupDigits(n))
+	return nil
+}
+
+// FixedPointTrap converts an integer counting hundredths to a decimal
+// string with two decimals and the digits grouped by thousands, e.g.
+// 123456 to "1,234.56", which prints amounts of money kept in cents.
+//
+// Input: the integer in register #0.
+//
+// Output: sets register #0 with the string.
+func FixedPointTrap(c *CPU, num int) error {
+	n, err := c.regs[0].GetInt()
+	if err != nil {
+		return err
+	}
+
+	sign := ""
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	c.regs[0].SetStr(sign + groupDigits(n/100) + "." + strconv.Itoa(n%100/10) + strconv.Itoa(n%10))
+	return nil
+}
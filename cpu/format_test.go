@@ -0,0 +1,35 @@
+package cpu
+
+import "testing"
+
+func TestFormatTraps(t *testing.T) {
+	tests := []struct {
+		trap int
+		n    int
+		want string
+	}{
+		{TrapGroupDigits, 0, "0"},
+		{TrapGroupDigits, 999, "999"},
+		{TrapGroupDigits, 1000, "1,000"},
+		{TrapGroupDigits, -1234567, "-1,234,567"},
+		{TrapFixedPoint, 0, "0.00"},
+		{TrapFixedPoint, -5, "-0.05"},
+		{TrapFixedPoint, 123456, "1,234.56"},
+		{TrapFixedPoint, 100000000, "1,000,000.00"},
+	}
+
+	c := NewCPU()
+	if err := c.SetWordSize(32); err != nil {
+		t.Fatal(err)
+	}
+	c.SetSigned(true)
+	for _, tt := range tests {
+		c.regs[0].SetInt(tt.n)
+		if err := TRAPS[tt.trap](c, tt.trap); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := c.regs[0].GetStr(); got != tt.want {
+			t.Errorf("trap %d of %d: %q, want %q", tt.trap, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package cpu
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// HeapStart is the lowest address of the heap managed by ALLOC and FREE,
+// which ends before the last byte of memory. If the program reaches
+// into it, the heap starts after the program. With words too narrow to
+// hold these addresses the heap is the upper half of the addresses
+// which fit in a register, leaving out the windows of the memory banks
+// and the devices.
+const HeapStart = 0x8000
+
+// ErrOutOfMemory is the error of allocations the heap has no room for
+var ErrOutOfMemory = errors.New("out of memory")
+
+// block is a range of heap memory
+type block struct {
+	addr, size int
+}
+
+// heap is a first-fit free-list allocator. Its bookkeeping is kept
+// outside of the memory, so programs can't corrupt it.
+type heap struct {
+	// free are the unallocated blocks ordered by address, neighbouring
+	// blocks are merged
+	free []block
+
+	// used maps the addresses of the allocated blocks to their sizes
+	used map[int]int
+
+	// ready is set once the free list covers the heap, which is done by
+	// the first allocation as the heap starts after the program
+	ready bool
+}
+
+// clone returns a copy of the heap
+func (h *heap) clone() heap {
+	return heap{free: slices.Clone(h.free), used: maps.Clone(h.used), ready: h.ready}
+}
+
+// Alloc allocates size bytes of zeroed heap memory, and returns their
+// address
+func (c *CPU) Alloc(size int) (int, error) {
+	if size <= 0 {
+		return 0, fmt.Errorf("invalid allocation size: %d", size)
+	}
+
+	h := &c.heap
+	if !h.ready {
+		_, top := wordRange(c.wordSize, c.signed)
+		end := min(MemSize-1, top+1)
+		if c.ramStack {
+			end = min(end, RAMStackTop-RAMStackSize)
+		}
+		start := HeapStart
+		if end <= HeapStart {
+			start = end / 2
+		}
+		start = max(start, c.codeSize)
+		h.free = heapBlocks(start, end)
+		h.used = map[int]int{}
+		h.ready = true
+	}
+
+	for i, b := range h.free {
+		if b.size < size {
+			continue
+		}
+		if err := c.clearMem(b.addr, size); err != nil {
+			return 0, err
+		}
+		if b.size == size {
+			h.free = slices.Delete(h.free, i, i+1)
+		} else {
+			h.free[i] = block{b.addr + size, b.size - size}
+		}
+		h.used[b.addr] = size
+		c.noteAlloc(b.addr, size)
+		return b.addr, nil
+	}
+	return 0, fmt.Errorf("%w: no free block of %d bytes, %d of %d heap bytes are allocated",
+		ErrOutOfMemory, size, h.allocated(), h.allocated()+h.available())
+}
+
+// heapBlocks returns the free blocks of a heap from start up to, but
+// excluding, end, leaving out the reserved windows, which may lie in
+// between with words too narrow for HeapStart
+func heapBlocks(start, end int) []block {
+	var free []block
+	for _, w := range reservedWindows {
+		if w.addr >= end || w.addr+w.size <= start {
+			continue
+		}
+		if start < w.addr {
+			free = append(free, block{start, w.addr - start})
+		}
+		start = w.addr + w.size
+	}
+	if start < end {
+		free = append(free, block{start, end - start})
+	}
+	return free
+}
+
+// clearMem zeroes the size bytes at the given address, going through
+// the same checks as Store, e.g. of code loaded with ReadOnlyCode. The
+// zeroes aren't recorded as data of the memory layout.
+func (c *CPU) clearMem(addr, size int) error {
+	for a := addr; a < addr+size; a++ {
+		if m, ok := c.device(a); ok {
+			if err := c.writeDevice(m, a, 0); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.checkWritable(a); err != nil {
+			return err
+		}
+		c.mem[a] = 0
+	}
+	c.literals.invalidate(addr, addr+size)
+	return nil
+}
+
+// Free releases the heap memory allocated at the given address
+func (c *CPU) Free(addr int) error {
+	h := &c.heap
+	size, ok := h.used[addr]
+	if !ok {
+		return fmt.Errorf("free of 0x%04x, which isn't allocated", addr)
+	}
+	delete(h.used, addr)
+
+	// insert the block in order, then merge it with its neighbours
+	i, _ := slices.BinarySearchFunc(h.free, addr, func(b block, addr int) int { return b.addr - addr })
+	h.free = slices.Insert(h.free, i, block{addr, size})
+	if i+1 < len(h.free) && addr+size == h.free[i+1].addr {
+		h.free[i].size += h.free[i+1].size
+		h.free = slices.Delete(h.free, i+1, i+2)
+	}
+	if i > 0 && h.free[i-1].addr+h.free[i-1].size == addr {
+		h.free[i-1].size += h.free[i].size
+		h.free = slices.Delete(h.free, i, i+1)
+	}
+	return nil
+}
+
+// allocated returns the number of allocated heap bytes
+func (h *heap) allocated() int {
+	n := 0
+	for _, size := range h.used {
+		n += size
+	}
+	return n
+}
+
+// available returns the number of free heap bytes
+func (h *heap) available() int {
+	n := 0
+	for _, b := range h.free {
+		n += b.size
+	}
+	return n
+}
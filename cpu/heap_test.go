@@ -0,0 +1,37 @@
+package cpu
+
+import (
+	"errors"
+	"testing"
+	"vm/opcode"
+)
+
+func TestHeapStaysOutOfReservedWindows(t *testing.T) {
+	for _, signed := range []bool{false, true} {
+		c := NewCPU()
+		// signed 16-bit registers hold addresses below 0x8000, so the
+		// heap lies below the banks and the devices
+		c.SetSigned(signed)
+		if err := c.LoadBytes(program(ins(opcode.EXIT))); err != nil {
+			t.Fatal(err)
+		}
+
+		allocated := 0
+		for {
+			addr, err := c.Alloc(0x100)
+			if errors.Is(err, ErrOutOfMemory) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w, ok := reservedWindow(addr, 0x100); ok {
+				t.Fatalf("signed %t: block at %04x overlaps the window at %04x", signed, addr, w.addr)
+			}
+			allocated++
+		}
+		if allocated == 0 {
+			t.Errorf("signed %t: nothing could be allocated", signed)
+		}
+	}
+}
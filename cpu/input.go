@@ -0,0 +1,36 @@
+package cpu
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// execReadInt reads a line from the console and stores the integer on
+// it in a register, clearing the zero flag. If the line isn't an integer
+// which fits in a register, or the input ended, the zero flag is set
+// and the register is left alone, so programs can ask again.
+func (c *CPU) execReadInt() (bool, error) {
+	c.ip++
+	reg, err := fetchReg(c)
+	if err != nil {
+		return false, err
+	}
+
+	line, err := c.STDIN.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+
+	i, err := strconv.Atoi(strings.TrimSpace(line))
+	lo, hi := wordRange(c.wordSize, c.signed)
+	if err != nil || i < lo || i > hi {
+		c.SetZero(true)
+		return true, nil
+	}
+
+	reg.SetInt(i)
+	c.SetZero(false)
+	return true, nil
+}
@@ -0,0 +1,119 @@
+package cpu
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// NumInterrupts is the number of interrupt vectors, see Interrupt
+const NumInterrupts = 8
+
+// interruptFrame records the state of an interrupted program, which is
+// restored when the handler returns
+type interruptFrame struct {
+	// depth is the number of recorded calls before the handler was called
+	depth int
+
+	// flags are the flags of the interrupted program, as a handler could
+	// otherwise change the outcome of a comparison it interrupted
+	flags Flags
+}
+
+// Interrupt raises the interrupt with the given vector, 0 to
+// NumInterrupts-1, e.g. when the host receives a signal. It may be
+// called from any goroutine. Other vectors are ignored.
+//
+// The handler registered for the vector via the ON_INTERRUPT trap is
+// called by Run before the next instruction, unless a handler is already
+// running, in which case the interrupt stays pending until it returns.
+// Interrupts without a handler are dropped, as are interrupts raised
+// again while pending. The flags are restored once the handler returns.
+func (c *CPU) Interrupt(vector int) {
+	if vector < 0 || vector >= NumInterrupts {
+		return
+	}
+	for {
+		pending := atomic.LoadUint32(&c.pending)
+		if atomic.CompareAndSwapUint32(&c.pending, pending, pending|1<<vector) {
+			return
+		}
+	}
+}
+
+// OnInterruptTrap registers the subroutine handling an interrupt vector,
+// replacing the previous one, see Interrupt.
+//
+// Input: the vector in register #0, the address of the subroutine in
+// register #1.
+//
+// Output: none.
+func OnInterruptTrap(c *CPU, num int) error {
+	vector, err := c.regs[0].GetInt()
+	if err != nil {
+		return err
+	}
+	if vector < 0 || vector >= NumInterrupts {
+		return fmt.Errorf("invalid interrupt vector: %d", vector)
+	}
+	addr, err := c.regs[1].GetInt()
+	if err != nil {
+		return err
+	}
+	if c.handlers == nil {
+		c.handlers = map[int]int{}
+	}
+	c.handlers[vector] = addr
+	return nil
+}
+
+// serveInterrupt calls the handler of the pending interrupt with the
+// lowest vector, if there are pending interrupts and no handler is
+// running. It fails if the return address doesn't fit on the stack.
+func (c *CPU) serveInterrupt() error {
+	if atomic.LoadUint32(&c.pending) == 0 || len(c.interrupted) > 0 || c.stackISA {
+		return nil
+	}
+
+	for vector := 0; vector < NumInterrupts; vector++ {
+		if !c.takePending(vector) {
+			continue
+		}
+		addr, ok := c.handlers[vector]
+		if !ok {
+			continue
+		}
+
+		// call the handler like CALL would
+		if err := c.Push(c.ip); err != nil {
+			return err
+		}
+		c.interrupted = append(c.interrupted, interruptFrame{depth: len(c.calls), flags: c.flags})
+		c.calls = append(c.calls, c.ip)
+		c.ip = addr
+		return nil
+	}
+	return nil
+}
+
+// takePending clears the given vector, and returns true if it was pending
+func (c *CPU) takePending(vector int) bool {
+	for {
+		pending := atomic.LoadUint32(&c.pending)
+		if pending&(1<<vector) == 0 {
+			return false
+		}
+		if atomic.CompareAndSwapUint32(&c.pending, pending, pending&^(1<<vector)) {
+			return true
+		}
+	}
+}
+
+// returned restores the state of the interrupted program once a
+// handler returns
+func (c *CPU) returned() {
+	n := len(c.interrupted)
+	if n > 0 && c.interrupted[n-1].depth == len(c.calls) {
+		c.flags = c.interrupted[n-1].flags
+		c.interrupted = c.interrupted[:n-1]
+	}
+}
@@ -0,0 +1,75 @@
+package cpu
+
+import (
+	"testing"
+	"vm/opcode"
+)
+
+func TestInterruptRestoresFlags(t *testing.T) {
+	c := NewCPU()
+	code := program(
+		// 0: sets the zero flag, then exits
+		ins(opcode.CMP_INT, 2), le16(0),
+		ins(opcode.EXIT),
+		// 5: the handler clears the zero flag
+		ins(opcode.INT_STORE, 3), le16(7),
+		ins(opcode.CMP_INT, 3), le16(0),
+		ins(opcode.RET),
+	)
+	if err := c.LoadBytes(code); err != nil {
+		t.Fatal(err)
+	}
+	c.handlers = map[int]int{1: 5}
+
+	if _, err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+	c.Interrupt(1)
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := c.intReg(3); got != 7 {
+		t.Errorf("the handler didn't run, #3 = %d", got)
+	}
+	if !c.Zero() {
+		t.Error("the zero flag of the interrupted program wasn't restored")
+	}
+	if len(c.interrupted) != 0 {
+		t.Errorf("%d interrupted programs are left", len(c.interrupted))
+	}
+}
+
+func TestTimer(t *testing.T) {
+	c := NewCPU()
+	code := program(
+		// 0: counts #1 down from 20, taking 41 instructions
+		ins(opcode.INT_STORE, 1), le16(20),
+		ins(opcode.DEC, 1),
+		ins(opcode.JMP_NZ), le16(4),
+		ins(opcode.EXIT),
+		// 10: the handler counts the interrupts in #4
+		ins(opcode.INC, 4),
+		ins(opcode.RET),
+	)
+	if err := c.SetTimer(2, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.LoadBytes(code); err != nil {
+		t.Fatal(err)
+	}
+	c.handlers = map[int]int{2: 10}
+
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the handlers take 2 instructions each, so 49 are executed before
+	// the EXIT and the timer fires after the 10th, 20th, 30th and 40th
+	if got := c.intReg(4); got != 4 {
+		t.Errorf("the timer fired %d times, want 4", got)
+	}
+	if got := c.intReg(1); got != 0 {
+		t.Errorf("the interrupted loop ended with #1 = %d", got)
+	}
+}
@@ -0,0 +1,98 @@
+package cpu
+
+// Region is the memory from Start up to, but excluding, End
+type Region struct {
+	Start, End int
+}
+
+// Size returns the number of bytes of the region
+func (r Region) Size() int {
+	return r.End - r.Start
+}
+
+// MemoryLayout summarizes how the program used the memory since it was
+// loaded, see CPU.MemoryLayout
+type MemoryLayout struct {
+	// Code is where the program is loaded
+	Code Region
+
+	// Data are the ranges of bytes written by the program, e.g. via
+	// POKE, in the order of their addresses, and Written is the number
+	// of these bytes
+	Data    []Region
+	Written int
+
+	// Heap spans the blocks allocated via ALLOC, and HeapPeak is the
+	// highest number of bytes allocated at once
+	Heap     Region
+	HeapPeak int
+
+	// Stack spans the deepest entry of the stack, which is empty unless
+	// the stack is in RAM
+	Stack Region
+
+	// StackDepth is the highest number of entries on the stack, and
+	// CallDepth the highest number of nested calls
+	StackDepth, CallDepth int
+}
+
+// layout records how the program uses the memory, see MemoryLayout
+type layout struct {
+	// written has a bit set for every byte written via Store
+	written [(MemSize + 63) / 64]uint64
+
+	heap                  Region
+	heapPeak              int
+	stackDepth, callDepth int
+}
+
+// MemoryLayout returns how the program used the memory since it was
+// loaded
+func (c *CPU) MemoryLayout() MemoryLayout {
+	l := MemoryLayout{
+		Code:       Region{c.codeStart, c.codeSize},
+		Heap:       c.layout.heap,
+		HeapPeak:   c.layout.heapPeak,
+		StackDepth: c.layout.stackDepth,
+		CallDepth:  c.layout.callDepth,
+	}
+	if c.ramStack && c.layout.stackDepth > 0 {
+		l.Stack = Region{RAMStackTop - c.layout.stackDepth*ramEntrySize, RAMStackTop}
+	}
+
+	start := -1
+	for addr := 0; addr <= MemSize; addr++ {
+		written := addr < MemSize && c.layout.written[addr/64]&(1<<(addr%64)) != 0
+		switch {
+		case written && start < 0:
+			start = addr
+		case !written && start >= 0:
+			l.Data = append(l.Data, Region{start, addr})
+			l.Written += addr - start
+			start = -1
+		}
+	}
+	return l
+}
+
+// noteWrite records that the program wrote the byte at the address
+func (c *CPU) noteWrite(addr int) {
+	c.layout.written[addr/64] |= 1 << (addr % 64)
+}
+
+// noteAlloc records the heap block allocated at the address
+func (c *CPU) noteAlloc(addr, size int) {
+	h := &c.layout.heap
+	if h.Size() == 0 {
+		*h = Region{addr, addr + size}
+	} else {
+		h.Start, h.End = min(h.Start, addr), max(h.End, addr+size)
+	}
+	c.layout.heapPeak = max(c.layout.heapPeak, c.heap.allocated())
+}
+
+// noteDepth records the depth of the stack and of the calls
+func (c *CPU) noteDepth() {
+	c.layout.stackDepth = max(c.layout.stackDepth, c.stackSize())
+	c.layout.callDepth = max(c.layout.callDepth, len(c.calls))
+}
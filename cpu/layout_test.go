@@ -0,0 +1,45 @@
+package cpu
+
+import (
+	"reflect"
+	"testing"
+	"vm/opcode"
+)
+
+func TestMemoryLayout(t *testing.T) {
+	code := program(
+		ins(opcode.INT_STORE, 1), le16(16),
+		ins(opcode.ALLOC, 2, 1), ins(opcode.ALLOC, 3, 1), ins(opcode.FREE, 2),
+		ins(opcode.INT_STORE, 4), le16(0x1000),
+		ins(opcode.POKE, 1, 4), ins(opcode.INC, 4), ins(opcode.POKE, 1, 4),
+		ins(opcode.PUSH, 1), ins(opcode.PUSH, 1), ins(opcode.POP, 0),
+	)
+
+	c := NewCPU()
+	if err := c.LoadBytes(code); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := MemoryLayout{
+		Code:       Region{0, len(code)},
+		Data:       []Region{{0x1000, 0x1002}},
+		Written:    2,
+		Heap:       Region{HeapStart, HeapStart + 32},
+		HeapPeak:   32,
+		StackDepth: 2,
+	}
+	if got := c.MemoryLayout(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// loading the next program forgets the layout
+	if err := c.LoadBytes(code); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.MemoryLayout(); got.Written != 0 || got.HeapPeak != 0 || got.StackDepth != 0 {
+		t.Errorf("got %+v after loading, want an empty layout", got)
+	}
+}
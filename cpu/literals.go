@@ -0,0 +1,79 @@
+package cpu
+
+// literal is a string operand decoded from the bytecode
+type literal struct {
+	value string
+
+	// end is the address following the literal
+	end int
+}
+
+// literalCache keeps the string operands of CMP_STR decoded, by the
+// address of their length, so loops comparing strings don't decode and
+// allocate them again on every iteration. Writes to the memory the
+// literals were decoded from invalidate the cache.
+type literalCache struct {
+	entries map[int]literal
+
+	// lo and hi delimit the memory the cached literals were decoded from
+	lo, hi int
+}
+
+// fetchLiteral reads a string operand from the IP like fetchStr, using
+// the cache
+func (c *CPU) fetchLiteral() (string, error) {
+	addr := c.ip
+	if l, ok := c.literals.entries[addr]; ok {
+		c.ip = l.end
+		return l.value, nil
+	}
+
+	val, err := fetchStr(c)
+	if err != nil {
+		return "", err
+	}
+
+	// literals wrapping around the end of memory aren't worth caching
+	if c.ip <= MemSize {
+		c.literals.add(addr, literal{value: val, end: c.ip})
+	}
+	return val, nil
+}
+
+// add caches the literal decoded from the given address
+func (lc *literalCache) add(addr int, l literal) {
+	if lc.entries == nil {
+		lc.entries = map[int]literal{}
+		lc.lo, lc.hi = addr, l.end
+	}
+	lc.entries[addr] = l
+	lc.lo, lc.hi = min(lc.lo, addr), max(lc.hi, l.end)
+}
+
+// invalidate forgets the cached literals if memory from lo up to hi,
+// excluding hi, overlaps with the memory they were decoded from
+func (lc *literalCache) invalidate(lo, hi int) {
+	if lc.entries != nil && lo < lc.hi && hi > lc.lo {
+		*lc = literalCache{}
+	}
+}
+
+// execCmpStr compares a register with a string operand like the shared
+// semantics, decoding the operand only once. Strings of different
+// lengths are told apart without comparing their bytes.
+func (c *CPU) execCmpStr() (bool, error) {
+	skip(c)
+	reg, err := fetchReg(c)
+	if err != nil {
+		return false, err
+	}
+
+	val, err := c.fetchLiteral()
+	if err != nil {
+		return false, err
+	}
+
+	s, ok := reg.obj.(*StrObject)
+	c.SetZero(ok && len(s.Value) == len(val) && s.Value == val)
+	return true, nil
+}
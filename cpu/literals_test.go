@@ -0,0 +1,29 @@
+package cpu
+
+import (
+	"testing"
+	"vm/opcode"
+)
+
+func TestCmpStrSeesModifiedLiteral(t *testing.T) {
+	c := NewCPU()
+	c.regs[0].SetStr("ab")
+	code := program(ins(opcode.CMP_STR, 0), lstr("ab"))
+
+	if err := c.execute(code...); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Zero() {
+		t.Fatal("equal strings compared unequal")
+	}
+
+	// change the literal to "ax" as a POKE would
+	c.Store(len(code)-1, 'x')
+	c.ip = 0
+	if _, err := c.step(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Zero() {
+		t.Error("the cached literal was compared after it was modified")
+	}
+}
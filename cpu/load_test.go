@@ -0,0 +1,72 @@
+package cpu
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"vm/opcode"
+)
+
+func TestLoadBytesTooLarge(t *testing.T) {
+	c := NewCPU()
+	for _, tc := range []struct {
+		size, offset int
+	}{
+		{MemSize, 0},
+		{MemSize - 1, 1},
+		{1, MemSize - 1},
+	} {
+		err := c.LoadBytesAt(make([]byte, tc.size), tc.offset)
+		if !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%d bytes at 0x%x: got %v, want ErrTooLarge", tc.size, tc.offset, err)
+		}
+	}
+
+	if err := c.LoadBytesAt(nil, -1); err == nil {
+		t.Error("loading at a negative offset succeeded")
+	}
+	if err := c.LoadBytes(make([]byte, MemSize-1)); err != nil {
+		t.Errorf("loading the largest program failed: %v", err)
+	}
+}
+
+func TestLoadBytesAtOffset(t *testing.T) {
+	c := NewCPU()
+	c.mem[0] = byte(opcode.NOP)
+
+	code := []byte{byte(opcode.INT_STORE), 1, 42, 0, byte(opcode.EXIT)}
+	if err := c.LoadBytesAt(code, 0x100); err != nil {
+		t.Fatal(err)
+	}
+	if c.mem[0] != 0 {
+		t.Error("memory outside of the program wasn't zeroed")
+	}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.intReg(1); got != 42 {
+		t.Errorf("#1 = %d, want 42", got)
+	}
+}
+
+func TestLoadReader(t *testing.T) {
+	c := NewCPU()
+	if err := c.LoadReader(strings.NewReader("\x01\x01\x07\x00")); err != nil {
+		t.Fatal(err)
+	}
+	if c.CodeSize() != 4 {
+		t.Errorf("code size = %d, want 4", c.CodeSize())
+	}
+
+	// an endless reader is only read until the program is too large
+	if err := c.LoadReader(neverEnding{}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got %v, want ErrTooLarge", err)
+	}
+}
+
+// neverEnding is a reader which never runs out of data
+type neverEnding struct{}
+
+func (neverEnding) Read(p []byte) (int, error) {
+	return len(p), nil
+}
@@ -0,0 +1,92 @@
+package cpu
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMemoryLimit is the error of programs whose strings, hashes and
+// stacks use more host memory than the limit set via SetMemoryLimit
+var ErrMemoryLimit = errors.New("memory limit exceeded")
+
+// objectOverhead is the approximate number of host bytes used by a
+// register value besides the bytes of its strings
+const objectOverhead = 16
+
+// entrySize is the number of host bytes used by a stack entry
+const entrySize = 8
+
+// SetMemoryLimit limits the approximate number of host bytes the strings
+// and hashes in the registers and the stacks may use, so a program
+// building ever longer strings is stopped before it exhausts the memory
+// of the host. Zero, the default, means no limit.
+func (c *CPU) SetMemoryLimit(bytes int) {
+	c.memoryLimit = bytes
+}
+
+// MemoryUsage returns the approximate number of host bytes used by the
+// strings and hashes in the registers and on the stack, and by the
+// stacks. RAM isn't included, as its size is fixed. The stack and the
+// hashes keep their usage up to date, so it takes time proportional to
+// the number of registers and of hashes on the stack, not to their size.
+func (c *CPU) MemoryUsage() int {
+	used := entrySize*(len(c.stack.entries)+len(c.calls)+len(c.exitHooks)) + c.stack.strUsage
+
+	// hashes referred to more than once are counted once
+	c.memoryPass++
+	for h := range c.stack.hashes {
+		used += c.countHash(h)
+	}
+	for _, r := range c.regs {
+		switch o := r.obj.(type) {
+		case *StrObject:
+			used += objectOverhead + len(o.Value)
+		case *HashObject:
+			used += c.countHash(o)
+		}
+	}
+	return used
+}
+
+// PeakMemoryUsage returns the largest MemoryUsage seen after any of the
+// executed instructions
+func (c *CPU) PeakMemoryUsage() int {
+	return c.peakMemory
+}
+
+// accountMemory records the peak memory usage, and returns an error if
+// the current usage exceeds the limit
+func (c *CPU) accountMemory() error {
+	c.noteDepth()
+	used := c.MemoryUsage()
+	c.peakMemory = max(c.peakMemory, used)
+	if c.memoryLimit > 0 && used > c.memoryLimit {
+		return fmt.Errorf("%w: %d bytes used, the limit is %d bytes", ErrMemoryLimit, used, c.memoryLimit)
+	}
+	return nil
+}
+
+// countHash returns the usage of the hash, unless the current pass of
+// MemoryUsage counted it already
+func (c *CPU) countHash(h *HashObject) int {
+	if h.counted == c.memoryPass {
+		return 0
+	}
+	h.counted = c.memoryPass
+	return hashUsage(h)
+}
+
+// hashUsage returns the approximate number of host bytes used by a hash
+func hashUsage(h *HashObject) int {
+	return objectOverhead + h.usage
+}
+
+// hashEntryUsage returns the approximate number of host bytes used by a
+// key of a hash and its value
+func hashEntryUsage(key string, v Object) int {
+	used := 2*objectOverhead + len(key)
+	if s, ok := v.(*StrObject); ok {
+		used += len(s.Value)
+	}
+	return used
+}
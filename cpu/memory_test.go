@@ -0,0 +1,85 @@
+package cpu
+
+import (
+	"testing"
+	"time"
+	"vm/opcode"
+)
+
+// hashLoop returns a program storing n keys in a hash
+func hashLoop(n int) []byte {
+	return program(
+		ins(opcode.HASH_NEW, 0),
+		ins(opcode.INT_STORE, 1), le16(n),
+		// loop at 0x0006
+		ins(opcode.REG_STORE, 2, 1), ins(opcode.INT_TO_STR, 2),
+		ins(opcode.HASH_SET, 0, 2, 1),
+		ins(opcode.DEC, 1), ins(opcode.CMP_INT, 1), le16(0),
+		ins(opcode.JMP_NZ), le16(0x0006),
+		ins(opcode.EXIT),
+	)
+}
+
+// runTime returns the shortest of a few runs of the program
+func runTime(t *testing.T, code []byte) time.Duration {
+	best := time.Duration(0)
+	for range 3 {
+		c := NewCPU()
+		if err := c.LoadBytes(code); err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		if err := c.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(start); best == 0 || d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+func TestMemoryUsageOfHashesIsLinear(t *testing.T) {
+	small := runTime(t, hashLoop(2000))
+	large := runTime(t, hashLoop(16000))
+
+	// eight times the keys take about eight times as long, rather than
+	// 64 times if the hash was walked after every instruction
+	if large > 24*small {
+		t.Errorf("2000 keys took %s, 16000 keys %s", small, large)
+	}
+}
+
+func TestMemoryUsageCountsHashesOnTheStack(t *testing.T) {
+	code := program(
+		ins(opcode.HASH_NEW, 0),
+		ins(opcode.STR_STORE, 2), lstr("k"),
+		ins(opcode.HASH_SET, 0, 2, 1),
+		ins(opcode.PUSH, 0),
+		// the hash is on the stack and in #3, it is counted once
+		ins(opcode.REG_STORE, 3, 0),
+		ins(opcode.HASH_NEW, 0),
+	)
+
+	c := NewCPU()
+	if err := c.LoadBytes(code); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the empty hash in #0, "k" in #2, the entry on the stack and the
+	// hash holding "k"
+	want := objectOverhead + objectOverhead + 1 + entrySize + objectOverhead + 2*objectOverhead + 1
+	if got := c.MemoryUsage(); got != want {
+		t.Errorf("usage %d, want %d", got, want)
+	}
+
+	c.pop()
+	c.regs[3].SetInt(0)
+	want -= entrySize + objectOverhead + 2*objectOverhead + 1
+	if got := c.MemoryUsage(); got != want {
+		t.Errorf("usage after dropping the hash %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,46 @@
+package cpu
+
+import "vm/header"
+
+// Observer is notified about the execution of every instruction, which
+// allows tools to track information the CPU itself doesn't, e.g. where
+// data came from.
+type Observer interface {
+	// Before is called before the instruction at ip is executed.
+	// Returning an error stops the execution.
+	Before(c *CPU, ip int) error
+
+	// After is called once the instruction at ip has been executed
+	// successfully.
+	After(c *CPU, ip int) error
+}
+
+// AddObserver adds an observer which is notified about every
+// instruction executed from now on.
+func (c *CPU) AddObserver(o Observer) {
+	c.observers = append(c.observers, o)
+}
+
+// Memory returns the memory of the CPU, for observers which inspect
+// instructions or data. It must not be modified.
+func (c *CPU) Memory() []byte {
+	return c.mem[:]
+}
+
+// StackISA returns true if the CPU executes the stack-machine
+// instruction set
+func (c *CPU) StackISA() bool {
+	return c.stackISA
+}
+
+// CodeSize returns the size of the loaded program, i.e. memory below
+// this address holds code
+func (c *CPU) CodeSize() int {
+	return c.codeSize
+}
+
+// Locate returns the given address along with the label it belongs to,
+// if the symbols of the program are known, e.g. "0029 (check+0x21)"
+func (c *CPU) Locate(addr int) string {
+	return header.Locate(c.symbols, addr)
+}
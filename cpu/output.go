@@ -0,0 +1,69 @@
+package cpu
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// ErrOutputLimit is the error of programs stopped as they printed more
+// than the limit set via SetOutputLimit
+var ErrOutputLimit = errors.New("output limit exceeded")
+
+// TruncationMarker is appended to the output where it was truncated by
+// the limit set via SetOutputLimit
+const TruncationMarker = "\n[output truncated]\n"
+
+// SetOutputLimit limits the number of bytes the program may print to
+// STDOUT and STDERR together, so a program printing endlessly can't
+// flood its reader. The output beyond the limit is dropped and
+// TruncationMarker is printed in its place. With abort the program is
+// then stopped with ErrOutputLimit, otherwise it keeps running and any
+// further output is dropped. Zero, the default, means no limit.
+func (c *CPU) SetOutputLimit(bytes int, abort bool) {
+	c.outputLimit = bytes
+	c.outputAbort = abort
+}
+
+// OutputTruncated returns true if output was dropped as it exceeded the
+// limit set via SetOutputLimit
+func (c *CPU) OutputTruncated() bool {
+	return c.truncated
+}
+
+// output writes s to w, which is STDOUT or STDERR, truncating it at the
+// output limit
+func (c *CPU) output(w *bufio.Writer, s string) error {
+	if c.outputLimit > 0 {
+		if c.truncated {
+			return nil
+		}
+		if left := max(c.outputLimit-c.printed, 0); len(s) > left {
+			// back up to the start of a multi-byte UTF-8 character
+			// rather than split it
+			cut := left
+			for cut > 0 && left-cut < utf8.UTFMax-1 && !utf8.RuneStart(s[cut]) {
+				cut--
+			}
+			if utf8.RuneStart(s[cut]) {
+				left = cut
+			}
+			s = s[:left] + TruncationMarker
+			c.truncated = true
+		}
+		c.printed += len(s)
+	}
+
+	if _, err := w.WriteString(s); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if c.truncated && c.outputAbort {
+		return fmt.Errorf("%w: the program printed more than %d bytes", ErrOutputLimit, c.outputLimit)
+	}
+	return nil
+}
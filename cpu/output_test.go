@@ -0,0 +1,50 @@
+package cpu
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+	"vm/opcode"
+)
+
+func TestOutputLimit(t *testing.T) {
+	printTwice := program(ins(opcode.STR_STORE, 0), lstr("hello"), ins(opcode.STR_PRINT, 0), ins(opcode.STR_PRINT, 0))
+
+	for _, abort := range []bool{false, true} {
+		var out strings.Builder
+		c := NewCPU()
+		c.STDOUT = bufio.NewWriter(&out)
+		c.SetOutputLimit(7, abort)
+
+		err := c.execute(printTwice...)
+		if got, want := out.String(), "hellohe"+TruncationMarker; got != want {
+			t.Errorf("abort %t: output %q, want %q", abort, got, want)
+		}
+		if !c.OutputTruncated() {
+			t.Errorf("abort %t: the truncation wasn't recorded", abort)
+		}
+		if abort != errors.Is(err, ErrOutputLimit) {
+			t.Errorf("abort %t: error %v", abort, err)
+		}
+	}
+}
+
+func TestOutputLimitKeepsCharactersWhole(t *testing.T) {
+	// "é" takes two bytes, the limit falls between them
+	var out strings.Builder
+	c := NewCPU()
+	c.STDOUT = bufio.NewWriter(&out)
+	c.SetOutputLimit(4, false)
+
+	if err := c.execute(program(ins(opcode.STR_STORE, 0), lstr("caté!"), ins(opcode.STR_PRINT, 0))...); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "cat"+TruncationMarker; got != want {
+		t.Errorf("output %q, want %q", got, want)
+	}
+	if !utf8.ValidString(out.String()) {
+		t.Error("the output isn't valid UTF-8")
+	}
+}
@@ -0,0 +1,57 @@
+package cpu
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrOverflow is the error of an integer which doesn't fit in the machine
+// word while faulting on overflows, see SetFaultOnOverflow
+var ErrOverflow = errors.New("integer overflow")
+
+// overflow records the first integer which didn't fit in a register
+// during an instruction, shared by the registers of a CPU faulting on
+// overflows
+type overflow struct {
+	hit   bool
+	value int
+}
+
+// SetFaultOnOverflow sets whether an integer which doesn't fit in the
+// machine word stops the program with ErrOverflow, rather than being
+// clamped to the range of the word, or wrapping around for INC and DEC.
+// ADC, SBC, MULH and the shifts wrap around by design and never fault.
+func (c *CPU) SetFaultOnOverflow(enabled bool) {
+	c.overflow = nil
+	if enabled {
+		c.overflow = &overflow{}
+	}
+	for _, r := range c.regs {
+		r.overflow = c.overflow
+	}
+}
+
+// FaultOnOverflow returns true if integers which don't fit in the
+// machine word stop the program
+func (c *CPU) FaultOnOverflow() bool {
+	return c.overflow != nil
+}
+
+// checkOverflow returns ErrOverflow if the last instruction stored an
+// integer which didn't fit in the word, and forgets about it
+func (c *CPU) checkOverflow() error {
+	if c.overflow == nil || !c.overflow.hit {
+		return nil
+	}
+	c.overflow.hit = false
+	return fmt.Errorf("%w: %d doesn't fit in a %d-bit word", ErrOverflow, c.overflow.value, c.wordSize)
+}
+
+// noteOverflow records an integer which didn't fit in the register, if
+// its CPU faults on overflows
+func (r *Register) noteOverflow(v int) {
+	if r.overflow != nil && !r.overflow.hit {
+		r.overflow.hit = true
+		r.overflow.value = v
+	}
+}
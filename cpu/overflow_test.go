@@ -0,0 +1,53 @@
+package cpu
+
+import (
+	"errors"
+	"testing"
+	"vm/opcode"
+)
+
+func TestFaultOnOverflow(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+	}{
+		{"ADD_IMM", program(ins(opcode.INT_STORE, 0), le16(0xfff0), ins(opcode.ADD_IMM, 0, 0), le16(0x20))},
+		{"SUB_IMM", program(ins(opcode.INT_STORE, 0), le16(1), ins(opcode.SUB_IMM, 0, 0), le16(2))},
+		{"INC", program(ins(opcode.INT_STORE, 0), le16(0xffff), ins(opcode.INC, 0))},
+		{"DEC", program(ins(opcode.DEC, 0))},
+	}
+
+	for _, test := range tests {
+		c := NewCPU()
+		if err := c.execute(test.code...); err != nil {
+			t.Errorf("%s: permissive run failed: %s", test.name, err)
+		}
+
+		c = NewCPU()
+		c.SetFaultOnOverflow(true)
+		if err := c.execute(test.code...); !errors.Is(err, ErrOverflow) {
+			t.Errorf("%s: got %v, want an overflow", test.name, err)
+		}
+	}
+
+	// results within the word never fault, and neither does ADC wrapping around
+	c := NewCPU()
+	c.SetFaultOnOverflow(true)
+	code := program(ins(opcode.INT_STORE, 0), le16(0xffff), ins(opcode.INT_STORE, 1), le16(1),
+		ins(opcode.ADC, 2, 0, 1), ins(opcode.SUB_IMM, 0, 0), le16(0xffff))
+	if err := c.execute(code...); err != nil {
+		t.Errorf("got %s, want no overflow", err)
+	}
+}
+
+func TestFaultOnOverflowAfterRestore(t *testing.T) {
+	c := NewCPU()
+	c.SetFaultOnOverflow(true)
+	c.saveCheckpoint("start")
+	c.restoreCheckpoint("start")
+
+	code := program(ins(opcode.INT_STORE, 0), le16(0xffff), ins(opcode.ADD, 1, 0, 0))
+	if err := c.execute(code...); !errors.Is(err, ErrOverflow) {
+		t.Errorf("got %v, want an overflow", err)
+	}
+}
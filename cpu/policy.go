@@ -0,0 +1,41 @@
+package cpu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"vm/header"
+)
+
+// ErrTimeout is the error of programs stopped as the context set via
+// SetContext is done
+var ErrTimeout = errors.New("timeout during execution")
+
+// ErrNotAllowed is the error of programs requiring capabilities which
+// aren't allowed by the policy
+var ErrNotAllowed = errors.New("capabilities not allowed by policy")
+
+// SetAllowedCapabilities sets the sensitive features programs may use.
+// By default everything is allowed.
+func (c *CPU) SetAllowedCapabilities(caps header.Capability) {
+	c.allowed = caps
+}
+
+// SetContext sets the context checked while the program runs, so
+// callers can limit its execution time, e.g. via context.WithTimeout.
+func (c *CPU) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// CheckCapabilities returns an error if the given capabilities aren't
+// allowed by the active policy. In dry-run mode nothing is performed,
+// so every capability passes.
+func (c *CPU) CheckCapabilities(caps header.Capability) error {
+	if c.dryRun {
+		return nil
+	}
+	if denied := caps &^ c.allowed; denied != 0 {
+		return fmt.Errorf("%w: %s", ErrNotAllowed, denied)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package cpu
+
+import "fmt"
+
+// SetStringPool sets the string pool the STR_POOL instruction loads
+// strings from. Each string is stored as its length (two bytes)
+// followed by its bytes, and referenced by its offset.
+func (c *CPU) SetStringPool(pool []byte) {
+	c.pool = pool
+}
+
+// poolString returns the string at the given offset of the string pool
+func (c *CPU) poolString(offset int) (string, error) {
+	if offset+2 > len(c.pool) {
+		return "", fmt.Errorf("string pool offset %d is out of range", offset)
+	}
+
+	strLen := int(c.pool[offset]) + int(c.pool[offset+1])*256
+	start := offset + 2
+	if start+strLen > len(c.pool) {
+		return "", fmt.Errorf("string at pool offset %d is truncated", offset)
+	}
+
+	return string(c.pool[start : start+strLen]), nil
+}
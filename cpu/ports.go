@@ -0,0 +1,78 @@
+package cpu
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// port is a line-based connection of the program to the host, e.g. to a
+// named pipe or a Unix socket
+type port struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+// ConnectPort connects the port with the given number to rw, so the
+// program can exchange lines with another process via the PORT_READ
+// and PORT_WRITE traps. A port connected before is replaced.
+func (c *CPU) ConnectPort(num int, rw io.ReadWriter) {
+	if c.ports == nil {
+		c.ports = map[int]*port{}
+	}
+	c.ports[num] = &port{r: bufio.NewReader(rw), w: rw}
+}
+
+// port returns the port whose number is in register #0
+func (c *CPU) port() (*port, error) {
+	num, err := c.regs[0].GetInt()
+	if err != nil {
+		return nil, err
+	}
+	p, ok := c.ports[num]
+	if !ok {
+		return nil, fmt.Errorf("port %d isn't connected", num)
+	}
+	return p, nil
+}
+
+// PortReadTrap reads a line from a port connected by the host.
+//
+// Input: the number of the port in register #0.
+//
+// Output: sets register #0 with the line, including its newline. If the
+// other end closed the port it is set to the empty string, and the zero
+// flag is set.
+func PortReadTrap(c *CPU, num int) error {
+	p, err := c.port()
+	if err != nil {
+		return err
+	}
+	line, err := p.r.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	c.regs[0].SetStr(line)
+	c.SetZero(line == "")
+	return nil
+}
+
+// PortWriteTrap writes a string to a port connected by the host.
+//
+// Input: the number of the port in register #0, the string in
+// register #1.
+//
+// Output: none.
+func PortWriteTrap(c *CPU, num int) error {
+	p, err := c.port()
+	if err != nil {
+		return err
+	}
+	str, err := c.regs[1].GetStr()
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(p.w, str)
+	return err
+}
@@ -0,0 +1,51 @@
+package cpu
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"vm/header"
+	"vm/opcode"
+)
+
+func TestPortTrapsRequireCapabilities(t *testing.T) {
+	for _, num := range []int{TrapPortRead, TrapPortWrite} {
+		if header.TrapCapabilities[num] != header.CapFile|header.CapNet {
+			t.Errorf("trap %d requires %s", num, header.TrapCapabilities[num])
+		}
+	}
+
+	write := program(ins(opcode.STR_STORE, 1), lstr("hi\n"), ins(opcode.TRAP), le16(TrapPortWrite))
+	for _, tc := range []struct {
+		name    string
+		allowed header.Capability
+		dryRun  bool
+		wantErr error
+		written string
+		out     string
+	}{
+		{"allowed", header.CapAll, false, nil, "hi\n", ""},
+		{"denied", header.CapFile, false, ErrNotAllowed, "", ""},
+		{"dry-run", 0, true, nil, "", "[dry-run] would invoke trap 0x0009 (FILE, NET)\n"},
+	} {
+		var port bytes.Buffer
+		var out strings.Builder
+		c := NewCPU()
+		c.STDOUT = bufio.NewWriter(&out)
+		c.ConnectPort(0, &port)
+		c.SetAllowedCapabilities(tc.allowed)
+		c.SetDryRun(tc.dryRun)
+
+		if err := c.execute(write...); !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: error %v, want %v", tc.name, err, tc.wantErr)
+		}
+		if got := port.String(); got != tc.written {
+			t.Errorf("%s: wrote %q to the port, want %q", tc.name, got, tc.written)
+		}
+		if got := out.String(); got != tc.out {
+			t.Errorf("%s: output %q, want %q", tc.name, got, tc.out)
+		}
+	}
+}
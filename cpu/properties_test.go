@@ -0,0 +1,113 @@
+package cpu
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"testing/quick"
+	"vm/opcode"
+)
+
+// The tests in this file check algebraic properties of the instruction
+// set against randomized register and memory states, to catch
+// regressions in the interpreter.
+
+// testCodeSize is the size of the area reserved for the instructions
+// under test, random memory operations stay clear of it
+const testCodeSize = 0x100
+
+// randomCPU returns a CPU with random memory contents, seeded so a
+// failing state can be reproduced from the reported arguments
+func randomCPU(seed int64) *CPU {
+	c := NewCPU()
+	rand.New(rand.NewSource(seed)).Read(c.mem[:])
+	return c
+}
+
+// execute places the given instructions at address zero and steps
+// through them
+func (c *CPU) execute(code ...byte) error {
+	copy(c.mem[:], code)
+	c.ip = 0
+	for c.ip < len(code) {
+		if _, err := c.step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CPU) intReg(reg int) int {
+	v, _ := c.regs[reg].GetInt()
+	return v
+}
+
+func checkProperty(t *testing.T, f any) {
+	t.Helper()
+	if err := quick.Check(f, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAddCommutative(t *testing.T) {
+	checkProperty(t, func(seed int64, a, b uint16) bool {
+		c := randomCPU(seed)
+		c.regs[0].SetInt(int(a))
+		c.regs[1].SetInt(int(b))
+
+		err := c.execute(
+			byte(opcode.ADD), 2, 0, 1,
+			byte(opcode.ADD), 3, 1, 0,
+		)
+		if err != nil || c.intReg(2) != c.intReg(3) {
+			return false
+		}
+		return int(a)+int(b) >= MemSize || c.intReg(2) == int(a)+int(b)
+	})
+}
+
+func TestXorSelfInverse(t *testing.T) {
+	checkProperty(t, func(seed int64, a, b uint16) bool {
+		c := randomCPU(seed)
+		c.regs[0].SetInt(int(a))
+		c.regs[1].SetInt(int(b))
+
+		err := c.execute(
+			byte(opcode.XOR), 2, 0, 1,
+			byte(opcode.XOR), 2, 2, 1,
+		)
+		return err == nil && c.intReg(2) == int(a)
+	})
+}
+
+func TestPushPopIdentity(t *testing.T) {
+	checkProperty(t, func(seed int64, a uint16) bool {
+		c := randomCPU(seed)
+		c.regs[0].SetInt(int(a))
+		size := c.stack.Size()
+
+		err := c.execute(
+			byte(opcode.PUSH), 0,
+			byte(opcode.POP), 1,
+		)
+		return err == nil && c.intReg(1) == int(a) && c.stack.Size() == size
+	})
+}
+
+func TestMemCpyEqual(t *testing.T) {
+	checkProperty(t, func(seed int64, length, srcOffset, gap uint8) bool {
+		c := randomCPU(seed)
+
+		// two non-overlapping ranges outside of the code
+		src := testCodeSize + int(srcOffset)*0x40
+		dst := src + int(length) + int(gap)*0x40
+		c.regs[0].SetInt(dst)
+		c.regs[1].SetInt(src)
+		c.regs[2].SetInt(int(length))
+
+		if err := c.execute(byte(opcode.MEM_CPY), 0, 1, 2); err != nil {
+			return false
+		}
+		return bytes.Equal(c.mem[src:src+int(length)], c.mem[dst:dst+int(length)])
+	})
+}
@@ -0,0 +1,48 @@
+package cpu
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrReadOnly is the error of writing into a program loaded with
+// ReadOnlyCode
+var ErrReadOnly = errors.New("write to read-only code")
+
+// LoadOption changes how a program is loaded, e.g. by LoadBytes
+type LoadOption func(c *CPU, start, end int)
+
+// ReadOnlyCode marks the memory the program is loaded into as read-only,
+// so POKE, POKE16, POKE_STR and MEM_CPY writing into it stop the program
+// with ErrReadOnly rather than silently changing the code, e.g. via a
+// wrong address. Its data, e.g. the buffers it declares, is read-only
+// too. Memory outside of the program stays writable.
+func ReadOnlyCode() LoadOption {
+	return func(c *CPU, start, end int) {
+		c.readOnly = codeRange{start: start, end: end}
+	}
+}
+
+// codeRange is the memory from start up to, but excluding, end
+type codeRange struct {
+	start, end int
+}
+
+// applyLoadOptions applies the options of loading a program into the
+// memory from start to end, forgetting the ones of the previous program
+func (c *CPU) applyLoadOptions(start, end int, opts []LoadOption) {
+	c.readOnly = codeRange{}
+	for _, opt := range opts {
+		opt(c, start, end)
+	}
+}
+
+// checkWritable returns ErrReadOnly if the address is inside of a program
+// loaded with ReadOnlyCode
+func (c *CPU) checkWritable(addr int) error {
+	if addr < c.readOnly.start || addr >= c.readOnly.end {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is inside of the program at %04x-%04x",
+		ErrReadOnly, c.Locate(addr), c.readOnly.start, c.readOnly.end-1)
+}
@@ -0,0 +1,81 @@
+package cpu
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"vm/opcode"
+)
+
+func TestReadOnlyCode(t *testing.T) {
+	// the program pokes 0xff at the address in #1, then copies its first
+	// two bytes to the address in #2
+	code := program(
+		ins(opcode.INT_STORE, 0), le16(0xff),
+		ins(opcode.POKE, 0, 1),
+		ins(opcode.INT_STORE, 3), le16(0),
+		ins(opcode.INT_STORE, 4), le16(2),
+		ins(opcode.MEM_CPY, 2, 3, 4),
+	)
+
+	run := func(poke, copyTo int, opts ...LoadOption) (*CPU, error) {
+		c := NewCPU()
+		if err := c.LoadBytes(code, opts...); err != nil {
+			t.Fatal(err)
+		}
+		c.regs[1].SetInt(poke)
+		c.regs[2].SetInt(copyTo)
+		return c, c.Run()
+	}
+
+	// outside of the program memory stays writable
+	c, err := run(0x100, 0x200, ReadOnlyCode())
+	if err != nil {
+		t.Fatalf("writing outside of the program failed: %s", err)
+	}
+	if c.mem[0x100] != 0xff || c.mem[0x200] != byte(opcode.INT_STORE) {
+		t.Errorf("memory wasn't written")
+	}
+
+	for _, tc := range []struct {
+		name         string
+		poke, copyTo int
+	}{
+		{"POKE", 4, 0x200},
+		{"MEM_CPY", 0x100, len(code) - 1},
+	} {
+		c, err = run(tc.poke, tc.copyTo, ReadOnlyCode())
+		if !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s into the program: got %v, want ErrReadOnly", tc.name, err)
+			continue
+		}
+		if !bytes.Equal(c.mem[:len(code)], code) {
+			t.Errorf("%s into the program changed it", tc.name)
+		}
+
+		// without the option the program can change itself
+		if _, err = run(tc.poke, tc.copyTo); errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s into the program without ReadOnlyCode: got %v", tc.name, err)
+		}
+	}
+}
+
+func TestRAMStackStaysOutOfReservedMemory(t *testing.T) {
+	c := NewCPU()
+	c.SetRAMStack(true)
+	if err := c.LoadBytes(program(ins(opcode.NOP)), ReadOnlyCode()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, addr := range []int{IOStart + 0x10, 0x4000 - 2} {
+		if err := c.storeRAMEntry(addr, 1); !errors.Is(err, ErrStackOverflow) {
+			t.Errorf("entry at %04x: got %v, want ErrStackOverflow", addr, err)
+		}
+	}
+	if err := c.storeRAMEntry(0, 1); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("entry in the program: got %v, want ErrReadOnly", err)
+	}
+	if err := c.SetSP(IOStart); err == nil {
+		t.Error("SP was moved into the window of the devices")
+	}
+}
@@ -0,0 +1,147 @@
+package cpu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TrapProvider implements traps in another process, so the traps
+// available to programs can be extended without recompiling the VM.
+//
+// The processes exchange JSON objects, one per line. The provider starts
+// by listing the traps it implements:
+//
+//	{"traps": [256, 257]}
+//
+// Every time the program invokes one of them, the values of all the
+// registers are sent, integers, strings and floats as such and hashes
+// as empty objects:
+//
+//	{"trap": 256, "regs": [{"int": 5}, {"str": "hello"}, ...]}
+//
+// The provider answers with the registers to change, and optionally the
+// zero flag, or with an error stopping the program:
+//
+//	{"regs": {"0": {"str": "HELLO"}}, "zero": false}
+//	{"error": "unsupported argument"}
+type TrapProvider struct {
+	dec   *json.Decoder
+	enc   *json.Encoder
+	traps []int
+}
+
+// trapValue is the value of a register exchanged with a provider
+type trapValue struct {
+	Int   *int     `json:"int,omitempty"`
+	Str   *string  `json:"str,omitempty"`
+	Float *float64 `json:"float,omitempty"`
+}
+
+type trapHello struct {
+	Traps []int `json:"traps"`
+}
+
+type trapRequest struct {
+	Trap int         `json:"trap"`
+	Regs []trapValue `json:"regs"`
+}
+
+type trapResponse struct {
+	Regs  map[int]trapValue `json:"regs"`
+	Zero  *bool             `json:"zero"`
+	Error string            `json:"error"`
+}
+
+// NewTrapProvider reads the traps implemented by the provider from r,
+// which it writes to first, and returns the provider sending requests
+// to w, e.g. the standard output and input of its process
+func NewTrapProvider(r io.Reader, w io.Writer) (*TrapProvider, error) {
+	p := &TrapProvider{dec: json.NewDecoder(r), enc: json.NewEncoder(w)}
+
+	var hello trapHello
+	if err := p.dec.Decode(&hello); err != nil {
+		return nil, fmt.Errorf("failed to read the traps of the provider: %w", err)
+	}
+	for _, num := range hello.Traps {
+		if num < 0 || num >= MemSize {
+			return nil, fmt.Errorf("invalid trap number: %d", num)
+		}
+	}
+	p.traps = hello.Traps
+	return p, nil
+}
+
+// Traps returns the numbers of the traps implemented by the provider
+func (p *TrapProvider) Traps() []int {
+	return p.traps
+}
+
+// SetTrap sets the function implementing a trap for this CPU only,
+// taking precedence over TRAPS
+func (c *CPU) SetTrap(num int, fn TrapFunction) {
+	if c.traps == nil {
+		c.traps = map[int]TrapFunction{}
+	}
+	c.traps[num] = fn
+}
+
+// AddTrapProvider makes the traps implemented by the provider available
+// to the programs of this CPU, replacing built-in ones with the same
+// numbers
+func (c *CPU) AddTrapProvider(p *TrapProvider) {
+	for _, num := range p.traps {
+		c.SetTrap(num, p.call)
+	}
+}
+
+// call is the TrapFunction of the traps implemented by the provider
+func (p *TrapProvider) call(c *CPU, num int) error {
+	req := trapRequest{Trap: num, Regs: make([]trapValue, len(c.regs))}
+	for i, r := range c.regs {
+		switch o := r.obj.(type) {
+		case *IntObject:
+			req.Regs[i].Int = &o.Value
+		case *StrObject:
+			req.Regs[i].Str = &o.Value
+		case *FloatObject:
+			req.Regs[i].Float = &o.Value
+		}
+	}
+	if err := p.enc.Encode(req); err != nil {
+		return fmt.Errorf("failed to call trap 0x%04x: %w", num, err)
+	}
+
+	var res trapResponse
+	if err := p.dec.Decode(&res); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("failed to read the result of trap 0x%04x: %w", num, err)
+	}
+	if res.Error != "" {
+		return fmt.Errorf("trap 0x%04x: %s", num, res.Error)
+	}
+
+	for n, v := range res.Regs {
+		reg, err := c.Reg(n)
+		if err != nil {
+			return fmt.Errorf("trap 0x%04x: %w", num, err)
+		}
+		switch {
+		case v.Int != nil:
+			reg.SetInt(*v.Int)
+		case v.Str != nil:
+			reg.SetStr(*v.Str)
+		case v.Float != nil:
+			reg.SetFloat(*v.Float)
+		default:
+			return fmt.Errorf("trap 0x%04x: register [%d] has no value", num, n)
+		}
+	}
+	if res.Zero != nil {
+		c.SetZero(*res.Zero)
+	}
+	return nil
+}
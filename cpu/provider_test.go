@@ -0,0 +1,66 @@
+package cpu
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"vm/opcode"
+)
+
+// serveUpper is a trap provider implementing trap 0x100, which converts
+// the string in register #0 to upper case
+func serveUpper(r io.Reader, w io.Writer) {
+	io.WriteString(w, `{"traps": [256]}`+"\n")
+
+	lines := bufio.NewScanner(r)
+	for lines.Scan() {
+		var req struct {
+			Trap int
+			Regs []struct{ Str *string }
+		}
+		if err := json.Unmarshal(lines.Bytes(), &req); err != nil || req.Regs[0].Str == nil {
+			io.WriteString(w, `{"error": "want a string in #0"}`+"\n")
+			continue
+		}
+		res, _ := json.Marshal(map[string]any{
+			"regs": map[string]any{"0": map[string]string{"str": strings.ToUpper(*req.Regs[0].Str)}},
+			"zero": true,
+		})
+		w.Write(append(res, '\n'))
+	}
+}
+
+func TestTrapProvider(t *testing.T) {
+	reqR, reqW := io.Pipe()
+	resR, resW := io.Pipe()
+	go serveUpper(reqR, resW)
+	defer reqW.Close()
+
+	p, err := NewTrapProvider(resR, reqW)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCPU()
+	c.AddTrapProvider(p)
+	if err := c.execute(program(ins(opcode.STR_STORE, 0), lstr("hi"))...); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.execute(program(ins(opcode.TRAP), le16(0x100))...); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c.regs[0].GetStr(); got != "HI" {
+		t.Errorf("#0 = %q, want %q", got, "HI")
+	}
+	if !c.Zero() {
+		t.Error("the zero flag wasn't set")
+	}
+
+	c.regs[0].SetInt(1)
+	err = c.execute(program(ins(opcode.TRAP), le16(0x100))...)
+	if err == nil || !strings.Contains(err.Error(), "want a string") {
+		t.Errorf("error %v, want the error of the provider", err)
+	}
+}
@@ -0,0 +1,64 @@
+package cpu
+
+import (
+	"errors"
+	"vm/header"
+)
+
+// pseudoRegister is the register standing in for #sp or #ip during the
+// current instruction, see Reg
+type pseudoRegister struct {
+	n   int
+	reg *Register
+
+	// read is the value the register was read with, so writes can be
+	// told apart
+	read Object
+}
+
+// pseudoRegister returns a register holding the stack pointer for
+// header.RegSP, or the address of the current instruction for
+// header.RegIP. Operands naming the same pseudo-register get the same
+// register within an instruction.
+func (c *CPU) pseudoRegister(n int) (*Register, error) {
+	for _, p := range c.pseudo {
+		if p.n == n {
+			return p.reg, nil
+		}
+	}
+
+	v := c.current
+	if n == header.RegSP {
+		if !c.ramStack {
+			return nil, errors.New("#sp needs the stack in RAM")
+		}
+		v = c.sp
+	}
+
+	reg := newRegister(wordRange(c.wordSize, c.signed))
+	reg.SetInt(v)
+	c.pseudo = append(c.pseudo, pseudoRegister{n: n, reg: reg, read: reg.obj})
+	return reg, nil
+}
+
+// writePseudoRegisters moves the stack pointer to the value the
+// instruction stored in #sp, if any. Storing into #ip is an error, jumps
+// are the way to change it.
+func (c *CPU) writePseudoRegisters() error {
+	for _, p := range c.pseudo {
+		if p.reg.obj == p.read {
+			continue
+		}
+		if p.n == header.RegIP {
+			return errors.New("#ip is read-only")
+		}
+		sp, err := p.reg.GetInt()
+		if err != nil {
+			return err
+		}
+		if err = c.SetSP(sp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
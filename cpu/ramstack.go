@@ -0,0 +1,234 @@
+package cpu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// RAMStackTop is the address above the topmost entry of an empty stack
+// in RAM, see SetRAMStack. The bytes above it are left alone, so the
+// last byte of memory is still an EXIT.
+const RAMStackTop = MemSize - 3
+
+// RAMStackSize is the number of bytes of memory below RAMStackTop
+// reserved for the stack in RAM. The heap ends where it starts.
+const RAMStackSize = 0x1000
+
+// ramEntrySize is the number of bytes of an entry of the stack in RAM
+const ramEntrySize = 4
+
+// ErrStackOverflow is the error of pushing onto a full stack in RAM
+var ErrStackOverflow = errors.New("stack overflow")
+
+// SetRAMStack selects whether the stack lives in the memory of the
+// program instead of being kept by the host. The stack in RAM grows
+// down from RAMStackTop, each entry being a 32-bit little-endian
+// integer, and SP holds the address of the topmost entry. Programs can
+// read and move SP via SP_GET and SP_SET, and inspect or change the
+// entries via PEEK and POKE. Only integers can be pushed, and pushing
+// beyond RAMStackSize bytes, or into the program, is a stack overflow.
+// The stack is emptied.
+func (c *CPU) SetRAMStack(enabled bool) {
+	c.ramStack = enabled
+	c.stack = NewStack()
+	c.sp = RAMStackTop
+	c.fp = 0
+}
+
+// RAMStack returns true if the stack lives in RAM, see SetRAMStack
+func (c *CPU) RAMStack() bool {
+	return c.ramStack
+}
+
+// SP returns the stack pointer, the address of the topmost entry of
+// the stack in RAM, or RAMStackTop if it is empty
+func (c *CPU) SP() int {
+	return c.sp
+}
+
+// SetSP moves the stack pointer, which must point at an entry of the
+// stack in RAM or at RAMStackTop
+func (c *CPU) SetSP(sp int) error {
+	if !c.ramStack {
+		return errors.New("the stack isn't in RAM")
+	}
+	if sp < c.ramStackLimit() || sp > RAMStackTop || (RAMStackTop-sp)%ramEntrySize != 0 {
+		return fmt.Errorf("stack pointer %04x is outside of the stack at %04x-%04x or not aligned to %d bytes",
+			sp, c.ramStackLimit(), RAMStackTop, ramEntrySize)
+	}
+	if w, ok := reservedWindow(sp, RAMStackTop-sp); ok {
+		return fmt.Errorf("stack pointer %04x puts the stack into the window at %04x-%04x", sp, w.addr, w.addr+w.size-1)
+	}
+	c.sp = sp
+	return nil
+}
+
+// ramStackLimit returns the lowest address the stack in RAM may grow to
+func (c *CPU) ramStackLimit() int {
+	limit := RAMStackTop - RAMStackSize
+	if c.codeSize > limit {
+		// round up to the next entry, so the program isn't overwritten
+		limit += (c.codeSize - limit + ramEntrySize - 1) / ramEntrySize * ramEntrySize
+	}
+	return limit
+}
+
+// push pushes a value onto the stack in use
+func (c *CPU) push(obj Object) error {
+	if !c.ramStack {
+		c.stack.PushObject(obj)
+		return nil
+	}
+
+	i, ok := obj.(*IntObject)
+	if !ok {
+		return fmt.Errorf("the stack in RAM only holds integers, not a %s", obj.Type())
+	}
+	if c.sp-ramEntrySize < c.ramStackLimit() {
+		return fmt.Errorf("%w: the stack in RAM is full at SP %04x", ErrStackOverflow, c.sp)
+	}
+	if err := c.storeRAMEntry(c.sp-ramEntrySize, i.Value); err != nil {
+		return err
+	}
+	c.sp -= ramEntrySize
+	return nil
+}
+
+// pop pops a value from the stack in use
+func (c *CPU) pop() (Object, error) {
+	if !c.ramStack {
+		return c.stack.PopObject()
+	}
+
+	if c.sp >= RAMStackTop {
+		return nil, errors.New("pop from an empty stack")
+	}
+	obj := &IntObject{Value: c.ramEntry(c.sp)}
+	c.sp += ramEntrySize
+	return obj, nil
+}
+
+// ramEntry returns the entry of the stack in RAM at the given address.
+// Entries are sign-extended unless registers hold unsigned 32-bit
+// integers, so negative values and all addresses survive the trip.
+func (c *CPU) ramEntry(addr int) int {
+	v := binary.LittleEndian.Uint32(c.mem[addr:])
+	if c.wordSize == 32 && !c.signed {
+		return int(v)
+	}
+	return int(int32(v))
+}
+
+// stackSize returns the number of entries of the stack in use
+func (c *CPU) stackSize() int {
+	if c.ramStack {
+		return (RAMStackTop - c.sp) / ramEntrySize
+	}
+	return c.stack.Size()
+}
+
+// truncateStack drops all but the bottom n entries of the stack in use
+func (c *CPU) truncateStack(n int) {
+	if c.ramStack {
+		c.sp = RAMStackTop - n*ramEntrySize
+		return
+	}
+	c.stack.truncate(n)
+}
+
+// stackEntry returns the entry of the stack in use with the given index,
+// counting from the bottom
+func (c *CPU) stackEntry(i int) Object {
+	if c.ramStack {
+		return &IntObject{Value: c.ramEntry(RAMStackTop - (i+1)*ramEntrySize)}
+	}
+	return c.stack.entries[i]
+}
+
+// setStackEntry replaces the entry of the stack in use with the given
+// index, counting from the bottom
+func (c *CPU) setStackEntry(i int, obj Object) error {
+	if !c.ramStack {
+		c.stack.set(i, obj)
+		return nil
+	}
+
+	v, ok := obj.(*IntObject)
+	if !ok {
+		return fmt.Errorf("the stack in RAM only holds integers, not a %s", obj.Type())
+	}
+	return c.storeRAMEntry(RAMStackTop-(i+1)*ramEntrySize, v.Value)
+}
+
+// storeRAMEntry writes an entry of the stack in RAM at the given address.
+// Like Store it doesn't overwrite code loaded with ReadOnlyCode, and the
+// stack never reaches into a reserved window, so neither devices nor
+// memory banks miss its writes. Writes of the stack aren't recorded as
+// data of the memory layout.
+func (c *CPU) storeRAMEntry(addr, v int) error {
+	if w, ok := reservedWindow(addr, ramEntrySize); ok {
+		return fmt.Errorf("%w: the stack in RAM reached the window at %04x-%04x", ErrStackOverflow, w.addr, w.addr+w.size-1)
+	}
+	for a := addr; a < addr+ramEntrySize; a++ {
+		if err := c.checkWritable(a); err != nil {
+			return err
+		}
+	}
+	binary.LittleEndian.PutUint32(c.mem[addr:], uint32(v))
+	c.literals.invalidate(addr, addr+ramEntrySize)
+	return nil
+}
+
+// stackEntries returns the entries of the stack in use, the bottom one
+// first
+func (c *CPU) stackEntries() []Object {
+	if !c.ramStack {
+		return c.stack.entries
+	}
+
+	entries := make([]Object, 0, c.stackSize())
+	for addr := RAMStackTop - ramEntrySize; addr >= c.sp; addr -= ramEntrySize {
+		entries = append(entries, &IntObject{Value: c.ramEntry(addr)})
+	}
+	return entries
+}
+
+// StackSize returns the number of entries on the stack
+func (c *CPU) StackSize() int {
+	return c.stackSize()
+}
+
+// execSPGet stores the stack pointer in a register
+func (c *CPU) execSPGet() (bool, error) {
+	c.ip++
+	reg, err := fetchReg(c)
+	if err != nil {
+		return false, err
+	}
+	if !c.ramStack {
+		return false, errors.New("SP_GET needs the stack in RAM")
+	}
+
+	reg.SetInt(c.sp)
+	return true, nil
+}
+
+// execSPSet moves the stack pointer to the address in a register,
+// pushing the entries below it or dropping the ones above it
+func (c *CPU) execSPSet() (bool, error) {
+	c.ip++
+	reg, err := fetchReg(c)
+	if err != nil {
+		return false, err
+	}
+	sp, err := reg.GetInt()
+	if err != nil {
+		return false, err
+	}
+
+	if err = c.SetSP(sp); err != nil {
+		return false, err
+	}
+	return true, nil
+}
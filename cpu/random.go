@@ -0,0 +1,62 @@
+package cpu
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// SetSeed seeds the random numbers of INT_RAND and RAND_RANGE, so they
+// are the same on every run. By default they are seeded from the clock
+// once the program draws the first one, see SetClock. Either way all
+// numbers of a run are drawn from a single stream, which restarts when
+// the program is loaded.
+func (c *CPU) SetSeed(seed int64) {
+	c.seed = seed
+	c.seeded = true
+	c.rng = nil
+}
+
+// random returns the stream of random numbers, creating it on first use
+func (c *CPU) random() *rand.Rand {
+	if c.rng == nil {
+		seed := c.seed
+		if !c.seeded {
+			seed = c.clock.Now().UnixNano()
+		}
+		c.rng = rand.New(rand.NewSource(seed))
+	}
+	return c.rng
+}
+
+// execIntRand stores a random integer in a register
+func (c *CPU) execIntRand() (bool, error) {
+	c.ip++
+	reg, err := fetchReg(c)
+	if err != nil {
+		return false, err
+	}
+
+	_, hi := wordRange(c.wordSize, c.signed)
+	reg.SetInt(c.random().Intn(hi))
+	return true, nil
+}
+
+// execRandRange stores a random integer from zero up to, but excluding,
+// the value of a second register, all of them being equally likely
+func (c *CPU) execRandRange() (bool, error) {
+	c.ip++
+	regs, err := fetchRegs(c, 2)
+	if err != nil {
+		return false, err
+	}
+	n, err := regs[1].GetInt()
+	if err != nil {
+		return false, err
+	}
+	if n <= 0 {
+		return false, fmt.Errorf("random number below %d requested, the bound must be positive", n)
+	}
+
+	regs[0].SetInt(c.random().Intn(n))
+	return true, nil
+}
@@ -0,0 +1,38 @@
+package cpu
+
+import (
+	"testing"
+	"vm/opcode"
+)
+
+func TestSeedMakesRandomNumbersReproducible(t *testing.T) {
+	code := program(ins(opcode.INT_STORE, 1), le16(6), ins(opcode.RAND_RANGE, 2, 1), ins(opcode.RAND_RANGE, 3, 1))
+
+	draw := func() []int {
+		var numbers []int
+		c := NewCPU()
+		c.SetSeed(42)
+		for range 50 {
+			if err := c.execute(code...); err != nil {
+				t.Fatal(err)
+			}
+			numbers = append(numbers, c.intReg(2), c.intReg(3))
+		}
+		return numbers
+	}
+
+	a, b := draw(), draw()
+	seen := map[int]bool{}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("number %d differs: %d and %d", i, a[i], b[i])
+		}
+		if a[i] < 0 || a[i] >= 6 {
+			t.Fatalf("number %d is %d, out of range", i, a[i])
+		}
+		seen[a[i]] = true
+	}
+	if len(seen) != 6 {
+		t.Errorf("only %d of the 6 numbers were drawn", len(seen))
+	}
+}
@@ -1,6 +1,10 @@
 package cpu
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
 
 // Object is the interface for a value stored in a register
 type Object interface {
@@ -19,31 +23,146 @@ func (IntObject) Type() string {
 // StrObject is an object containing a string
 type StrObject struct {
 	Value string
+
+	// builder holds the bytes of Value if it was built by concatenation,
+	// see concatStr
+	builder *strings.Builder
+}
+
+// concatStr returns an object holding the concatenation of a and b.
+//
+// Strings built by CONCAT in a loop, e.g. "concat #1, #1, #2", would take
+// quadratic time if each one was copied, so the bytes are appended to
+// the builder of a instead, if nothing was appended to it since. Value
+// of a and of any earlier result still refer to the bytes they had, as
+// the builder never changes bytes once written.
+func concatStr(a *StrObject, b string) *StrObject {
+	builder := a.builder
+	if builder == nil || builder.Len() != len(a.Value) {
+		builder = &strings.Builder{}
+		builder.Grow(len(a.Value) + len(b))
+		builder.WriteString(a.Value)
+	}
+	builder.WriteString(b)
+	return &StrObject{Value: builder.String(), builder: builder}
 }
 
 func (StrObject) Type() string {
 	return "str"
 }
 
+// FloatObject is an object containing a floating-point number
+type FloatObject struct {
+	Value float64
+}
+
+func (FloatObject) Type() string {
+	return "float"
+}
+
+// HashObject is an object mapping strings to integers and strings.
+// Copying a register holding a hash copies a reference, so changes
+// through either register are seen by both.
+type HashObject struct {
+	Values map[string]Object
+
+	// usage is the approximate number of host bytes used by the keys
+	// and values, kept up to date by set and del, see hashUsage
+	usage int
+
+	// counted is the pass of MemoryUsage which counted the hash last,
+	// so hashes referred to more than once are counted once
+	counted int
+}
+
+func (HashObject) Type() string {
+	return "hash"
+}
+
+// Keys returns the keys of the hash in sorted order
+func (h *HashObject) Keys() []string {
+	keys := make([]string, 0, len(h.Values))
+	for key := range h.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// set stores the value under the key, replacing any previous one
+func (h *HashObject) set(key string, v Object) {
+	h.del(key)
+	h.Values[key] = v
+	h.usage += hashEntryUsage(key, v)
+}
+
+// del removes the key, if it is present
+func (h *HashObject) del(key string) {
+	if old, ok := h.Values[key]; ok {
+		h.usage -= hashEntryUsage(key, old)
+		delete(h.Values, key)
+	}
+}
+
+// String formats the hash ordered by keys, e.g. {"a": 1, "b": "x"}
+func (h *HashObject) String() string {
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, key := range h.Keys() {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		switch v := h.Values[key].(type) {
+		case *IntObject:
+			fmt.Fprintf(&sb, "%q: %d", key, v.Value)
+		case *StrObject:
+			fmt.Fprintf(&sb, "%q: %q", key, v.Value)
+		}
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
 // Register contains the value of a single register as an object.
-// This means it can contain either an IntObject or a StrObject.
+// This means it can contain an IntObject, a StrObject, a FloatObject
+// or a HashObject.
 type Register struct {
 	obj Object
+
+	// min and max are the smallest and the largest integer the register
+	// can hold, which depend on the word size and the signed mode
+	min, max int
+
+	// overflow records integers which didn't fit, nil unless the CPU
+	// faults on overflows
+	overflow *overflow
 }
 
 func NewRegister() *Register {
-	r := &Register{}
+	return newRegister(wordRange(defaultWordSize, false))
+}
+
+// newRegister creates a register holding integers from min to max
+func newRegister(min, max int) *Register {
+	r := &Register{min: min, max: max}
 	r.SetInt(0)
 	return r
 }
 
 // SetInt stores the given integer in the register.
-// Note that a register may only contain integers in the range 0x0000-0xffff.
+// Note that a register may only contain integers in the range of the
+// machine word, e.g. 0x0000-0xffff for 16-bit words, or -32768-32767
+// for 16-bit words in signed mode. Values outside of the range are clamped,
+// or fault if the CPU faults on overflows.
 func (r *Register) SetInt(v int) {
-	if v <= 0 {
-		r.obj = &IntObject{Value: 0}
-	} else if v >= maxMemSize {
-		r.obj = &IntObject{Value: maxMemSize}
+	if v < r.min || v > r.max {
+		r.noteOverflow(v)
+	}
+
+	if v <= r.min {
+		r.obj = &IntObject{Value: r.min}
+	} else if v >= r.max {
+		r.obj = &IntObject{Value: r.max}
 	} else {
 		r.obj = &IntObject{Value: v}
 	}
@@ -74,7 +193,47 @@ func (r *Register) GetStr() (string, error) {
 	return "", fmt.Errorf("attempting to call GetStr on a register containing a non-string value: %v", r.obj)
 }
 
-// Type returns the type of the register's value (integer or string)
+// SetFloat stores the given floating-point number in the register
+func (r *Register) SetFloat(v float64) {
+	r.obj = &FloatObject{Value: v}
+}
+
+// GetFloat retrieves the floating-point number of the given register.
+// If the register does not contain a float that is a fatal error.
+func (r *Register) GetFloat() (float64, error) {
+	v, ok := r.obj.(*FloatObject)
+	if ok {
+		return v.Value, nil
+	}
+	return 0, fmt.Errorf("attempting to call GetFloat on a register containing a non-float value: %v", r.obj)
+}
+
+// SetHash stores a reference to the given hash in the register
+func (r *Register) SetHash(h *HashObject) {
+	r.obj = h
+}
+
+// GetHash retrieves the hash of the given register.
+// If the register does not contain a hash that is a fatal error.
+func (r *Register) GetHash() (*HashObject, error) {
+	v, ok := r.obj.(*HashObject)
+	if ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("attempting to call GetHash on a register containing a non-hash value: %v", r.obj)
+}
+
+// setObject stores the given value of any type in the register, e.g. one
+// popped from the stack. Integers are clamped like by SetInt.
+func (r *Register) setObject(obj Object) {
+	if i, ok := obj.(*IntObject); ok {
+		r.SetInt(i.Value)
+		return
+	}
+	r.obj = obj
+}
+
+// Type returns the type of the register's value (integer, string, float or hash)
 func (r *Register) Type() string {
 	return r.obj.Type()
 }
@@ -0,0 +1,30 @@
+package cpu
+
+import "testing"
+
+func TestConcatStrKeepsEarlierValues(t *testing.T) {
+	base := concatStr(&StrObject{Value: "ab"}, "c")
+	longer := concatStr(base, "d")
+	branch := concatStr(base, "e")
+
+	for _, tc := range []struct {
+		obj  *StrObject
+		want string
+	}{
+		{base, "abc"},
+		{longer, "abcd"},
+		{branch, "abce"},
+		{concatStr(longer, "f"), "abcdf"},
+	} {
+		if tc.obj.Value != tc.want {
+			t.Errorf("got %q, want %q", tc.obj.Value, tc.want)
+		}
+	}
+
+	if longer.builder != base.builder {
+		t.Error("appending to the latest string copied it")
+	}
+	if branch.builder == base.builder {
+		t.Error("appending to an earlier string reused its builder")
+	}
+}
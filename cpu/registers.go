@@ -0,0 +1,40 @@
+package cpu
+
+import (
+	"fmt"
+	"vm/header"
+)
+
+// SetNumRegisters sets the number of registers, which is
+// header.DefaultNumRegisters unless set, and resets all of them
+func (c *CPU) SetNumRegisters(n int) error {
+	if n < 1 || n > header.MaxNumRegisters {
+		return fmt.Errorf("unsupported number of registers: %d, valid are 1 to %d", n, header.MaxNumRegisters)
+	}
+
+	c.regs = make([]*Register, n)
+	c.resetRegisters()
+	return nil
+}
+
+// NumRegisters returns the number of registers
+func (c *CPU) NumRegisters() int {
+	return len(c.regs)
+}
+
+// CheckRegisters returns an error if the CPU has fewer registers than
+// the given number a program uses, e.g. header.Header.Registers
+func (c *CPU) CheckRegisters(n int) error {
+	if n > len(c.regs) {
+		return fmt.Errorf("program uses %d registers, the CPU has %d", n, len(c.regs))
+	}
+	return nil
+}
+
+// resetRegisters sets every register to integer zero
+func (c *CPU) resetRegisters() {
+	for i := range c.regs {
+		c.regs[i] = newRegister(wordRange(c.wordSize, c.signed))
+		c.regs[i].overflow = c.overflow
+	}
+}
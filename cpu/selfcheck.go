@@ -0,0 +1,1276 @@
+package cpu
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+	"vm/header"
+	"vm/opcode"
+)
+
+// SelfCheck is a tiny program exercising a single opcode, together with
+// the state expected after running it. The checks double as a
+// description of the semantics of the instruction set, and as a smoke
+// test for ports of the interpreter.
+type SelfCheck struct {
+	// Opcode is the opcode under test
+	Opcode int
+
+	// Stack marks opcodes of the stack-machine instruction set
+	Stack bool
+
+	// Name describes the checked behavior
+	Name string
+
+	// Code is the program, loaded at address zero
+	Code []byte
+
+	// Setup prepares the CPU after the program was loaded, if set
+	Setup func(c *CPU)
+
+	// Err is a part of the error the program must fail with, if set
+	Err string
+
+	// Want are the expectations about the state after running the program
+	Want []Expectation
+}
+
+// Expectation checks the state of the CPU and the output of a program
+type Expectation func(c *CPU, out string) error
+
+// SelfCheckFailure describes a self-check which didn't pass
+type SelfCheckFailure struct {
+	Check string
+	Err   error
+}
+
+func (f SelfCheckFailure) Error() string {
+	return fmt.Sprintf("%s: %s", f.Check, f.Err)
+}
+
+// maxSelfCheckSteps stops self-checks which never reach EXIT
+const maxSelfCheckSteps = 1000
+
+// le16 encodes a 16-bit operand
+func le16(v int) []byte {
+	return []byte{byte(v % 256), byte(v / 256)}
+}
+
+// lstr encodes a string operand as its length and its bytes
+func lstr(str string) []byte {
+	return append(le16(len(str)), str...)
+}
+
+// f64 encodes a floating-point operand
+func f64(v float64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, math.Float64bits(v))
+}
+
+// program joins instructions into a program
+func program(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// ins encodes an opcode followed by register operands
+func ins(code int, regs ...byte) []byte {
+	return append([]byte{byte(code)}, regs...)
+}
+
+func wantInt(reg, v int) Expectation {
+	return func(c *CPU, _ string) error {
+		got, err := c.regs[reg].GetInt()
+		if err != nil {
+			return fmt.Errorf("#%d: %s", reg, err)
+		}
+		if got != v {
+			return fmt.Errorf("#%d = %d, want %d", reg, got, v)
+		}
+		return nil
+	}
+}
+
+func wantStr(reg int, v string) Expectation {
+	return func(c *CPU, _ string) error {
+		got, err := c.regs[reg].GetStr()
+		if err != nil {
+			return fmt.Errorf("#%d: %s", reg, err)
+		}
+		if got != v {
+			return fmt.Errorf("#%d = %q, want %q", reg, got, v)
+		}
+		return nil
+	}
+}
+
+func wantFloat(reg int, v float64) Expectation {
+	return func(c *CPU, _ string) error {
+		got, err := c.regs[reg].GetFloat()
+		if err != nil {
+			return fmt.Errorf("#%d: %s", reg, err)
+		}
+		if got != v {
+			return fmt.Errorf("#%d = %g, want %g", reg, got, v)
+		}
+		return nil
+	}
+}
+
+func wantZ(z bool) Expectation {
+	return func(c *CPU, _ string) error {
+		if c.flags.z != z {
+			return fmt.Errorf("z = %t, want %t", c.flags.z, z)
+		}
+		return nil
+	}
+}
+
+func wantS(s bool) Expectation {
+	return func(c *CPU, _ string) error {
+		if c.flags.s != s {
+			return fmt.Errorf("s = %t, want %t", c.flags.s, s)
+		}
+		return nil
+	}
+}
+
+func wantC(carry bool) Expectation {
+	return func(c *CPU, _ string) error {
+		if c.flags.c != carry {
+			return fmt.Errorf("c = %t, want %t", c.flags.c, carry)
+		}
+		return nil
+	}
+}
+
+func wantV(v bool) Expectation {
+	return func(c *CPU, _ string) error {
+		if c.flags.v != v {
+			return fmt.Errorf("v = %t, want %t", c.flags.v, v)
+		}
+		return nil
+	}
+}
+
+func wantOut(v string) Expectation {
+	return func(_ *CPU, out string) error {
+		if out != v {
+			return fmt.Errorf("output %q, want %q", out, v)
+		}
+		return nil
+	}
+}
+
+func wantOutContains(v string) Expectation {
+	return func(_ *CPU, out string) error {
+		if !strings.Contains(out, v) {
+			return fmt.Errorf("output %q doesn't contain %q", out, v)
+		}
+		return nil
+	}
+}
+
+func wantExitCode(v int) Expectation {
+	return func(c *CPU, _ string) error {
+		if c.exitCode != v {
+			return fmt.Errorf("exit code %d, want %d", c.exitCode, v)
+		}
+		return nil
+	}
+}
+
+func wantMem(addr int, v byte) Expectation {
+	return func(c *CPU, _ string) error {
+		if c.mem[addr] != v {
+			return fmt.Errorf("[%04x] = %02x, want %02x", addr, c.mem[addr], v)
+		}
+		return nil
+	}
+}
+
+func wantStack(entries ...int) Expectation {
+	want := make([]Object, len(entries))
+	for i, v := range entries {
+		want[i] = &IntObject{Value: v}
+	}
+	return wantStackObjects(want...)
+}
+
+func wantStackObjects(entries ...Object) Expectation {
+	format := func(objs []Object) string {
+		formatted := make([]string, len(objs))
+		for i, obj := range objs {
+			formatted[i] = formatEntry(obj)
+		}
+		return "[" + strings.Join(formatted, " ") + "]"
+	}
+	return func(c *CPU, _ string) error {
+		if got, want := format(c.stackEntries()), format(entries); got != want {
+			return fmt.Errorf("stack %s, want %s", got, want)
+		}
+		return nil
+	}
+}
+
+// SelfChecks contains a check for every opcode
+var SelfChecks = []SelfCheck{
+	{
+		Opcode: opcode.EXIT, Name: "EXIT stops the execution",
+		Code: program(ins(opcode.EXIT), ins(opcode.INT_STORE, 0), le16(1)),
+		Want: []Expectation{wantInt(0, 0), wantExitCode(0)},
+	},
+	{
+		Opcode: opcode.EXIT_IMM, Name: "EXIT_IMM stops the execution with an exit code",
+		Code: program(ins(opcode.EXIT_IMM, 3), ins(opcode.INT_STORE, 0), le16(1)),
+		Want: []Expectation{wantInt(0, 0), wantExitCode(3)},
+	},
+	{
+		Opcode: opcode.EXIT_REG, Name: "EXIT_REG stops the execution with the exit code in a register",
+		Code: program(ins(opcode.INT_STORE, 1), le16(42), ins(opcode.EXIT_REG, 1), ins(opcode.INT_STORE, 0), le16(1)),
+		Want: []Expectation{wantInt(0, 0), wantExitCode(42)},
+	},
+	{
+		Opcode: opcode.EXIT_REG, Name: "EXIT_REG rejects an exit code beyond a byte",
+		Code: program(ins(opcode.INT_STORE, 1), le16(256), ins(opcode.EXIT_REG, 1)),
+		Err:  "exit code 256 is out of range, it must be between 0 and 255",
+	},
+	{
+		Opcode: opcode.INT_STORE, Name: "INT_STORE stores a two byte integer",
+		Code: program(ins(opcode.INT_STORE, 0), le16(0x1234)),
+		Want: []Expectation{wantInt(0, 0x1234)},
+	},
+	{
+		Opcode: opcode.INT_STORE, Name: "INT_STORE reads two's complement in signed mode",
+		Code:  program(ins(opcode.INT_STORE, 0), le16(0xfffe)),
+		Setup: func(c *CPU) { c.SetSigned(true) },
+		Want:  []Expectation{wantInt(0, -2)},
+	},
+	{
+		Opcode: opcode.INT_PRINT, Name: "INT_PRINT prints the integer in hex",
+		Code: program(ins(opcode.INT_STORE, 0), le16(42), ins(opcode.INT_PRINT, 0)),
+		Want: []Expectation{wantOut("2a")},
+	},
+	{
+		Opcode: opcode.INT_TO_STR, Name: "INT_TO_STR converts to a decimal string",
+		Code: program(ins(opcode.INT_STORE, 0), le16(42), ins(opcode.INT_TO_STR, 0)),
+		Want: []Expectation{wantStr(0, "42")},
+	},
+	{
+		Opcode: opcode.INT_RAND, Name: "INT_RAND stores an integer",
+		Code: program(ins(opcode.STR_STORE, 0), lstr("x"), ins(opcode.INT_RAND, 0), ins(opcode.IS_INT, 0)),
+		Want: []Expectation{wantZ(true)},
+	},
+	{
+		Opcode: opcode.RAND_RANGE, Name: "RAND_RANGE stores an integer below the bound",
+		Code: program(ins(opcode.INT_STORE, 1), le16(1), ins(opcode.INT_STORE, 0), le16(7), ins(opcode.RAND_RANGE, 0, 1)),
+		Want: []Expectation{wantInt(0, 0)},
+	},
+	{
+		Opcode: opcode.RAND_RANGE, Name: "RAND_RANGE rejects a bound of zero",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0), ins(opcode.RAND_RANGE, 0, 1)),
+		Err:  "random number below 0 requested, the bound must be positive",
+	},
+	{
+		Opcode: opcode.TIME, Name: "TIME stores the Unix time, wrapped around to a word",
+		Code:  program(ins(opcode.TIME, 0, TimeUnix)),
+		Setup: func(c *CPU) { c.SetClock(NewFakeClock(time.Unix(0x6591ff00, 0), time.Millisecond)) },
+		Want:  []Expectation{wantInt(0, 0xff00)},
+	},
+	{
+		Opcode: opcode.TIME, Name: "TIME stores the elapsed milliseconds",
+		Code:  program(ins(opcode.TIME, 0, TimeElapsed), ins(opcode.TIME, 0, TimeElapsed)),
+		Setup: func(c *CPU) { c.SetClock(NewFakeClock(time.Unix(0, 0), time.Millisecond)) },
+		Want:  []Expectation{wantInt(0, 2)},
+	},
+	{
+		Opcode: opcode.TIME, Name: "TIME stores the number of executed instructions",
+		Code: program(ins(opcode.NOP), ins(opcode.NOP), ins(opcode.TIME, 0, TimeInstructions)),
+		Want: []Expectation{wantInt(0, 2)},
+	},
+	{
+		Opcode: opcode.TIME, Name: "TIME fails on an unknown clock",
+		Code: program(ins(opcode.TIME, 0, 3)),
+		Err:  "unknown clock: 3",
+	},
+	{
+		Opcode: opcode.READ_INT, Name: "READ_INT reads an integer from a line",
+		Code:  program(ins(opcode.READ_INT, 0)),
+		Setup: func(c *CPU) { c.STDIN = bufio.NewReader(strings.NewReader(" 42\n7\n")) },
+		Want:  []Expectation{wantInt(0, 42), wantZ(false)},
+	},
+	{
+		Opcode: opcode.READ_INT, Name: "READ_INT sets the zero flag on a line without an integer",
+		Code:  program(ins(opcode.INT_STORE, 0), le16(9), ins(opcode.READ_INT, 0)),
+		Setup: func(c *CPU) { c.STDIN = bufio.NewReader(strings.NewReader("nine\n")) },
+		Want:  []Expectation{wantInt(0, 9), wantZ(true)},
+	},
+	{
+		Opcode: opcode.READ_INT, Name: "READ_INT sets the zero flag at the end of the input",
+		Code: program(ins(opcode.READ_INT, 0)),
+		Want: []Expectation{wantInt(0, 0), wantZ(true)},
+	},
+	{
+		Opcode: opcode.JMP, Name: "JMP jumps to the address",
+		Code: program(ins(opcode.JMP), le16(7), ins(opcode.INT_STORE, 0), le16(1)),
+		Want: []Expectation{wantInt(0, 0)},
+	},
+	{
+		Opcode: opcode.JMP_Z, Name: "JMP_Z jumps if the zero flag is set",
+		Code: program(ins(opcode.CMP_INT, 0), le16(0), ins(opcode.JMP_Z), le16(11), ins(opcode.INT_STORE, 1), le16(1)),
+		Want: []Expectation{wantInt(1, 0)},
+	},
+	{
+		Opcode: opcode.JMP_NZ, Name: "JMP_NZ jumps if the zero flag is clear",
+		Code: program(ins(opcode.CMP_INT, 0), le16(0), ins(opcode.JMP_NZ), le16(11), ins(opcode.INT_STORE, 1), le16(1)),
+		Want: []Expectation{wantInt(1, 1)},
+	},
+	{
+		Opcode: opcode.JMP_S, Name: "JMP_S jumps if the sign flag is set",
+		Code: program(ins(opcode.CMP_INT, 0), le16(1), ins(opcode.JMP_S), le16(11), ins(opcode.INT_STORE, 1), le16(1)),
+		Want: []Expectation{wantInt(1, 0), wantS(true)},
+	},
+	{
+		Opcode: opcode.JMP_NS, Name: "JMP_NS doesn't jump if the sign flag is set",
+		Code: program(ins(opcode.CMP_INT, 0), le16(1), ins(opcode.JMP_NS), le16(11), ins(opcode.INT_STORE, 1), le16(1)),
+		Want: []Expectation{wantInt(1, 1)},
+	},
+	{
+		Opcode: opcode.ADD, Name: "ADD adds two registers",
+		Code: program(ins(opcode.INT_STORE, 1), le16(3), ins(opcode.INT_STORE, 2), le16(4), ins(opcode.ADD, 0, 1, 2)),
+		Want: []Expectation{wantInt(0, 7)},
+	},
+	{
+		Opcode: opcode.ADD, Name: "ADD sets the carry flag if the sum doesn't fit in the word",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0xffff), ins(opcode.INT_STORE, 2), le16(1), ins(opcode.ADD, 0, 1, 2)),
+		Want: []Expectation{wantInt(0, 0xffff), wantC(true), wantV(false)},
+	},
+	{
+		Opcode: opcode.ADD, Name: "ADD sets the overflow flag if the signed sum doesn't fit in the word",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0x7fff), ins(opcode.INT_STORE, 2), le16(1), ins(opcode.ADD, 0, 1, 2)),
+		Want: []Expectation{wantInt(0, 0x8000), wantC(false), wantV(true)},
+	},
+	{
+		Opcode: opcode.SUB, Name: "SUB clamps at zero and sets the zero flag",
+		Code: program(ins(opcode.INT_STORE, 1), le16(3), ins(opcode.INT_STORE, 2), le16(4), ins(opcode.SUB, 0, 1, 2)),
+		Want: []Expectation{wantInt(0, 0), wantZ(true), wantC(true)},
+	},
+	{
+		Opcode: opcode.ADC, Name: "ADC adds the carry and wraps around",
+		Code:  program(ins(opcode.INT_STORE, 1), le16(0xffff), ins(opcode.INT_STORE, 2), le16(1), ins(opcode.ADC, 0, 1, 2)),
+		Setup: func(c *CPU) { c.SetCarry(true) },
+		Want:  []Expectation{wantInt(0, 1), wantC(true)},
+	},
+	{
+		Opcode: opcode.SBC, Name: "SBC subtracts the carry and wraps around",
+		Code:  program(ins(opcode.INT_STORE, 1), le16(2), ins(opcode.INT_STORE, 2), le16(2), ins(opcode.SBC, 0, 1, 2)),
+		Setup: func(c *CPU) { c.SetCarry(true) },
+		Want:  []Expectation{wantInt(0, 0xffff), wantC(true)},
+	},
+	{
+		Opcode: opcode.SUB, Name: "SUB goes negative and sets the sign flag in signed mode",
+		Code:  program(ins(opcode.INT_STORE, 1), le16(3), ins(opcode.INT_STORE, 2), le16(4), ins(opcode.SUB, 0, 1, 2)),
+		Setup: func(c *CPU) { c.SetSigned(true) },
+		Want:  []Expectation{wantInt(0, -1), wantS(true)},
+	},
+	{
+		Opcode: opcode.MUL, Name: "MUL multiplies two registers",
+		Code: program(ins(opcode.INT_STORE, 1), le16(3), ins(opcode.INT_STORE, 2), le16(4), ins(opcode.MUL, 0, 1, 2)),
+		Want: []Expectation{wantInt(0, 12)},
+	},
+	{
+		Opcode: opcode.DIV, Name: "DIV divides two registers, rounding down",
+		Code: program(ins(opcode.INT_STORE, 1), le16(13), ins(opcode.INT_STORE, 2), le16(4), ins(opcode.DIV, 0, 1, 2)),
+		Want: []Expectation{wantInt(0, 3)},
+	},
+	{
+		Opcode: opcode.DIV, Name: "DIV fails on division by zero",
+		Code: program(ins(opcode.DIV, 0, 1, 2)),
+		Err:  "devision by zero",
+	},
+	{
+		Opcode: opcode.INC, Name: "INC wraps around at the largest word",
+		Code: program(ins(opcode.INT_STORE, 0), le16(0xffff), ins(opcode.INC, 0)),
+		Want: []Expectation{wantInt(0, 0), wantZ(true)},
+	},
+	{
+		Opcode: opcode.DEC, Name: "DEC wraps around at zero",
+		Code: program(ins(opcode.DEC, 0)),
+		Want: []Expectation{wantInt(0, 0xffff), wantZ(false)},
+	},
+	{
+		Opcode: opcode.AND, Name: "AND is the bitwise and",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0b1100), ins(opcode.INT_STORE, 2), le16(0b1010), ins(opcode.AND, 0, 1, 2)),
+		Want: []Expectation{wantInt(0, 0b1000)},
+	},
+	{
+		Opcode: opcode.OR, Name: "OR is the bitwise or",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0b1100), ins(opcode.INT_STORE, 2), le16(0b1010), ins(opcode.OR, 0, 1, 2)),
+		Want: []Expectation{wantInt(0, 0b1110)},
+	},
+	{
+		Opcode: opcode.XOR, Name: "XOR is the bitwise exclusive or",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0b1100), ins(opcode.INT_STORE, 2), le16(0b1010), ins(opcode.XOR, 0, 1, 2)),
+		Want: []Expectation{wantInt(0, 0b0110)},
+	},
+	{
+		Opcode: opcode.MOD, Name: "MOD stores the remainder of a division",
+		Code: program(ins(opcode.INT_STORE, 1), le16(13), ins(opcode.INT_STORE, 2), le16(4), ins(opcode.MOD, 0, 1, 2)),
+		Want: []Expectation{wantInt(0, 1)},
+	},
+	{
+		Opcode: opcode.MOD, Name: "MOD fails on division by zero",
+		Code: program(ins(opcode.MOD, 0, 1, 2)),
+		Err:  "devision by zero",
+	},
+	{
+		Opcode: opcode.MULH, Name: "MULH stores the high word of a product",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0x1234), ins(opcode.INT_STORE, 2), le16(0x100), ins(opcode.MULH, 0, 1, 2)),
+		Want: []Expectation{wantInt(0, 0x12)},
+	},
+	{
+		Opcode: opcode.MULH, Name: "MULH sign-extends the product in signed mode",
+		Code:  program(ins(opcode.INT_STORE, 1), le16(0xfffe), ins(opcode.INT_STORE, 2), le16(0x4000), ins(opcode.MULH, 0, 1, 2)),
+		Setup: func(c *CPU) { c.SetSigned(true) },
+		Want:  []Expectation{wantInt(0, -1)},
+	},
+	{
+		Opcode: opcode.DIVMOD, Name: "DIVMOD stores the quotient and the remainder",
+		Code: program(ins(opcode.INT_STORE, 2), le16(13), ins(opcode.INT_STORE, 3), le16(4), ins(opcode.DIVMOD, 0, 1, 2, 3)),
+		Want: []Expectation{wantInt(0, 3), wantInt(1, 1)},
+	},
+	{
+		Opcode: opcode.DIVMOD, Name: "DIVMOD fails on division by zero",
+		Code: program(ins(opcode.DIVMOD, 0, 1, 2, 3)),
+		Err:  "devision by zero",
+	},
+	{
+		Opcode: opcode.ADD_PAIR, Name: "ADD_PAIR adds double words",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0x0001), ins(opcode.INT_STORE, 2), le16(0xffff),
+			ins(opcode.INT_STORE, 4), le16(1), ins(opcode.ADD_PAIR, 5, 1, 3)),
+		Want: []Expectation{wantInt(5, 2), wantInt(6, 0), wantC(false), wantZ(false)},
+	},
+	{
+		Opcode: opcode.ADD_PAIR, Name: "ADD_PAIR sets the carry flag if the sum doesn't fit",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0xffff), ins(opcode.INT_STORE, 2), le16(0xffff),
+			ins(opcode.INT_STORE, 4), le16(1), ins(opcode.ADD_PAIR, 5, 1, 3)),
+		Want: []Expectation{wantInt(5, 0), wantInt(6, 0), wantC(true), wantZ(true)},
+	},
+	{
+		Opcode: opcode.SUB_PAIR, Name: "SUB_PAIR subtracts double words, borrowing from the upper word",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0x0002), ins(opcode.INT_STORE, 4), le16(1), ins(opcode.SUB_PAIR, 5, 1, 3)),
+		Want: []Expectation{wantInt(5, 1), wantInt(6, 0xffff), wantC(false)},
+	},
+	{
+		Opcode: opcode.SUB_PAIR, Name: "SUB_PAIR sets the carry flag on a borrow",
+		Code: program(ins(opcode.INT_STORE, 4), le16(1), ins(opcode.SUB_PAIR, 5, 1, 3)),
+		Want: []Expectation{wantInt(5, 0xffff), wantInt(6, 0xffff), wantC(true)},
+	},
+	{
+		Opcode: opcode.MUL_PAIR, Name: "MUL_PAIR multiplies double words",
+		Code: program(ins(opcode.INT_STORE, 2), le16(0x1234), ins(opcode.INT_STORE, 4), le16(0x100), ins(opcode.MUL_PAIR, 5, 1, 3)),
+		Want: []Expectation{wantInt(5, 0x12), wantInt(6, 0x3400), wantC(false)},
+	},
+	{
+		Opcode: opcode.DIV_PAIR, Name: "DIV_PAIR divides double words",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0x0002), ins(opcode.INT_STORE, 4), le16(4), ins(opcode.DIV_PAIR, 5, 1, 3)),
+		Want: []Expectation{wantInt(5, 0), wantInt(6, 0x8000)},
+	},
+	{
+		Opcode: opcode.DIV_PAIR, Name: "DIV_PAIR fails on division by zero",
+		Code: program(ins(opcode.DIV_PAIR, 5, 1, 3)),
+		Err:  "devision by zero",
+	},
+
+	// hashes
+	{
+		Opcode: opcode.HASH_NEW, Name: "HASH_NEW stores an empty hash",
+		Code: program(ins(opcode.HASH_NEW, 0), ins(opcode.HASH_KEYS, 1, 0)),
+		Want: []Expectation{wantStr(1, ""), wantZ(true)},
+	},
+	{
+		Opcode: opcode.HASH_SET, Name: "HASH_SET stores a value under a key",
+		Code: program(ins(opcode.HASH_NEW, 0), ins(opcode.STR_STORE, 1), lstr("k"), ins(opcode.INT_STORE, 2), le16(7),
+			ins(opcode.HASH_SET, 0, 1, 2), ins(opcode.HASH_GET, 3, 0, 1)),
+		Want: []Expectation{wantInt(3, 7), wantZ(false)},
+	},
+	{
+		Opcode: opcode.HASH_SET, Name: "HASH_SET fails on a register without a hash",
+		Code: program(ins(opcode.STR_STORE, 1), lstr("k"), ins(opcode.HASH_SET, 0, 1, 1)),
+		Err:  "non-hash value",
+	},
+	{
+		Opcode: opcode.HASH_GET, Name: "HASH_GET sets the zero flag for a missing key",
+		Code: program(ins(opcode.HASH_NEW, 0), ins(opcode.STR_STORE, 1), lstr("k"), ins(opcode.HASH_GET, 3, 0, 1)),
+		Want: []Expectation{wantInt(3, 0), wantZ(true)},
+	},
+	{
+		Opcode: opcode.HASH_GET, Name: "HASH_GET sees changes through a copy of the hash",
+		Code: program(ins(opcode.HASH_NEW, 0), ins(opcode.REG_STORE, 1, 0), ins(opcode.STR_STORE, 2), lstr("k"),
+			ins(opcode.HASH_SET, 1, 2, 2), ins(opcode.HASH_GET, 3, 0, 2)),
+		Want: []Expectation{wantStr(3, "k")},
+	},
+	{
+		Opcode: opcode.HASH_DEL, Name: "HASH_DEL removes a key",
+		Code: program(ins(opcode.HASH_NEW, 0), ins(opcode.STR_STORE, 1), lstr("k"), ins(opcode.HASH_SET, 0, 1, 1),
+			ins(opcode.HASH_DEL, 0, 1), ins(opcode.HASH_GET, 3, 0, 1)),
+		Want: []Expectation{wantZ(true)},
+	},
+	{
+		Opcode: opcode.HASH_KEYS, Name: "HASH_KEYS lists the keys in sorted order",
+		Code: program(ins(opcode.HASH_NEW, 0), ins(opcode.STR_STORE, 1), lstr("b"), ins(opcode.HASH_SET, 0, 1, 1),
+			ins(opcode.STR_STORE, 1), lstr("a"), ins(opcode.HASH_SET, 0, 1, 1), ins(opcode.HASH_KEYS, 2, 0)),
+		Want: []Expectation{wantStr(2, "a\nb\n"), wantZ(false)},
+	},
+	{
+		Opcode: opcode.ALLOC, Name: "ALLOC allocates consecutive blocks",
+		Code: program(ins(opcode.INT_STORE, 1), le16(16), ins(opcode.ALLOC, 2, 1), ins(opcode.ALLOC, 3, 1)),
+		Want: []Expectation{wantInt(2, HeapStart), wantInt(3, HeapStart+16)},
+	},
+	{
+		Opcode: opcode.ALLOC, Name: "ALLOC reuses freed blocks",
+		Code: program(ins(opcode.INT_STORE, 1), le16(16), ins(opcode.ALLOC, 2, 1), ins(opcode.FREE, 2),
+			ins(opcode.ALLOC, 3, 1)),
+		Want: []Expectation{wantInt(3, HeapStart)},
+	},
+	{
+		Opcode: opcode.ALLOC, Name: "ALLOC fails when the heap is exhausted",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0x8000), ins(opcode.ALLOC, 2, 1)),
+		Err:  "out of memory",
+	},
+	{
+		Opcode: opcode.FREE, Name: "FREE fails on an address which isn't allocated",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0x9000), ins(opcode.FREE, 1)),
+		Err:  "isn't allocated",
+	},
+	{
+		Opcode: opcode.SHL, Name: "SHL shifts left, discarding the bits shifted out",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0x1234), ins(opcode.INT_STORE, 2), le16(8), ins(opcode.SHL, 0, 1, 2)),
+		Want: []Expectation{wantInt(0, 0x3400)},
+	},
+	{
+		Opcode: opcode.SHL, Name: "SHL fails on a negative count",
+		Code:  program(ins(opcode.INT_STORE, 2), le16(0xffff), ins(opcode.SHL, 0, 1, 2)),
+		Setup: func(c *CPU) { c.SetSigned(true) },
+		Err:   "negative shift count: -1",
+	},
+	{
+		Opcode: opcode.SHR, Name: "SHR shifts right",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0x1234), ins(opcode.INT_STORE, 2), le16(8), ins(opcode.SHR, 0, 1, 2)),
+		Want: []Expectation{wantInt(0, 0x12)},
+	},
+	{
+		Opcode: opcode.SHL_IMM, Name: "SHL_IMM shifts left by a constant",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0x12), ins(opcode.SHL_IMM, 0, 1, 4)),
+		Want: []Expectation{wantInt(0, 0x120)},
+	},
+	{
+		Opcode: opcode.SHR_IMM, Name: "SHR_IMM shifts right by a constant",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0x1234), ins(opcode.SHR_IMM, 0, 1, 4)),
+		Want: []Expectation{wantInt(0, 0x123)},
+	},
+	{
+		Opcode: opcode.ADD_IMM, Name: "ADD_IMM adds a constant",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0x1234), ins(opcode.ADD_IMM, 0, 1), le16(0x100)),
+		Want: []Expectation{wantInt(0, 0x1334)},
+	},
+	{
+		Opcode: opcode.SUB_IMM, Name: "SUB_IMM subtracts a constant, setting Z at zero",
+		Code: program(ins(opcode.INT_STORE, 1), le16(5), ins(opcode.SUB_IMM, 0, 1), le16(5)),
+		Want: []Expectation{wantInt(0, 0), wantZ(true)},
+	},
+	{
+		Opcode: opcode.MUL_IMM, Name: "MUL_IMM multiplies by a constant",
+		Code: program(ins(opcode.INT_STORE, 1), le16(6), ins(opcode.MUL_IMM, 0, 1), le16(7)),
+		Want: []Expectation{wantInt(0, 42)},
+	},
+	{
+		Opcode: opcode.DIV_IMM, Name: "DIV_IMM divides by a constant",
+		Code: program(ins(opcode.INT_STORE, 1), le16(42), ins(opcode.DIV_IMM, 0, 1), le16(5)),
+		Want: []Expectation{wantInt(0, 8)},
+	},
+	{
+		Opcode: opcode.DIV_IMM, Name: "DIV_IMM fails on zero",
+		Code: program(ins(opcode.INT_STORE, 1), le16(42), ins(opcode.DIV_IMM, 0, 1), le16(0)),
+		Err:  "devision by zero",
+	},
+	{
+		Opcode: opcode.MOD_IMM, Name: "MOD_IMM stores the remainder",
+		Code: program(ins(opcode.INT_STORE, 1), le16(42), ins(opcode.MOD_IMM, 0, 1), le16(5)),
+		Want: []Expectation{wantInt(0, 2)},
+	},
+	{
+		Opcode: opcode.AND_IMM, Name: "AND_IMM masks with a constant",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0x1234), ins(opcode.AND_IMM, 0, 1), le16(0xff)),
+		Want: []Expectation{wantInt(0, 0x34)},
+	},
+	{
+		Opcode: opcode.OR_IMM, Name: "OR_IMM sets the bits of a constant",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0x1200), ins(opcode.OR_IMM, 0, 1), le16(0x34)),
+		Want: []Expectation{wantInt(0, 0x1234)},
+	},
+	{
+		Opcode: opcode.XOR_IMM, Name: "XOR_IMM flips the bits of a constant",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0xff), ins(opcode.XOR_IMM, 0, 1), le16(0x0f)),
+		Want: []Expectation{wantInt(0, 0xf0)},
+	},
+	{
+		Opcode: opcode.STR_STORE, Name: "STR_STORE stores an inline string",
+		Code: program(ins(opcode.STR_STORE, 0), lstr("hello")),
+		Want: []Expectation{wantStr(0, "hello")},
+	},
+	{
+		Opcode: opcode.PRINT_ERR, Name: "PRINT_ERR prints the string to STDERR only",
+		Code: program(ins(opcode.STR_STORE, 0), lstr("oops"), ins(opcode.PRINT_ERR, 0)),
+		Want: []Expectation{wantOut("")},
+	},
+	{
+		Opcode: opcode.PRINT_CHAR, Name: "PRINT_CHAR prints the character with the code",
+		Code: program(ins(opcode.INT_STORE, 0), le16('A'), ins(opcode.PRINT_CHAR, 0), ins(opcode.INT_STORE, 0), le16(0x2500), ins(opcode.PRINT_CHAR, 0)),
+		Want: []Expectation{wantOut("A\u2500")},
+	},
+	{
+		Opcode: opcode.PRINT_CHAR, Name: "PRINT_CHAR fails on a surrogate",
+		Code: program(ins(opcode.INT_STORE, 0), le16(0xd800), ins(opcode.PRINT_CHAR, 0)),
+		Err:  "print_char of 55296 is not a valid character code",
+	},
+	{
+		Opcode: opcode.STR_PRINT, Name: "STR_PRINT prints the string",
+		Code: program(ins(opcode.STR_STORE, 0), lstr("hello"), ins(opcode.STR_PRINT, 0)),
+		Want: []Expectation{wantOut("hello")},
+	},
+	{
+		Opcode: opcode.CONCAT, Name: "CONCAT joins two strings",
+		Code: program(ins(opcode.STR_STORE, 1), lstr("foo"), ins(opcode.STR_STORE, 2), lstr("bar"), ins(opcode.CONCAT, 0, 1, 2)),
+		Want: []Expectation{wantStr(0, "foobar")},
+	},
+	{
+		Opcode: opcode.CONCAT, Name: "CONCAT fails beyond the memory limit",
+		Code:  program(ins(opcode.STR_STORE, 1), lstr("foo"), ins(opcode.STR_STORE, 2), lstr("bar"), ins(opcode.CONCAT, 0, 1, 2)),
+		Setup: func(c *CPU) { c.SetMemoryLimit(2*objectOverhead + 6) },
+		Err:   "memory limit exceeded",
+	},
+	{
+		Opcode: opcode.SYSTEM, Name: "SYSTEM runs a command, reported in dry-run mode",
+		Code:  program(ins(opcode.STR_STORE, 0), lstr("true"), ins(opcode.SYSTEM, 0)),
+		Setup: func(c *CPU) { c.SetDryRun(true) },
+		Want:  []Expectation{wantOutContains("would execute")},
+	},
+	{
+		Opcode: opcode.STR_TO_INT, Name: "STR_TO_INT parses a decimal string",
+		Code: program(ins(opcode.STR_STORE, 0), lstr("42"), ins(opcode.STR_TO_INT, 0)),
+		Want: []Expectation{wantInt(0, 42)},
+	},
+	{
+		Opcode: opcode.CMP_INT, Name: "CMP_INT sets the zero flag on equality",
+		Code: program(ins(opcode.INT_STORE, 0), le16(42), ins(opcode.CMP_INT, 0), le16(42)),
+		Want: []Expectation{wantZ(true)},
+	},
+	{
+		Opcode: opcode.CMP_STR, Name: "CMP_STR sets the zero flag on equality",
+		Code: program(ins(opcode.STR_STORE, 0), lstr("a"), ins(opcode.CMP_STR, 0), lstr("a")),
+		Want: []Expectation{wantZ(true)},
+	},
+	{
+		Opcode: opcode.CMP_REG, Name: "CMP_REG clears the zero flag on inequality",
+		Code: program(ins(opcode.INT_STORE, 0), le16(1), ins(opcode.CMP_REG, 0, 1)),
+		Want: []Expectation{wantZ(false)},
+	},
+	{
+		Opcode: opcode.IS_INT, Name: "IS_INT sets the zero flag for integers",
+		Code: program(ins(opcode.IS_INT, 0)),
+		Want: []Expectation{wantZ(true)},
+	},
+	{
+		Opcode: opcode.IS_STR, Name: "IS_STR clears the zero flag for integers",
+		Code: program(ins(opcode.IS_STR, 0)),
+		Want: []Expectation{wantZ(false)},
+	},
+	{
+		Opcode: opcode.ORD, Name: "ORD stores the code of the first character",
+		Code: program(ins(opcode.STR_STORE, 1), lstr("Az"), ins(opcode.ORD, 0, 1)),
+		Want: []Expectation{wantInt(0, 'A'), wantStr(1, "Az")},
+	},
+	{
+		Opcode: opcode.ORD, Name: "ORD fails on an empty string",
+		Code: program(ins(opcode.STR_STORE, 1), lstr(""), ins(opcode.ORD, 0, 1)),
+		Err:  "ord of an empty string",
+	},
+	{
+		Opcode: opcode.CHR, Name: "CHR stores a single-character string",
+		Code: program(ins(opcode.INT_STORE, 1), le16('a'), ins(opcode.CHR, 0, 1)),
+		Want: []Expectation{wantStr(0, "a"), wantInt(1, 'a')},
+	},
+	{
+		Opcode: opcode.CHR, Name: "CHR fails above a byte",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0x100), ins(opcode.CHR, 0, 1)),
+		Err:  "chr of 256 is out of range, it must be a byte",
+	},
+	{
+		Opcode: opcode.SPLIT_COUNT, Name: "SPLIT_COUNT counts the parts, empty ones included",
+		Code: program(ins(opcode.STR_STORE, 1), lstr("a,b,,c"), ins(opcode.STR_STORE, 2), lstr(","),
+			ins(opcode.SPLIT_COUNT, 0, 1, 2)),
+		Want: []Expectation{wantInt(0, 4)},
+	},
+	{
+		Opcode: opcode.SPLIT_COUNT, Name: "SPLIT_COUNT fails on an empty delimiter",
+		Code: program(ins(opcode.STR_STORE, 1), lstr("a"), ins(opcode.STR_STORE, 2), lstr(""),
+			ins(opcode.SPLIT_COUNT, 0, 1, 2)),
+		Err: "empty delimiter",
+	},
+	{
+		Opcode: opcode.SPLIT_PART, Name: "SPLIT_PART stores the nth part and clears Z",
+		Code: program(ins(opcode.STR_STORE, 1), lstr("one two three"), ins(opcode.STR_STORE, 2), lstr(" "),
+			ins(opcode.INT_STORE, 3), le16(1), ins(opcode.SPLIT_PART, 0, 1, 2, 3)),
+		Want: []Expectation{wantStr(0, "two"), wantZ(false)},
+	},
+	{
+		Opcode: opcode.SPLIT_PART, Name: "SPLIT_PART sets Z past the last part",
+		Code: program(ins(opcode.INT_STORE, 0), le16(7), ins(opcode.STR_STORE, 1), lstr("one two"),
+			ins(opcode.STR_STORE, 2), lstr(" "), ins(opcode.INT_STORE, 3), le16(2), ins(opcode.SPLIT_PART, 0, 1, 2, 3)),
+		Want: []Expectation{wantInt(0, 7), wantZ(true)},
+	},
+	{
+		Opcode: opcode.FLOAT_STORE, Name: "FLOAT_STORE stores a float",
+		Code: program(ins(opcode.FLOAT_STORE, 0), f64(-2.5)),
+		Want: []Expectation{wantFloat(0, -2.5)},
+	},
+	{
+		Opcode: opcode.FLOAT_PRINT, Name: "FLOAT_PRINT prints the shortest exact form",
+		Code: program(ins(opcode.FLOAT_STORE, 0), f64(0.1), ins(opcode.FLOAT_PRINT, 0)),
+		Want: []Expectation{wantOut("0.1")},
+	},
+	{
+		Opcode: opcode.FADD, Name: "FADD adds floats",
+		Code: program(ins(opcode.FLOAT_STORE, 1), f64(1.5), ins(opcode.FLOAT_STORE, 2), f64(2.25), ins(opcode.FADD, 0, 1, 2)),
+		Want: []Expectation{wantFloat(0, 3.75), wantZ(false), wantS(false)},
+	},
+	{
+		Opcode: opcode.FSUB, Name: "FSUB sets S for a negative result",
+		Code: program(ins(opcode.FLOAT_STORE, 1), f64(1.5), ins(opcode.FLOAT_STORE, 2), f64(2.25), ins(opcode.FSUB, 0, 1, 2)),
+		Want: []Expectation{wantFloat(0, -0.75), wantS(true)},
+	},
+	{
+		Opcode: opcode.FMUL, Name: "FMUL multiplies floats, setting Z at zero",
+		Code: program(ins(opcode.FLOAT_STORE, 1), f64(1.5), ins(opcode.FLOAT_STORE, 2), f64(0), ins(opcode.FMUL, 0, 1, 2)),
+		Want: []Expectation{wantFloat(0, 0), wantZ(true)},
+	},
+	{
+		Opcode: opcode.FDIV, Name: "FDIV divides floats",
+		Code: program(ins(opcode.FLOAT_STORE, 1), f64(1), ins(opcode.FLOAT_STORE, 2), f64(8), ins(opcode.FDIV, 0, 1, 2)),
+		Want: []Expectation{wantFloat(0, 0.125)},
+	},
+	{
+		Opcode: opcode.FDIV, Name: "FDIV fails on zero",
+		Code: program(ins(opcode.FLOAT_STORE, 1), f64(1), ins(opcode.FLOAT_STORE, 2), f64(0), ins(opcode.FDIV, 0, 1, 2)),
+		Err:  "devision by zero",
+	},
+	{
+		Opcode: opcode.IS_FLOAT, Name: "IS_FLOAT sets the zero flag for floats",
+		Code: program(ins(opcode.FLOAT_STORE, 0), f64(1), ins(opcode.IS_FLOAT, 0)),
+		Want: []Expectation{wantZ(true)},
+	},
+	{
+		Opcode: opcode.INT_TO_FLOAT, Name: "INT_TO_FLOAT converts an integer",
+		Code: program(ins(opcode.INT_STORE, 0), le16(42), ins(opcode.INT_TO_FLOAT, 0)),
+		Want: []Expectation{wantFloat(0, 42)},
+	},
+	{
+		Opcode: opcode.FLOAT_TO_INT, Name: "FLOAT_TO_INT truncates towards zero",
+		Code: program(ins(opcode.FLOAT_STORE, 0), f64(42.9), ins(opcode.FLOAT_TO_INT, 0)),
+		Want: []Expectation{wantInt(0, 42)},
+	},
+	{
+		Opcode: opcode.FLOAT_TO_INT, Name: "FLOAT_TO_INT clamps to the word",
+		Code: program(ins(opcode.FLOAT_STORE, 0), f64(1e9), ins(opcode.FLOAT_TO_INT, 0)),
+		Want: []Expectation{wantInt(0, 0xffff)},
+	},
+	{
+		Opcode: opcode.NOP, Name: "NOP does nothing",
+		Code: program(ins(opcode.NOP), ins(opcode.INT_STORE, 0), le16(1)),
+		Want: []Expectation{wantInt(0, 1)},
+	},
+	{
+		Opcode: opcode.REG_STORE, Name: "REG_STORE copies a register",
+		Code: program(ins(opcode.STR_STORE, 1), lstr("a"), ins(opcode.REG_STORE, 0, 1)),
+		Want: []Expectation{wantStr(0, "a")},
+	},
+	{
+		Opcode: opcode.CMOV_Z, Name: "CMOV_Z copies a register if Z is set",
+		Code: program(ins(opcode.INT_STORE, 1), le16(7), ins(opcode.CMP_INT, 0), le16(0), ins(opcode.CMOV_Z, 0, 1)),
+		Want: []Expectation{wantInt(0, 7)},
+	},
+	{
+		Opcode: opcode.CMOV_Z, Name: "CMOV_Z keeps the register if Z is clear",
+		Code: program(ins(opcode.INT_STORE, 1), le16(7), ins(opcode.CMP_INT, 0), le16(1), ins(opcode.CMOV_Z, 0, 1)),
+		Want: []Expectation{wantInt(0, 0)},
+	},
+	{
+		Opcode: opcode.CMOV_NZ, Name: "CMOV_NZ copies a register if Z is clear",
+		Code: program(ins(opcode.STR_STORE, 1), lstr("a"), ins(opcode.CMP_INT, 0), le16(1), ins(opcode.CMOV_NZ, 0, 1)),
+		Want: []Expectation{wantStr(0, "a")},
+	},
+	{
+		Opcode: opcode.CMOV_NZ, Name: "CMOV_NZ keeps the register if Z is set",
+		Code: program(ins(opcode.INT_STORE, 1), le16(7), ins(opcode.CMP_INT, 0), le16(0), ins(opcode.CMOV_NZ, 0, 1)),
+		Want: []Expectation{wantInt(0, 0)},
+	},
+	{
+		Opcode: opcode.ABORT, Name: "ABORT fails with the message",
+		Code: program(ins(opcode.STR_STORE, 0), lstr("oops"), ins(opcode.ABORT, 0)),
+		Err:  "abort: oops",
+	},
+	{
+		Opcode: opcode.STR_POOL, Name: "STR_POOL stores a string from the pool",
+		Code:  program(ins(opcode.STR_POOL, 0), le16(3)),
+		Setup: func(c *CPU) { c.SetStringPool(program(lstr("a"), lstr("hello"))) },
+		Want:  []Expectation{wantStr(0, "hello")},
+	},
+	{
+		Opcode: opcode.DUMP, Name: "DUMP prints the CPU state",
+		Code: program(ins(opcode.INT_STORE, 1), le16(42), ins(opcode.DUMP)),
+		Want: []Expectation{wantOutContains("#1  int 0x002a"), wantOutContains("ip: 0004")},
+	},
+	{
+		Opcode: opcode.PEEK, Name: "PEEK reads a byte of memory",
+		Code: program(ins(opcode.INT_STORE, 1), le16(1), ins(opcode.PEEK, 0, 1)),
+		Want: []Expectation{wantInt(0, 1)},
+	},
+	{
+		Opcode: opcode.POKE, Name: "POKE writes a byte of memory",
+		Code: program(ins(opcode.INT_STORE, 0), le16(0xab), ins(opcode.INT_STORE, 1), le16(0x100), ins(opcode.POKE, 0, 1)),
+		Want: []Expectation{wantMem(0x100, 0xab)},
+	},
+	{
+		Opcode: opcode.PEEK16, Name: "PEEK16 reads a little-endian word of memory",
+		// the bytes at 2 and 3 are the immediate of the first INT_STORE
+		Code: program(ins(opcode.INT_STORE, 2), le16(0x1234), ins(opcode.INT_STORE, 1), le16(2), ins(opcode.PEEK16, 0, 1)),
+		Want: []Expectation{wantInt(0, 0x1234)},
+	},
+	{
+		Opcode: opcode.PEEK16, Name: "PEEK16 fails on the last byte of memory",
+		Code: program(ins(opcode.INT_STORE, 1), le16(MemSize-1), ins(opcode.PEEK16, 0, 1)),
+		Err:  "out of range",
+	},
+	{
+		Opcode: opcode.PEEK16, Name: "PEEK16 fails with 8-bit words rather than dropping the high byte",
+		Code:  program(ins(opcode.INT_STORE, 1), []byte{0}, ins(opcode.PEEK16, 0, 1)),
+		Setup: func(c *CPU) { c.SetWordSize(8) },
+		Err:   "PEEK16 needs words of at least 16 bits",
+	},
+	{
+		Opcode: opcode.POKE16, Name: "POKE16 writes a little-endian word of memory",
+		Code: program(ins(opcode.INT_STORE, 0), le16(0xabcd), ins(opcode.INT_STORE, 1), le16(0x100), ins(opcode.POKE16, 0, 1)),
+		Want: []Expectation{wantMem(0x100, 0xcd), wantMem(0x101, 0xab)},
+	},
+	{
+		Opcode: opcode.POKE_STR, Name: "POKE_STR writes a NUL-terminated string",
+		Code:  program(ins(opcode.STR_STORE, 0), lstr("hi"), ins(opcode.INT_STORE, 1), le16(0x100), ins(opcode.POKE_STR, 0, 1)),
+		Setup: func(c *CPU) { c.Store(0x102, 0xff) },
+		Want:  []Expectation{wantMem(0x100, 'h'), wantMem(0x101, 'i'), wantMem(0x102, 0)},
+	},
+	{
+		Opcode: opcode.POKE_STR, Name: "POKE_STR fails beyond the end of memory",
+		Code: program(ins(opcode.STR_STORE, 0), lstr("hi"), ins(opcode.INT_STORE, 1), le16(MemSize-2), ins(opcode.POKE_STR, 0, 1)),
+		Err:  "doesn't fit",
+	},
+	{
+		Opcode: opcode.MEM_FIND, Name: "MEM_FIND finds a byte",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0xaa), ins(opcode.INT_STORE, 2), le16(0x100),
+			ins(opcode.INT_STORE, 3), le16(0x10), ins(opcode.MEM_FIND, 0, 1, 2, 3)),
+		Setup: func(c *CPU) { c.Store(0x105, 0xaa); c.Store(0x107, 0xaa) },
+		Want:  []Expectation{wantInt(0, 0x105), wantZ(false)},
+	},
+	{
+		Opcode: opcode.MEM_FIND, Name: "MEM_FIND finds a string within the region",
+		Code: program(ins(opcode.STR_STORE, 1), lstr("ab"), ins(opcode.INT_STORE, 2), le16(0x100),
+			ins(opcode.INT_STORE, 3), le16(0x4), ins(opcode.MEM_FIND, 0, 1, 2, 3)),
+		// the match at 0x103 doesn't fit the region
+		Setup: func(c *CPU) { c.Store(0x103, 'a'); c.Store(0x104, 'b') },
+		Want:  []Expectation{wantInt(0, 0), wantZ(true)},
+	},
+	{
+		Opcode: opcode.MEM_FIND, Name: "MEM_FIND fails on a region beyond the end of memory",
+		Code: program(ins(opcode.INT_STORE, 1), le16(0), ins(opcode.INT_STORE, 2), le16(MemSize-1),
+			ins(opcode.INT_STORE, 3), le16(2), ins(opcode.MEM_FIND, 0, 1, 2, 3)),
+		Err: "out of range",
+	},
+	{
+		Opcode: opcode.BANK_SELECT, Name: "BANK_SELECT swaps the banked window of memory",
+		Code: program(ins(opcode.INT_STORE, 0), le16(2), ins(opcode.BANK_SELECT, 0)),
+		Setup: func(c *CPU) {
+			c.Store(header.BankStart, 0xaa)
+			c.SetBanks(map[int][]byte{2: {0xbb}})
+		},
+		Want: []Expectation{wantMem(header.BankStart, 0xbb), func(c *CPU, _ string) error {
+			if c.Bank() != 2 || c.banks[0][0] != 0xaa {
+				return fmt.Errorf("bank %d is selected, bank 0 starts with %02x", c.Bank(), c.banks[0][0])
+			}
+			return nil
+		}},
+	},
+	{
+		Opcode: opcode.BANK_SELECT, Name: "BANK_SELECT fails on a bank out of range",
+		Code: program(ins(opcode.INT_STORE, 0), le16(header.NumBanks), ins(opcode.BANK_SELECT, 0)),
+		Err:  "bank 16 is out of range",
+	},
+	{
+		Opcode: opcode.PEEK_STR, Name: "PEEK_STR reads a NUL-terminated string",
+		Code:  program(ins(opcode.INT_STORE, 1), le16(0x100), ins(opcode.PEEK_STR, 0, 1)),
+		Setup: func(c *CPU) { c.Store(0x100, 'o'); c.Store(0x101, 'k') },
+		Want:  []Expectation{wantStr(0, "ok")},
+	},
+	{
+		Opcode: opcode.MEM_CPY, Name: "MEM_CPY copies a range of memory",
+		Code: program(
+			ins(opcode.INT_STORE, 0), le16(0x100),
+			ins(opcode.INT_STORE, 1), le16(0),
+			ins(opcode.INT_STORE, 2), le16(2),
+			ins(opcode.MEM_CPY, 0, 1, 2),
+		),
+		// the first two bytes of the program are INT_STORE #0
+		Want: []Expectation{wantMem(0x100, byte(opcode.INT_STORE)), wantMem(0x101, 0)},
+	},
+	{
+		Opcode: opcode.PUSH, Name: "PUSH pushes an integer",
+		Code: program(ins(opcode.INT_STORE, 0), le16(42), ins(opcode.PUSH, 0)),
+		Want: []Expectation{wantStack(42)},
+	},
+	{
+		Opcode: opcode.POP, Name: "POP pops an integer",
+		Code: program(ins(opcode.INT_STORE, 0), le16(42), ins(opcode.PUSH, 0), ins(opcode.POP, 1)),
+		Want: []Expectation{wantInt(1, 42), wantStack()},
+	},
+	{
+		Opcode: opcode.PUSH, Name: "PUSH pushes a string",
+		Code: program(ins(opcode.STR_STORE, 0), lstr("hi"), ins(opcode.PUSH, 0)),
+		Want: []Expectation{wantStackObjects(&StrObject{Value: "hi"})},
+	},
+	{
+		Opcode: opcode.POP, Name: "POP pops a string",
+		Code: program(ins(opcode.STR_STORE, 0), lstr("hi"), ins(opcode.PUSH, 0), ins(opcode.POP, 1)),
+		Want: []Expectation{wantStr(1, "hi"), wantStack()},
+	},
+	{
+		Opcode: opcode.POP, Name: "POP fails on an empty stack",
+		Code: program(ins(opcode.POP, 0)),
+		Err:  "stackunderflow",
+	},
+	{
+		Opcode: opcode.PUSH, Name: "PUSH writes to the stack in RAM",
+		Code:  program(ins(opcode.INT_STORE, 0), le16(0x1234), ins(opcode.PUSH, 0)),
+		Setup: func(c *CPU) { c.SetRAMStack(true) },
+		Want: []Expectation{wantStack(0x1234), wantMem(RAMStackTop-4, 0x34), wantMem(RAMStackTop-3, 0x12),
+			wantMem(RAMStackTop-2, 0)},
+	},
+	{
+		Opcode: opcode.PUSH, Name: "PUSH fails once the stack in RAM is full",
+		Code:  program(ins(opcode.PUSH, 0)),
+		Setup: func(c *CPU) { c.SetRAMStack(true); c.sp = c.ramStackLimit() },
+		Err:   "stack overflow",
+	},
+	{
+		Opcode: opcode.PUSH, Name: "PUSH fails on a string if the stack is in RAM",
+		Code:  program(ins(opcode.STR_STORE, 0), lstr("hi"), ins(opcode.PUSH, 0)),
+		Setup: func(c *CPU) { c.SetRAMStack(true) },
+		Err:   "only holds integers",
+	},
+	{
+		Opcode: opcode.SP_GET, Name: "SP_GET stores the address of the topmost entry",
+		Code:  program(ins(opcode.PUSH, 0), ins(opcode.SP_GET, 1)),
+		Setup: func(c *CPU) { c.SetRAMStack(true) },
+		Want:  []Expectation{wantInt(1, RAMStackTop-4)},
+	},
+	{
+		Opcode: opcode.SP_GET, Name: "SP_GET fails unless the stack is in RAM",
+		Code: program(ins(opcode.SP_GET, 0)),
+		Err:  "needs the stack in RAM",
+	},
+	{
+		Opcode: opcode.SP_SET, Name: "SP_SET drops entries",
+		Code: program(ins(opcode.INT_STORE, 0), le16(7), ins(opcode.PUSH, 0), ins(opcode.PUSH, 0),
+			ins(opcode.INT_STORE, 1), le16(RAMStackTop-4), ins(opcode.SP_SET, 1)),
+		Setup: func(c *CPU) { c.SetRAMStack(true) },
+		Want:  []Expectation{wantStack(7)},
+	},
+	{
+		Opcode: opcode.SP_SET, Name: "SP_SET fails outside of the stack",
+		Code:  program(ins(opcode.INT_STORE, 1), le16(0x10), ins(opcode.SP_SET, 1)),
+		Setup: func(c *CPU) { c.SetRAMStack(true) },
+		Err:   "outside of the stack",
+	},
+	{
+		Opcode: opcode.CALL, Name: "CALL pushes the return address and jumps",
+		Code: program(ins(opcode.CALL), le16(4), ins(opcode.EXIT), ins(opcode.EXIT)),
+		Want: []Expectation{wantStack(3)},
+	},
+	{
+		Opcode: opcode.CALL_REG, Name: "CALL_REG calls the address in a register",
+		Code: program(ins(opcode.INT_STORE, 1), le16(7), ins(opcode.CALL_REG, 1), ins(opcode.EXIT), ins(opcode.EXIT)),
+		Want: []Expectation{wantStack(6)},
+	},
+	{
+		Opcode: opcode.RET, Name: "RET returns to the caller",
+		Code: program(ins(opcode.CALL), le16(8), ins(opcode.INT_STORE, 0), le16(1), ins(opcode.EXIT), ins(opcode.RET)),
+		Want: []Expectation{wantInt(0, 1), wantStack()},
+	},
+	{
+		Opcode: opcode.RET, Name: "RET fails if the top of the stack isn't an address",
+		Code: program(ins(opcode.STR_STORE, 0), lstr("hi"), ins(opcode.PUSH, 0), ins(opcode.RET)),
+		Err:  "not an integer",
+	},
+	{
+		Opcode: opcode.ENTER, Name: "ENTER saves the frame pointer and reserves local values",
+		Code: program(ins(opcode.ENTER), le16(2)),
+		Want: []Expectation{wantStack(0, 0, 0)},
+	},
+	{
+		Opcode: opcode.LEAVE, Name: "LEAVE discards the frame",
+		Code: program(ins(opcode.INT_STORE, 0), le16(7), ins(opcode.PUSH, 0), ins(opcode.ENTER), le16(1),
+			ins(opcode.PUSH, 0), ins(opcode.LEAVE)),
+		Want: []Expectation{wantStack(7)},
+	},
+	{
+		Opcode: opcode.LEAVE, Name: "LEAVE fails without a frame",
+		Code: program(ins(opcode.LEAVE)),
+		Err:  "without a frame",
+	},
+	{
+		Opcode: opcode.STORE_LOCAL, Name: "STORE_LOCAL writes a local value",
+		Code: program(ins(opcode.ENTER), le16(2), ins(opcode.INT_STORE, 0), le16(5), ins(opcode.STORE_LOCAL, 0), le16(1)),
+		Want: []Expectation{wantStack(0, 0, 5)},
+	},
+	{
+		Opcode: opcode.STORE_LOCAL, Name: "STORE_LOCAL writes the stack in RAM",
+		Code:  program(ins(opcode.ENTER), le16(1), ins(opcode.INT_STORE, 0), le16(6), ins(opcode.STORE_LOCAL, 0), le16(0)),
+		Setup: func(c *CPU) { c.SetRAMStack(true) },
+		Want:  []Expectation{wantStack(0, 6), wantMem(RAMStackTop-8, 6)},
+	},
+	{
+		Opcode: opcode.STORE_LOCAL, Name: "STORE_LOCAL fails outside of the stack",
+		Code: program(ins(opcode.ENTER), le16(1), ins(opcode.STORE_LOCAL, 0), le16(1)),
+		Err:  "outside of the stack",
+	},
+	{
+		Opcode: opcode.LOAD_LOCAL, Name: "LOAD_LOCAL reads a local value",
+		Code: program(ins(opcode.ENTER), le16(2), ins(opcode.INT_STORE, 0), le16(5), ins(opcode.STORE_LOCAL, 0), le16(1),
+			ins(opcode.LOAD_LOCAL, 1), le16(1)),
+		Want: []Expectation{wantInt(1, 5)},
+	},
+	{
+		Opcode: opcode.LOAD_LOCAL, Name: "LOAD_LOCAL reads an argument below the frame",
+		Code: program(ins(opcode.INT_STORE, 0), le16(9), ins(opcode.PUSH, 0), ins(opcode.CALL), le16(10), ins(opcode.EXIT),
+			ins(opcode.ENTER), le16(0), ins(opcode.LOAD_LOCAL, 1), le16(-3&0xffff), ins(opcode.LEAVE), ins(opcode.RET)),
+		Want: []Expectation{wantInt(1, 9), wantStack(9)},
+	},
+	{
+		Opcode: opcode.LOAD_LOCAL, Name: "LOAD_LOCAL fails without a frame",
+		Code: program(ins(opcode.LOAD_LOCAL, 0), le16(0)),
+		Err:  "without a frame",
+	},
+	{
+		Opcode: opcode.TRAP, Name: "TRAP calls a trap function",
+		Code: program(ins(opcode.STR_STORE, 0), lstr("hello"), ins(opcode.TRAP), le16(0)),
+		Want: []Expectation{wantInt(0, 5)},
+	},
+	{
+		Opcode: opcode.TRAP, Name: "TRAP restores a checkpoint",
+		Code: program(ins(opcode.STR_STORE, 0), lstr("c"), ins(opcode.INT_STORE, 2), le16(5),
+			ins(opcode.TRAP), le16(TrapCheckpoint), ins(opcode.CMP_INT, 0), le16(0), ins(opcode.JMP_NZ), le16(35),
+			ins(opcode.INT_STORE, 2), le16(9), ins(opcode.INT_STORE, 1), le16(3),
+			ins(opcode.STR_STORE, 0), lstr("c"), ins(opcode.TRAP), le16(TrapRestore)),
+		Want: []Expectation{wantInt(0, 3), wantInt(2, 5)},
+	},
+	{
+		Opcode: opcode.TRAP, Name: "TRAP fails to restore an unknown checkpoint",
+		Code: program(ins(opcode.STR_STORE, 0), lstr("c"), ins(opcode.TRAP), le16(TrapRestore)),
+		Err:  `unknown checkpoint: "c"`,
+	},
+	{
+		Opcode: opcode.TRAP, Name: "TRAP classifies a digit",
+		Code: program(ins(opcode.INT_STORE, 0), le16('7'), ins(opcode.TRAP), le16(TrapIsDigit)),
+		Want: []Expectation{wantInt(0, 1)},
+	},
+	{
+		Opcode: opcode.TRAP, Name: "TRAP classifies a letter that isn't white space",
+		Code: program(ins(opcode.INT_STORE, 0), le16('x'), ins(opcode.TRAP), le16(TrapIsSpace)),
+		Want: []Expectation{wantInt(0, 0), wantZ(true)},
+	},
+	{
+		Opcode: opcode.TRAP, Name: "TRAP converts a letter to upper case",
+		Code: program(ins(opcode.INT_STORE, 0), le16('q'), ins(opcode.TRAP), le16(TrapToUpper)),
+		Want: []Expectation{wantInt(0, 'Q')},
+	},
+	{
+		Opcode: opcode.TRAP, Name: "TRAP rejects a character code beyond a byte",
+		Code: program(ins(opcode.INT_STORE, 0), le16(0x100), ins(opcode.TRAP), le16(TrapIsAlpha)),
+		Err:  "character code 256 is out of range, it must be a byte",
+	},
+	{
+		Opcode: opcode.TRAP, Name: "TRAP groups the digits of an integer",
+		Code: program(ins(opcode.INT_STORE, 0), le16(65535), ins(opcode.TRAP), le16(TrapGroupDigits)),
+		Want: []Expectation{wantStr(0, "65,535")},
+	},
+	{
+		Opcode: opcode.TRAP, Name: "TRAP formats hundredths with two decimals",
+		Code: program(ins(opcode.INT_STORE, 0), le16(65505), ins(opcode.TRAP), le16(TrapFixedPoint)),
+		Want: []Expectation{wantStr(0, "655.05")},
+	},
+	{
+		Opcode: opcode.TRAP, Name: "TRAP reads the performance counters",
+		Code:  program(ins(opcode.JMP), le16(3), ins(opcode.TRAP), le16(TrapStrLen), ins(opcode.TRAP), le16(TrapPerfCounters)),
+		Setup: func(c *CPU) { c.regs[0].SetStr("") },
+		Want:  []Expectation{wantInt(0, 2), wantInt(1, 1), wantInt(2, 1)},
+	},
+
+	// the stack-machine instruction set
+	{
+		Opcode: opcode.STACK_EXIT, Stack: true, Name: "STACK_EXIT stops the execution",
+		Code: program(ins(opcode.STACK_EXIT), ins(opcode.STACK_PUSH), le16(1)),
+		Want: []Expectation{wantStack()},
+	},
+	{
+		Opcode: opcode.STACK_PUSH, Stack: true, Name: "STACK_PUSH pushes a constant",
+		Code: program(ins(opcode.STACK_PUSH), le16(42)),
+		Want: []Expectation{wantStack(42)},
+	},
+	{
+		Opcode: opcode.STACK_POP, Stack: true, Name: "STACK_POP discards the top",
+		Code: program(ins(opcode.STACK_PUSH), le16(1), ins(opcode.STACK_PUSH), le16(2), ins(opcode.STACK_POP)),
+		Want: []Expectation{wantStack(1)},
+	},
+	{
+		Opcode: opcode.STACK_DUP, Stack: true, Name: "STACK_DUP duplicates the top",
+		Code: program(ins(opcode.STACK_PUSH), le16(1), ins(opcode.STACK_DUP)),
+		Want: []Expectation{wantStack(1, 1)},
+	},
+	{
+		Opcode: opcode.STACK_SWAP, Stack: true, Name: "STACK_SWAP swaps the two topmost values",
+		Code: program(ins(opcode.STACK_PUSH), le16(1), ins(opcode.STACK_PUSH), le16(2), ins(opcode.STACK_SWAP)),
+		Want: []Expectation{wantStack(2, 1)},
+	},
+	{
+		Opcode: opcode.STACK_ADD, Stack: true, Name: "STACK_ADD adds the two topmost values",
+		Code: program(ins(opcode.STACK_PUSH), le16(3), ins(opcode.STACK_PUSH), le16(4), ins(opcode.STACK_ADD)),
+		Want: []Expectation{wantStack(7)},
+	},
+	{
+		Opcode: opcode.STACK_SUB, Stack: true, Name: "STACK_SUB subtracts the top from the value below",
+		Code: program(ins(opcode.STACK_PUSH), le16(7), ins(opcode.STACK_PUSH), le16(4), ins(opcode.STACK_SUB)),
+		Want: []Expectation{wantStack(3)},
+	},
+	{
+		Opcode: opcode.STACK_MUL, Stack: true, Name: "STACK_MUL multiplies the two topmost values",
+		Code: program(ins(opcode.STACK_PUSH), le16(3), ins(opcode.STACK_PUSH), le16(4), ins(opcode.STACK_MUL)),
+		Want: []Expectation{wantStack(12)},
+	},
+	{
+		Opcode: opcode.STACK_DIV, Stack: true, Name: "STACK_DIV divides the value below by the top",
+		Code: program(ins(opcode.STACK_PUSH), le16(13), ins(opcode.STACK_PUSH), le16(4), ins(opcode.STACK_DIV)),
+		Want: []Expectation{wantStack(3)},
+	},
+	{
+		Opcode: opcode.STACK_JMP, Stack: true, Name: "STACK_JMP jumps to the address",
+		Code: program(ins(opcode.STACK_JMP), le16(6), ins(opcode.STACK_PUSH), le16(1)),
+		Want: []Expectation{wantStack()},
+	},
+	{
+		Opcode: opcode.STACK_JMP_Z, Stack: true, Name: "STACK_JMP_Z pops and jumps if zero",
+		Code: program(ins(opcode.STACK_PUSH), le16(0), ins(opcode.STACK_JMP_Z), le16(9), ins(opcode.STACK_PUSH), le16(1)),
+		Want: []Expectation{wantStack()},
+	},
+	{
+		Opcode: opcode.STACK_JMP_NZ, Stack: true, Name: "STACK_JMP_NZ pops and doesn't jump if zero",
+		Code: program(ins(opcode.STACK_PUSH), le16(0), ins(opcode.STACK_JMP_NZ), le16(9), ins(opcode.STACK_PUSH), le16(1)),
+		Want: []Expectation{wantStack(1)},
+	},
+	{
+		Opcode: opcode.STACK_PRINT, Stack: true, Name: "STACK_PRINT pops and prints in decimal",
+		Code: program(ins(opcode.STACK_PUSH), le16(42), ins(opcode.STACK_PRINT)),
+		Want: []Expectation{wantOut("42\n"), wantStack()},
+	},
+}
+
+// Run runs the program of the check on a fresh CPU and returns the
+// first expectation which doesn't hold
+func (sc SelfCheck) Run() error {
+	var out bytes.Buffer
+
+	c := NewCPU()
+	c.STDIN = bufio.NewReader(strings.NewReader(""))
+	c.STDOUT = bufio.NewWriter(&out)
+	c.STDERR = bufio.NewWriter(io.Discard)
+	c.SetStackISA(sc.Stack)
+	if err := c.LoadBytes(sc.Code); err != nil {
+		return err
+	}
+	if sc.Setup != nil {
+		sc.Setup(c)
+	}
+
+	var err error
+	for steps := 0; ; steps++ {
+		if steps == maxSelfCheckSteps {
+			return fmt.Errorf("no EXIT after %d steps", steps)
+		}
+
+		var ok bool
+		if ok, err = c.step(); err != nil || !ok {
+			break
+		}
+	}
+	c.STDOUT.Flush()
+
+	switch {
+	case sc.Err == "" && err != nil:
+		return fmt.Errorf("unexpected error: %s", err)
+	case sc.Err != "" && err == nil:
+		return fmt.Errorf("no error, want %q", sc.Err)
+	case sc.Err != "" && !strings.Contains(err.Error(), sc.Err):
+		return fmt.Errorf("error %q, want %q", err, sc.Err)
+	}
+
+	for _, want := range sc.Want {
+		if err = want(c, out.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunSelfChecks runs every self-check, and reports opcodes which
+// don't have one, so new opcodes can't be added without documenting
+// their behavior.
+func RunSelfChecks() []SelfCheckFailure {
+	var failures []SelfCheckFailure
+
+	covered := make(map[string]bool)
+	for _, sc := range SelfChecks {
+		covered[selfCheckOpcodeName(sc.Opcode, sc.Stack)] = true
+
+		if err := sc.Run(); err != nil {
+			failures = append(failures, SelfCheckFailure{Check: sc.Name, Err: err})
+		}
+	}
+
+	for i := 0; i < 256; i++ {
+		for _, stack := range []bool{false, true} {
+			name := selfCheckOpcodeName(i, stack)
+			if name != "unknown opcode" && !covered[name] {
+				failures = append(failures, SelfCheckFailure{Check: name, Err: fmt.Errorf("opcode has no self-check")})
+			}
+		}
+	}
+	return failures
+}
+
+// selfCheckOpcodeName returns the name of an opcode of either instruction set
+func selfCheckOpcodeName(code int, stack bool) string {
+	if stack {
+		return opcode.StackName(byte(code))
+	}
+	return opcode.NewOpcode(byte(code)).String()
+}
@@ -0,0 +1,1850 @@
+package cpu
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"vm/opcode"
+)
+
+// State is the part of the machine the semantics of the instructions
+// operate on. The CPU implements it, and so can alternative executors,
+// e.g. a verifier or a symbolic analyzer, to reuse the semantics rather
+// than re-implementing every opcode.
+type State interface {
+	// Reg returns the given register, or an error if there is no such register
+	Reg(n int) (*Register, error)
+
+	// Load returns the byte at the given address
+	Load(addr int) byte
+
+	// ReadMem returns the byte at the given address as the program reads
+	// it, e.g. via PEEK, which may come from a memory-mapped device
+	ReadMem(addr int) (byte, error)
+
+	// Store sets the byte at the given address, or returns an error if
+	// the address is read-only or the device mapped there fails
+	Store(addr int, v byte) error
+
+	// IP returns the instruction pointer
+	IP() int
+
+	// SetIP sets the instruction pointer
+	SetIP(ip int)
+
+	// Zero returns the zero flag
+	Zero() bool
+
+	// SetZero sets the zero flag
+	SetZero(z bool)
+
+	// Sign returns the sign flag
+	Sign() bool
+
+	// SetSign sets the sign flag
+	SetSign(s bool)
+
+	// Carry returns the carry flag
+	Carry() bool
+
+	// SetCarry sets the carry flag
+	SetCarry(c bool)
+
+	// Overflow returns the overflow flag
+	Overflow() bool
+
+	// SetOverflow sets the overflow flag
+	SetOverflow(v bool)
+
+	// Push pushes a value onto the stack
+	Push(v int) error
+
+	// Pop pops a value from the stack
+	Pop() (int, error)
+
+	// PushObject pushes the value of a register of any type onto the stack
+	PushObject(obj Object) error
+
+	// PopObject pops a value of any type from the stack
+	PopObject() (Object, error)
+
+	// WordSize returns the size of the machine word in bits
+	WordSize() int
+
+	// Signed returns true if registers hold signed integers
+	Signed() bool
+
+	// Print writes the given string to the output
+	Print(s string) error
+
+	// PrintErr writes the given string to the error output
+	PrintErr(s string) error
+
+	// Alloc allocates the given number of bytes of memory and returns
+	// their address
+	Alloc(size int) (int, error)
+
+	// Free releases the memory allocated at the given address
+	Free(addr int) error
+}
+
+// Semantic executes the instruction at the IP of the given state, which
+// points at its opcode, and leaves the IP at the next instruction.
+// It returns false once the program should stop.
+type Semantic func(s State) (bool, error)
+
+// Semantics maps opcodes to their semantics. Opcodes which need more
+// than the State, e.g. access to the host, aren't listed here but
+// implemented by the CPU itself, see hostInstructions.
+var Semantics [256]Semantic
+
+// fetch returns the byte at the IP and moves the IP over it
+func fetch(s State) byte {
+	v := s.Load(s.IP())
+	s.SetIP(s.IP() + 1)
+	return v
+}
+
+// fetchReg returns the register whose number is at the IP and moves
+// the IP over it
+func fetchReg(s State) (*Register, error) {
+	return s.Reg(int(fetch(s)))
+}
+
+// fetchRegs returns n registers whose numbers follow each other at the IP
+func fetchRegs(s State, n int) ([]*Register, error) {
+	regs := make([]*Register, n)
+	for i := range regs {
+		reg, err := fetchReg(s)
+		if err != nil {
+			return nil, err
+		}
+		regs[i] = reg
+	}
+	return regs, nil
+}
+
+// fetchInt reads a two byte number from the IP, e.g. an address
+func fetchInt(s State) int {
+	r := int(fetch(s))
+	q := int(fetch(s))
+	return r + q*256
+}
+
+// fetchWord reads an immediate integer whose width depends on the word size
+func fetchWord(s State) int {
+	v := 0
+	for i := 0; i < s.WordSize()/8; i++ {
+		v += int(fetch(s)) << (8 * i)
+	}
+	return toWord(s, v)
+}
+
+// toWord truncates an integer to the bits of the machine word, which
+// are read as two's complement in signed mode, e.g. 0xffff is -1
+func toWord(s State, v int) int {
+	v &= wordMax(s.WordSize())
+	if s.Signed() && v >= 1<<(s.WordSize()-1) {
+		v -= 1 << s.WordSize()
+	}
+	return v
+}
+
+// fetchStr reads a string prefixed by its length from the IP
+func fetchStr(s State) (string, error) {
+	strLen := fetchInt(s)
+
+	// can't read beyond RAM but wrap-around will be allowed
+	if strLen >= MemSize {
+		return "", fmt.Errorf(
+			"string is too large for memory: RAM size => %d bytes, string size => %d bytes",
+			MemSize, strLen)
+	}
+
+	ip := s.IP()
+	buf := make([]byte, strLen)
+	for i := range buf {
+		addr := ip + i
+		// wrap around
+		if addr == MemSize {
+			addr = 0
+		}
+		buf[i] = s.Load(addr)
+	}
+
+	// move the IP over the length of the string
+	s.SetIP(ip + strLen)
+
+	return string(buf), nil
+}
+
+// skip moves the IP over the opcode
+func skip(s State) {
+	s.SetIP(s.IP() + 1)
+}
+
+func execExit(s State) (bool, error) {
+	return false, nil
+}
+
+func execIntStore(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+	reg.SetInt(fetchWord(s))
+	return true, nil
+}
+
+func execIntPrint(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	val, err := reg.GetInt()
+	if err != nil {
+		return false, err
+	}
+
+	if val < 0 {
+		if err := s.Print("-"); err != nil {
+			return false, err
+		}
+		val = -val
+	}
+
+	switch {
+	case val < 256:
+		return true, s.Print(fmt.Sprintf("%02x", val))
+	case val < 0x10000:
+		return true, s.Print(fmt.Sprintf("%04x", val))
+	default:
+		return true, s.Print(fmt.Sprintf("%08x", val))
+	}
+}
+
+func execIntToStr(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	i, err := reg.GetInt()
+	if err != nil {
+		return false, err
+	}
+
+	// change from int to string
+	reg.SetStr(fmt.Sprintf("%d", i))
+	return true, nil
+}
+
+func execJmp(s State) (bool, error) {
+	skip(s)
+	s.SetIP(fetchInt(s))
+	return true, nil
+}
+
+func execJmpZ(s State) (bool, error) {
+	skip(s)
+	addr := fetchInt(s)
+	if s.Zero() {
+		s.SetIP(addr)
+	}
+	return true, nil
+}
+
+func execJmpNZ(s State) (bool, error) {
+	skip(s)
+	addr := fetchInt(s)
+	if !s.Zero() {
+		s.SetIP(addr)
+	}
+	return true, nil
+}
+
+func execJmpS(s State) (bool, error) {
+	skip(s)
+	addr := fetchInt(s)
+	if s.Sign() {
+		s.SetIP(addr)
+	}
+	return true, nil
+}
+
+func execJmpNS(s State) (bool, error) {
+	skip(s)
+	addr := fetchInt(s)
+	if !s.Sign() {
+		s.SetIP(addr)
+	}
+	return true, nil
+}
+
+// arithmetic returns the semantics of an instruction storing the result
+// of an operation on two integer registers in a third one.
+// The sign flag is set if the stored result is negative.
+func arithmetic(fn func(s State, a, b int) (int, error)) Semantic {
+	return func(s State) (bool, error) {
+		skip(s)
+		regs, err := fetchRegs(s, 3)
+		if err != nil {
+			return false, err
+		}
+
+		a, err := regs[1].GetInt()
+		if err != nil {
+			return false, err
+		}
+		b, err := regs[2].GetInt()
+		if err != nil {
+			return false, err
+		}
+
+		res, err := fn(s, a, b)
+		if err != nil {
+			return false, err
+		}
+		regs[0].SetInt(res)
+		s.SetSign(res < 0 && s.Signed())
+		return true, nil
+	}
+}
+
+// setAddFlags sets the carry flag if the unsigned addition of a, b and
+// the carry doesn't fit in the word, and the overflow flag if the signed
+// addition doesn't. Both are computed on the two's complement bits, so
+// they don't depend on the signed mode. For subtractions the carry flag
+// is the borrow.
+func setAddFlags(s State, a, b, carry int, sub bool) {
+	size := s.WordSize()
+	ua, ub := a&wordMax(size), b&wordMax(size)
+	sa, sb := signExtend(ua, size), signExtend(ub, size)
+
+	var sum int
+	if sub {
+		s.SetCarry(ua < ub+carry)
+		sum = sa - sb - carry
+	} else {
+		s.SetCarry(ua+ub+carry > wordMax(size))
+		sum = sa + sb + carry
+	}
+	s.SetOverflow(sum < -(1<<(size-1)) || sum >= 1<<(size-1))
+}
+
+// signExtend reads the bits of a word of the given size as two's complement
+func signExtend(v, size int) int {
+	if v >= 1<<(size-1) {
+		return v - 1<<size
+	}
+	return v
+}
+
+// carryIn returns the carry flag as the integer added by ADC and SBC
+func carryIn(s State) int {
+	if s.Carry() {
+		return 1
+	}
+	return 0
+}
+
+// arithmeticImm returns the semantics of an instruction storing the
+// result of an operation on an integer register and a constant, which is
+// encoded as a word following the two registers
+func arithmeticImm(fn func(s State, a, b int) (int, error)) Semantic {
+	return func(s State) (bool, error) {
+		skip(s)
+		regs, err := fetchRegs(s, 2)
+		if err != nil {
+			return false, err
+		}
+		b := fetchWord(s)
+
+		a, err := regs[1].GetInt()
+		if err != nil {
+			return false, err
+		}
+
+		res, err := fn(s, a, b)
+		if err != nil {
+			return false, err
+		}
+		regs[0].SetInt(res)
+		s.SetSign(res < 0 && s.Signed())
+		return true, nil
+	}
+}
+
+func andInts(_ State, a, b int) (int, error) { return a & b, nil }
+func mulInts(_ State, a, b int) (int, error) { return a * b, nil }
+func orInts(_ State, a, b int) (int, error)  { return a | b, nil }
+func xorInts(_ State, a, b int) (int, error) { return a ^ b, nil }
+
+func addInts(s State, a, b int) (int, error) {
+	setAddFlags(s, a, b, 0, false)
+	return a + b, nil
+}
+
+func subInts(s State, a, b int) (int, error) {
+	// Set the zero flag if the result was zero or less.
+	// Used during iteration (see examples/concat.in).
+	if a-b <= 0 {
+		s.SetZero(true)
+	}
+	setAddFlags(s, a, b, 0, true)
+	return a - b, nil
+}
+
+func divInts(_ State, a, b int) (int, error) {
+	if b == 0 {
+		return 0, fmt.Errorf("devision by zero")
+	}
+	return a / b, nil
+}
+
+func modInts(_ State, a, b int) (int, error) {
+	if b == 0 {
+		return 0, fmt.Errorf("devision by zero")
+	}
+	return a % b, nil
+}
+
+var (
+	execAnd = arithmetic(andInts)
+	execMul = arithmetic(mulInts)
+	execOr  = arithmetic(orInts)
+	execXor = arithmetic(xorInts)
+	execAdd = arithmetic(addInts)
+	execSub = arithmetic(subInts)
+	execDiv = arithmetic(divInts)
+	execMod = arithmetic(modInts)
+
+	execAndImm = arithmeticImm(andInts)
+	execMulImm = arithmeticImm(mulInts)
+	execOrImm  = arithmeticImm(orInts)
+	execXorImm = arithmeticImm(xorInts)
+	execAddImm = arithmeticImm(addInts)
+	execSubImm = arithmeticImm(subInts)
+	execDivImm = arithmeticImm(divInts)
+	execModImm = arithmeticImm(modInts)
+
+	// ADC and SBC wrap around rather than clamping the result, so the
+	// lower words of numbers larger than a word are correct
+	execAdc = arithmetic(func(s State, a, b int) (int, error) {
+		carry := carryIn(s)
+		setAddFlags(s, a, b, carry, false)
+		return toWord(s, a+b+carry), nil
+	})
+
+	execSbc = arithmetic(func(s State, a, b int) (int, error) {
+		carry := carryIn(s)
+		setAddFlags(s, a, b, carry, true)
+		return toWord(s, a-b-carry), nil
+	})
+
+	// MULH stores the word above the one MUL stores, so products wider
+	// than a word aren't lost. Signed products are sign-extended.
+	execMulh = arithmetic(func(s State, a, b int) (int, error) {
+		size := s.WordSize()
+		if s.Signed() {
+			return (a * b) >> size, nil
+		}
+		hi, lo := bits.Mul64(uint64(a), uint64(b))
+		return int(hi<<(64-size) | lo>>size), nil
+	})
+
+	// shifted out bits are discarded rather than clamping the result,
+	// and negative integers are shifted as their two's complement bits
+	execShl = arithmetic(func(s State, a, b int) (int, error) {
+		if b < 0 {
+			return 0, negativeShift(b)
+		}
+		return toWord(s, a<<b), nil
+	})
+	execShr = arithmetic(func(s State, a, b int) (int, error) {
+		if b < 0 {
+			return 0, negativeShift(b)
+		}
+		return toWord(s, (a&wordMax(s.WordSize()))>>b), nil
+	})
+
+	execShlImm = shiftImm(func(s State, a, bits int) int { return toWord(s, a<<bits) })
+	execShrImm = shiftImm(func(s State, a, bits int) int { return toWord(s, (a&wordMax(s.WordSize()))>>bits) })
+)
+
+// negativeShift reports a shift by a negative number of bits, which is
+// only possible in signed mode
+func negativeShift(bits int) error {
+	return fmt.Errorf("negative shift count: %d", bits)
+}
+
+// shiftImm returns the semantics of a shift by a constant number of
+// bits, which is encoded as a single byte following two registers
+func shiftImm(fn func(s State, a, bits int) int) Semantic {
+	return func(s State) (bool, error) {
+		skip(s)
+		regs, err := fetchRegs(s, 2)
+		if err != nil {
+			return false, err
+		}
+		bits := int(fetch(s))
+
+		a, err := regs[1].GetInt()
+		if err != nil {
+			return false, err
+		}
+
+		res := fn(s, a, bits)
+		regs[0].SetInt(res)
+		s.SetSign(res < 0)
+		return true, nil
+	}
+}
+
+// execDivmod stores the quotient of the division of the third register
+// by the fourth in the first register, and the remainder in the second
+func execDivmod(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 4)
+	if err != nil {
+		return false, err
+	}
+
+	a, err := regs[2].GetInt()
+	if err != nil {
+		return false, err
+	}
+	b, err := regs[3].GetInt()
+	if err != nil {
+		return false, err
+	}
+	if b == 0 {
+		return false, fmt.Errorf("devision by zero")
+	}
+
+	quotient, remainder := a/b, a%b
+	regs[0].SetInt(quotient)
+	regs[1].SetInt(remainder)
+	s.SetSign(quotient < 0 && s.Signed())
+	return true, nil
+}
+
+// fetchPair returns the register pair whose first register number is at
+// the IP, the register holding the upper word first, and moves the IP
+// over it
+func fetchPair(s State) ([2]*Register, error) {
+	n := int(fetch(s))
+	hi, err := s.Reg(n)
+	if err != nil {
+		return [2]*Register{}, err
+	}
+	lo, err := s.Reg(n + 1)
+	if err != nil {
+		return [2]*Register{}, err
+	}
+	return [2]*Register{hi, lo}, nil
+}
+
+// pairValue returns the unsigned double word held by a register pair
+func pairValue(s State, pair [2]*Register) (uint64, error) {
+	hi, err := pair[0].GetInt()
+	if err != nil {
+		return 0, err
+	}
+	lo, err := pair[1].GetInt()
+	if err != nil {
+		return 0, err
+	}
+	size, mask := s.WordSize(), uint64(wordMax(s.WordSize()))
+	return uint64(hi)&mask<<size | uint64(lo)&mask, nil
+}
+
+// pairArithmetic returns the semantics of an operation on the double
+// words of two register pairs, storing the result in a third one. The
+// double words are unsigned, e.g. 32 bits with 16-bit words. fn returns
+// the result, which is truncated to a double word, and whether it didn't
+// fit, which sets the carry flag.
+func pairArithmetic(fn func(a, b uint64) (uint64, bool, error)) Semantic {
+	return func(s State) (bool, error) {
+		skip(s)
+		var pairs [3][2]*Register
+		for i := range pairs {
+			pair, err := fetchPair(s)
+			if err != nil {
+				return false, err
+			}
+			pairs[i] = pair
+		}
+
+		a, err := pairValue(s, pairs[1])
+		if err != nil {
+			return false, err
+		}
+		b, err := pairValue(s, pairs[2])
+		if err != nil {
+			return false, err
+		}
+
+		res, carry, err := fn(a, b)
+		if err != nil {
+			return false, err
+		}
+		size := s.WordSize()
+		if size < 32 {
+			carry = carry || res>>(2*size) != 0
+			res &= 1<<(2*size) - 1
+		}
+		pairs[0][0].SetInt(toWord(s, int(res>>size)))
+		pairs[0][1].SetInt(toWord(s, int(res)))
+		s.SetCarry(carry)
+		s.SetZero(res == 0)
+		return true, nil
+	}
+}
+
+var (
+	execAddPair = pairArithmetic(func(a, b uint64) (uint64, bool, error) {
+		sum, carry := bits.Add64(a, b, 0)
+		return sum, carry != 0, nil
+	})
+
+	// the carry flag is the borrow, like with SUB
+	execSubPair = pairArithmetic(func(a, b uint64) (uint64, bool, error) {
+		return a - b, a < b, nil
+	})
+
+	execMulPair = pairArithmetic(func(a, b uint64) (uint64, bool, error) {
+		hi, lo := bits.Mul64(a, b)
+		return lo, hi != 0, nil
+	})
+
+	execDivPair = pairArithmetic(func(a, b uint64) (uint64, bool, error) {
+		if b == 0 {
+			return 0, false, fmt.Errorf("devision by zero")
+		}
+		return a / b, false, nil
+	})
+)
+
+func execInc(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	i, err := reg.GetInt()
+	if err != nil {
+		return false, err
+	}
+
+	setAddFlags(s, i, 1, 0, false)
+
+	// if the value equals the largest word it will wrap around
+	lo, hi := wordRange(s.WordSize(), s.Signed())
+	if i == hi {
+		reg.noteOverflow(i + 1)
+		i = lo
+	} else {
+		i++
+	}
+
+	s.SetZero(i == 0)
+	s.SetSign(i < 0)
+	reg.SetInt(i)
+	return true, nil
+}
+
+func execDec(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	i, err := reg.GetInt()
+	if err != nil {
+		return false, err
+	}
+
+	setAddFlags(s, i, 1, 0, true)
+
+	// if the value equals the smallest word it will wrap around
+	lo, hi := wordRange(s.WordSize(), s.Signed())
+	if i == lo {
+		reg.noteOverflow(i - 1)
+		i = hi
+	} else {
+		i--
+	}
+
+	s.SetZero(i == 0)
+	s.SetSign(i < 0)
+	reg.SetInt(i)
+	return true, nil
+}
+
+func execStrStore(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	str, err := fetchStr(s)
+	if err != nil {
+		return false, err
+	}
+
+	reg.SetStr(str)
+	return true, nil
+}
+
+func execStrPrint(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	str, err := reg.GetStr()
+	if err != nil {
+		return false, err
+	}
+	return true, s.Print(str)
+}
+
+// execPrintErr prints the string of a register to the error output
+func execPrintErr(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	str, err := reg.GetStr()
+	if err != nil {
+		return false, err
+	}
+	return true, s.PrintErr(str)
+}
+
+// execPrintChar prints the Unicode character with the code in a register,
+// encoded as UTF-8, so codes below 0x80 print a single byte
+func execPrintChar(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	code, err := reg.GetInt()
+	if err != nil {
+		return false, err
+	}
+	if code < 0 || code > utf8.MaxRune || !utf8.ValidRune(rune(code)) {
+		return false, fmt.Errorf("print_char of %d is not a valid character code", code)
+	}
+	return true, s.Print(string(rune(code)))
+}
+
+func execConcat(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 3)
+	if err != nil {
+		return false, err
+	}
+
+	a, ok := regs[1].obj.(*StrObject)
+	if !ok {
+		// report the usual error
+		_, err = regs[1].GetStr()
+		return false, err
+	}
+	b, err := regs[2].GetStr()
+	if err != nil {
+		return false, err
+	}
+
+	regs[0].obj = concatStr(a, b)
+	return true, nil
+}
+
+// splitOperands returns the string and the delimiter of a split, which
+// are in the second and third register
+func splitOperands(regs []*Register) (string, string, error) {
+	str, err := regs[1].GetStr()
+	if err != nil {
+		return "", "", err
+	}
+	delim, err := regs[2].GetStr()
+	if err != nil {
+		return "", "", err
+	}
+	if delim == "" {
+		return "", "", fmt.Errorf("split on an empty delimiter")
+	}
+	return str, delim, nil
+}
+
+// execSplitCount stores the number of parts of a string split on a
+// delimiter, which is one more than the number of delimiters
+func execSplitCount(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 3)
+	if err != nil {
+		return false, err
+	}
+
+	str, delim, err := splitOperands(regs)
+	if err != nil {
+		return false, err
+	}
+	regs[0].SetInt(strings.Count(str, delim) + 1)
+	return true, nil
+}
+
+// execSplitPart stores the part of a string split on a delimiter with
+// the index in the fourth register, counting from zero. The zero flag
+// is set, and the register left unchanged, if there is no such part, so
+// a loop over the parts ends with JMP_Z.
+func execSplitPart(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 4)
+	if err != nil {
+		return false, err
+	}
+
+	str, delim, err := splitOperands(regs)
+	if err != nil {
+		return false, err
+	}
+	n, err := regs[3].GetInt()
+	if err != nil {
+		return false, err
+	}
+
+	parts := strings.Split(str, delim)
+	if n < 0 || n >= len(parts) {
+		s.SetZero(true)
+		return true, nil
+	}
+	regs[0].SetStr(parts[n])
+	s.SetZero(false)
+	return true, nil
+}
+
+func execStrToInt(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	str, err := reg.GetStr()
+	if err != nil {
+		return false, err
+	}
+
+	i, err := strconv.Atoi(str)
+	if err != nil {
+		return false, fmt.Errorf("failed to convert string (%s) to int: %s", str, err)
+	}
+
+	reg.SetInt(i)
+	return true, nil
+}
+
+func execCmpInt(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	val := fetchWord(s)
+
+	// the sign flag is set if the register is smaller than the value
+	s.SetZero(false)
+	s.SetSign(false)
+	if reg.Type() == "int" {
+		regVal, err := reg.GetInt()
+		if err != nil {
+			return false, err
+		}
+		s.SetZero(regVal == val)
+		s.SetSign(regVal < val)
+	}
+	return true, nil
+}
+
+func execCmpStr(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	val, err := fetchStr(s)
+	if err != nil {
+		return false, err
+	}
+
+	s.SetZero(false)
+	if reg.Type() == "str" {
+		regVal, err := reg.GetStr()
+		if err != nil {
+			return false, err
+		}
+		s.SetZero(regVal == val)
+	}
+	return true, nil
+}
+
+func execCmpReg(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 2)
+	if err != nil {
+		return false, err
+	}
+
+	// the sign flag is set if the first integer is smaller than the second
+	s.SetZero(false)
+	s.SetSign(false)
+
+	switch regs[0].Type() {
+	case "int":
+		a, err := regs[0].GetInt()
+		if err != nil {
+			return false, err
+		}
+		b, err := regs[1].GetInt()
+		if err != nil {
+			return false, err
+		}
+		s.SetZero(a == b)
+		s.SetSign(a < b)
+	case "str":
+		a, err := regs[0].GetStr()
+		if err != nil {
+			return false, err
+		}
+		b, err := regs[1].GetStr()
+		if err != nil {
+			return false, err
+		}
+		s.SetZero(a == b)
+	case "float":
+		a, err := regs[0].GetFloat()
+		if err != nil {
+			return false, err
+		}
+		b, err := regs[1].GetFloat()
+		if err != nil {
+			return false, err
+		}
+		s.SetZero(a == b)
+		s.SetSign(a < b)
+	case "hash":
+		a, err := regs[0].GetHash()
+		if err != nil {
+			return false, err
+		}
+		b, err := regs[1].GetHash()
+		if err != nil {
+			return false, err
+		}
+		// hashes are equal if they are the same one
+		s.SetZero(a == b)
+	}
+	return true, nil
+}
+
+// isType returns the semantics of an instruction setting the zero flag
+// if a register contains a value of the given type
+func isType(typ string) Semantic {
+	return func(s State) (bool, error) {
+		skip(s)
+		reg, err := fetchReg(s)
+		if err != nil {
+			return false, err
+		}
+		s.SetZero(reg.Type() == typ)
+		return true, nil
+	}
+}
+
+var (
+	execIsInt   = isType("int")
+	execIsStr   = isType("str")
+	execIsFloat = isType("float")
+)
+
+func execOrd(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 2)
+	if err != nil {
+		return false, err
+	}
+
+	str, err := regs[1].GetStr()
+	if err != nil {
+		return false, err
+	}
+	if str == "" {
+		return false, fmt.Errorf("ord of an empty string")
+	}
+	regs[0].SetInt(int(str[0]))
+	return true, nil
+}
+
+func execChr(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 2)
+	if err != nil {
+		return false, err
+	}
+
+	code, err := regs[1].GetInt()
+	if err != nil {
+		return false, err
+	}
+	if code < 0 || code > 0xff {
+		return false, fmt.Errorf("chr of %d is out of range, it must be a byte", code)
+	}
+	regs[0].SetStr(string([]byte{byte(code)}))
+	return true, nil
+}
+
+// fetchFloat reads an immediate floating-point number, which is encoded
+// as the 8 bytes of an IEEE 754 double regardless of the word size
+func fetchFloat(s State) float64 {
+	var bits uint64
+	for i := 0; i < 8; i++ {
+		bits |= uint64(fetch(s)) << (8 * i)
+	}
+	return math.Float64frombits(bits)
+}
+
+func execFloatStore(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+	reg.SetFloat(fetchFloat(s))
+	return true, nil
+}
+
+func execFloatPrint(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	val, err := reg.GetFloat()
+	if err != nil {
+		return false, err
+	}
+	return true, s.Print(strconv.FormatFloat(val, 'g', -1, 64))
+}
+
+// floatArithmetic returns the semantics of an instruction storing the
+// result of an operation on two floating-point registers in a third one.
+// Unlike the integer instructions the zero flag is set if the result is
+// zero, and the sign flag if it is negative.
+func floatArithmetic(fn func(a, b float64) (float64, error)) Semantic {
+	return func(s State) (bool, error) {
+		skip(s)
+		regs, err := fetchRegs(s, 3)
+		if err != nil {
+			return false, err
+		}
+
+		a, err := regs[1].GetFloat()
+		if err != nil {
+			return false, err
+		}
+		b, err := regs[2].GetFloat()
+		if err != nil {
+			return false, err
+		}
+
+		res, err := fn(a, b)
+		if err != nil {
+			return false, err
+		}
+		regs[0].SetFloat(res)
+		s.SetZero(res == 0)
+		s.SetSign(res < 0)
+		return true, nil
+	}
+}
+
+var (
+	execFadd = floatArithmetic(func(a, b float64) (float64, error) { return a + b, nil })
+	execFsub = floatArithmetic(func(a, b float64) (float64, error) { return a - b, nil })
+	execFmul = floatArithmetic(func(a, b float64) (float64, error) { return a * b, nil })
+
+	execFdiv = floatArithmetic(func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, fmt.Errorf("devision by zero")
+		}
+		return a / b, nil
+	})
+)
+
+func execIntToFloat(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	i, err := reg.GetInt()
+	if err != nil {
+		return false, err
+	}
+	reg.SetFloat(float64(i))
+	return true, nil
+}
+
+// execFloatToInt truncates the float towards zero, numbers outside of
+// the range of the machine word are clamped like any other integer
+func execFloatToInt(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	f, err := reg.GetFloat()
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case math.IsNaN(f):
+		return false, fmt.Errorf("can't convert NaN to an integer")
+	case f >= math.MaxInt64:
+		reg.SetInt(math.MaxInt64)
+	case f <= math.MinInt64:
+		reg.SetInt(math.MinInt64)
+	default:
+		reg.SetInt(int(f))
+	}
+	return true, nil
+}
+
+func execHashNew(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+	reg.SetHash(&HashObject{Values: map[string]Object{}})
+	return true, nil
+}
+
+// execHashSet stores the integer or string of the third register in the
+// hash of the first register, under the string key of the second
+func execHashSet(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 3)
+	if err != nil {
+		return false, err
+	}
+	h, err := regs[0].GetHash()
+	if err != nil {
+		return false, err
+	}
+	key, err := regs[1].GetStr()
+	if err != nil {
+		return false, err
+	}
+
+	switch v := regs[2].obj.(type) {
+	case *IntObject:
+		h.set(key, &IntObject{Value: v.Value})
+	case *StrObject:
+		h.set(key, &StrObject{Value: v.Value})
+	default:
+		return false, fmt.Errorf("hashes can only hold integers and strings, not %s", regs[2].Type())
+	}
+	return true, nil
+}
+
+// execHashGet copies the value of a key of a hash into a register. The
+// zero flag is set if there is no such key, leaving the register as it is.
+func execHashGet(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 3)
+	if err != nil {
+		return false, err
+	}
+	h, err := regs[1].GetHash()
+	if err != nil {
+		return false, err
+	}
+	key, err := regs[2].GetStr()
+	if err != nil {
+		return false, err
+	}
+
+	value, ok := h.Values[key]
+	switch v := value.(type) {
+	case *IntObject:
+		regs[0].SetInt(v.Value)
+	case *StrObject:
+		regs[0].SetStr(v.Value)
+	}
+	s.SetZero(!ok)
+	return true, nil
+}
+
+// execHashDel removes a key from a hash, if it is present
+func execHashDel(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 2)
+	if err != nil {
+		return false, err
+	}
+	h, err := regs[0].GetHash()
+	if err != nil {
+		return false, err
+	}
+	key, err := regs[1].GetStr()
+	if err != nil {
+		return false, err
+	}
+	h.del(key)
+	return true, nil
+}
+
+// execHashKeys stores the keys of a hash in a register as a string, in
+// sorted order and each followed by a newline. The zero flag is set if
+// the hash is empty.
+func execHashKeys(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 2)
+	if err != nil {
+		return false, err
+	}
+	h, err := regs[1].GetHash()
+	if err != nil {
+		return false, err
+	}
+
+	var sb strings.Builder
+	for _, key := range h.Keys() {
+		sb.WriteString(key)
+		sb.WriteString("\n")
+	}
+	regs[0].SetStr(sb.String())
+	s.SetZero(len(h.Values) == 0)
+	return true, nil
+}
+
+// execAlloc allocates the number of bytes given by the second register
+// on the heap, and stores their address in the first one
+func execAlloc(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 2)
+	if err != nil {
+		return false, err
+	}
+	size, err := regs[1].GetInt()
+	if err != nil {
+		return false, err
+	}
+	addr, err := s.Alloc(size)
+	if err != nil {
+		return false, err
+	}
+	regs[0].SetInt(addr)
+	return true, nil
+}
+
+// execFree releases the heap memory at the address in a register
+func execFree(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 1)
+	if err != nil {
+		return false, err
+	}
+	addr, err := regs[0].GetInt()
+	if err != nil {
+		return false, err
+	}
+	if err = s.Free(addr); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func execNop(s State) (bool, error) {
+	skip(s)
+	return true, nil
+}
+
+func execAbort(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	msg, err := reg.GetStr()
+	if err != nil {
+		return false, err
+	}
+	return false, fmt.Errorf("abort: %s", msg)
+}
+
+func execRegStore(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 2)
+	if err != nil {
+		return false, err
+	}
+	return true, copyReg(regs[0], regs[1])
+}
+
+// copyReg copies the contents of src to dst
+func copyReg(dst, src *Register) error {
+	switch src.Type() {
+	case "int":
+		val, err := src.GetInt()
+		if err != nil {
+			return err
+		}
+		dst.SetInt(val)
+	case "str":
+		val, err := src.GetStr()
+		if err != nil {
+			return err
+		}
+		dst.SetStr(val)
+	case "float":
+		val, err := src.GetFloat()
+		if err != nil {
+			return err
+		}
+		dst.SetFloat(val)
+	case "hash":
+		val, err := src.GetHash()
+		if err != nil {
+			return err
+		}
+		dst.SetHash(val)
+	default:
+		return fmt.Errorf("invalid register type")
+	}
+	return nil
+}
+
+// conditionalMove returns the semantics of a move which copies a
+// register only if the Z-flag equals zero
+func conditionalMove(zero bool) Semantic {
+	return func(s State) (bool, error) {
+		skip(s)
+		regs, err := fetchRegs(s, 2)
+		if err != nil {
+			return false, err
+		}
+		if s.Zero() != zero {
+			return true, nil
+		}
+		return true, copyReg(regs[0], regs[1])
+	}
+}
+
+var (
+	execCmovZ  = conditionalMove(true)
+	execCmovNZ = conditionalMove(false)
+)
+
+func execPeek(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 2)
+	if err != nil {
+		return false, err
+	}
+
+	// get the address from the second register
+	addr, err := regs[1].GetInt()
+	if err != nil {
+		return false, err
+	}
+	if addr < 0 || addr >= MemSize {
+		return false, fmt.Errorf("address [%d] is out of range", addr)
+	}
+
+	// store the contents of the given address
+	v, err := s.ReadMem(addr)
+	if err != nil {
+		return false, err
+	}
+	regs[0].SetInt(int(v))
+	return true, nil
+}
+
+func execPoke(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 2)
+	if err != nil {
+		return false, err
+	}
+
+	// the first register contains the value which will be stored to memory (RAM)
+	val, err := regs[0].GetInt()
+	if err != nil {
+		return false, err
+	}
+	if val >= MemSize {
+		return false, fmt.Errorf("value [%d] is out of range", val)
+	}
+
+	// the second register contains the memory address where the value will be stored
+	addr, err := regs[1].GetInt()
+	if err != nil {
+		return false, err
+	}
+	if addr < 0 || addr >= MemSize {
+		return false, fmt.Errorf("address [%d] is out of range", addr)
+	}
+
+	return true, s.Store(addr, byte(val))
+}
+
+// execPeek16 reads the 16-bit word at the address in the second register
+// into the first one, least significant byte first like immediates. It
+// fails with 8-bit words, whose registers can't hold the word.
+func execPeek16(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 2)
+	if err != nil {
+		return false, err
+	}
+	if s.WordSize() < 16 {
+		return false, fmt.Errorf("PEEK16 needs words of at least 16 bits, not %d, use PEEK", s.WordSize())
+	}
+
+	addr, err := regs[1].GetInt()
+	if err != nil {
+		return false, err
+	}
+	if addr < 0 || addr+1 >= MemSize {
+		return false, fmt.Errorf("address [%d] is out of range", addr)
+	}
+
+	lo, err := s.ReadMem(addr)
+	if err != nil {
+		return false, err
+	}
+	hi, err := s.ReadMem(addr + 1)
+	if err != nil {
+		return false, err
+	}
+	regs[0].SetInt(toWord(s, int(lo)+int(hi)<<8))
+	return true, nil
+}
+
+// execPoke16 writes the value of the first register as a 16-bit word to
+// the address in the second one, least significant byte first. Negative
+// values are written in two's complement.
+func execPoke16(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 2)
+	if err != nil {
+		return false, err
+	}
+
+	val, err := regs[0].GetInt()
+	if err != nil {
+		return false, err
+	}
+	if val < -0x8000 || val > 0xffff {
+		return false, fmt.Errorf("value [%d] is out of range", val)
+	}
+
+	addr, err := regs[1].GetInt()
+	if err != nil {
+		return false, err
+	}
+	if addr < 0 || addr+1 >= MemSize {
+		return false, fmt.Errorf("address [%d] is out of range", addr)
+	}
+
+	if err = s.Store(addr, byte(val)); err != nil {
+		return false, err
+	}
+	return true, s.Store(addr+1, byte(val>>8))
+}
+
+// execPeekStr reads the NUL-terminated string at the address in the
+// second register into the first one
+func execPeekStr(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 2)
+	if err != nil {
+		return false, err
+	}
+
+	addr, err := regs[1].GetInt()
+	if err != nil {
+		return false, err
+	}
+	if addr < 0 || addr >= MemSize {
+		return false, fmt.Errorf("address [%d] is out of range", addr)
+	}
+
+	var buf []byte
+	for i := addr; ; i++ {
+		if i == MemSize {
+			return false, fmt.Errorf("string at [%d] isn't terminated before the end of RAM", addr)
+		}
+		b, err := s.ReadMem(i)
+		if err != nil {
+			return false, err
+		}
+		if b == 0 {
+			break
+		}
+		buf = append(buf, b)
+	}
+
+	regs[0].SetStr(string(buf))
+	return true, nil
+}
+
+// execPokeStr writes the string in the first register to the address in
+// the second one, followed by a NUL byte, so PEEK_STR reads it back
+func execPokeStr(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 2)
+	if err != nil {
+		return false, err
+	}
+
+	str, err := regs[0].GetStr()
+	if err != nil {
+		return false, err
+	}
+	if strings.IndexByte(str, 0) >= 0 {
+		return false, fmt.Errorf("string contains a NUL byte")
+	}
+
+	addr, err := regs[1].GetInt()
+	if err != nil {
+		return false, err
+	}
+	if addr < 0 || addr+len(str) >= MemSize {
+		return false, fmt.Errorf("string of %d bytes doesn't fit at address [%d]", len(str), addr)
+	}
+
+	for i := 0; i < len(str); i++ {
+		if err = s.Store(addr+i, str[i]); err != nil {
+			return false, err
+		}
+	}
+	return true, s.Store(addr+len(str), 0)
+}
+
+func execMemCpy(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 3)
+	if err != nil {
+		return false, err
+	}
+
+	dst, err := regs[0].GetInt()
+	if err != nil {
+		return false, err
+	}
+	src, err := regs[1].GetInt()
+	if err != nil {
+		return false, err
+	}
+	length, err := regs[2].GetInt()
+	if err != nil {
+		return false, err
+	}
+	if dst < 0 || src < 0 {
+		return false, fmt.Errorf("address [%d] is out of range", min(dst, src))
+	}
+
+	for i := 0; i < length; i++ {
+		if dst >= MemSize {
+			dst = 0
+		}
+		if src >= MemSize {
+			src = 0
+		}
+		b, err := s.ReadMem(src)
+		if err != nil {
+			return false, err
+		}
+		if err = s.Store(dst, b); err != nil {
+			return false, err
+		}
+		dst++
+		src++
+	}
+	return true, nil
+}
+
+// execMemFind searches the region of memory starting at the address in
+// the third register, as long as the fourth one, for the byte or the
+// string in the second register. The address of the first match is
+// stored in the first register. If there is none, the zero flag is set
+// and the register is left as it is.
+func execMemFind(s State) (bool, error) {
+	skip(s)
+	regs, err := fetchRegs(s, 4)
+	if err != nil {
+		return false, err
+	}
+
+	var pattern []byte
+	switch regs[1].Type() {
+	case "int":
+		b, _ := regs[1].GetInt()
+		if b < 0 || b > 0xff {
+			return false, fmt.Errorf("value [%d] is out of range", b)
+		}
+		pattern = []byte{byte(b)}
+	case "str":
+		str, _ := regs[1].GetStr()
+		if str == "" {
+			return false, fmt.Errorf("empty search pattern")
+		}
+		pattern = []byte(str)
+	default:
+		return false, fmt.Errorf("search pattern must be an integer or a string, not %s", regs[1].Type())
+	}
+
+	start, err := regs[2].GetInt()
+	if err != nil {
+		return false, err
+	}
+	length, err := regs[3].GetInt()
+	if err != nil {
+		return false, err
+	}
+	if start < 0 || length < 0 || start+length > MemSize {
+		return false, fmt.Errorf("region of %d bytes at address [%d] is out of range", length, start)
+	}
+
+	for addr := start; addr+len(pattern) <= start+length; addr++ {
+		match := true
+		for i, b := range pattern {
+			v, err := s.ReadMem(addr + i)
+			if err != nil {
+				return false, err
+			}
+			if v != b {
+				match = false
+				break
+			}
+		}
+		if match {
+			regs[0].SetInt(addr)
+			s.SetZero(false)
+			return true, nil
+		}
+	}
+	s.SetZero(true)
+	return true, nil
+}
+
+// execPush pushes the value of a register of any type, hashes are
+// pushed by reference like REG_STORE copies them
+func execPush(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	if err = s.PushObject(reg.obj); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func execPop(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	obj, err := s.PopObject()
+	if err != nil {
+		return false, fmt.Errorf("stackunderflow")
+	}
+
+	reg.setObject(obj)
+	return true, nil
+}
+
+func execCall(s State) (bool, error) {
+	skip(s)
+	addr := fetchInt(s)
+
+	// push the return address to the stack
+	if err := s.Push(s.IP()); err != nil {
+		return false, err
+	}
+
+	s.SetIP(addr)
+	return true, nil
+}
+
+func execCallReg(s State) (bool, error) {
+	skip(s)
+	reg, err := fetchReg(s)
+	if err != nil {
+		return false, err
+	}
+
+	addr, err := reg.GetInt()
+	if err != nil {
+		return false, err
+	}
+	if addr < 0 || addr >= MemSize {
+		return false, fmt.Errorf("address [%d] is out of range", addr)
+	}
+
+	// push the return address to the stack
+	if err := s.Push(s.IP()); err != nil {
+		return false, err
+	}
+
+	s.SetIP(addr)
+	return true, nil
+}
+
+func execRet(s State) (bool, error) {
+	obj, err := s.PopObject()
+	if err != nil {
+		return false, fmt.Errorf("stackunderflow")
+	}
+	ret, ok := obj.(*IntObject)
+	if !ok {
+		return false, fmt.Errorf("return address is a %s, not an integer", obj.Type())
+	}
+	addr := ret.Value
+
+	s.SetIP(addr)
+	return true, nil
+}
+
+func init() {
+	Semantics[opcode.EXIT] = execExit
+	Semantics[opcode.INT_STORE] = execIntStore
+	Semantics[opcode.INT_PRINT] = execIntPrint
+	Semantics[opcode.INT_TO_STR] = execIntToStr
+
+	Semantics[opcode.JMP] = execJmp
+	Semantics[opcode.JMP_Z] = execJmpZ
+	Semantics[opcode.JMP_NZ] = execJmpNZ
+	Semantics[opcode.JMP_S] = execJmpS
+	Semantics[opcode.JMP_NS] = execJmpNS
+
+	Semantics[opcode.ADD] = execAdd
+	Semantics[opcode.SUB] = execSub
+	Semantics[opcode.ADC] = execAdc
+	Semantics[opcode.SBC] = execSbc
+	Semantics[opcode.MUL] = execMul
+	Semantics[opcode.DIV] = execDiv
+	Semantics[opcode.INC] = execInc
+	Semantics[opcode.DEC] = execDec
+	Semantics[opcode.AND] = execAnd
+	Semantics[opcode.OR] = execOr
+	Semantics[opcode.XOR] = execXor
+	Semantics[opcode.MOD] = execMod
+	Semantics[opcode.MULH] = execMulh
+	Semantics[opcode.DIVMOD] = execDivmod
+	Semantics[opcode.ADD_PAIR] = execAddPair
+	Semantics[opcode.SUB_PAIR] = execSubPair
+	Semantics[opcode.MUL_PAIR] = execMulPair
+	Semantics[opcode.DIV_PAIR] = execDivPair
+
+	Semantics[opcode.HASH_NEW] = execHashNew
+	Semantics[opcode.HASH_SET] = execHashSet
+	Semantics[opcode.HASH_GET] = execHashGet
+	Semantics[opcode.HASH_DEL] = execHashDel
+	Semantics[opcode.HASH_KEYS] = execHashKeys
+	Semantics[opcode.ALLOC] = execAlloc
+	Semantics[opcode.FREE] = execFree
+	Semantics[opcode.SHL] = execShl
+	Semantics[opcode.SHR] = execShr
+	Semantics[opcode.SHL_IMM] = execShlImm
+	Semantics[opcode.SHR_IMM] = execShrImm
+	Semantics[opcode.ADD_IMM] = execAddImm
+	Semantics[opcode.SUB_IMM] = execSubImm
+	Semantics[opcode.MUL_IMM] = execMulImm
+	Semantics[opcode.DIV_IMM] = execDivImm
+	Semantics[opcode.MOD_IMM] = execModImm
+	Semantics[opcode.AND_IMM] = execAndImm
+	Semantics[opcode.OR_IMM] = execOrImm
+	Semantics[opcode.XOR_IMM] = execXorImm
+
+	Semantics[opcode.STR_STORE] = execStrStore
+	Semantics[opcode.STR_PRINT] = execStrPrint
+	Semantics[opcode.PRINT_ERR] = execPrintErr
+	Semantics[opcode.PRINT_CHAR] = execPrintChar
+	Semantics[opcode.CONCAT] = execConcat
+	Semantics[opcode.SPLIT_COUNT] = execSplitCount
+	Semantics[opcode.SPLIT_PART] = execSplitPart
+	Semantics[opcode.STR_TO_INT] = execStrToInt
+	Semantics[opcode.ORD] = execOrd
+	Semantics[opcode.CHR] = execChr
+
+	Semantics[opcode.CMP_INT] = execCmpInt
+	Semantics[opcode.CMP_STR] = execCmpStr
+	Semantics[opcode.CMP_REG] = execCmpReg
+	Semantics[opcode.IS_INT] = execIsInt
+	Semantics[opcode.IS_STR] = execIsStr
+
+	Semantics[opcode.FLOAT_STORE] = execFloatStore
+	Semantics[opcode.FLOAT_PRINT] = execFloatPrint
+	Semantics[opcode.FADD] = execFadd
+	Semantics[opcode.FSUB] = execFsub
+	Semantics[opcode.FMUL] = execFmul
+	Semantics[opcode.FDIV] = execFdiv
+	Semantics[opcode.IS_FLOAT] = execIsFloat
+	Semantics[opcode.INT_TO_FLOAT] = execIntToFloat
+	Semantics[opcode.FLOAT_TO_INT] = execFloatToInt
+
+	Semantics[opcode.NOP] = execNop
+	Semantics[opcode.REG_STORE] = execRegStore
+	Semantics[opcode.CMOV_Z] = execCmovZ
+	Semantics[opcode.CMOV_NZ] = execCmovNZ
+	Semantics[opcode.ABORT] = execAbort
+
+	Semantics[opcode.PEEK] = execPeek
+	Semantics[opcode.POKE] = execPoke
+	Semantics[opcode.PEEK16] = execPeek16
+	Semantics[opcode.POKE16] = execPoke16
+	Semantics[opcode.PEEK_STR] = execPeekStr
+	Semantics[opcode.POKE_STR] = execPokeStr
+	Semantics[opcode.MEM_FIND] = execMemFind
+	Semantics[opcode.MEM_CPY] = execMemCpy
+
+	Semantics[opcode.PUSH] = execPush
+	Semantics[opcode.POP] = execPop
+	Semantics[opcode.CALL] = execCall
+	Semantics[opcode.CALL_REG] = execCallReg
+	Semantics[opcode.RET] = execRet
+}
@@ -1,36 +1,122 @@
 package cpu
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // Stack contains return addresses when the call operation is being
-// completed. It can also be used for storing integers.
+// completed. It can also be used for storing the values of registers:
+// integers, strings, floats and references to hashes.
 type Stack struct {
-	entries []int
+	entries []Object
+
+	// strUsage is the approximate number of host bytes used by the
+	// strings on the stack, and hashes counts the entries referring to
+	// each hash, both kept up to date as entries come and go, so
+	// CPU.MemoryUsage doesn't walk the stack
+	strUsage int
+	hashes   map[*HashObject]int
 }
 
 func NewStack() *Stack {
 	return &Stack{}
 }
 
+// Push pushes an integer, e.g. a return address
 func (s *Stack) Push(val int) {
-	s.entries = append(s.entries, val)
+	s.PushObject(&IntObject{Value: val})
 }
 
+// Pop pops an integer, it is an error if the topmost value isn't one
 func (s *Stack) Pop() (int, error) {
 	if s.Empty() {
 		return 0, errors.New("pop from an empty stack")
 	}
 
+	top, ok := s.entries[len(s.entries)-1].(*IntObject)
+	if !ok {
+		return 0, fmt.Errorf("the top of the stack holds a %s, not an integer", s.entries[len(s.entries)-1].Type())
+	}
+	s.PopObject()
+	return top.Value, nil
+}
+
+// PushObject pushes the value of a register. Values are never changed
+// in place, except for hashes, which are shared like between registers.
+func (s *Stack) PushObject(obj Object) {
+	s.entries = append(s.entries, obj)
+	s.account(obj, 1)
+}
+
+// PopObject pops a value of any type
+func (s *Stack) PopObject() (Object, error) {
+	if s.Empty() {
+		return nil, errors.New("pop from an empty stack")
+	}
+
 	// get top
 	length := len(s.entries)
 	top := s.entries[length-1]
 
 	// truncate
 	s.entries = s.entries[:length-1]
+	s.account(top, -1)
 
 	return top, nil
 }
 
+// truncate drops all but the bottom n entries
+func (s *Stack) truncate(n int) {
+	for _, obj := range s.entries[n:] {
+		s.account(obj, -1)
+	}
+	s.entries = s.entries[:n]
+}
+
+// set replaces the entry with the given index, counting from the bottom
+func (s *Stack) set(i int, obj Object) {
+	s.account(s.entries[i], -1)
+	s.entries[i] = obj
+	s.account(obj, 1)
+}
+
+// clone returns a copy of the stack whose hashes are replaced by the
+// copies returned by copyHash
+func (s *Stack) clone(copyHash func(*HashObject) *HashObject) *Stack {
+	clone := &Stack{entries: make([]Object, len(s.entries)), strUsage: s.strUsage}
+	for i, obj := range s.entries {
+		if h, ok := obj.(*HashObject); ok {
+			obj = copyHash(h)
+		}
+		clone.entries[i] = obj
+	}
+	for h, n := range s.hashes {
+		if clone.hashes == nil {
+			clone.hashes = map[*HashObject]int{}
+		}
+		clone.hashes[copyHash(h)] = n
+	}
+	return clone
+}
+
+// account adds an entry holding obj to the memory usage of the stack if
+// n is 1, or removes it if n is -1
+func (s *Stack) account(obj Object, n int) {
+	switch o := obj.(type) {
+	case *StrObject:
+		s.strUsage += n * (objectOverhead + len(o.Value))
+	case *HashObject:
+		if s.hashes == nil {
+			s.hashes = map[*HashObject]int{}
+		}
+		s.hashes[o] += n
+		if s.hashes[o] == 0 {
+			delete(s.hashes, o)
+		}
+	}
+}
+
 func (s *Stack) Size() int {
 	return len(s.entries)
 }
@@ -38,3 +124,12 @@ func (s *Stack) Size() int {
 func (s *Stack) Empty() bool {
 	return len(s.entries) == 0
 }
+
+// formatEntry formats a stack entry: integers, e.g. return addresses, as
+// four hex digits, other values with their type
+func formatEntry(obj Object) string {
+	if i, ok := obj.(*IntObject); ok {
+		return fmt.Sprintf("%04x", i.Value)
+	}
+	return formatObject(obj)
+}
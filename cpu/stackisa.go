@@ -0,0 +1,136 @@
+package cpu
+
+import (
+	"fmt"
+	"vm/opcode"
+)
+
+// SetStackISA selects the stack-machine instruction set instead of the
+// register one, for programs compiled with it.
+func (c *CPU) SetStackISA(enabled bool) {
+	c.stackISA = enabled
+}
+
+// pop2 pops the two topmost values, returning them in the order they
+// were pushed
+func (c *CPU) pop2() (int, int, error) {
+	b, err := c.stack.Pop()
+	if err != nil {
+		return 0, 0, fmt.Errorf("stackunderflow")
+	}
+	a, err := c.stack.Pop()
+	if err != nil {
+		return 0, 0, fmt.Errorf("stackunderflow")
+	}
+	return a, b, nil
+}
+
+// pushWord pushes a value clamped to the range of the machine word,
+// the same way registers clamp their values
+func (c *CPU) pushWord(v int) {
+	if c.overflow != nil && (v < 0 || v > wordMax(c.wordSize)) && !c.overflow.hit {
+		c.overflow.hit = true
+		c.overflow.value = v
+	}
+
+	if v < 0 {
+		v = 0
+	} else if v > wordMax(c.wordSize) {
+		v = wordMax(c.wordSize)
+	}
+	c.stack.Push(v)
+}
+
+// stepStack executes the single stack-machine instruction at the current IP.
+// It returns false once an EXIT instruction has been executed.
+func (c *CPU) stepStack() (bool, error) {
+	op := c.mem[c.ip]
+
+	debugPrintf("%04x %02x [%s]\n", c.ip, op, opcode.StackName(op))
+
+	switch int(op) {
+	case opcode.STACK_EXIT:
+		return false, nil
+
+	case opcode.STACK_PUSH:
+		c.ip++
+		c.pushWord(c.readWord())
+
+	case opcode.STACK_POP:
+		c.ip++
+		if _, err := c.stack.Pop(); err != nil {
+			return false, fmt.Errorf("stackunderflow")
+		}
+
+	case opcode.STACK_DUP:
+		c.ip++
+		v, err := c.stack.Pop()
+		if err != nil {
+			return false, fmt.Errorf("stackunderflow")
+		}
+		c.stack.Push(v)
+		c.stack.Push(v)
+
+	case opcode.STACK_SWAP:
+		c.ip++
+		a, b, err := c.pop2()
+		if err != nil {
+			return false, err
+		}
+		c.stack.Push(b)
+		c.stack.Push(a)
+
+	case opcode.STACK_ADD, opcode.STACK_SUB, opcode.STACK_MUL, opcode.STACK_DIV:
+		c.ip++
+		a, b, err := c.pop2()
+		if err != nil {
+			return false, err
+		}
+
+		switch int(op) {
+		case opcode.STACK_ADD:
+			c.pushWord(a + b)
+		case opcode.STACK_SUB:
+			c.pushWord(a - b)
+		case opcode.STACK_MUL:
+			c.pushWord(a * b)
+		case opcode.STACK_DIV:
+			if b == 0 {
+				return false, fmt.Errorf("devision by zero")
+			}
+			c.pushWord(a / b)
+		}
+
+	case opcode.STACK_JMP:
+		c.ip++
+		c.ip = c.readInt()
+
+	case opcode.STACK_JMP_Z, opcode.STACK_JMP_NZ:
+		c.ip++
+		addr := c.readInt()
+
+		v, err := c.stack.Pop()
+		if err != nil {
+			return false, fmt.Errorf("stackunderflow")
+		}
+
+		if (v == 0) == (int(op) == opcode.STACK_JMP_Z) {
+			c.ip = addr
+		}
+
+	case opcode.STACK_PRINT:
+		c.ip++
+		v, err := c.stack.Pop()
+		if err != nil {
+			return false, fmt.Errorf("stackunderflow")
+		}
+		if err = c.Print(fmt.Sprintf("%d\n", v)); err != nil {
+			return false, err
+		}
+
+	default:
+		return false, fmt.Errorf("unknown stack-machine opcode %02x", op)
+	}
+
+	return true, nil
+}
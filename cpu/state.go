@@ -0,0 +1,143 @@
+package cpu
+
+import (
+	"fmt"
+	"vm/header"
+)
+
+// The CPU implements State, so it executes the shared instruction semantics.
+var _ State = (*CPU)(nil)
+
+// Reg returns the given register
+func (c *CPU) Reg(n int) (*Register, error) {
+	if n == header.RegSP || n == header.RegIP {
+		return c.pseudoRegister(n)
+	}
+	if n < 0 || n >= len(c.regs) {
+		return nil, fmt.Errorf("register [%d] is out of range", n)
+	}
+	return c.regs[n], nil
+}
+
+// Load returns the byte at the given address
+func (c *CPU) Load(addr int) byte {
+	return c.mem[addr]
+}
+
+// ReadMem returns the byte at the given address as the program reads it,
+// i.e. from the device mapped there, if any
+func (c *CPU) ReadMem(addr int) (byte, error) {
+	if m, ok := c.device(addr); ok {
+		return m.read(addr)
+	}
+	return c.mem[addr], nil
+}
+
+// Store sets the byte at the given address, or writes it to the device
+// mapped there, unless it is inside of a program loaded with ReadOnlyCode.
+// Writes to devices are only reported in dry-run mode.
+func (c *CPU) Store(addr int, v byte) error {
+	if m, ok := c.device(addr); ok {
+		return c.writeDevice(m, addr, v)
+	}
+	if err := c.checkWritable(addr); err != nil {
+		return err
+	}
+	c.mem[addr] = v
+	c.literals.invalidate(addr, addr+1)
+	c.noteWrite(addr)
+	return nil
+}
+
+// IP returns the instruction pointer
+func (c *CPU) IP() int {
+	return c.ip
+}
+
+// SetIP sets the instruction pointer
+func (c *CPU) SetIP(ip int) {
+	c.ip = ip
+}
+
+// Zero returns the zero flag
+func (c *CPU) Zero() bool {
+	return c.flags.z
+}
+
+// SetZero sets the zero flag
+func (c *CPU) SetZero(z bool) {
+	c.flags.z = z
+}
+
+// Sign returns the sign flag
+func (c *CPU) Sign() bool {
+	return c.flags.s
+}
+
+// SetSign sets the sign flag
+func (c *CPU) SetSign(s bool) {
+	c.flags.s = s
+}
+
+// Carry returns the carry flag
+func (c *CPU) Carry() bool {
+	return c.flags.c
+}
+
+// SetCarry sets the carry flag
+func (c *CPU) SetCarry(carry bool) {
+	c.flags.c = carry
+}
+
+// Overflow returns the overflow flag
+func (c *CPU) Overflow() bool {
+	return c.flags.v
+}
+
+// SetOverflow sets the overflow flag
+func (c *CPU) SetOverflow(v bool) {
+	c.flags.v = v
+}
+
+// FP returns the frame pointer, the size of the stack after ENTER saved
+// the previous frame pointer, or zero outside of frames
+func (c *CPU) FP() int {
+	return c.fp
+}
+
+// Push pushes a value onto the stack
+func (c *CPU) Push(v int) error {
+	return c.push(&IntObject{Value: v})
+}
+
+// Pop pops a value from the stack
+func (c *CPU) Pop() (int, error) {
+	if !c.ramStack {
+		return c.stack.Pop()
+	}
+	obj, err := c.pop()
+	if err != nil {
+		return 0, err
+	}
+	return obj.(*IntObject).Value, nil
+}
+
+// PushObject pushes the value of a register onto the stack
+func (c *CPU) PushObject(obj Object) error {
+	return c.push(obj)
+}
+
+// PopObject pops a value of any type from the stack
+func (c *CPU) PopObject() (Object, error) {
+	return c.pop()
+}
+
+// Print writes the given string to STDOUT
+func (c *CPU) Print(s string) error {
+	return c.output(c.STDOUT, s)
+}
+
+// PrintErr writes the given string to STDERR
+func (c *CPU) PrintErr(s string) error {
+	return c.output(c.STDERR, s)
+}
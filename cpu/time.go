@@ -0,0 +1,48 @@
+package cpu
+
+import "fmt"
+
+// Clocks selectable by the operand of TIME
+const (
+	// TimeUnix is the Unix time in seconds
+	TimeUnix = 0
+
+	// TimeElapsed is the number of milliseconds since Run was first
+	// called after loading the program
+	TimeElapsed = 1
+
+	// TimeInstructions is the number of instructions executed since
+	// loading the program, which unlike the other clocks is the same on
+	// every run
+	TimeInstructions = 2
+)
+
+// execTime stores the reading of the clock selected by the operand in a
+// register. Readings larger than a word wrap around, so the difference
+// of two readings is still right as long as it fits.
+func (c *CPU) execTime() (bool, error) {
+	c.ip++
+	reg, err := fetchReg(c)
+	if err != nil {
+		return false, err
+	}
+
+	var v int
+	switch clock := fetch(c); clock {
+	case TimeUnix:
+		v = int(c.clock.Now().Unix())
+	case TimeElapsed:
+		if c.started.IsZero() {
+			// stepped without Run
+			c.started = c.clock.Now()
+		}
+		v = int(c.clock.Now().Sub(c.started).Milliseconds())
+	case TimeInstructions:
+		v = c.executed
+	default:
+		return false, fmt.Errorf("unknown clock: %d", clock)
+	}
+
+	reg.SetInt(toWord(c, v))
+	return true, nil
+}
@@ -0,0 +1,81 @@
+package cpu
+
+import (
+	"fmt"
+	"time"
+)
+
+// timer raises an interrupt every given number of executed instructions,
+// see SetTimer
+type timer struct {
+	// vector is the interrupt vector raised when the timer fires
+	vector int
+
+	// every is the number of instructions between two interrupts, the
+	// timer is disabled if zero
+	every int
+
+	// left is the number of instructions until the timer fires
+	left int
+}
+
+// SetTimer raises the interrupt with the given vector every given number
+// of executed instructions, counting those of its handler, which lets a
+// program preempt itself, e.g. to switch between tasks or to check it
+// is making progress. As with Interrupt, a handler must be registered
+// for the vector via the ON_INTERRUPT trap, and the timer fires again
+// only once the handler returned. The timer is disabled if instructions
+// is zero, and it restarts when the program is loaded.
+func (c *CPU) SetTimer(vector, instructions int) error {
+	if vector < 0 || vector >= NumInterrupts {
+		return fmt.Errorf("invalid interrupt vector: %d", vector)
+	}
+	if instructions < 0 {
+		return fmt.Errorf("invalid timer period of %d instructions", instructions)
+	}
+	c.timer = timer{vector: vector, every: instructions, left: instructions}
+	return nil
+}
+
+// tick counts an executed instruction, raising the interrupt of the
+// timer once its period is over
+func (c *CPU) tick() {
+	if c.timer.every == 0 {
+		return
+	}
+	c.timer.left--
+	if c.timer.left <= 0 {
+		c.timer.left = c.timer.every
+		c.Interrupt(c.timer.vector)
+	}
+}
+
+// StartTimer raises the interrupt with the given vector every interval
+// of wall time, see SetTimer to count instructions instead, which unlike
+// this timer is deterministic. The returned function stops the timer.
+func (c *CPU) StartTimer(vector int, interval time.Duration) (stop func(), err error) {
+	if vector < 0 || vector >= NumInterrupts {
+		return nil, fmt.Errorf("invalid interrupt vector: %d", vector)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid timer interval: %s", interval)
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				c.Interrupt(vector)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}, nil
+}
@@ -14,7 +14,29 @@ import (
 type TrapFunction func(c *CPU, num int) error
 
 // TRAPS is an array of trap functions
-var TRAPS [maxMemSize]TrapFunction
+var TRAPS [MemSize]TrapFunction
+
+// Numbers of the built-in traps
+const (
+	TrapStrLen        = 0
+	TrapReadString    = 1
+	TrapRemoveNewLine = 2
+	TrapAtExit        = 3
+	TrapHexDump       = 4
+	TrapCheckpoint    = 5
+	TrapRestore       = 6
+	TrapOnInterrupt   = 7
+	TrapPortRead      = 8
+	TrapPortWrite     = 9
+	TrapIsDigit       = 10
+	TrapIsAlpha       = 11
+	TrapIsSpace       = 12
+	TrapToUpper       = 13
+	TrapToLower       = 14
+	TrapGroupDigits   = 15
+	TrapFixedPoint    = 16
+	TrapPerfCounters  = 17
+)
 
 // TrapNOP is the default trap function for any trap IDs that haven't
 // explicitly been set up
@@ -64,15 +86,113 @@ func RemoveNewLineTrap(c *CPU, num int) error {
 	return nil
 }
 
+// AtExitTrap registers a subroutine which is called when the program
+// executes EXIT or when the execution is cancelled by the host.
+// Subroutines are called in the reverse order of their registration.
+//
+// Input: the address of the subroutine in register #0.
+//
+// Output: none.
+func AtExitTrap(c *CPU, num int) error {
+	addr, err := c.regs[0].GetInt()
+	if err != nil {
+		return err
+	}
+	c.exitHooks = append(c.exitHooks, addr)
+	return nil
+}
+
+// HexDumpTrap prints a range of memory in the format of xxd.
+//
+// Input: the address in register #0, the number of bytes in register #1.
+//
+// Output: none.
+func HexDumpTrap(c *CPU, num int) error {
+	addr, err := c.regs[0].GetInt()
+	if err != nil {
+		return err
+	}
+	length, err := c.regs[1].GetInt()
+	if err != nil {
+		return err
+	}
+	var sb strings.Builder
+	if err = c.hexDump(&sb, addr, length); err != nil {
+		return err
+	}
+	return c.Print(sb.String())
+}
+
+// CheckpointTrap saves the state of the CPU under a name, so it can be
+// restored later by the RESTORE trap, e.g. to backtrack in a search.
+// Like setjmp, it sets register #0 to zero when the state is saved, and
+// to the value passed to RESTORE when execution resumes after it.
+//
+// Input: the name of the checkpoint in register #0.
+//
+// Output: sets register #0 with zero.
+func CheckpointTrap(c *CPU, num int) error {
+	name, err := c.regs[0].GetStr()
+	if err != nil {
+		return err
+	}
+	c.regs[0].SetInt(0)
+	c.saveCheckpoint(name)
+	return nil
+}
+
+// RestoreTrap restores the registers, flags, memory and stacks saved by
+// the CHECKPOINT trap, and resumes execution after it. The checkpoint is
+// kept, so it can be restored again.
+//
+// Input: the name of the checkpoint in register #0, the value to pass
+// in register #1. A value of zero is passed as one, so the program can
+// tell the resumed execution apart from saving the checkpoint.
+//
+// Output: sets register #0 with the passed value.
+func RestoreTrap(c *CPU, num int) error {
+	name, err := c.regs[0].GetStr()
+	if err != nil {
+		return err
+	}
+	value, err := c.regs[1].GetInt()
+	if err != nil {
+		return err
+	}
+	if value == 0 {
+		value = 1
+	}
+	if !c.restoreCheckpoint(name) {
+		return fmt.Errorf("unknown checkpoint: %q", name)
+	}
+	c.regs[0].SetInt(value)
+	return nil
+}
+
 func init() {
 	// default to all traps being "empty", i.e. configured to
 	// contain a reference to a function that just reports an error
-	for i := 0; i < maxMemSize; i++ {
+	for i := 0; i < MemSize; i++ {
 		TRAPS[i] = TrapNOP
 	}
 
 	// set up implemented traps
-	TRAPS[0] = StrLenTrap
-	TRAPS[1] = ReadStringTrap
-	TRAPS[2] = RemoveNewLineTrap
+	TRAPS[TrapStrLen] = StrLenTrap
+	TRAPS[TrapReadString] = ReadStringTrap
+	TRAPS[TrapRemoveNewLine] = RemoveNewLineTrap
+	TRAPS[TrapAtExit] = AtExitTrap
+	TRAPS[TrapHexDump] = HexDumpTrap
+	TRAPS[TrapCheckpoint] = CheckpointTrap
+	TRAPS[TrapRestore] = RestoreTrap
+	TRAPS[TrapOnInterrupt] = OnInterruptTrap
+	TRAPS[TrapPortRead] = PortReadTrap
+	TRAPS[TrapPortWrite] = PortWriteTrap
+	TRAPS[TrapIsDigit] = IsDigitTrap
+	TRAPS[TrapIsAlpha] = IsAlphaTrap
+	TRAPS[TrapIsSpace] = IsSpaceTrap
+	TRAPS[TrapToUpper] = ToUpperTrap
+	TRAPS[TrapToLower] = ToLowerTrap
+	TRAPS[TrapGroupDigits] = GroupDigitsTrap
+	TRAPS[TrapFixedPoint] = FixedPointTrap
+	TRAPS[TrapPerfCounters] = PerfCountersTrap
 }
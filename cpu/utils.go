@@ -6,12 +6,13 @@ import (
 	"regexp"
 )
 
-// debugPrintf outputs when "DEBUG=1"
+// debugPrintf outputs to STDERR when "DEBUG=1", so the trace doesn't
+// mix with the output of the program
 func debugPrintf(format string, args ...any) {
 	if os.Getenv("DEBUG") == "" {
 		return
 	}
-	fmt.Printf(format, args...)
+	fmt.Fprintf(os.Stderr, format, args...)
 }
 
 // splitCommand splits a string into tokens but keeps anything "quoted" together.
@@ -35,7 +36,7 @@ func splitCommand(input string) []string {
 		strSlice = append(strSlice, trimStringEnds(s, '"'))
 	}
 
-	return result
+	return strSlice
 }
 
 // trimStringEnds removes balanced characters around a string
@@ -0,0 +1,121 @@
+package cpu
+
+import (
+	"fmt"
+	"hash/fnv"
+	"vm/header"
+	"vm/opcode"
+)
+
+// maxWatchdogStates limits the number of states the watchdog remembers,
+// it forgets all of them once there are more
+const maxWatchdogStates = 1 << 16
+
+// Watchdog is an Observer detecting probable infinite loops.
+//
+// Whenever a jump goes backwards it records the state of the CPU, i.e.
+// the IP, the flags, the registers and the top of the stack. If the same
+// state is reached again and again the program can't make any progress,
+// unless it performs I/O or writes memory, which makes the watchdog
+// forget the states recorded so far.
+type Watchdog struct {
+	limit  int
+	abort  bool
+	warn   func(msg string)
+	seen   map[uint64]int
+	warned map[int]bool
+}
+
+// NewWatchdog creates a watchdog reporting a loop once the same state
+// was reached limit times. The report is passed to warn, and if abort
+// is set the execution is stopped as well.
+func NewWatchdog(limit int, abort bool, warn func(msg string)) *Watchdog {
+	return &Watchdog{
+		limit:  limit,
+		abort:  abort,
+		warn:   warn,
+		seen:   map[uint64]int{},
+		warned: map[int]bool{},
+	}
+}
+
+// Before forgets the recorded states if the instruction has a side effect
+func (w *Watchdog) Before(c *CPU, ip int) error {
+	if c.stackISA {
+		return nil
+	}
+
+	switch int(c.mem[ip]) {
+	case opcode.INT_PRINT, opcode.STR_PRINT, opcode.PRINT_ERR, opcode.PRINT_CHAR, opcode.READ_INT, opcode.SYSTEM, opcode.TRAP, opcode.DUMP,
+		opcode.INT_RAND, opcode.RAND_RANGE, opcode.TIME, opcode.POKE, opcode.POKE16, opcode.POKE_STR, opcode.MEM_CPY,
+		opcode.BANK_SELECT:
+		clear(w.seen)
+	case opcode.PEEK, opcode.PEEK16, opcode.PEEK_STR, opcode.MEM_FIND:
+		// polling a device makes progress once it changes
+		if len(c.devices) > 0 {
+			clear(w.seen)
+		}
+	}
+	return nil
+}
+
+// After records the state after a backward jump, and reports a loop if
+// it was seen too often
+func (w *Watchdog) After(c *CPU, ip int) error {
+	if c.stackISA || c.ip > ip {
+		return nil
+	}
+
+	if len(w.seen) >= maxWatchdogStates {
+		clear(w.seen)
+	}
+
+	state := w.hash(c)
+	w.seen[state]++
+	if w.seen[state] < w.limit || w.warned[c.ip] {
+		return nil
+	}
+
+	if w.abort {
+		// the runtime error reports the location of the jump
+		return fmt.Errorf("probable infinite loop")
+	}
+
+	msg := fmt.Sprintf("probable infinite loop at 0x%04x", c.ip)
+	if label, offset, ok := header.ResolveSymbol(c.symbols, c.ip); ok {
+		if offset == 0 {
+			msg += fmt.Sprintf(" (label :%s)", label)
+		} else {
+			msg += fmt.Sprintf(" (label :%s+0x%x)", label, offset)
+		}
+	}
+
+	w.warned[c.ip] = true
+	if w.warn != nil {
+		w.warn(msg)
+	}
+	return nil
+}
+
+// hash returns a hash of the state of the CPU
+func (w *Watchdog) hash(c *CPU) uint64 {
+	h := fnv.New64a()
+	entries := c.stackEntries()
+	fmt.Fprintf(h, "%d %v %d", c.ip, c.flags, len(entries))
+	if n := len(entries); n > 0 {
+		fmt.Fprintf(h, " %s", formatEntry(entries[n-1]))
+	}
+	for _, r := range c.regs {
+		switch obj := r.obj.(type) {
+		case *IntObject:
+			fmt.Fprintf(h, " i%d", obj.Value)
+		case *StrObject:
+			fmt.Fprintf(h, " s%q", obj.Value)
+		case *FloatObject:
+			fmt.Fprintf(h, " f%g", obj.Value)
+		case *HashObject:
+			fmt.Fprintf(h, " h%s", obj)
+		}
+	}
+	return h.Sum64()
+}
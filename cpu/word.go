@@ -0,0 +1,80 @@
+package cpu
+
+import (
+	"fmt"
+	"vm/header"
+)
+
+// defaultWordSize is the size of the machine word in bits, unless
+// the program header asks for a different one
+const defaultWordSize = header.DefaultWordSize
+
+// wordMax returns the largest integer of a word with the given size
+func wordMax(bits int) int {
+	return 1<<bits - 1
+}
+
+// wordRange returns the smallest and the largest integer of a word with
+// the given size, using two's complement for signed words
+func wordRange(bits int, signed bool) (int, int) {
+	if signed {
+		return -(1 << (bits - 1)), 1<<(bits-1) - 1
+	}
+	return 0, wordMax(bits)
+}
+
+// SetWordSize sets the size of the machine word in bits: 8, 16 or 32.
+//
+// The word size affects the range registers are clamped to, where
+// INC and DEC wrap around, and the width of immediate integer operands
+// in the bytecode, so it must match the word size the program was
+// compiled for. Zero selects the default of 16 bits.
+func (c *CPU) SetWordSize(bits int) error {
+	if bits == 0 {
+		bits = defaultWordSize
+	}
+
+	switch bits {
+	case 8, 16, 32:
+	default:
+		return fmt.Errorf("unsupported word size: %d bits", bits)
+	}
+
+	c.wordSize = bits
+	c.resizeRegisters()
+	return nil
+}
+
+// SetSigned sets whether registers hold signed integers, e.g.
+// -32768-32767 rather than 0-65535 for 16-bit words.
+//
+// In signed mode immediate integer operands are read as two's
+// complement, INC and DEC wrap around between the smallest and the
+// largest integer, and comparisons and the sign flag consider negative
+// numbers. Shifts operate on the two's complement bits.
+// The stack-machine instruction set is always unsigned.
+func (c *CPU) SetSigned(enabled bool) {
+	c.signed = enabled
+	c.resizeRegisters()
+}
+
+// Signed returns true if registers hold signed integers
+func (c *CPU) Signed() bool {
+	return c.signed
+}
+
+// resizeRegisters applies the range of the word to the registers,
+// clamping the integers they contain
+func (c *CPU) resizeRegisters() {
+	for _, r := range c.regs {
+		r.min, r.max = wordRange(c.wordSize, c.signed)
+		if v, err := r.GetInt(); err == nil {
+			r.SetInt(v)
+		}
+	}
+}
+
+// WordSize returns the size of the machine word in bits
+func (c *CPU) WordSize() int {
+	return c.wordSize
+}
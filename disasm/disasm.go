@@ -0,0 +1,309 @@
+// Package disasm decodes the bytecode of the register instruction set
+// back into instructions, for tools which inspect compiled programs.
+package disasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"vm/header"
+	"vm/opcode"
+)
+
+// operand kinds used in the layouts
+const (
+	// register number, one byte
+	reg = 'r'
+
+	// immediate integer as wide as the machine word
+	word = 'w'
+
+	// two byte number, e.g. an address or a trap number
+	addr = 'a'
+
+	// string prefixed by its two byte length
+	str = 's'
+
+	// one byte number, e.g. the bits of a shift
+	imm8 = 'b'
+
+	// floating-point number, the 8 bytes of an IEEE 754 double
+	float = 'f'
+
+	// signed two byte number, e.g. an offset from the frame pointer
+	offset = 'o'
+)
+
+// layouts describes the operands following each opcode
+var layouts map[int]string
+
+func init() {
+	layouts = map[int]string{
+		opcode.EXIT:       "",
+		opcode.INT_STORE:  "rw",
+		opcode.INT_PRINT:  "r",
+		opcode.INT_TO_STR: "r",
+		opcode.INT_RAND:   "r",
+		opcode.EXIT_IMM:   "b",
+		opcode.EXIT_REG:   "r",
+		opcode.RAND_RANGE: "rr",
+		opcode.TIME:       "rb",
+		opcode.READ_INT:   "r",
+
+		opcode.JMP:    "a",
+		opcode.JMP_Z:  "a",
+		opcode.JMP_NZ: "a",
+		opcode.JMP_S:  "a",
+		opcode.JMP_NS: "a",
+
+		opcode.ADD: "rrr",
+		opcode.SUB: "rrr",
+		opcode.MUL: "rrr",
+		opcode.DIV: "rrr",
+		opcode.INC: "r",
+		opcode.DEC: "r",
+		opcode.AND: "rrr",
+		opcode.OR:  "rrr",
+		opcode.XOR: "rrr",
+		opcode.MOD: "rrr",
+		opcode.ADC: "rrr",
+		opcode.SBC: "rrr",
+
+		opcode.MULH:   "rrr",
+		opcode.DIVMOD: "rrrr",
+
+		opcode.ADD_PAIR: "rrr",
+		opcode.SUB_PAIR: "rrr",
+		opcode.MUL_PAIR: "rrr",
+		opcode.DIV_PAIR: "rrr",
+
+		opcode.HASH_NEW:  "r",
+		opcode.HASH_SET:  "rrr",
+		opcode.HASH_GET:  "rrr",
+		opcode.HASH_DEL:  "rr",
+		opcode.HASH_KEYS: "rr",
+
+		opcode.ALLOC: "rr",
+		opcode.FREE:  "r",
+
+		opcode.ADD_IMM: "rrw",
+		opcode.SUB_IMM: "rrw",
+		opcode.MUL_IMM: "rrw",
+		opcode.DIV_IMM: "rrw",
+		opcode.MOD_IMM: "rrw",
+		opcode.AND_IMM: "rrw",
+		opcode.OR_IMM:  "rrw",
+		opcode.XOR_IMM: "rrw",
+
+		opcode.SHL:     "rrr",
+		opcode.SHR:     "rrr",
+		opcode.SHL_IMM: "rrb",
+		opcode.SHR_IMM: "rrb",
+
+		opcode.FLOAT_STORE:  "rf",
+		opcode.FLOAT_PRINT:  "r",
+		opcode.FADD:         "rrr",
+		opcode.FSUB:         "rrr",
+		opcode.FMUL:         "rrr",
+		opcode.FDIV:         "rrr",
+		opcode.IS_FLOAT:     "r",
+		opcode.INT_TO_FLOAT: "r",
+		opcode.FLOAT_TO_INT: "r",
+
+		opcode.STR_STORE:   "rs",
+		opcode.STR_PRINT:   "r",
+		opcode.CONCAT:      "rrr",
+		opcode.SYSTEM:      "r",
+		opcode.STR_TO_INT:  "r",
+		opcode.ORD:         "rr",
+		opcode.CHR:         "rr",
+		opcode.PRINT_ERR:   "r",
+		opcode.PRINT_CHAR:  "r",
+		opcode.SPLIT_COUNT: "rrr",
+		opcode.SPLIT_PART:  "rrrr",
+
+		opcode.CMP_INT: "rw",
+		opcode.CMP_STR: "rs",
+		opcode.CMP_REG: "rr",
+		opcode.IS_INT:  "r",
+		opcode.IS_STR:  "r",
+
+		opcode.NOP:       "",
+		opcode.REG_STORE: "rr",
+		opcode.CMOV_Z:    "rr",
+		opcode.CMOV_NZ:   "rr",
+		opcode.ABORT:     "r",
+		opcode.STR_POOL:  "ra",
+		opcode.DUMP:      "",
+
+		opcode.PEEK:        "rr",
+		opcode.POKE:        "rr",
+		opcode.PEEK16:      "rr",
+		opcode.POKE16:      "rr",
+		opcode.PEEK_STR:    "rr",
+		opcode.POKE_STR:    "rr",
+		opcode.MEM_CPY:     "rrr",
+		opcode.MEM_FIND:    "rrrr",
+		opcode.BANK_SELECT: "r",
+
+		opcode.PUSH:     "r",
+		opcode.POP:      "r",
+		opcode.CALL:     "a",
+		opcode.CALL_REG: "r",
+		opcode.RET:      "",
+		opcode.ENTER:    "a",
+		opcode.LEAVE:    "",
+		opcode.SP_GET:   "r",
+		opcode.SP_SET:   "r",
+
+		opcode.LOAD_LOCAL:  "ro",
+		opcode.STORE_LOCAL: "ro",
+		opcode.TRAP:        "a",
+	}
+}
+
+// Instruction is a decoded instruction
+type Instruction struct {
+	// Addr is the address of the opcode
+	Addr int
+
+	// Opcode is the opcode of the instruction
+	Opcode int
+
+	// Regs are the register operands, in the order they are encoded
+	Regs []int
+
+	// Imm is the immediate integer, address or trap number, if any
+	Imm int
+
+	// Str is the string operand, if any
+	Str string
+
+	// Float is the floating-point operand, if any
+	Float float64
+
+	// Size is the number of bytes of the instruction including its opcode
+	Size int
+}
+
+// Name returns the name of the opcode, e.g. "INT_STORE"
+func (i Instruction) Name() string {
+	return opcode.NewOpcode(byte(i.Opcode)).String()
+}
+
+// String returns the instruction in a readable form,
+// e.g. "INT_STORE #1, 0x002a"
+func (i Instruction) String() string {
+	var operands []string
+	for _, kind := range layouts[i.Opcode] {
+		switch kind {
+		case reg:
+			operands = append(operands, regName(i.Regs[len(operands)]))
+		case word, addr:
+			operands = append(operands, fmt.Sprintf("0x%04x", i.Imm))
+		case imm8, offset:
+			operands = append(operands, fmt.Sprintf("%d", i.Imm))
+		case str:
+			operands = append(operands, fmt.Sprintf("%q", i.Str))
+		case float:
+			operands = append(operands, strconv.FormatFloat(i.Float, 'g', -1, 64))
+		}
+	}
+	if len(operands) == 0 {
+		return i.Name()
+	}
+	return i.Name() + " " + strings.Join(operands, ", ")
+}
+
+// regName returns the name of a register operand, e.g. "#1" or "#sp"
+func regName(n int) string {
+	switch n {
+	case header.RegSP:
+		return "#sp"
+	case header.RegIP:
+		return "#ip"
+	}
+	return fmt.Sprintf("#%d", n)
+}
+
+// Decode decodes the instruction at the given address of code.
+// The word size determines the width of immediate integers.
+func Decode(code []byte, at int, wordSize int) (Instruction, error) {
+	if at < 0 || at >= len(code) {
+		return Instruction{}, fmt.Errorf("address %04x is outside of the program", at)
+	}
+
+	ins := Instruction{Addr: at, Opcode: int(code[at])}
+	layout, ok := layouts[ins.Opcode]
+	if !ok {
+		return ins, fmt.Errorf("unknown opcode %02x at %04x", code[at], at)
+	}
+
+	pos := at + 1
+	read := func(n int) ([]byte, error) {
+		if pos+n > len(code) {
+			return nil, fmt.Errorf("truncated %s at %04x", ins.Name(), at)
+		}
+		b := code[pos : pos+n]
+		pos += n
+		return b, nil
+	}
+
+	for _, kind := range layout {
+		switch kind {
+		case reg:
+			b, err := read(1)
+			if err != nil {
+				return ins, err
+			}
+			ins.Regs = append(ins.Regs, int(b[0]))
+		case word:
+			b, err := read(wordSize / 8)
+			if err != nil {
+				return ins, err
+			}
+			for i, v := range b {
+				ins.Imm += int(v) << (8 * i)
+			}
+		case imm8:
+			b, err := read(1)
+			if err != nil {
+				return ins, err
+			}
+			ins.Imm = int(b[0])
+		case addr:
+			b, err := read(2)
+			if err != nil {
+				return ins, err
+			}
+			ins.Imm = int(b[0]) + int(b[1])*256
+		case offset:
+			b, err := read(2)
+			if err != nil {
+				return ins, err
+			}
+			ins.Imm = int(int16(binary.LittleEndian.Uint16(b)))
+		case str:
+			b, err := read(2)
+			if err != nil {
+				return ins, err
+			}
+			s, err := read(int(b[0]) + int(b[1])*256)
+			if err != nil {
+				return ins, err
+			}
+			ins.Str = string(s)
+		case float:
+			b, err := read(8)
+			if err != nil {
+				return ins, err
+			}
+			ins.Float = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+	}
+
+	ins.Size = pos - at
+	return ins, nil
+}
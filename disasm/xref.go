@@ -0,0 +1,97 @@
+package disasm
+
+import (
+	"sort"
+	"vm/opcode"
+)
+
+// Disassemble decodes the instructions of code one after another,
+// starting at its first byte, which is loaded at the given address. The
+// bytes which don't start an instruction, e.g. data embedded in the
+// code, are skipped and their addresses returned as data.
+func Disassemble(code []byte, base, wordSize int) (instrs []Instruction, data []int) {
+	for at := 0; at < len(code); {
+		ins, err := Decode(code, at, wordSize)
+		if err != nil {
+			data = append(data, base+at)
+			at++
+			continue
+		}
+		ins.Addr += base
+		instrs = append(instrs, ins)
+		at += ins.Size
+	}
+	return instrs, data
+}
+
+// RefKind is how an instruction references an address
+type RefKind string
+
+const (
+	// RefJump is a jump to the address, taken or not
+	RefJump RefKind = "jump"
+
+	// RefCall is a call of the subroutine at the address
+	RefCall RefKind = "call"
+
+	// RefAddress is an integer store of the address, e.g. of a label
+	// whose data is read via PEEK or which is called via CALL_REG
+	RefAddress RefKind = "address"
+)
+
+// branchKinds are the kinds of references of the instructions branching
+// to their immediate address
+var branchKinds = map[int]RefKind{
+	opcode.JMP:    RefJump,
+	opcode.JMP_Z:  RefJump,
+	opcode.JMP_NZ: RefJump,
+	opcode.JMP_S:  RefJump,
+	opcode.JMP_NS: RefJump,
+	opcode.CALL:   RefCall,
+}
+
+// Reference returns how the instruction references the address in its
+// immediate, if it may: jumps and calls branch to it, and INT_STORE may
+// store it
+func (i Instruction) Reference() (RefKind, bool) {
+	if kind, ok := branchKinds[i.Opcode]; ok {
+		return kind, true
+	}
+	return RefAddress, i.Opcode == opcode.INT_STORE
+}
+
+// Ref is an instruction referencing an address
+type Ref struct {
+	Instruction Instruction
+	Kind        RefKind
+}
+
+// XRefs maps addresses to the instructions referencing them, in the
+// order of the instructions. Jumps and calls reference their targets.
+// Integers stored in registers are taken for addresses if they are the
+// address of one of the labels or the target of a jump or a call, since
+// any other integer might just as well be a number.
+func XRefs(instrs []Instruction, labels map[string]int) map[int][]Ref {
+	refs := map[int][]Ref{}
+	known := map[int]bool{}
+	for _, addr := range labels {
+		known[addr] = true
+	}
+
+	for _, ins := range instrs {
+		if kind, ok := ins.Reference(); ok && kind != RefAddress {
+			refs[ins.Imm] = append(refs[ins.Imm], Ref{Instruction: ins, Kind: kind})
+			known[ins.Imm] = true
+		}
+	}
+	for _, ins := range instrs {
+		if kind, ok := ins.Reference(); ok && kind == RefAddress && known[ins.Imm] {
+			refs[ins.Imm] = append(refs[ins.Imm], Ref{Instruction: ins, Kind: kind})
+		}
+	}
+
+	for _, r := range refs {
+		sort.SliceStable(r, func(i, j int) bool { return r[i].Instruction.Addr < r[j].Instruction.Addr })
+	}
+	return refs
+}
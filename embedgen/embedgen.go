@@ -0,0 +1,190 @@
+// Package embedgen generates Go source embedding compiled programs.
+//
+// Applications embedding the virtual machine can ship their guest
+// programs compiled at build time, without compiling them at runtime or
+// reading any files:
+//
+//	//go:generate go run vm embedgen -package guests -o guests.go hello.in
+//
+// The generated file declares a byte slice for every program, which
+// vm.Exec runs, and a map of its labels to their addresses. It doesn't
+// import anything, so the package containing it stays free of
+// dependencies.
+package embedgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"vm/compiler"
+	"vm/lexer"
+)
+
+// Options are the settings the programs are compiled with
+type Options struct {
+	// ISA is the instruction set, "register" or "stack"
+	ISA string
+
+	// WordSize is the size of the machine word in bits
+	WordSize int
+
+	// Signed selects signed integer registers
+	Signed bool
+
+	// StringPool stores string literals in the string pool rather than
+	// inline
+	StringPool bool
+
+	// Aliases map custom mnemonics to keywords, see
+	// compiler.Compiler.SetAliases
+	Aliases map[string]string
+}
+
+// Program is a compiled program to embed
+type Program struct {
+	// Name is the Go identifier of the program, see Identifier
+	Name string
+
+	// Source is the path of the file the program was compiled from
+	Source string
+
+	// Program is the bytecode prefixed by its header, as written by
+	// compile
+	Program []byte
+
+	// Symbols maps the labels of the program to their addresses
+	Symbols map[string]int
+}
+
+// Compile compiles the source file at the given path into a program
+// named after the file
+func Compile(path string, opts Options) (Program, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return Program{}, err
+	}
+
+	c := compiler.New(lexer.New(string(source)))
+	if err = c.SetISA(opts.ISA); err != nil {
+		return Program{}, err
+	}
+	if err = c.SetWordSize(opts.WordSize); err != nil {
+		return Program{}, err
+	}
+	if err = c.SetSigned(opts.Signed); err != nil {
+		return Program{}, err
+	}
+	if err = c.SetAliases(opts.Aliases); err != nil {
+		return Program{}, err
+	}
+	c.SetStringPool(opts.StringPool)
+	c.SetBaseDir(filepath.Dir(path))
+	if err = c.Compile(); err != nil {
+		return Program{}, fmt.Errorf("compiling %s: %w", path, err)
+	}
+
+	h, err := c.Header().Encode()
+	if err != nil {
+		return Program{}, fmt.Errorf("compiling %s: %w", path, err)
+	}
+	return Program{
+		Name:    Identifier(path),
+		Source:  path,
+		Program: append(h, c.Output()...),
+		Symbols: c.Labels(),
+	}, nil
+}
+
+// Identifier returns the exported Go identifier of the program compiled
+// from the file at the given path, e.g. HelloWorld for hello_world.in
+func Identifier(path string) string {
+	base := filepath.Base(path)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+
+	var sb strings.Builder
+	for _, word := range strings.FieldsFunc(base, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		runes := []rune(word)
+		sb.WriteRune(unicode.ToUpper(runes[0]))
+		sb.WriteString(string(runes[1:]))
+	}
+
+	name := sb.String()
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "Program" + name
+	}
+	return name
+}
+
+// Generate writes the Go source of the package with the given name,
+// declaring the programs. It fails on names which aren't exported
+// identifiers or are declared twice.
+func Generate(w io.Writer, pkg string, programs []Program) error {
+	if !token.IsIdentifier(pkg) {
+		return fmt.Errorf("invalid package name %q", pkg)
+	}
+
+	declared := map[string]string{}
+	declare := func(name, source string) error {
+		if !token.IsIdentifier(name) || !token.IsExported(name) {
+			return fmt.Errorf("%s: %q isn't an exported Go identifier", source, name)
+		}
+		if other, ok := declared[name]; ok {
+			return fmt.Errorf("%s and %s both declare %s", other, source, name)
+		}
+		declared[name] = source
+		return nil
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by \"vm embedgen\"; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n", pkg)
+
+	for _, p := range programs {
+		if err := declare(p.Name, p.Source); err != nil {
+			return err
+		}
+		if err := declare(p.Name+"Symbols", p.Source); err != nil {
+			return err
+		}
+
+		source := filepath.ToSlash(p.Source)
+		fmt.Fprintf(&buf, "\n// %s is the program compiled from %s, prefixed by its header\n", p.Name, source)
+		fmt.Fprintf(&buf, "var %s = []byte{", p.Name)
+		for i, b := range p.Program {
+			if i%16 == 0 {
+				buf.WriteString("\n")
+			}
+			fmt.Fprintf(&buf, "0x%02x, ", b)
+		}
+		buf.WriteString("\n}\n")
+
+		labels := make([]string, 0, len(p.Symbols))
+		for label := range p.Symbols {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+
+		fmt.Fprintf(&buf, "\n// %sSymbols maps the labels of %s to their addresses\n", p.Name, source)
+		fmt.Fprintf(&buf, "var %sSymbols = map[string]int{\n", p.Name)
+		for _, label := range labels {
+			fmt.Fprintf(&buf, "%q: 0x%04x,\n", label, p.Symbols[label])
+		}
+		buf.WriteString("}\n")
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
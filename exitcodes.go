@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/subcommands"
+	"io"
+	"io/fs"
+	"vm/cpu"
+)
+
+// Exit codes of the subcommands beyond the ones of the subcommands
+// package, so wrapper scripts can tell the kinds of failures apart.
+// See exitCodesHelp for their meanings.
+const (
+	exitCompile subcommands.ExitStatus = iota + 3
+	exitRuntime
+	exitTimeout
+	exitPolicy
+	exitIO
+)
+
+// exitCodesHelp documents the exit codes in the top level help
+const exitCodesHelp = `
+Exit codes:
+	0	success
+	1	other failures, e.g. invalid programs, failed test cases or self-checks
+	2	invalid usage, e.g. unknown flags
+	3	compile error
+	4	runtime fault of the program
+	5	the program ran out of time
+	6	the program requires capabilities not allowed by -allow, uses
+		more memory than -max-memory, or prints more than -max-output
+		with -max-output-abort
+	7	I/O error reading or writing a file
+
+A program ending with an exit code, e.g. "exit 1", makes run and execute
+exit with it instead, which may be any of the codes above.
+`
+
+// explainExitCodes appends the documentation of the exit codes to the
+// top level help
+func explainExitCodes(cdr *subcommands.Commander) {
+	explain := cdr.Explain
+	cdr.Explain = func(w io.Writer) {
+		explain(w)
+		fmt.Fprint(w, exitCodesHelp)
+	}
+}
+
+// exitStatus returns the exit code of a failure with the given error,
+// which is fallback unless the error is of a specific kind
+func exitStatus(err error, fallback subcommands.ExitStatus) subcommands.ExitStatus {
+	var pathErr *fs.PathError
+	switch {
+	case errors.Is(err, cpu.ErrTimeout):
+		return exitTimeout
+	case errors.Is(err, cpu.ErrNotAllowed), errors.Is(err, cpu.ErrMemoryLimit), errors.Is(err, cpu.ErrOutputLimit):
+		return exitPolicy
+	case errors.As(err, &pathErr):
+		return exitIO
+	}
+	return fallback
+}
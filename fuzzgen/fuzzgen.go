@@ -0,0 +1,198 @@
+// Package fuzzgen generates random, but structurally valid, programs.
+//
+// The generated programs are meant to stress the compiler and the
+// interpreter. They are valid in the sense that they always compile
+// and terminate with EXIT:
+//
+//   - jumps only go forward, so there are no loops
+//   - subroutines only call subroutines defined after them, so there is
+//     no recursion, and every PUSH is paired with a POP before the RET
+//   - registers #0-#6 always contain integers, #10-#14 always contain
+//     strings, so instructions never fail because of a type mismatch
+//   - #7-#9 are scratch registers used to address a data area at the end
+//     of the program, so PEEK, POKE and MEM_CPY never touch the code
+package fuzzgen
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// Options control the shape of the generated program
+type Options struct {
+	// Instructions is the approximate number of instructions in the
+	// main program and in every subroutine
+	Instructions int
+
+	// Subroutines is the number of subroutines
+	Subroutines int
+}
+
+// scratchSize is the size of the data area used by memory instructions
+const scratchSize = 32
+
+// strings which are stored in string registers, escaped as in the source
+var words = []string{"apple", "banana", "cherry", "", "a", "hello, world", "\\n"}
+
+type generator struct {
+	r      *rand.Rand
+	opts   Options
+	sb     strings.Builder
+	labels int // number of labels generated so far
+}
+
+// Generate returns the source of a random program
+func Generate(r *rand.Rand, opts Options) string {
+	g := &generator{r: r, opts: opts}
+
+	g.line(".meta name \"fuzzgen\"")
+
+	// make sure the string registers contain strings
+	for reg := 10; reg <= 14; reg++ {
+		g.emit("store #%d, \"%s\"", reg, g.word())
+	}
+
+	g.block(0)
+	g.emit("exit")
+
+	for i := 0; i < opts.Subroutines; i++ {
+		g.line("")
+		g.line(":sub_%d", i)
+		g.block(i + 1)
+		g.emit("ret")
+	}
+
+	g.line("")
+	g.line(":scratch")
+	data := make([]string, scratchSize)
+	for i := range data {
+		data[i] = fmt.Sprintf("0x%02x", r.Intn(256))
+	}
+	g.emit("data %s", strings.Join(data, ", "))
+
+	return g.sb.String()
+}
+
+// block generates straight-line code with forward jumps.
+// Only subroutines with an index of at least firstSub may be called.
+func (g *generator) block(firstSub int) {
+	// labels which have been jumped to, but not placed yet
+	var pending []string
+
+	for i := 0; i < g.opts.Instructions; i++ {
+		// place a pending label now and then
+		if len(pending) > 0 && g.r.Intn(4) == 0 {
+			g.line(":%s", pending[0])
+			pending = pending[1:]
+		}
+
+		switch g.r.Intn(12) {
+		case 0:
+			label := fmt.Sprintf("l_%d", g.labels)
+			g.labels++
+			pending = append(pending, label)
+			g.emit("%s %s", []string{"jmp", "jmp_z", "jmp_nz"}[g.r.Intn(3)], label)
+		case 1:
+			if firstSub < g.opts.Subroutines {
+				g.emit("call sub_%d", firstSub+g.r.Intn(g.opts.Subroutines-firstSub))
+			} else {
+				g.emit("nop")
+			}
+		default:
+			g.instruction()
+		}
+	}
+
+	// every jump target must exist
+	for _, label := range pending {
+		g.line(":%s", label)
+	}
+}
+
+// instruction generates a single instruction which can't fail
+func (g *generator) instruction() {
+	switch g.r.Intn(16) {
+	case 0:
+		g.emit("store #%d, %d", g.intReg(), g.r.Intn(0x10000))
+	case 1:
+		op := []string{"add", "sub", "mul", "and", "or", "xor"}[g.r.Intn(6)]
+		g.emit("%s #%d, #%d, #%d", op, g.intReg(), g.intReg(), g.intReg())
+	case 2:
+		g.emit("%s #%d", []string{"inc", "dec"}[g.r.Intn(2)], g.intReg())
+	case 3:
+		g.emit("cmp #%d, %d", g.intReg(), g.r.Intn(0x100))
+	case 4:
+		g.emit("cmp #%d, #%d", g.intReg(), g.intReg())
+	case 5:
+		g.emit("cmp #%d, \"%s\"", g.strReg(), g.word())
+	case 6:
+		g.emit("store #%d, \"%s\"", g.sourceStrReg(), g.word())
+	case 7:
+		// concat results are never used as concat inputs,
+		// so strings can't grow exponentially
+		g.emit("concat #%d, #%d, #%d", 13+g.r.Intn(2), g.sourceStrReg(), g.sourceStrReg())
+	case 8:
+		g.emit("%s #%d", []string{"is_int", "is_str"}[g.r.Intn(2)], g.anyReg())
+	case 9:
+		g.emit("store #%d, #%d", g.intReg(), g.intReg())
+	case 10:
+		a, b := g.intReg(), g.intReg()
+		g.emit("push #%d", a)
+		g.emit("pop #%d", b)
+	case 11:
+		g.emit("store #9, scratch")
+		g.emit("store #8, %d", g.r.Intn(scratchSize))
+		g.emit("add #9, #9, #8")
+		g.emit("peek #%d, #9", g.intReg())
+	case 12:
+		g.emit("store #9, scratch")
+		g.emit("store #8, %d", g.r.Intn(scratchSize))
+		g.emit("add #9, #9, #8")
+		g.emit("store #8, %d", g.r.Intn(256))
+		g.emit("poke #8, #9")
+	case 13:
+		length := 1 + g.r.Intn(scratchSize/2)
+		g.emit("store #9, scratch")
+		g.emit("store #8, %d", g.r.Intn(scratchSize-length+1))
+		g.emit("add #8, #9, #8")
+		g.emit("store #7, %d", length)
+		g.emit("mem_cpy #8, #9, #7")
+	case 14:
+		g.emit("print_int #%d", g.intReg())
+	default:
+		g.emit("print_str #%d", g.strReg())
+	}
+}
+
+func (g *generator) intReg() int {
+	return g.r.Intn(7)
+}
+
+func (g *generator) strReg() int {
+	return 10 + g.r.Intn(5)
+}
+
+func (g *generator) sourceStrReg() int {
+	return 10 + g.r.Intn(3)
+}
+
+func (g *generator) anyReg() int {
+	if g.r.Intn(2) == 0 {
+		return g.intReg()
+	}
+	return g.strReg()
+}
+
+func (g *generator) word() string {
+	return words[g.r.Intn(len(words))]
+}
+
+func (g *generator) emit(format string, args ...any) {
+	g.line("    "+format, args...)
+}
+
+func (g *generator) line(format string, args ...any) {
+	g.sb.WriteString(fmt.Sprintf(format, args...))
+	g.sb.WriteString("\n")
+}
@@ -2,4 +2,4 @@ module vm
 
 go 1.22.2
 
-require github.com/google/subcommands v1.2.0 // indirect
+require github.com/google/subcommands v1.2.0
@@ -0,0 +1,60 @@
+package grade
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Diff returns the minimal set of lines which have to be removed from
+// expected and added to make it equal to actual, as computed by the
+// Levenshtein distance over lines. Removed lines are prefixed by "-",
+// added lines by "+", each followed by its line number.
+// Lines common to both are omitted.
+func Diff(expected, actual string) []string {
+	a, b := splitLines(expected), splitLines(actual)
+
+	// dist[i][j] is the number of edits turning a[i:] into b[j:]
+	dist := make([][]int, len(a)+1)
+	for i := range dist {
+		dist[i] = make([]int, len(b)+1)
+	}
+	for i := len(a); i >= 0; i-- {
+		for j := len(b); j >= 0; j-- {
+			switch {
+			case i == len(a):
+				dist[i][j] = len(b) - j
+			case j == len(b):
+				dist[i][j] = len(a) - i
+			case a[i] == b[j]:
+				dist[i][j] = dist[i+1][j+1]
+			default:
+				dist[i][j] = 1 + min(dist[i+1][j], dist[i][j+1])
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || (i < len(a) && dist[i+1][j] <= dist[i][j+1]):
+			lines = append(lines, fmt.Sprintf("-%d %s", i+1, a[i]))
+			i++
+		default:
+			lines = append(lines, fmt.Sprintf("+%d %s", j+1, b[j]))
+			j++
+		}
+	}
+	return lines
+}
+
+// splitLines splits text into lines, ignoring the final newline
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,210 @@
+// Package grade runs a compiled program against a set of test cases,
+// each giving the input of the program and the output it must produce,
+// and scores the program by the number of cases it passes.
+//
+// A directory of test cases contains for every case NAME:
+//
+//   - NAME.input, which is read by the input trap (may be missing)
+//   - NAME.expected, the output the program must produce
+//   - NAME.limits, optional limits overriding the defaults, one per line:
+//     "timeout 2s", "allow system,file" or "max_output 4096"
+package grade
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"vm/cpu"
+	"vm/header"
+)
+
+// Limits restrict a single run of the program
+type Limits struct {
+	// Timeout is the maximum run time of the program
+	Timeout time.Duration
+
+	// Allowed are the capabilities the program may use
+	Allowed header.Capability
+
+	// MaxOutput is the number of bytes the program may print, it is
+	// stopped once it prints more, unlimited if zero
+	MaxOutput int
+}
+
+// DefaultLimits are used for cases which don't have limits of their own:
+// one second of run time, no access to the host and 1 MiB of output.
+var DefaultLimits = Limits{Timeout: time.Second, Allowed: 0, MaxOutput: 1 << 20}
+
+// Case is a single test case
+type Case struct {
+	Name     string
+	Input    []byte
+	Expected []byte
+	Limits   Limits
+}
+
+// Result is the outcome of a single test case
+type Result struct {
+	Name     string `json:"name"`
+	Passed   bool   `json:"passed"`
+	Error    string `json:"error,omitempty"`
+	Output   string `json:"output"`
+	Expected string `json:"expected"`
+
+	// Diff lists the lines differing after normalization, see Diff
+	Diff []string `json:"diff,omitempty"`
+
+	// Millis is the run time of the program in milliseconds
+	Millis int64 `json:"millis"`
+
+	// Truncated is set if the program was stopped as it printed more
+	// than Limits.MaxOutput, Output ends with cpu.TruncationMarker then
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Report is the outcome of all test cases
+type Report struct {
+	Program string   `json:"program"`
+	Passed  int      `json:"passed"`
+	Total   int      `json:"total"`
+	Score   float64  `json:"score"`
+	Cases   []Result `json:"cases"`
+}
+
+// LoadCases reads the test cases in the given directory, sorted by name.
+// The given limits are used for cases without a limits file.
+func LoadCases(dir string, defaults Limits) ([]Case, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.expected"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no test cases (*.expected) found in %s", dir)
+	}
+	sort.Strings(matches)
+
+	var cases []Case
+	for _, expected := range matches {
+		base := strings.TrimSuffix(expected, ".expected")
+		tc := Case{Name: filepath.Base(base), Limits: defaults}
+
+		if tc.Expected, err = os.ReadFile(expected); err != nil {
+			return nil, err
+		}
+
+		tc.Input, err = os.ReadFile(base + ".input")
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		limits, err := os.ReadFile(base + ".limits")
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err = parseLimits(string(limits), &tc.Limits); err != nil {
+			return nil, fmt.Errorf("%s.limits: %s", base, err.Error())
+		}
+
+		cases = append(cases, tc)
+	}
+	return cases, nil
+}
+
+// parseLimits applies the limits given one per line to l
+func parseLimits(text string, l *Limits) error {
+	for n, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("line %d: expected a name and a value", n+1)
+		}
+
+		var err error
+		switch fields[0] {
+		case "timeout":
+			l.Timeout, err = time.ParseDuration(fields[1])
+		case "allow":
+			l.Allowed, err = header.ParseCapabilities(fields[1])
+		case "max_output":
+			l.MaxOutput, err = strconv.Atoi(fields[1])
+		default:
+			err = fmt.Errorf("unknown limit %q", fields[0])
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %s", n+1, err.Error())
+		}
+	}
+	return nil
+}
+
+// Grade runs the compiled program in the named file against every case,
+// each on a fresh CPU, comparing the outputs normalized by norm.
+func Grade(program string, cases []Case, norm Normalization) Report {
+	report := Report{Program: program, Total: len(cases)}
+
+	for _, tc := range cases {
+		result := Run(program, tc, norm)
+		if result.Passed {
+			report.Passed++
+		}
+		report.Cases = append(report.Cases, result)
+	}
+
+	if report.Total > 0 {
+		report.Score = float64(report.Passed) / float64(report.Total)
+	}
+	return report
+}
+
+// Run runs the compiled program in the named file against a single case
+func Run(program string, tc Case, norm Normalization) Result {
+	result := Result{Name: tc.Name, Expected: string(tc.Expected)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tc.Limits.Timeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	c := cpu.NewCPU()
+	c.SetAllowedCapabilities(tc.Limits.Allowed)
+	c.SetOutputLimit(tc.Limits.MaxOutput, true)
+	c.SetContext(ctx)
+	c.STDIN = bufio.NewReader(bytes.NewReader(tc.Input))
+	c.STDOUT = bufio.NewWriter(&out)
+
+	// only the output is graded, errors of the program aren't
+	c.STDERR = bufio.NewWriter(io.Discard)
+
+	start := time.Now()
+	err := c.ReadFile(program)
+	if err == nil {
+		err = c.Run()
+	}
+	result.Millis = time.Since(start).Milliseconds()
+
+	c.STDOUT.Flush()
+	result.Output = out.String()
+	result.Truncated = c.OutputTruncated()
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	output, expected := norm.Output(result.Output), norm.Expected(result.Expected)
+	result.Passed = output == expected
+	if !result.Passed {
+		result.Diff = Diff(expected, output)
+	}
+	return result
+}
@@ -0,0 +1,89 @@
+package grade
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Normalization relaxes the comparison of the output with the expected
+// output, as formatting rarely matches exactly
+type Normalization struct {
+	// TrimSpace ignores whitespace at the end of lines, and empty lines
+	// at the end of the output
+	TrimSpace bool
+
+	// IgnoreCase compares letters case-insensitively
+	IgnoreCase bool
+
+	// Numeric compares numbers by their value, e.g. "0x1f" and "31".
+	// Numbers prefixed by "0x" are hexadecimal, the radix of the others
+	// is given by OutputRadix and ExpectedRadix.
+	Numeric bool
+
+	// OutputRadix is the radix of unprefixed numbers in the output of
+	// the program, 16 if zero, as print_int prints hexadecimal numbers
+	OutputRadix int
+
+	// ExpectedRadix is the radix of unprefixed numbers in the expected
+	// output, 10 if zero
+	ExpectedRadix int
+}
+
+// number matches prefixed hexadecimal numbers, and unprefixed numbers
+// of any radix up to 16
+var number = regexp.MustCompile(`\b(0[xX][0-9a-fA-F]+|[0-9a-fA-F]+)\b`)
+
+// Output returns the output of the program normalized as configured
+func (n Normalization) Output(text string) string {
+	return n.apply(text, n.OutputRadix, 16)
+}
+
+// Expected returns the expected output normalized as configured
+func (n Normalization) Expected(text string) string {
+	return n.apply(text, n.ExpectedRadix, 10)
+}
+
+// apply returns the text normalized as configured, reading unprefixed
+// numbers in the given radix, or def if it is zero
+func (n Normalization) apply(text string, radix, def int) string {
+	if n.TrimSpace {
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(line, " \t\r")
+		}
+		text = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+		if text != "" {
+			text += "\n"
+		}
+	}
+
+	if radix == 0 {
+		radix = def
+	}
+
+	if n.Numeric {
+		text = number.ReplaceAllStringFunc(text, func(s string) string {
+			var v int64
+			var err error
+			if len(s) > 2 && (s[1] == 'x' || s[1] == 'X') {
+				v, err = strconv.ParseInt(s[2:], 16, 64)
+			} else if strings.ContainsAny(s, "0123456789") {
+				v, err = strconv.ParseInt(s, radix, 64)
+			} else {
+				// a word made of hexadecimal digits, e.g. "add"
+				return s
+			}
+			if err != nil {
+				// too large or not a number in the radix, compare as is
+				return s
+			}
+			return strconv.FormatInt(v, 10)
+		})
+	}
+
+	if n.IgnoreCase {
+		text = strings.ToLower(text)
+	}
+	return text
+}
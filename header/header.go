@@ -0,0 +1,467 @@
+// Package header contains the header which prefixes compiled programs.
+//
+// The header carries information about the program which isn't part of
+// the bytecode itself, e.g. metadata, the entry point, the capabilities
+// the program requires, and the labels used as debug info.
+//
+// The layout is:
+//
+//	0xff 'V' 'M'  magic
+//	version       one byte
+//	size          two bytes, the size of the whole header
+//	sections      tag (one byte), length (two bytes), payload
+//
+// All numbers are stored the same way as in the bytecode, i.e. as a
+// remainder and a quotient of 256 (little-endian).
+//
+// Bytecode without the magic is treated as a raw program with an
+// empty header, so older files still load.
+//
+// Unknown sections are skipped by readers. Information which can't be
+// safely ignored, i.e. the features changing how the bytecode must be
+// interpreted, bumps the version instead, so older readers reject the
+// program rather than misinterpreting it.
+package header
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultWordSize is the word size of programs which don't record one
+const DefaultWordSize = 16
+
+// DefaultNumRegisters is the number of registers of the CPU unless it
+// is configured otherwise, which the compiler validates register
+// operands against by default. MaxNumRegisters is the most registers
+// the one byte of a register operand can address, the numbers above
+// being reserved for the pseudo-registers.
+const (
+	DefaultNumRegisters = 32
+	MaxNumRegisters     = 0xfe
+)
+
+// RegSP and RegIP are the register operands of the pseudo-registers #sp
+// and #ip, which read the stack pointer and the address of the
+// instruction. Writing #sp moves the stack pointer, #ip is read-only.
+const (
+	RegSP = 0xfe
+	RegIP = 0xff
+)
+
+// BankStart is the lowest address of the window of memory BANK_SELECT
+// switches between banks, and BankSize its size. Bank zero is the memory
+// the program is loaded into, the others start out zeroed unless the
+// program places code or data into them, see Header.Banks.
+const (
+	BankStart = 0x4000
+	BankSize  = 0x2000
+	NumBanks  = 16
+)
+
+// Version is the newest version of the header format.
+//
+// Version 1 has no feature flags, version 2 adds them. Programs which
+// don't use any feature are still written as version 1.
+const Version = 2
+
+// magic identifies a program which starts with a header.
+// 0xff is not a valid opcode, so a raw program can't start with it.
+var magic = []byte{0xff, 'V', 'M'}
+
+// size of the fixed part of the header: magic, version and size
+const fixedSize = 6
+
+// section tags
+const (
+	tagEntry        = 0x01
+	tagCapabilities = 0x02
+	tagMeta         = 0x03
+	tagSymbol       = 0x04
+	tagFeatures     = 0x05
+	tagWordSize     = 0x06
+	tagStrings      = 0x07
+	tagBank         = 0x08
+	tagRegisters    = 0x09
+)
+
+// Capability is a sensitive feature a program requires
+type Capability int
+
+const (
+	// CapSystem is required to execute host binaries via SYSTEM
+	CapSystem Capability = 1 << iota
+
+	// CapFile is required to access host files via traps
+	CapFile
+
+	// CapNet is required to access the network via traps
+	CapNet
+
+	// CapAll contains every capability
+	CapAll = CapSystem | CapFile | CapNet
+)
+
+// TrapCapabilities lists the traps which give access to sensitive
+// features, so the compiler can record them as requirements, the CPU
+// can enforce them and skip the traps in dry-run mode, and the taint
+// analysis treats their results as untrusted. The other built-in traps
+// only use the console and the state of the program. The numbers are
+// those of the traps in the cpu package, which imports this one.
+var TrapCapabilities = map[int]Capability{
+	// the ports of PORT_READ and PORT_WRITE are named pipes or Unix
+	// sockets, which the program can't tell apart
+	8: CapFile | CapNet,
+	9: CapFile | CapNet,
+}
+
+// capabilityNames is used to present capabilities to users
+var capabilityNames = []struct {
+	cap  Capability
+	name string
+}{
+	{CapSystem, "SYSTEM"},
+	{CapFile, "FILE"},
+	{CapNet, "NET"},
+}
+
+func (c Capability) String() string {
+	var names []string
+	for _, n := range capabilityNames {
+		if c&n.cap != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// ParseCapabilities parses a comma-separated list of capability names,
+// e.g. "system,net". The special names "all" and "none" are accepted too.
+func ParseCapabilities(s string) (Capability, error) {
+	var caps Capability
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(strings.ToUpper(name))
+		switch name {
+		case "", "NONE":
+			continue
+		case "ALL":
+			caps |= CapAll
+			continue
+		}
+
+		found := false
+		for _, n := range capabilityNames {
+			if n.name == name {
+				caps |= n.cap
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown capability: %s", strings.ToLower(name))
+		}
+	}
+	return caps, nil
+}
+
+// Feature is a change of the bytecode format which a runtime must
+// understand to execute a program using it
+type Feature int
+
+const (
+	// FeatSignedInts marks programs using signed integer registers
+	FeatSignedInts Feature = 1 << iota
+
+	// FeatFixedWidth marks programs using the fixed-width instruction encoding
+	FeatFixedWidth
+
+	// FeatFloat marks programs using the floating-point register type
+	FeatFloat
+
+	// FeatWordSize marks programs using a word size other than 16 bits
+	FeatWordSize
+
+	// FeatStackISA marks programs using the stack-machine instruction set
+	FeatStackISA
+
+	// FeatStringPool marks programs loading strings from the string pool
+	FeatStringPool
+
+	// FeatRAMStack marks programs keeping the stack in RAM
+	FeatRAMStack
+
+	// FeatBanks marks programs placing code or data into memory banks
+	FeatBanks
+
+	// FeatRegisters marks programs using more than the 15 registers of
+	// older runtimes, see Header.Registers
+	FeatRegisters
+)
+
+// SupportedFeatures contains the features understood by this runtime
+const SupportedFeatures = FeatSignedInts | FeatFloat | FeatWordSize | FeatStackISA | FeatStringPool | FeatRAMStack | FeatBanks | FeatRegisters
+
+var featureNames = []struct {
+	feat Feature
+	name string
+}{
+	{FeatSignedInts, "SIGNED_INTS"},
+	{FeatFixedWidth, "FIXED_WIDTH"},
+	{FeatFloat, "FLOAT"},
+	{FeatWordSize, "WORD_SIZE"},
+	{FeatStackISA, "STACK_ISA"},
+	{FeatStringPool, "STRING_POOL"},
+	{FeatRAMStack, "RAM_STACK"},
+	{FeatBanks, "BANKS"},
+	{FeatRegisters, "REGISTERS"},
+}
+
+func (f Feature) String() string {
+	var names []string
+	known := Feature(0)
+	for _, n := range featureNames {
+		known |= n.feat
+		if f&n.feat != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if unknown := f &^ known; unknown != 0 {
+		names = append(names, fmt.Sprintf("unknown(0x%04x)", int(unknown)))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// Header describes a compiled program
+type Header struct {
+	// Version is the version of the header format
+	Version int
+
+	// Entry is the address at which the execution starts
+	Entry int
+
+	// Capabilities are the sensitive features used by the program
+	Capabilities Capability
+
+	// Features are the bytecode format features used by the program
+	Features Feature
+
+	// WordSize is the size of the machine word in bits, which also is
+	// the width of immediate integer operands
+	WordSize int
+
+	// Registers is the number of registers the program uses, i.e. its
+	// highest register plus one, which the CPU has to have. It is zero
+	// for programs which don't record it.
+	Registers int
+
+	// Meta contains the values of the ".meta" directives
+	Meta map[string]string
+
+	// Symbols maps labels to addresses
+	Symbols map[string]int
+
+	// Strings is the string pool. Each string is stored as its length
+	// (two bytes) followed by its bytes, and referenced by its offset.
+	Strings []byte
+
+	// Banks are the initial contents of the memory banks other than
+	// zero, loaded at BankStart when the bank is selected. Together they
+	// have to fit in the header.
+	Banks map[int][]byte
+}
+
+// New creates an empty header
+func New() *Header {
+	return &Header{
+		Version:  Version,
+		WordSize: DefaultWordSize,
+		Meta:     make(map[string]string),
+		Symbols:  make(map[string]int),
+	}
+}
+
+// maxSize is the largest header which can be encoded, as the size and
+// the section lengths are stored as 16-bit numbers
+const maxSize = 0xffff
+
+// Encode returns the binary form of the header, or an error if it
+// doesn't fit into the size which can be recorded
+func (h *Header) Encode() ([]byte, error) {
+	var sections []byte
+	var err error
+
+	add := func(tag byte, payload []byte) {
+		if len(payload) > maxSize && err == nil {
+			err = fmt.Errorf("header section 0x%02x is %d bytes long, the maximum is %d", tag, len(payload), maxSize)
+		}
+		sections = append(sections, tag)
+		sections = appendInt(sections, len(payload))
+		sections = append(sections, payload...)
+	}
+
+	add(tagEntry, appendInt(nil, h.Entry))
+	add(tagCapabilities, appendInt(nil, int(h.Capabilities)))
+
+	if h.WordSize != 0 && h.WordSize != DefaultWordSize {
+		add(tagWordSize, appendInt(nil, h.WordSize))
+	}
+
+	if h.Registers > 0 {
+		add(tagRegisters, appendInt(nil, h.Registers))
+	}
+
+	version := 1
+	if h.Features != 0 {
+		add(tagFeatures, appendInt(nil, int(h.Features)))
+		version = 2
+	}
+
+	for _, key := range sortedKeys(h.Meta) {
+		add(tagMeta, []byte(key+"\x00"+h.Meta[key]))
+	}
+
+	for _, name := range sortedKeys(h.Symbols) {
+		add(tagSymbol, append(appendInt(nil, h.Symbols[name]), name...))
+	}
+
+	if len(h.Strings) > 0 {
+		add(tagStrings, h.Strings)
+	}
+
+	banks := make([]int, 0, len(h.Banks))
+	for bank := range h.Banks {
+		banks = append(banks, bank)
+	}
+	sort.Ints(banks)
+	for _, bank := range banks {
+		add(tagBank, append([]byte{byte(bank)}, h.Banks[bank]...))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if size := fixedSize + len(sections); size > maxSize {
+		return nil, fmt.Errorf("header is %d bytes long, the maximum is %d", size, maxSize)
+	}
+
+	out := append([]byte{}, magic...)
+	out = append(out, byte(version))
+	out = appendInt(out, fixedSize+len(sections))
+	return append(out, sections...), nil
+}
+
+// Decode splits the given program into its header and bytecode.
+// A program without a header gets an empty one.
+func Decode(data []byte) (*Header, []byte, error) {
+	h := New()
+
+	if len(data) < len(magic) || string(data[:len(magic)]) != string(magic) {
+		h.Version = 0
+		return h, data, nil
+	}
+
+	if len(data) < fixedSize {
+		return nil, nil, errors.New("truncated header")
+	}
+
+	h.Version = int(data[3])
+	if h.Version > Version {
+		return nil, nil, fmt.Errorf("unsupported header version %d, the newest supported is %d", h.Version, Version)
+	}
+
+	size := readInt(data[4:])
+	if size < fixedSize || size > len(data) {
+		return nil, nil, fmt.Errorf("invalid header size %d", size)
+	}
+
+	sections := data[fixedSize:size]
+	for len(sections) > 0 {
+		if len(sections) < 3 {
+			return nil, nil, errors.New("truncated header section")
+		}
+
+		tag := sections[0]
+		length := readInt(sections[1:])
+		if len(sections) < 3+length {
+			return nil, nil, fmt.Errorf("truncated header section 0x%02x", tag)
+		}
+		payload := sections[3 : 3+length]
+		sections = sections[3+length:]
+
+		switch tag {
+		case tagEntry:
+			h.Entry = readInt(payload)
+		case tagCapabilities:
+			h.Capabilities = Capability(readInt(payload))
+		case tagMeta:
+			key, value, _ := strings.Cut(string(payload), "\x00")
+			h.Meta[key] = value
+		case tagWordSize:
+			h.WordSize = readInt(payload)
+		case tagRegisters:
+			h.Registers = readInt(payload)
+		case tagFeatures:
+			h.Features = Feature(readInt(payload))
+		case tagSymbol:
+			if len(payload) < 2 {
+				return nil, nil, errors.New("truncated symbol")
+			}
+			h.Symbols[string(payload[2:])] = readInt(payload)
+		case tagStrings:
+			h.Strings = append([]byte{}, payload...)
+		case tagBank:
+			if len(payload) < 1 || int(payload[0]) == 0 || int(payload[0]) >= NumBanks || len(payload)-1 > BankSize {
+				return nil, nil, errors.New("invalid memory bank")
+			}
+			if h.Banks == nil {
+				h.Banks = map[int][]byte{}
+			}
+			h.Banks[int(payload[0])] = append([]byte{}, payload[1:]...)
+		default:
+			// unknown sections are skipped, so newer
+			// optional information doesn't break older readers
+		}
+	}
+
+	return h, data[size:], nil
+}
+
+// CheckFeatures returns an error if the program uses features which
+// aren't supported by this runtime
+func (h *Header) CheckFeatures() error {
+	if unsupported := h.Features &^ SupportedFeatures; unsupported != 0 {
+		return fmt.Errorf("program requires features not supported by this runtime: %s", unsupported)
+	}
+	return nil
+}
+
+// appendInt appends a 16-bit number as a remainder and a quotient of 256
+func appendInt(b []byte, v int) []byte {
+	return append(b, byte(v%256), byte(v/256))
+}
+
+// readInt reads a 16-bit number written by appendInt
+func readInt(b []byte) int {
+	if len(b) < 2 {
+		return 0
+	}
+	return int(b[0]) + int(b[1])*256
+}
+
+// sortedKeys returns the keys of the given map in a stable order,
+// so the same program always produces the same header
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,47 @@
+package header
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Locate formats the given address, along with the closest label at or
+// before it when symbols are available (e.g. "0029 (check+0x21)").
+func Locate(symbols map[string]int, addr int) string {
+	label, offset, ok := ResolveSymbol(symbols, addr)
+	if !ok {
+		return fmt.Sprintf("%04x", addr)
+	}
+	if offset == 0 {
+		return fmt.Sprintf("%04x (%s)", addr, label)
+	}
+	return fmt.Sprintf("%04x (%s+0x%x)", addr, label, offset)
+}
+
+// ResolveSymbol finds the closest label at or before the given address.
+// If several labels share the same address the alphabetically first wins.
+func ResolveSymbol(symbols map[string]int, addr int) (string, int, bool) {
+	names := make([]string, 0, len(symbols))
+	for name := range symbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best := ""
+	found := false
+	for _, name := range names {
+		labelAddr := symbols[name]
+		if labelAddr > addr {
+			continue
+		}
+		if !found || labelAddr > symbols[best] {
+			best = name
+			found = true
+		}
+	}
+
+	if !found {
+		return "", 0, false
+	}
+	return best, addr - symbols[best], true
+}
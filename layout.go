@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"vm/cpu"
+)
+
+// layoutHelp documents -memory-layout in the help of run and execute
+const layoutHelp = `
+With -memory-layout a bar showing how the program used the 64 KiB of
+memory is printed once it ended, one character per KiB: # is code, +
+data written by the program, = the heap and s the stack in RAM, along
+with the number of bytes of each, the highest stack depth and the most
+heap bytes allocated at once.
+`
+
+// bytesPerCell is the number of bytes of memory shown by a character of
+// the bar of the memory layout
+const bytesPerCell = 1024
+
+// layoutBar draws the regions of memory as a bar, one character per
+// bytesPerCell. A character shows the first kind of region in the order
+// code, stack, heap and data reaching into its bytes.
+func layoutBar(l cpu.MemoryLayout) string {
+	cells := make([]byte, (cpu.MemSize+bytesPerCell-1)/bytesPerCell)
+	for i := range cells {
+		cells[i] = '.'
+	}
+
+	mark := func(r cpu.Region, c byte) {
+		if r.Size() <= 0 {
+			return
+		}
+		for i := r.Start / bytesPerCell; i <= (r.End-1)/bytesPerCell; i++ {
+			if cells[i] == '.' {
+				cells[i] = c
+			}
+		}
+	}
+	mark(l.Code, '#')
+	mark(l.Stack, 's')
+	mark(l.Heap, '=')
+	for _, r := range l.Data {
+		mark(r, '+')
+	}
+	return "[" + string(cells) + "]"
+}
+
+// printLayout prints the memory layout of the program in the file
+func printLayout(file string, l cpu.MemoryLayout) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: memory layout, one character per KiB\n", file)
+	fmt.Fprintf(&sb, "  %s\n", layoutBar(l))
+	fmt.Fprintf(&sb, "  # code   %6d bytes%s\n", l.Code.Size(), regionAt(" at", l.Code))
+	fmt.Fprintf(&sb, "  + data   %6d bytes written\n", l.Written)
+	fmt.Fprintf(&sb, "  = heap   %6d bytes allocated at most%s\n", l.HeapPeak, regionAt(", within", l.Heap))
+	fmt.Fprintf(&sb, "  s stack  %6d entries at most%s, %d nested calls", l.StackDepth, regionAt(", within", l.Stack), l.CallDepth)
+	infof("%s", sb.String())
+}
+
+// regionAt describes where a region is, after the given preposition,
+// if it isn't empty
+func regionAt(preposition string, r cpu.Region) string {
+	if r.Size() <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s %04x-%04x", preposition, r.Start, r.End-1)
+}
@@ -1,32 +1,78 @@
 package lexer
 
-import "vm/token"
+import (
+	"bufio"
+	"io"
+	"strings"
+	"vm/token"
+)
 
-// Lexer is a lexer for VM
+// Lexer is a lexer for VM.
+//
+// It reads its input incrementally from an io.Reader, so partial input
+// and very large sources can be tokenized without holding the whole
+// input in memory.
 type Lexer struct {
-	pos        int    // current character position
-	nextPos    int    // next character position
-	char       rune   // current character
-	characters []rune // rune slice of input string
+	reader  *bufio.Reader
+	char    rune  // current character
+	next    rune  // next character, valid if peeked is set
+	peeked  bool  // whether the next character has been read already
+	line    int   // line of the current character, starting at 1
+	column  int   // column of the current character, starting at 1
+	err     error // first error reported by the reader, other than io.EOF
+	started bool  // whether the current character is the first one
 }
 
 // New creates a Lexer instance from string input
 func New(input string) *Lexer {
-	l := &Lexer{characters: []rune(input)}
+	return NewReader(strings.NewReader(input))
+}
+
+// NewReader creates a Lexer instance reading its input from r
+func NewReader(r io.Reader) *Lexer {
+	l := &Lexer{reader: bufio.NewReader(r), line: 1}
 	// prime the pump
 	l.readChar()
 	return l
 }
 
+// Err returns the first error reported by the reader, other than io.EOF.
+// A read error ends the token stream with EOF.
+func (l *Lexer) Err() error {
+	return l.err
+}
+
+// read reads a character from the reader, rune(0) marks the end of input
+func (l *Lexer) read() rune {
+	char, _, err := l.reader.ReadRune()
+	if err != nil {
+		if err != io.EOF && l.err == nil {
+			l.err = err
+		}
+		return rune(0)
+	}
+	return char
+}
+
 // readChar reads next character
 func (l *Lexer) readChar() {
-	if l.nextPos >= len(l.characters) {
-		l.char = rune(0)
+	// track the position of the new character
+	if !l.started {
+		l.started = true
+		l.column = 1
+	} else if l.char == '\n' {
+		l.line++
+		l.column = 1
+	} else if l.char != rune(0) {
+		l.column++
+	}
+
+	if l.peeked {
+		l.char = l.next
+		l.peeked = false
 	} else {
-		l.char = l.characters[l.nextPos]
+		l.char = l.read()
 	}
-	l.pos = l.nextPos
-	l.nextPos++
 }
 
 // NextToken reads the next token, skipping the white space
@@ -34,10 +80,18 @@ func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 	l.skipWhitespace()
 
+	tok.Line = l.line
+	tok.Column = l.column
+
 	// skip single-line comments unless they are immediately followed by a number,
-	// because the registers are "#N"
+	// because the registers are "#N", or are the pseudo-registers "#sp" and "#ip"
 	if l.char == '#' {
 		if !isDigit(l.peekChar()) {
+			if word := l.readIdentifier(); word == "#sp" || word == "#ip" {
+				tok.Type = token.IDENT
+				tok.Literal = word
+				return tok
+			}
 			l.skipComment()
 			return l.NextToken()
 		}
@@ -45,19 +99,30 @@ func (l *Lexer) NextToken() token.Token {
 
 	switch l.char {
 	case ',':
-		tok = newToken(token.COMMA, l.char)
+		tok.Type = token.COMMA
+		tok.Literal = string(l.char)
 	case '"':
 		tok.Type = token.STR
 		tok.Literal = l.readStr()
 	case ':':
 		tok.Type = token.LABEL
 		tok.Literal = l.readLabel()
+		return tok
 	case rune(0):
 		tok.Type = token.EOF
 		tok.Literal = ""
 	default:
 		if isDigit(l.char) {
-			return l.readDecimal()
+			tok.Type, tok.Literal = l.readDecimal()
+			return tok
+		}
+
+		// negative integers, e.g. "-5"
+		if l.char == '-' && isDigit(l.peekChar()) {
+			l.readChar()
+			tok.Type, tok.Literal = l.readDecimal()
+			tok.Literal = "-" + tok.Literal
+			return tok
 		}
 
 		tok.Literal = l.readIdentifier()
@@ -69,13 +134,6 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
-func newToken(typ token.Type, char rune) token.Token {
-	return token.Token{
-		Type:    typ,
-		Literal: string(char),
-	}
-}
-
 func (l *Lexer) skipWhitespace() {
 	for isWhiteSpace(l.char) {
 		l.readChar()
@@ -89,18 +147,19 @@ func (l *Lexer) skipComment() {
 }
 
 func (l *Lexer) peekChar() rune {
-	if l.nextPos >= len(l.characters) {
-		return rune(0)
+	if !l.peeked {
+		l.next = l.read()
+		l.peeked = true
 	}
-	return l.characters[l.nextPos]
+	return l.next
 }
 
 func (l *Lexer) readStr() string {
-	var str string
+	var sb strings.Builder
 
 	for {
 		l.readChar()
-		if l.char == '"' {
+		if l.char == '"' || l.char == rune(0) {
 			break
 		}
 
@@ -124,9 +183,9 @@ func (l *Lexer) readStr() string {
 				l.char = '\\'
 			}
 		}
-		str += string(l.char)
+		sb.WriteRune(l.char)
 	}
-	return str
+	return sb.String()
 }
 
 func (l *Lexer) readLabel() string {
@@ -134,40 +193,63 @@ func (l *Lexer) readLabel() string {
 }
 
 func (l *Lexer) readUntilWhitespace() string {
-	pos := l.pos
-	// 7 8 9 10 11
-	// : f o r  sp
+	var sb strings.Builder
 	for !isWhiteSpace(l.char) && l.char != rune(0) {
+		sb.WriteRune(l.char)
 		l.readChar()
 	}
-	return string(l.characters[pos:l.pos])
+	return sb.String()
 }
 
-func (l *Lexer) readDecimal() token.Token {
+func (l *Lexer) readDecimal() (token.Type, string) {
 	integer := l.readNumber()
 	if isWhiteSpace(l.char) || isEmpty(l.char) || l.char == ',' {
-		return token.Token{Type: token.INT, Literal: integer}
+		return token.INT, integer
+	}
+
+	// floating-point numbers, e.g. "1.5" or "2.5e-3"
+	if l.char == '.' && isDigit(l.peekChar()) && !strings.ContainsAny(integer, "xX") {
+		l.readChar()
+		fraction := l.readFraction()
+		if isWhiteSpace(l.char) || isEmpty(l.char) || l.char == ',' {
+			return token.FLOAT, integer + "." + fraction
+		}
+		integer += "." + fraction
 	}
 
 	illegalPart := l.readUntilWhitespace()
 
-	return token.Token{Type: token.ILLEGAL, Literal: integer + illegalPart}
+	return token.ILLEGAL, integer + illegalPart
 }
 
 func (l *Lexer) readNumber() string {
-	pos := l.pos
+	var sb strings.Builder
 	for isHexDigit(l.char) {
+		sb.WriteRune(l.char)
+		l.readChar()
+	}
+	return sb.String()
+}
+
+// readFraction reads the digits after the decimal point of a
+// floating-point number, including an exponent, e.g. "5e-3"
+func (l *Lexer) readFraction() string {
+	var sb strings.Builder
+	for isDigit(l.char) || l.char == 'e' || l.char == 'E' ||
+		((l.char == '-' || l.char == '+') && strings.HasSuffix(strings.ToLower(sb.String()), "e")) {
+		sb.WriteRune(l.char)
 		l.readChar()
 	}
-	return string(l.characters[pos:l.pos])
+	return sb.String()
 }
 
 func (l *Lexer) readIdentifier() string {
-	pos := l.pos
+	var sb strings.Builder
 	for isIdentifier(l.char) {
+		sb.WriteRune(l.char)
 		l.readChar()
 	}
-	return string(l.characters[pos:l.pos])
+	return sb.String()
 }
 
 // isWhiteSpace checks if a character is a whitespace
@@ -0,0 +1,74 @@
+package lexer
+
+import "vm/token"
+
+// Stream buffers the tokens of a Lexer, so a parser can look ahead by any
+// number of tokens and backtrack to a checkpoint.
+//
+// Tokens are only kept while they might be needed again, i.e. while they
+// haven't been read yet or a checkpoint before them is active.
+type Stream struct {
+	lexer  *Lexer
+	tokens []token.Token // buffered tokens
+	base   int           // index of tokens[0] in the whole stream
+	pos    int           // index of the next token in the whole stream
+	marks  int           // number of active checkpoints
+}
+
+// Checkpoint is a position in a Stream which can be returned to
+type Checkpoint struct {
+	pos int
+}
+
+// NewStream creates a Stream reading its tokens from l
+func NewStream(l *Lexer) *Stream {
+	return &Stream{lexer: l}
+}
+
+// fill buffers tokens until the token at index pos of the whole stream is available
+func (s *Stream) fill(pos int) {
+	for s.base+len(s.tokens) <= pos {
+		s.tokens = append(s.tokens, s.lexer.NextToken())
+	}
+}
+
+// NextToken returns the next token and advances the stream
+func (s *Stream) NextToken() token.Token {
+	s.fill(s.pos)
+	tok := s.tokens[s.pos-s.base]
+	s.pos++
+
+	// forget consumed tokens unless they can be rewound to
+	if s.marks == 0 {
+		s.tokens = s.tokens[s.pos-s.base:]
+		s.base = s.pos
+	}
+	return tok
+}
+
+// Peek returns the n-th upcoming token without advancing the stream,
+// where zero is the token NextToken would return
+func (s *Stream) Peek(n int) token.Token {
+	s.fill(s.pos + n)
+	return s.tokens[s.pos+n-s.base]
+}
+
+// Checkpoint marks the current position, so the stream can be rewound to
+// it. Every checkpoint must be ended by either Rewind or Release.
+func (s *Stream) Checkpoint() Checkpoint {
+	s.marks++
+	return Checkpoint{pos: s.pos}
+}
+
+// Rewind returns the stream to the given checkpoint and ends it
+func (s *Stream) Rewind(cp Checkpoint) {
+	s.pos = cp.pos
+	s.Release(cp)
+}
+
+// Release ends the given checkpoint without moving the stream
+func (s *Stream) Release(cp Checkpoint) {
+	if s.marks > 0 {
+		s.marks--
+	}
+}
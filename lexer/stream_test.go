@@ -0,0 +1,70 @@
+package lexer
+
+import "testing"
+
+// literals reads n tokens from s and returns their literals
+func literals(s *Stream, n int) []string {
+	var out []string
+	for i := 0; i < n; i++ {
+		out = append(out, s.NextToken().Literal)
+	}
+	return out
+}
+
+func expectLiterals(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestStreamPeek(t *testing.T) {
+	s := NewStream(New("inc #1\ndec #2\n"))
+
+	if tok := s.Peek(2); tok.Literal != "dec" {
+		t.Fatalf("Peek(2) = %q, want \"dec\"", tok.Literal)
+	}
+	expectLiterals(t, literals(s, 4), "inc", "#1", "dec", "#2")
+}
+
+func TestStreamNestedCheckpoints(t *testing.T) {
+	s := NewStream(New("a b c d e f"))
+
+	outer := s.Checkpoint()
+	expectLiterals(t, literals(s, 2), "a", "b")
+
+	inner := s.Checkpoint()
+	expectLiterals(t, literals(s, 2), "c", "d")
+	s.Rewind(inner)
+	expectLiterals(t, literals(s, 3), "c", "d", "e")
+
+	// the outer checkpoint is still active after the inner one ended
+	s.Rewind(outer)
+	expectLiterals(t, literals(s, 2), "a", "b")
+
+	released := s.Checkpoint()
+	expectLiterals(t, literals(s, 1), "c")
+	s.Release(released)
+	expectLiterals(t, literals(s, 1), "d")
+}
+
+func TestStreamForgetsConsumedTokens(t *testing.T) {
+	s := NewStream(New("a b c d"))
+
+	cp := s.Checkpoint()
+	literals(s, 2)
+	if len(s.tokens) != 2 {
+		t.Fatalf("%d tokens buffered while a checkpoint is active, want 2", len(s.tokens))
+	}
+	s.Release(cp)
+
+	literals(s, 1)
+	if len(s.tokens) != 0 {
+		t.Fatalf("%d tokens buffered without a checkpoint, want 0", len(s.tokens))
+	}
+}
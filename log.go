@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"io"
+	"os"
+)
+
+// Verbosity levels of the messages printed besides the output of a
+// subcommand, which are written to STDERR so they never mix with it.
+const (
+	// levelQuiet only prints errors, selected by -q
+	levelQuiet = iota
+
+	// levelInfo prints informational messages and warnings, e.g. the
+	// size of the generated bytecode
+	levelInfo
+
+	// levelVerbose prints what is being done, e.g. every file which is
+	// compiled or run, selected by -v
+	levelVerbose
+
+	// levelDebug prints details useful to debug a program, e.g. the
+	// header of every program which is loaded, selected by -vv
+	levelDebug
+)
+
+// verbosity is the level of the messages which are printed
+var verbosity = levelInfo
+
+// logOutput is where the messages are printed
+var logOutput io.Writer = os.Stderr
+
+// verbosityFlags registers the global -q, -v and -vv flags on f. The
+// returned function applies them once the flags are parsed.
+func verbosityFlags(f *flag.FlagSet) func() {
+	quiet := f.Bool("q", false, "only print errors")
+	verbose := f.Bool("v", false, "print what is being done")
+	debug := f.Bool("vv", false, "print details useful for debugging, implies -v")
+	subcommands.ImportantFlag("q")
+	subcommands.ImportantFlag("v")
+	subcommands.ImportantFlag("vv")
+
+	return func() {
+		switch {
+		case *debug:
+			verbosity = levelDebug
+		case *verbose:
+			verbosity = levelVerbose
+		case *quiet:
+			verbosity = levelQuiet
+		}
+	}
+}
+
+// logf prints a message if the verbosity is at least the given level
+func logf(level int, format string, args ...any) {
+	if verbosity >= level {
+		fmt.Fprintf(logOutput, format+"\n", args...)
+	}
+}
+
+// errorf prints an error whatever the verbosity. Like the other messages
+// it is written to STDERR, so it never mixes with the output of a program.
+func errorf(format string, args ...any) {
+	fmt.Fprintf(logOutput, format+"\n", args...)
+}
+
+// infof prints an informational message, unless -q is used
+func infof(format string, args ...any) {
+	logf(levelInfo, format, args...)
+}
+
+// verbosef prints a message about what is being done if -v is used
+func verbosef(format string, args ...any) {
+	logf(levelVerbose, format, args...)
+}
+
+// debugf prints a message useful for debugging if -vv is used
+func debugf(format string, args ...any) {
+	logf(levelDebug, format, args...)
+}
+
+// logWriter returns a writer printing messages at the given level,
+// discarding them at a lower verbosity, e.g. for compiler warnings
+func logWriter(level int) io.Writer {
+	if verbosity >= level {
+		return logOutput
+	}
+	return io.Discard
+}
@@ -3,21 +3,54 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"github.com/google/subcommands"
 	"os"
 )
 
 func main() {
+	explainExitCodes(subcommands.DefaultCommander)
+	explainConfig(subcommands.DefaultCommander)
+
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.CommandsCommand(), "")
-	subcommands.Register(&compileCmd{}, "")
-	subcommands.Register(&dumpCmd{}, "")
-	subcommands.Register(&executeCmd{}, "")
-	subcommands.Register(&runCmd{}, "")
-	subcommands.Register(&versionCmd{}, "")
+
+	cmds := []subcommands.Command{
+		&analyzeCmd{},
+		&buildCmd{},
+		&compatCmd{},
+		&compileCmd{},
+		&disasmCmd{},
+		&dumpCmd{},
+		&embedgenCmd{},
+		&examplesCmd{},
+		&executeCmd{},
+		&fuzzgenCmd{},
+		&gradeCmd{},
+		&infoCmd{},
+		&minimizeCmd{},
+		&runCmd{},
+		&statsCmd{},
+		&versionCmd{},
+	}
+	for _, cmd := range cmds {
+		subcommands.Register(configured{cmd}, "")
+	}
+
+	applyVerbosity := verbosityFlags(flag.CommandLine)
+
+	var err error
+	if settings, err = loadConfig(flag.CommandLine, cmds); err == nil {
+		err = settings.apply("", flag.CommandLine)
+	}
+	if err != nil {
+		fmt.Println("error reading configuration:", err)
+		os.Exit(int(subcommands.ExitUsageError))
+	}
 
 	flag.Parse()
+	applyVerbosity()
 	ctx := context.Background()
 	os.Exit(int(subcommands.Execute(ctx)))
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/google/subcommands"
+	"strings"
+	"time"
+	"vm/cpu"
+	"vm/header"
+)
+
+// matrixHelp documents -fault-on-overflow and -matrix in the help of run
+const matrixHelp = `
+Integers which don't fit in the word are clamped to its range, and INC
+and DEC wrap around. With -fault-on-overflow the program is stopped
+with an error instead, which catches results silently going wrong.
+-matrix runs every program both ways, each on a fresh CPU, and reports
+whether the output, the exit code or the error differ, helping to
+migrate programs to -fault-on-overflow. STDIN is read up front so both
+runs get the same input, and both draw the same random numbers, but
+programs reading the time need -clock to behave the same. The output of
+the program isn't printed.
+`
+
+// matrixModes are the modes -matrix runs a program in, the first one
+// being the one the others are compared to
+var matrixModes = []struct {
+	name            string
+	faultOnOverflow bool
+}{
+	{"permissive", false},
+	{"fault-on-overflow", true},
+}
+
+// matrixResult is the outcome of a single run of -matrix
+type matrixResult struct {
+	stdout, stderr string
+	exitCode       int
+	err            error
+}
+
+// String describes how the run ended
+func (m matrixResult) String() string {
+	end := fmt.Sprintf("exit code %d", m.exitCode)
+	if m.err != nil {
+		end = "error: " + m.err.Error()
+	}
+	return fmt.Sprintf("%d bytes of output, %s", len(m.stdout)+len(m.stderr), end)
+}
+
+// runMatrix runs the compiled program in every mode of matrixModes and
+// reports whether they differ, failing if they do
+func (r *runCmd) runMatrix(file string, h *header.Header, code []byte, allowed header.Capability, clock cpu.Clock, timer timerSpec, input []byte) subcommands.ExitStatus {
+	// every run draws the same random numbers
+	seed := r.seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	results := make([]matrixResult, len(matrixModes))
+	for i, mode := range matrixModes {
+		c := r.newCPU(allowed, clock)
+		c.SetFaultOnOverflow(mode.faultOnOverflow)
+		c.SetSeed(seed)
+		if status := r.load(c, file, h, code, true); status != subcommands.ExitSuccess {
+			return status
+		}
+
+		var stdout, stderr bytes.Buffer
+		c.STDIN = bufio.NewReader(bytes.NewReader(input))
+		c.STDOUT = bufio.NewWriter(&stdout)
+		c.STDERR = bufio.NewWriter(&stderr)
+
+		verbosef("running %s %s", file, mode.name)
+		stopTimer, err := startTimer(c, timer)
+		if err != nil {
+			errorf("error starting the timer: %s", err)
+			return subcommands.ExitFailure
+		}
+		cancel := limitTime(c, r.timeout)
+		err = c.Run()
+		cancel()
+		stopTimer()
+		c.STDOUT.Flush()
+		c.STDERR.Flush()
+
+		results[i] = matrixResult{stdout: stdout.String(), stderr: stderr.String(), exitCode: c.ExitCode(), err: err}
+	}
+
+	same := true
+	for _, result := range results[1:] {
+		same = same && sameResult(results[0], result)
+	}
+	if same {
+		fmt.Printf("%s: the runs agree, %s\n", file, results[0])
+		return subcommands.ExitSuccess
+	}
+
+	fmt.Printf("%s: the runs differ\n", file)
+	for i, mode := range matrixModes {
+		fmt.Printf("  %-18s %s\n", mode.name+":", results[i])
+	}
+	for i, result := range results[1:] {
+		if line, a, b, ok := firstDifference(results[0].stdout, result.stdout); ok {
+			fmt.Printf("  STDOUT of %s differs from line %d: %q and %q\n", matrixModes[i+1].name, line, a, b)
+		}
+		if line, a, b, ok := firstDifference(results[0].stderr, result.stderr); ok {
+			fmt.Printf("  STDERR of %s differs from line %d: %q and %q\n", matrixModes[i+1].name, line, a, b)
+		}
+	}
+	return subcommands.ExitFailure
+}
+
+// sameResult returns true if two runs behaved the same. Runs timing out
+// are the same regardless of where they were stopped.
+func sameResult(a, b matrixResult) bool {
+	timeout := errors.Is(a.err, cpu.ErrTimeout) && errors.Is(b.err, cpu.ErrTimeout)
+	if !timeout && ((a.err == nil) != (b.err == nil) || a.err != nil && a.err.Error() != b.err.Error()) {
+		return false
+	}
+	return a.stdout == b.stdout && a.stderr == b.stderr && a.exitCode == b.exitCode
+}
+
+// firstDifference returns the number, starting at 1, and the contents
+// of the first line which differs between two outputs
+func firstDifference(a, b string) (int, string, string, bool) {
+	if a == b {
+		return 0, "", "", false
+	}
+	linesA, linesB := strings.SplitAfter(a, "\n"), strings.SplitAfter(b, "\n")
+	for i := 0; ; i++ {
+		var lineA, lineB string
+		if i < len(linesA) {
+			lineA = linesA[i]
+		}
+		if i < len(linesB) {
+			lineB = linesB[i]
+		}
+		if lineA != lineB {
+			return i + 1, lineA, lineB, true
+		}
+	}
+}
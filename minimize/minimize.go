@@ -0,0 +1,105 @@
+// Package minimize reduces a failing program to a smaller one which
+// still fails the same way, using delta debugging: parts of the program
+// are removed as long as the failure persists, in smaller and smaller
+// parts, until no single line can be removed anymore.
+package minimize
+
+import "strings"
+
+// Test reports whether the program given as lines still fails
+type Test func(lines []string) bool
+
+// Lines returns the smallest subsequence of lines found for which test
+// still reports the failure, and the number of tests run. The lines
+// themselves are expected to fail.
+//
+// Blank lines and comments are removed first, in a single step. Then the
+// lines are split into n chunks, starting with two: if a chunk alone
+// fails it replaces the lines, otherwise if the lines without a chunk
+// fail those replace them. If neither is the case the chunks are halved,
+// until they are single lines.
+func Lines(lines []string, test Test) ([]string, int) {
+	m := &minimizer{test: test, seen: map[string]bool{}}
+
+	var code []string
+	for _, line := range lines {
+		text := strings.TrimSpace(line)
+		if text != "" && !strings.HasPrefix(text, "#") {
+			code = append(code, line)
+		}
+	}
+	if len(code) < len(lines) && m.fails(code) {
+		lines = code
+	}
+
+	n := 2
+	for len(lines) >= 2 {
+		chunks := split(lines, n)
+		reduced := false
+
+		for _, chunk := range chunks {
+			if m.fails(chunk) {
+				lines, n, reduced = chunk, 2, true
+				break
+			}
+		}
+		if !reduced && n > 2 {
+			for i := range chunks {
+				if rest := without(chunks, i); m.fails(rest) {
+					lines, n, reduced = rest, max(n-1, 2), true
+					break
+				}
+			}
+		}
+		if !reduced {
+			if n >= len(lines) {
+				break
+			}
+			n = min(n*2, len(lines))
+		}
+	}
+	return lines, m.tests
+}
+
+type minimizer struct {
+	test  Test
+	seen  map[string]bool // results of the programs tested before
+	tests int
+}
+
+// fails runs the test, unless the same program was tested before
+func (m *minimizer) fails(lines []string) bool {
+	key := strings.Join(lines, "\n")
+	if failed, ok := m.seen[key]; ok {
+		return failed
+	}
+	m.tests++
+	failed := m.test(lines)
+	m.seen[key] = failed
+	return failed
+}
+
+// split splits the lines into n chunks of nearly equal size
+func split(lines []string, n int) [][]string {
+	var chunks [][]string
+	start := 0
+	for i := 0; i < n; i++ {
+		end := start + (len(lines)-start)/(n-i)
+		if end > start {
+			chunks = append(chunks, lines[start:end])
+		}
+		start = end
+	}
+	return chunks
+}
+
+// without joins the chunks except the i-th one
+func without(chunks [][]string, i int) []string {
+	var lines []string
+	for j, chunk := range chunks {
+		if j != i {
+			lines = append(lines, chunk...)
+		}
+	}
+	return lines
+}
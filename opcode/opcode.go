@@ -17,6 +17,22 @@ var (
 	// INT_RAND generates a random number
 	INT_RAND = 0x04
 
+	// EXIT_IMM terminates the program with an exit code
+	EXIT_IMM = 0x05
+
+	// EXIT_REG terminates the program with the exit code in a register
+	EXIT_REG = 0x06
+
+	// RAND_RANGE generates a random number below the value of a register
+	RAND_RANGE = 0x07
+
+	// TIME stores the wall clock time, the elapsed time or the number of
+	// executed instructions in a register
+	TIME = 0x08
+
+	// READ_INT reads an integer from the console into a register
+	READ_INT = 0x09
+
 	// JMP is an unconditional jump
 	JMP = 0x10
 
@@ -26,6 +42,12 @@ var (
 	// JMP_NZ jumps if the Z-flag is NOT set
 	JMP_NZ = 0x12
 
+	// JMP_S jumps if the S-flag is set
+	JMP_S = 0x13
+
+	// JMP_NS jumps if the S-flag is NOT set
+	JMP_NS = 0x14
+
 	// ADD performs an addition operation against two registers
 	ADD = 0x20
 
@@ -53,6 +75,27 @@ var (
 	// XOR performs an XOR operation against two registers
 	XOR = 0x28
 
+	// MOD stores the remainder of the division of two registers
+	MOD = 0x29
+
+	// SHL shifts a register left by the number of bits in another register
+	SHL = 0x2a
+
+	// SHR shifts a register right by the number of bits in another register
+	SHR = 0x2b
+
+	// SHL_IMM shifts a register left by a constant number of bits
+	SHL_IMM = 0x2c
+
+	// SHR_IMM shifts a register right by a constant number of bits
+	SHR_IMM = 0x2d
+
+	// ADC adds two registers and the carry flag
+	ADC = 0x2e
+
+	// SBC subtracts a register and the carry flag from another register
+	SBC = 0x2f
+
 	// STR_STORE stores a string in a register
 	STR_STORE = 0x30
 
@@ -68,6 +111,24 @@ var (
 	// STR_TO_INT converts the given string register contents to an integer
 	STR_TO_INT = 0x34
 
+	// ORD stores the code of the first character of a string register in another register
+	ORD = 0x35
+
+	// CHR stores the single-character string of an integer register in another register
+	CHR = 0x36
+
+	// PRINT_ERR prints the string contents of a register to the error output
+	PRINT_ERR = 0x37
+
+	// SPLIT_COUNT stores the number of parts of a string split on a delimiter
+	SPLIT_COUNT = 0x38
+
+	// SPLIT_PART stores a single part of a string split on a delimiter
+	SPLIT_PART = 0x39
+
+	// PRINT_CHAR prints the character with the code in a register
+	PRINT_CHAR = 0x3a
+
 	// CMP_INT compares a register contents with a number
 	CMP_INT = 0x40
 
@@ -89,6 +150,21 @@ var (
 	// REG_STORE stores the contents of one register in another
 	REG_STORE = 0x51
 
+	// ABORT terminates the program with the error message stored in the given string register
+	ABORT = 0x52
+
+	// STR_POOL stores a string from the string pool in a register
+	STR_POOL = 0x53
+
+	// DUMP prints the registers, flags, IP and stack
+	DUMP = 0x54
+
+	// CMOV_Z copies one register into another if the Z-flag is set
+	CMOV_Z = 0x55
+
+	// CMOV_NZ copies one register into another if the Z-flag is NOT set
+	CMOV_NZ = 0x56
+
 	// PEEK reads from memory
 	PEEK = 0x60
 
@@ -98,6 +174,24 @@ var (
 	// MEM_CPY copies a region of RAM
 	MEM_CPY = 0x62
 
+	// PEEK16 reads a 16-bit little-endian word from memory
+	PEEK16 = 0x63
+
+	// POKE16 writes a 16-bit little-endian word to memory
+	POKE16 = 0x64
+
+	// PEEK_STR reads a NUL-terminated string from memory
+	PEEK_STR = 0x65
+
+	// POKE_STR writes a string to memory, followed by a NUL byte
+	POKE_STR = 0x66
+
+	// MEM_FIND searches a region of RAM for a byte or a string
+	MEM_FIND = 0x67
+
+	// BANK_SELECT switches the banked window of memory to another bank
+	BANK_SELECT = 0x68
+
 	// PUSH pushes the given register contents onto the stack
 	PUSH = 0x70
 
@@ -110,8 +204,119 @@ var (
 	// RET returns from a CALL
 	RET = 0x73
 
+	// CALL_REG calls the subroutine at the address in a register
+	CALL_REG = 0x74
+
+	// ENTER sets up a stack frame with room for local values
+	ENTER = 0x75
+
+	// LEAVE tears down the stack frame set up by ENTER
+	LEAVE = 0x76
+
+	// SP_GET stores the stack pointer in a register
+	SP_GET = 0x77
+
+	// SP_SET moves the stack pointer to the address in a register
+	SP_SET = 0x78
+
+	// LOAD_LOCAL stores a stack entry relative to the frame pointer in a register
+	LOAD_LOCAL = 0x79
+
+	// STORE_LOCAL stores a register in a stack entry relative to the frame pointer
+	STORE_LOCAL = 0x7a
+
 	// TRAP invokes a CPU trap
 	TRAP = 0x80
+
+	// ADD_IMM adds a constant to a register
+	ADD_IMM = 0x90
+
+	// SUB_IMM subtracts a constant from a register
+	SUB_IMM = 0x91
+
+	// MUL_IMM multiplies a register by a constant
+	MUL_IMM = 0x92
+
+	// DIV_IMM divides a register by a constant
+	DIV_IMM = 0x93
+
+	// MOD_IMM stores the remainder of the division of a register by a constant
+	MOD_IMM = 0x94
+
+	// AND_IMM performs a logical AND operation against a register and a constant
+	AND_IMM = 0x95
+
+	// OR_IMM performs a logical OR operation against a register and a constant
+	OR_IMM = 0x96
+
+	// XOR_IMM performs an XOR operation against a register and a constant
+	XOR_IMM = 0x97
+
+	// FLOAT_STORE stores a floating-point number in a register
+	FLOAT_STORE = 0xa0
+
+	// FLOAT_PRINT prints the floating-point contents of a register
+	FLOAT_PRINT = 0xa1
+
+	// FADD adds two floating-point registers
+	FADD = 0xa2
+
+	// FSUB subtracts two floating-point registers
+	FSUB = 0xa3
+
+	// FMUL multiplies two floating-point registers
+	FMUL = 0xa4
+
+	// FDIV divides two floating-point registers
+	FDIV = 0xa5
+
+	// IS_FLOAT tests if a register contains a floating-point number
+	IS_FLOAT = 0xa6
+
+	// INT_TO_FLOAT converts an integer register value to a floating-point number
+	INT_TO_FLOAT = 0xa7
+
+	// FLOAT_TO_INT converts a floating-point register value to an integer, truncating it
+	FLOAT_TO_INT = 0xa8
+
+	// MULH stores the high word of the product of two registers
+	MULH = 0xb0
+
+	// DIVMOD stores the quotient and the remainder of a division in two registers
+	DIVMOD = 0xb1
+
+	// ADD_PAIR adds the double words of two register pairs
+	ADD_PAIR = 0xb2
+
+	// SUB_PAIR subtracts the double words of two register pairs
+	SUB_PAIR = 0xb3
+
+	// MUL_PAIR multiplies the double words of two register pairs
+	MUL_PAIR = 0xb4
+
+	// DIV_PAIR divides the double words of two register pairs
+	DIV_PAIR = 0xb5
+
+	// HASH_NEW stores a new, empty hash in a register
+	HASH_NEW = 0xc0
+
+	// HASH_SET stores a value under a key of a hash
+	HASH_SET = 0xc1
+
+	// HASH_GET retrieves the value of a key of a hash
+	HASH_GET = 0xc2
+
+	// HASH_DEL removes a key from a hash
+	HASH_DEL = 0xc3
+
+	// HASH_KEYS stores the keys of a hash in a register
+	HASH_KEYS = 0xc4
+
+	// ALLOC allocates heap memory and stores its address in a register
+	ALLOC = 0xd0
+
+	// FREE releases heap memory allocated by ALLOC
+	FREE = 0xd1
 )
 
 // Opcode is a holder for a single instruction.
@@ -138,12 +343,26 @@ func (o *Opcode) String() string {
 		return "INT_TO_STR"
 	case INT_RAND:
 		return "INT_RAND"
+	case EXIT_IMM:
+		return "EXIT_IMM"
+	case EXIT_REG:
+		return "EXIT_REG"
+	case RAND_RANGE:
+		return "RAND_RANGE"
+	case TIME:
+		return "TIME"
+	case READ_INT:
+		return "READ_INT"
 	case JMP:
 		return "JMP"
 	case JMP_Z:
 		return "JMP_Z"
 	case JMP_NZ:
 		return "JMP_NZ"
+	case JMP_S:
+		return "JMP_S"
+	case JMP_NS:
+		return "JMP_NS"
 	case ADD:
 		return "ADD"
 	case SUB:
@@ -162,6 +381,20 @@ func (o *Opcode) String() string {
 		return "OR"
 	case XOR:
 		return "XOR"
+	case MOD:
+		return "MOD"
+	case ADC:
+		return "ADC"
+	case SBC:
+		return "SBC"
+	case SHL:
+		return "SHL"
+	case SHR:
+		return "SHR"
+	case SHL_IMM:
+		return "SHL_IMM"
+	case SHR_IMM:
+		return "SHR_IMM"
 	case STR_STORE:
 		return "STR_STORE"
 	case STR_PRINT:
@@ -172,6 +405,18 @@ func (o *Opcode) String() string {
 		return "SYSTEM"
 	case STR_TO_INT:
 		return "STR_TO_INT"
+	case ORD:
+		return "ORD"
+	case CHR:
+		return "CHR"
+	case PRINT_ERR:
+		return "PRINT_ERR"
+	case SPLIT_COUNT:
+		return "SPLIT_COUNT"
+	case SPLIT_PART:
+		return "SPLIT_PART"
+	case PRINT_CHAR:
+		return "PRINT_CHAR"
 	case CMP_REG:
 		return "CMP_REG"
 	case CMP_INT:
@@ -186,10 +431,32 @@ func (o *Opcode) String() string {
 		return "NOP"
 	case REG_STORE:
 		return "REG_STORE"
+	case ABORT:
+		return "ABORT"
+	case STR_POOL:
+		return "STR_POOL"
+	case DUMP:
+		return "DUMP"
+	case CMOV_Z:
+		return "CMOV_Z"
+	case CMOV_NZ:
+		return "CMOV_NZ"
 	case PEEK:
 		return "PEEK"
 	case POKE:
 		return "POKE"
+	case PEEK16:
+		return "PEEK16"
+	case POKE16:
+		return "POKE16"
+	case PEEK_STR:
+		return "PEEK_STR"
+	case POKE_STR:
+		return "POKE_STR"
+	case MEM_FIND:
+		return "MEM_FIND"
+	case BANK_SELECT:
+		return "BANK_SELECT"
 	case MEM_CPY:
 		return "MEM_CPY"
 	case PUSH:
@@ -198,10 +465,84 @@ func (o *Opcode) String() string {
 		return "POP"
 	case CALL:
 		return "CALL"
+	case CALL_REG:
+		return "CALL_REG"
+	case ENTER:
+		return "ENTER"
+	case LEAVE:
+		return "LEAVE"
+	case SP_GET:
+		return "SP_GET"
+	case SP_SET:
+		return "SP_SET"
+	case LOAD_LOCAL:
+		return "LOAD_LOCAL"
+	case STORE_LOCAL:
+		return "STORE_LOCAL"
 	case RET:
 		return "RET"
 	case TRAP:
 		return "TRAP"
+	case ADD_IMM:
+		return "ADD_IMM"
+	case SUB_IMM:
+		return "SUB_IMM"
+	case MUL_IMM:
+		return "MUL_IMM"
+	case DIV_IMM:
+		return "DIV_IMM"
+	case MOD_IMM:
+		return "MOD_IMM"
+	case AND_IMM:
+		return "AND_IMM"
+	case OR_IMM:
+		return "OR_IMM"
+	case XOR_IMM:
+		return "XOR_IMM"
+	case FLOAT_STORE:
+		return "FLOAT_STORE"
+	case FLOAT_PRINT:
+		return "FLOAT_PRINT"
+	case FADD:
+		return "FADD"
+	case FSUB:
+		return "FSUB"
+	case FMUL:
+		return "FMUL"
+	case FDIV:
+		return "FDIV"
+	case IS_FLOAT:
+		return "IS_FLOAT"
+	case INT_TO_FLOAT:
+		return "INT_TO_FLOAT"
+	case FLOAT_TO_INT:
+		return "FLOAT_TO_INT"
+	case MULH:
+		return "MULH"
+	case DIVMOD:
+		return "DIVMOD"
+	case ADD_PAIR:
+		return "ADD_PAIR"
+	case SUB_PAIR:
+		return "SUB_PAIR"
+	case MUL_PAIR:
+		return "MUL_PAIR"
+	case DIV_PAIR:
+		return "DIV_PAIR"
+	case HASH_NEW:
+		return "HASH_NEW"
+	case HASH_SET:
+		return "HASH_SET"
+	case HASH_GET:
+		return "HASH_GET"
+	case HASH_DEL:
+		return "HASH_DEL"
+	case HASH_KEYS:
+		return "HASH_KEYS"
+	case ALLOC:
+		return "ALLOC"
+	case FREE:
+		return "FREE"
 	default:
 		return "unknown opcode"
 	}
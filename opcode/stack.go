@@ -0,0 +1,81 @@
+package opcode
+
+// Opcodes of the alternative stack-machine instruction set.
+//
+// Programs using it keep all values on the stack instead of in registers.
+// The values overlap with the register instruction set, the program
+// header tells which one a program uses.
+var (
+	// STACK_EXIT terminates the program
+	STACK_EXIT = 0x00
+
+	// STACK_PUSH pushes a constant onto the stack
+	STACK_PUSH = 0x01
+
+	// STACK_POP discards the top of the stack
+	STACK_POP = 0x02
+
+	// STACK_DUP duplicates the top of the stack
+	STACK_DUP = 0x03
+
+	// STACK_SWAP swaps the two topmost values
+	STACK_SWAP = 0x04
+
+	// STACK_ADD replaces the two topmost values with their sum
+	STACK_ADD = 0x10
+
+	// STACK_SUB replaces the two topmost values with their difference
+	STACK_SUB = 0x11
+
+	// STACK_MUL replaces the two topmost values with their product
+	STACK_MUL = 0x12
+
+	// STACK_DIV replaces the two topmost values with their quotient
+	STACK_DIV = 0x13
+
+	// STACK_JMP is an unconditional jump
+	STACK_JMP = 0x20
+
+	// STACK_JMP_Z pops a value and jumps if it is zero
+	STACK_JMP_Z = 0x21
+
+	// STACK_JMP_NZ pops a value and jumps if it is NOT zero
+	STACK_JMP_NZ = 0x22
+
+	// STACK_PRINT pops a value and prints it as an integer
+	STACK_PRINT = 0x30
+)
+
+// StackName returns the name of an opcode of the stack-machine instruction set
+func StackName(instruction byte) string {
+	switch int(instruction) {
+	case STACK_EXIT:
+		return "STACK_EXIT"
+	case STACK_PUSH:
+		return "STACK_PUSH"
+	case STACK_POP:
+		return "STACK_POP"
+	case STACK_DUP:
+		return "STACK_DUP"
+	case STACK_SWAP:
+		return "STACK_SWAP"
+	case STACK_ADD:
+		return "STACK_ADD"
+	case STACK_SUB:
+		return "STACK_SUB"
+	case STACK_MUL:
+		return "STACK_MUL"
+	case STACK_DIV:
+		return "STACK_DIV"
+	case STACK_JMP:
+		return "STACK_JMP"
+	case STACK_JMP_Z:
+		return "STACK_JMP_Z"
+	case STACK_JMP_NZ:
+		return "STACK_JMP_NZ"
+	case STACK_PRINT:
+		return "STACK_PRINT"
+	default:
+		return "unknown opcode"
+	}
+}
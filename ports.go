@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"vm/cpu"
+)
+
+// portsHelp documents -ports in the usage of the commands running programs
+const portsHelp = `
+With -ports the program exchanges lines with other processes: every
+port number is connected to a named pipe or, prefixed by unix:, to a
+Unix socket, e.g. -ports 0=/tmp/vm.fifo,1=unix:/run/app.sock. Trap 8
+reads a line from the port in register #0, trap 9 writes the string in
+register #1 to it. A named pipe carries lines in one direction only, so
+use one for reading and another one for writing, and as it is kept open
+for writing too, reading it never reports that the other end closed it.
+Both traps require the file and net capabilities, see -allow.
+`
+
+// portSpec is a port given via -ports
+type portSpec struct {
+	num     int
+	network string
+	path    string
+}
+
+// parsePorts parses the value of -ports, e.g. "0=/tmp/vm.fifo,1=unix:/run/app.sock"
+func parsePorts(spec string) ([]portSpec, error) {
+	var ports []portSpec
+	if spec == "" {
+		return ports, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		num, target, ok := strings.Cut(pair, "=")
+		if !ok || target == "" {
+			return nil, fmt.Errorf("%q isn't of the form port=path", pair)
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid port number %q", num)
+		}
+		p := portSpec{num: n, path: target}
+		if path, ok := strings.CutPrefix(target, "unix:"); ok {
+			p.network, p.path = "unix", path
+		}
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
+
+// connectPorts opens the given ports and connects them to the program
+// running on c. The returned function closes them.
+func connectPorts(c *cpu.CPU, ports []portSpec) (func(), error) {
+	var conns []io.Closer
+	closeAll := func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+
+	for _, p := range ports {
+		var conn io.ReadWriteCloser
+		var err error
+		if p.network != "" {
+			conn, err = net.Dial(p.network, p.path)
+		} else {
+			// opening a named pipe for reading and writing doesn't
+			// block until the other end is opened
+			conn, err = os.OpenFile(p.path, os.O_RDWR, 0)
+		}
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		verbosef("connected port %d to %s", p.num, p.path)
+		conns = append(conns, conn)
+		c.ConnectPort(p.num, conn)
+	}
+	return closeAll, nil
+}
@@ -0,0 +1,76 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"vm/cpu"
+)
+
+// signalNames are the host signals which may be forwarded via -signals
+var signalNames = map[string]os.Signal{
+	"hup":  syscall.SIGHUP,
+	"usr1": syscall.SIGUSR1,
+	"usr2": syscall.SIGUSR2,
+}
+
+// parseSignals parses the value of -signals, e.g. "hup=0,usr1=1", which
+// maps host signals to interrupt vectors of the program
+func parseSignals(spec string) (map[os.Signal]int, error) {
+	vectors := map[os.Signal]int{}
+	if spec == "" {
+		return vectors, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q isn't of the form signal=vector", pair)
+		}
+		sig, ok := signalNames[strings.TrimPrefix(strings.ToLower(name), "sig")]
+		if !ok {
+			return nil, fmt.Errorf("unsupported signal %q, use hup, usr1 or usr2", name)
+		}
+		vector, err := strconv.Atoi(value)
+		if err != nil || vector < 0 || vector >= cpu.NumInterrupts {
+			return nil, fmt.Errorf("invalid interrupt vector %q, it must be between 0 and %d", value, cpu.NumInterrupts-1)
+		}
+		vectors[sig] = vector
+	}
+	return vectors, nil
+}
+
+// forwardSignals raises the mapped interrupt of the program running on c
+// whenever the host receives one of the given signals. The returned
+// function stops forwarding them.
+func forwardSignals(c *cpu.CPU, vectors map[os.Signal]int) func() {
+	if len(vectors) == 0 {
+		return func() {}
+	}
+
+	ch := make(chan os.Signal, 1)
+	for sig := range vectors {
+		signal.Notify(ch, sig)
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-ch:
+				debugf("received %s, raising interrupt %d", sig, vectors[sig])
+				c.Interrupt(vectors[sig])
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
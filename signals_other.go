@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"vm/cpu"
+)
+
+// parseSignals rejects -signals, as the host signals it forwards only
+// exist on Unix
+func parseSignals(spec string) (map[os.Signal]int, error) {
+	if spec != "" {
+		return nil, errors.New("forwarding signals is only supported on Unix")
+	}
+	return nil, nil
+}
+
+// forwardSignals does nothing, see parseSignals
+func forwardSignals(c *cpu.CPU, vectors map[os.Signal]int) func() {
+	return func() {}
+}
@@ -0,0 +1,125 @@
+// Package stats aggregates how a corpus of compiled programs uses the
+// instruction set: which opcodes and traps occur how often, and how
+// large the programs are. This guides which instructions are worth
+// optimizing, or combining into superinstructions.
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"vm/analysis"
+	"vm/disasm"
+	"vm/header"
+	"vm/opcode"
+)
+
+// Count is the number of uses of an opcode or a trap
+type Count struct {
+	// Uses is the number of instructions
+	Uses int
+
+	// Programs is the number of programs using it at least once
+	Programs int
+}
+
+// Stats are the statistics of a corpus of programs
+type Stats struct {
+	// Programs is the number of programs added
+	Programs int
+
+	// Size is the total size of the code of all programs in bytes, and
+	// MinSize and MaxSize the smallest and the largest program
+	Size, MinSize, MaxSize int
+
+	// Instructions is the total number of instructions
+	Instructions int
+
+	// Opcodes and Traps count the uses by opcode and trap number
+	Opcodes map[int]*Count
+	Traps   map[int]*Count
+}
+
+// New returns empty statistics
+func New() *Stats {
+	return &Stats{Opcodes: map[int]*Count{}, Traps: map[int]*Count{}}
+}
+
+// Add adds the instructions of the program to the statistics. The
+// instructions are the reachable ones if the program can be analyzed,
+// so embedded data isn't counted, or else the ones decoded from the
+// start until the first invalid one.
+// Only programs of the register instruction set are supported.
+func (s *Stats) Add(h *header.Header, code []byte) error {
+	if h.Features&header.FeatStackISA != 0 {
+		return fmt.Errorf("programs of the stack-machine instruction set aren't supported")
+	}
+
+	var instrs []disasm.Instruction
+	if report, err := analysis.Analyze(h, code); err == nil && !report.Incomplete {
+		instrs = report.Reachable
+	} else {
+		for addr := 0; addr < len(code); {
+			ins, err := disasm.Decode(code, addr, h.WordSize)
+			if err != nil {
+				break
+			}
+			instrs = append(instrs, ins)
+			addr += ins.Size
+		}
+	}
+
+	if s.Programs == 0 || len(code) < s.MinSize {
+		s.MinSize = len(code)
+	}
+	s.MaxSize = max(s.MaxSize, len(code))
+	s.Programs++
+	s.Size += len(code)
+	s.Instructions += len(instrs)
+
+	opcodes, traps := map[int]bool{}, map[int]bool{}
+	for _, ins := range instrs {
+		count(s.Opcodes, ins.Opcode, opcodes)
+		if ins.Opcode == opcode.TRAP {
+			count(s.Traps, ins.Imm, traps)
+		}
+	}
+	return nil
+}
+
+// count counts a use of key, and the program if it's the first use in it
+func count(counts map[int]*Count, key int, seen map[int]bool) {
+	c, ok := counts[key]
+	if !ok {
+		c = &Count{}
+		counts[key] = c
+	}
+	c.Uses++
+	if !seen[key] {
+		seen[key] = true
+		c.Programs++
+	}
+}
+
+// AverageSize returns the average size of the code of the programs
+func (s *Stats) AverageSize() float64 {
+	if s.Programs == 0 {
+		return 0
+	}
+	return float64(s.Size) / float64(s.Programs)
+}
+
+// ByUses returns the keys of the counts, the most used first
+func ByUses(counts map[int]*Count) []int {
+	keys := make([]int, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := counts[keys[i]], counts[keys[j]]
+		if a.Uses != b.Uses {
+			return a.Uses > b.Uses
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"vm/analysis"
+	"vm/header"
+)
+
+// strictTimeout limits the time programs run for with -strict, unless
+// -timeout is given
+const strictTimeout = 10 * time.Second
+
+// strictHelp documents -strict in the help of the subcommands having it
+var strictHelp = `
+-strict turns on every check at once: warnings, e.g. about undefined
+labels, fail the compilation, and the compiled program is verified by
+the analyzer before it is written or run. It is rejected if it contains
+instructions which always fail, e.g. truncated ones or divisions by
+zero, if it jumps outside of its code, or if it can't be analyzed at
+all, e.g. programs of the stack instruction set. run also stops the
+program after ` + strictTimeout.String() + ` unless -timeout is given.
+`
+
+// verify checks a compiled program for -strict, returning the first
+// problem found by the analyzer
+func verify(h *header.Header, code []byte) error {
+	report, err := analysis.Analyze(h, code)
+	if err != nil {
+		return fmt.Errorf("the program can't be verified: %w", err)
+	}
+	if report.Incomplete {
+		return errors.New("the program jumps outside of its code, which can't be verified")
+	}
+	for _, finding := range report.Findings {
+		if finding.Fails {
+			return fmt.Errorf("%s %s: %s",
+				header.Locate(h.Symbols, finding.Instruction.Addr), finding.Instruction, finding.Message)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+// Package taint tracks data which originates from outside of a program,
+// e.g. lines read from STDIN, while it runs, and reports when such data
+// reaches sensitive operations: the command of SYSTEM, or code memory
+// via POKE and MEM_CPY.
+//
+// Taint flows through registers, memory and the stack along with the
+// data. Implicit flows, e.g. a branch depending on tainted data, aren't
+// tracked.
+package taint
+
+import (
+	"fmt"
+	"vm/cpu"
+	"vm/disasm"
+	"vm/header"
+	"vm/opcode"
+)
+
+// Sources contains the traps whose results, returned in register #0,
+// come from outside of the program. Traps giving access to files or the
+// network are sources as well.
+var Sources = map[int]bool{
+	cpu.TrapReadString: true,
+	cpu.TrapPortRead:   true,
+}
+
+// Report describes tainted data reaching a sensitive operation
+type Report struct {
+	// IP is the address of the instruction
+	IP int
+
+	// Instruction is the instruction using the tainted data
+	Instruction disasm.Instruction
+
+	// Message describes how the data is used
+	Message string
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("%04x %s: %s", r.IP, r.Instruction, r.Message)
+}
+
+// Tracker is a cpu.Observer tracking tainted data
+type Tracker struct {
+	report func(Report)
+
+	regs  [header.MaxNumRegisters]bool
+	mem   [cpu.MemSize]bool
+	stack []bool
+
+	// banks keep the taint of the memory banks not selected
+	banks map[int][]bool
+
+	// pending is applied once the current instruction succeeded
+	pending func()
+}
+
+// New creates a tracker which passes every use of tainted data by a
+// sensitive operation to report
+func New(report func(Report)) *Tracker {
+	return &Tracker{report: report}
+}
+
+// Before checks the instruction at ip for uses of tainted data, and
+// determines how it propagates taint.
+func (t *Tracker) Before(c *cpu.CPU, ip int) error {
+	t.pending = nil
+	if c.StackISA() {
+		return nil
+	}
+
+	ins, err := disasm.Decode(c.Memory(), ip, c.WordSize())
+	if err != nil {
+		// the CPU reports invalid instructions itself
+		return nil
+	}
+	for _, n := range ins.Regs {
+		if n >= len(t.regs) {
+			return nil
+		}
+	}
+
+	r := ins.Regs
+	switch ins.Opcode {
+	case opcode.INT_STORE, opcode.STR_STORE, opcode.STR_POOL, opcode.INT_RAND, opcode.RAND_RANGE, opcode.TIME, opcode.FLOAT_STORE:
+		t.pending = func() { t.regs[r[0]] = false }
+
+	case opcode.READ_INT:
+		t.pending = t.set(r[0], true)
+
+	case opcode.REG_STORE, opcode.ORD, opcode.CHR:
+		t.pending = t.set(r[0], t.regs[r[1]])
+
+	case opcode.CMOV_Z, opcode.CMOV_NZ:
+		if c.Zero() == (ins.Opcode == opcode.CMOV_Z) {
+			t.pending = t.set(r[0], t.regs[r[1]])
+		}
+
+	case opcode.ADD, opcode.SUB, opcode.MUL, opcode.DIV, opcode.MOD, opcode.ADC, opcode.SBC,
+		opcode.AND, opcode.OR, opcode.XOR, opcode.SHL, opcode.SHR, opcode.CONCAT,
+		opcode.FADD, opcode.FSUB, opcode.FMUL, opcode.FDIV, opcode.MULH, opcode.SPLIT_COUNT:
+		t.pending = t.set(r[0], t.regs[r[1]] || t.regs[r[2]])
+
+	case opcode.ADD_PAIR, opcode.SUB_PAIR, opcode.MUL_PAIR, opcode.DIV_PAIR:
+		if max(r[0], r[1], r[2])+1 >= len(t.regs) {
+			// the CPU reports the missing register of the lower word
+			return nil
+		}
+		tainted := t.regs[r[1]] || t.regs[r[1]+1] || t.regs[r[2]] || t.regs[r[2]+1]
+		t.pending = func() { t.regs[r[0]], t.regs[r[0]+1] = tainted, tainted }
+
+	case opcode.SPLIT_PART:
+		t.pending = t.set(r[0], t.regs[r[0]] || t.regs[r[1]] || t.regs[r[2]] || t.regs[r[3]])
+
+	case opcode.HASH_NEW, opcode.ALLOC:
+		t.pending = t.set(r[0], false)
+
+	case opcode.HASH_SET:
+		// the hash is tainted as a whole by tainted keys or values
+		t.pending = t.set(r[0], t.regs[r[0]] || t.regs[r[1]] || t.regs[r[2]])
+
+	case opcode.HASH_GET:
+		t.pending = t.set(r[0], t.regs[r[1]] || t.regs[r[2]])
+
+	case opcode.HASH_KEYS:
+		t.pending = t.set(r[0], t.regs[r[1]])
+
+	case opcode.DIVMOD:
+		tainted := t.regs[r[2]] || t.regs[r[3]]
+		t.pending = func() { t.regs[r[0]], t.regs[r[1]] = tainted, tainted }
+
+	case opcode.SHL_IMM, opcode.SHR_IMM, opcode.ADD_IMM, opcode.SUB_IMM, opcode.MUL_IMM,
+		opcode.DIV_IMM, opcode.MOD_IMM, opcode.AND_IMM, opcode.OR_IMM, opcode.XOR_IMM:
+		t.pending = t.set(r[0], t.regs[r[1]])
+
+	case opcode.SYSTEM:
+		if t.regs[r[0]] {
+			t.emit(ip, ins, fmt.Sprintf("tainted command in #%d reaches SYSTEM", r[0]))
+		}
+
+	case opcode.PEEK:
+		addr := intReg(c, r[1])
+		if addr >= 0 && addr < cpu.MemSize {
+			t.pending = t.set(r[0], t.mem[addr] || t.regs[r[1]])
+		}
+
+	case opcode.POKE:
+		addr := intReg(c, r[1])
+		if addr < 0 || addr >= cpu.MemSize {
+			break
+		}
+		tainted := t.regs[r[0]]
+		if tainted && addr < c.CodeSize() {
+			t.emit(ip, ins, fmt.Sprintf("tainted value in #%d is written into the code at %04x", r[0], addr))
+		}
+		t.pending = func() { t.mem[addr] = tainted }
+
+	case opcode.PEEK16:
+		addr := intReg(c, r[1])
+		if addr >= 0 && addr+1 < cpu.MemSize {
+			t.pending = t.set(r[0], t.mem[addr] || t.mem[addr+1] || t.regs[r[1]])
+		}
+
+	case opcode.POKE16:
+		addr := intReg(c, r[1])
+		if addr < 0 || addr+1 >= cpu.MemSize {
+			break
+		}
+		tainted := t.regs[r[0]]
+		if tainted && addr < c.CodeSize() {
+			t.emit(ip, ins, fmt.Sprintf("tainted value in #%d is written into the code at %04x", r[0], addr))
+		}
+		t.pending = func() { t.mem[addr], t.mem[addr+1] = tainted, tainted }
+
+	case opcode.PEEK_STR:
+		addr := intReg(c, r[1])
+		if addr < 0 || addr >= cpu.MemSize {
+			break
+		}
+		tainted := t.regs[r[1]]
+		for i, mem := addr, c.Memory(); i < cpu.MemSize && mem[i] != 0; i++ {
+			tainted = tainted || t.mem[i]
+		}
+		t.pending = t.set(r[0], tainted)
+
+	case opcode.MEM_FIND:
+		// the address found depends on the searched memory
+		start, length := intReg(c, r[2]), intReg(c, r[3])
+		tainted := t.regs[r[0]] || t.regs[r[1]] || t.regs[r[2]] || t.regs[r[3]]
+		for i := max(start, 0); i < min(start+length, cpu.MemSize); i++ {
+			tainted = tainted || t.mem[i]
+		}
+		t.pending = t.set(r[0], tainted)
+
+	case opcode.POKE_STR:
+		addr := intReg(c, r[1])
+		reg, err := c.Reg(r[0])
+		if err != nil || addr < 0 {
+			break
+		}
+		str, err := reg.GetStr()
+		if err != nil || addr+len(str) >= cpu.MemSize {
+			break
+		}
+		tainted := t.regs[r[0]]
+		if tainted && addr < c.CodeSize() {
+			t.emit(ip, ins, fmt.Sprintf("tainted string in #%d is written into the code at %04x", r[0], addr))
+		}
+		t.pending = func() {
+			for i := addr; i <= addr+len(str); i++ {
+				t.mem[i] = tainted
+			}
+		}
+
+	case opcode.MEM_CPY:
+		t.memCpy(c, ip, ins)
+
+	case opcode.BANK_SELECT:
+		from, to := c.Bank(), intReg(c, r[0])
+		if to >= 0 && to < header.NumBanks && to != from {
+			t.pending = func() { t.selectBank(from, to) }
+		}
+
+	case opcode.PUSH:
+		tainted := t.regs[r[0]]
+		t.pending = func() { t.stack = append(t.stack, tainted) }
+
+	case opcode.POP:
+		t.pending = func() { t.regs[r[0]] = t.pop() }
+
+	case opcode.CALL, opcode.CALL_REG:
+		t.pending = func() { t.stack = append(t.stack, false) }
+
+	case opcode.RET:
+		t.pending = func() { t.pop() }
+
+	case opcode.ENTER:
+		// the frame pointer and the local values aren't tainted
+		t.pending = func() {
+			t.stack = append(t.stack, make([]bool, 1+ins.Imm)...)
+		}
+
+	case opcode.LEAVE:
+		fp := c.FP()
+		t.pending = func() {
+			t.stack = t.stack[:min(fp, len(t.stack))]
+			t.pop()
+		}
+
+	case opcode.LOAD_LOCAL:
+		if i := c.FP() + ins.Imm; c.FP() > 0 && i >= 0 && i < len(t.stack) {
+			t.pending = t.set(r[0], t.stack[i])
+		}
+
+	case opcode.STORE_LOCAL:
+		if i := c.FP() + ins.Imm; c.FP() > 0 && i >= 0 && i < len(t.stack) {
+			tainted := t.regs[r[0]]
+			t.pending = func() { t.stack[i] = tainted }
+		}
+
+	case opcode.SP_GET:
+		t.pending = t.set(r[0], false)
+
+	case opcode.SP_SET:
+		// entries uncovered by moving the stack pointer aren't tainted
+		t.pending = func() {
+			size := c.StackSize()
+			for len(t.stack) < size {
+				t.stack = append(t.stack, false)
+			}
+			t.stack = t.stack[:size]
+		}
+
+	case opcode.TRAP:
+		if Sources[ins.Imm] || header.TrapCapabilities[ins.Imm]&(header.CapFile|header.CapNet) != 0 {
+			t.pending = func() { t.regs[0] = true }
+		}
+	}
+	return nil
+}
+
+// After applies the taint propagation of the executed instruction
+func (t *Tracker) After(c *cpu.CPU, ip int) error {
+	if t.pending != nil {
+		t.pending()
+		t.pending = nil
+	}
+	return nil
+}
+
+// memCpy checks and propagates the taint of the copied bytes
+func (t *Tracker) memCpy(c *cpu.CPU, ip int, ins disasm.Instruction) {
+	dst, src, length := intReg(c, ins.Regs[0]), intReg(c, ins.Regs[1]), intReg(c, ins.Regs[2])
+	if dst < 0 || src < 0 || length < 0 {
+		return
+	}
+
+	// copy the taint byte by byte like the CPU does, wrapping around at
+	// the end of memory, so overlapping ranges propagate the same way
+	written := map[int]bool{}
+	taintAt := func(addr int) bool {
+		if tainted, ok := written[addr]; ok {
+			return tainted
+		}
+		return t.mem[addr]
+	}
+
+	reported := false
+	for i := 0; i < length; i++ {
+		if dst >= cpu.MemSize {
+			dst = 0
+		}
+		if src >= cpu.MemSize {
+			src = 0
+		}
+		tainted := taintAt(src)
+		if tainted && dst < c.CodeSize() && !reported {
+			t.emit(ip, ins, fmt.Sprintf("tainted data at %04x is copied into the code at %04x", src, dst))
+			reported = true
+		}
+		written[dst] = tainted
+		dst++
+		src++
+	}
+
+	t.pending = func() {
+		for addr, tainted := range written {
+			t.mem[addr] = tainted
+		}
+	}
+}
+
+// set returns a function setting the taint of a register
+func (t *Tracker) set(reg int, tainted bool) func() {
+	return func() { t.regs[reg] = tainted }
+}
+
+// pop pops the taint of the topmost stack entry
+func (t *Tracker) pop() bool {
+	if len(t.stack) == 0 {
+		return false
+	}
+	tainted := t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+	return tainted
+}
+
+func (t *Tracker) emit(ip int, ins disasm.Instruction, msg string) {
+	if t.report != nil {
+		t.report(Report{IP: ip, Instruction: ins, Message: msg})
+	}
+}
+
+// intReg returns the integer in the given register, or -1 if it doesn't
+// contain one, in which case the instruction fails anyway
+func intReg(c *cpu.CPU, n int) int {
+	reg, err := c.Reg(n)
+	if err != nil {
+		return -1
+	}
+	v, err := reg.GetInt()
+	if err != nil {
+		return -1
+	}
+	return v
+}
+
+// selectBank swaps the taint of the banked window of memory like the CPU
+// swaps its contents
+func (t *Tracker) selectBank(from, to int) {
+	window := t.mem[header.BankStart : header.BankStart+header.BankSize]
+	if t.banks == nil {
+		t.banks = map[int][]bool{}
+	}
+	t.banks[from] = append(t.banks[from][:0], window...)
+	if saved, ok := t.banks[to]; ok {
+		copy(window, saved)
+	} else {
+		clear(window)
+	}
+}
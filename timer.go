@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"vm/cpu"
+)
+
+// timerHelp documents -timer in the usage of the commands running programs
+const timerHelp = `
+With -timer an interrupt of the program is raised periodically, e.g.
+-timer 0=1000 every 1000 executed instructions, or -timer 0=10ms every
+10 milliseconds, whose handler is registered via trap 7 like with
+-signals. This lets programs schedule tasks or watch their progress.
+Counting instructions makes runs repeatable, unlike the wall time.
+`
+
+// timerSpec is the timer given via -timer, disabled if both periods are
+// zero
+type timerSpec struct {
+	vector       int
+	instructions int
+	interval     time.Duration
+}
+
+// parseTimer parses the value of -timer, e.g. "0=1000" or "0=10ms",
+// which maps an interrupt vector to a number of instructions or a time
+func parseTimer(spec string) (timerSpec, error) {
+	if spec == "" {
+		return timerSpec{}, nil
+	}
+	vector, period, ok := strings.Cut(spec, "=")
+	if !ok {
+		return timerSpec{}, fmt.Errorf("%q isn't of the form vector=period", spec)
+	}
+	t := timerSpec{}
+	var err error
+	t.vector, err = strconv.Atoi(vector)
+	if err != nil || t.vector < 0 || t.vector >= cpu.NumInterrupts {
+		return timerSpec{}, fmt.Errorf("invalid interrupt vector %q, it must be between 0 and %d", vector, cpu.NumInterrupts-1)
+	}
+	if n, err := strconv.Atoi(period); err == nil {
+		if n <= 0 {
+			return timerSpec{}, fmt.Errorf("invalid number of instructions %q", period)
+		}
+		t.instructions = n
+		return t, nil
+	}
+	t.interval, err = time.ParseDuration(period)
+	if err != nil || t.interval <= 0 {
+		return timerSpec{}, fmt.Errorf("invalid period %q, it must be a number of instructions or a duration", period)
+	}
+	return t, nil
+}
+
+// startTimer sets up the given timer on c. The returned function stops
+// a timer counting the wall time.
+func startTimer(c *cpu.CPU, t timerSpec) (func(), error) {
+	if t.interval > 0 {
+		return c.StartTimer(t.vector, t.interval)
+	}
+	return func() {}, c.SetTimer(t.vector, t.instructions)
+}
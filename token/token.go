@@ -7,6 +7,10 @@ type Type string
 type Token struct {
 	Type    Type
 	Literal string
+
+	// position of the first character of the token, starting at 1
+	Line   int
+	Column int
 }
 
 // pre-defined types
@@ -16,6 +20,7 @@ const (
 	LABEL   = "LABEL"
 	EOF     = "EOF"
 	INT     = "INT"
+	FLOAT   = "FLOAT"
 	ILLEGAL = "ILLEGAL"
 	IDENT   = "IDENT"
 
@@ -29,46 +34,119 @@ const (
 	AND = "AND"
 	OR  = "OR"
 	XOR = "XOR"
+	MOD = "MOD"
+	ADC = "ADC"
+	SBC = "SBC"
+	SHL = "SHL"
+	SHR = "SHR"
+
+	// wide math
+	MULH     = "MULH"
+	DIVMOD   = "DIVMOD"
+	ADD_PAIR = "ADD_PAIR"
+	SUB_PAIR = "SUB_PAIR"
+	MUL_PAIR = "MUL_PAIR"
+	DIV_PAIR = "DIV_PAIR"
+
+	// hashes
+	HASH_NEW  = "HASH_NEW"
+	HASH_SET  = "HASH_SET"
+	HASH_GET  = "HASH_GET"
+	HASH_DEL  = "HASH_DEL"
+	HASH_KEYS = "HASH_KEYS"
+
+	// heap
+	ALLOC = "ALLOC"
+	FREE  = "FREE"
+
+	// floating-point math
+	FADD = "FADD"
+	FSUB = "FSUB"
+	FMUL = "FMUL"
+	FDIV = "FDIV"
 
 	// control flow
 	CALL   = "CALL"
 	RET    = "RET"
+	ENTER  = "ENTER"
+	LEAVE  = "LEAVE"
 	JMP    = "JMP"
 	JMP_Z  = "JMP_Z"
 	JMP_NZ = "JMP_NZ"
+	JMP_S  = "JMP_S"
+	JMP_NS = "JMP_NS"
 
 	// stack
-	PUSH = "PUSH"
-	POP  = "POP"
+	PUSH   = "PUSH"
+	POP    = "POP"
+	DUP    = "DUP"
+	SWAP   = "SWAP"
+	SP_GET = "SP_GET"
+	SP_SET = "SP_SET"
+
+	LOAD_LOCAL  = "LOAD_LOCAL"
+	STORE_LOCAL = "STORE_LOCAL"
 
 	// types
 	IS_INT     = "IS_INT"
 	IS_STR     = "IS_STR"
 	INT_TO_STR = "INT_TO_STR"
 	STR_TO_INT = "STR_TO_INT"
+	ORD        = "ORD"
+	CHR        = "CHR"
+
+	IS_FLOAT     = "IS_FLOAT"
+	INT_TO_FLOAT = "INT_TO_FLOAT"
+	FLOAT_TO_INT = "FLOAT_TO_INT"
 
 	// compare
 	CMP = "CMP"
 
 	// store
-	STORE = "STORE"
+	STORE   = "STORE"
+	CMOV_Z  = "CMOV_Z"
+	CMOV_NZ = "CMOV_NZ"
 
-	PRINT_INT = "PRINT_INT"
-	PRINT_STR = "PRINT_STR"
+	PRINT_INT   = "PRINT_INT"
+	PRINT_STR   = "PRINT_STR"
+	PRINT_FLOAT = "PRINT_FLOAT"
+	PRINT_ERR   = "PRINT_ERR"
+	PRINT_CHAR  = "PRINT_CHAR"
 
 	// memory
-	PEEK = "PEEK"
-	POKE = "POKE"
+	PEEK   = "PEEK"
+	POKE   = "POKE"
+	PEEK16 = "PEEK16"
+	POKE16 = "POKE16"
+
+	PEEK_STR = "PEEK_STR"
+	POKE_STR = "POKE_STR"
+	MEM_FIND = "MEM_FIND"
+
+	BANK_SELECT = "BANK_SELECT"
+
+	// strings
+	SPLIT_COUNT = "SPLIT_COUNT"
+	SPLIT_PART  = "SPLIT_PART"
 
 	// misc
-	CONCAT  = "CONCAT"
-	DATA    = "DATA"
-	EXIT    = "EXIT"
-	MEM_CPY = "MEM_CPY"
-	NOP     = "NOP"
-	RAND    = "RAND"
-	SYSTEM  = "SYSTEM"
-	TRAP    = "TRAP"
+	ABORT    = "ABORT"
+	CONCAT   = "CONCAT"
+	DATA     = "DATA"
+	DUMP     = "DUMP"
+	EXIT     = "EXIT"
+	INCBIN   = "INCBIN"
+	MEM_CPY  = "MEM_CPY"
+	NOP      = "NOP"
+	RAND     = "RAND"
+	READ_INT = "READ_INT"
+	SYSTEM   = "SYSTEM"
+	TIME     = "TIME"
+	TRAP     = "TRAP"
+
+	// directives
+	META = "META"
+	BANK = "BANK"
 )
 
 // reserved keywords
@@ -83,46 +161,119 @@ var keywords = map[string]Type{
 	"and": AND,
 	"or":  OR,
 	"xor": XOR,
+	"mod": MOD,
+	"adc": ADC,
+	"sbc": SBC,
+	"shl": SHL,
+	"shr": SHR,
+
+	// wide math
+	"mulh":     MULH,
+	"divmod":   DIVMOD,
+	"add_pair": ADD_PAIR,
+	"sub_pair": SUB_PAIR,
+	"mul_pair": MUL_PAIR,
+	"div_pair": DIV_PAIR,
+
+	// hashes
+	"hash_new":  HASH_NEW,
+	"hash_set":  HASH_SET,
+	"hash_get":  HASH_GET,
+	"hash_del":  HASH_DEL,
+	"hash_keys": HASH_KEYS,
+
+	// heap
+	"alloc": ALLOC,
+	"free":  FREE,
+
+	// floating-point math
+	"fadd": FADD,
+	"fsub": FSUB,
+	"fmul": FMUL,
+	"fdiv": FDIV,
 
 	// control flow
 	"call":   CALL,
 	"ret":    RET,
+	"enter":  ENTER,
+	"leave":  LEAVE,
 	"jmp":    JMP,
 	"jmp_z":  JMP_Z,
 	"jmp_nz": JMP_NZ,
+	"jmp_s":  JMP_S,
+	"jmp_ns": JMP_NS,
 
 	// stack
-	"push": PUSH,
-	"pop":  POP,
+	"push":   PUSH,
+	"pop":    POP,
+	"dup":    DUP,
+	"swap":   SWAP,
+	"sp_get": SP_GET,
+	"sp_set": SP_SET,
+
+	"load_local":  LOAD_LOCAL,
+	"store_local": STORE_LOCAL,
 
 	// types
 	"is_int":     IS_INT,
 	"is_str":     IS_STR,
 	"int_to_str": INT_TO_STR,
 	"str_to_int": STR_TO_INT,
+	"ord":        ORD,
+	"chr":        CHR,
+
+	"is_float":     IS_FLOAT,
+	"int_to_float": INT_TO_FLOAT,
+	"float_to_int": FLOAT_TO_INT,
 
 	// compare
 	"cmp": CMP,
 
 	// store
-	"store": STORE,
+	"store":   STORE,
+	"cmov_z":  CMOV_Z,
+	"cmov_nz": CMOV_NZ,
 
-	"print_int": PRINT_INT,
-	"print_str": PRINT_STR,
+	"print_int":   PRINT_INT,
+	"print_str":   PRINT_STR,
+	"print_float": PRINT_FLOAT,
+	"print_err":   PRINT_ERR,
+	"print_char":  PRINT_CHAR,
 
 	// memory
-	"peek": PEEK,
-	"poke": POKE,
+	"peek":   PEEK,
+	"poke":   POKE,
+	"peek16": PEEK16,
+	"poke16": POKE16,
+
+	"peek_str": PEEK_STR,
+	"poke_str": POKE_STR,
+	"mem_find": MEM_FIND,
+
+	"bank_select": BANK_SELECT,
+
+	// strings
+	"split_count": SPLIT_COUNT,
+	"split_part":  SPLIT_PART,
 
 	// misc
-	"concat":  CONCAT,
-	"data":    DATA,
-	"exit":    EXIT,
-	"mem_cpy": MEM_CPY,
-	"nop":     NOP,
-	"rand":    RAND,
-	"system":  SYSTEM,
-	"trap":    TRAP,
+	"abort":    ABORT,
+	"concat":   CONCAT,
+	"data":     DATA,
+	"dump":     DUMP,
+	"exit":     EXIT,
+	"incbin":   INCBIN,
+	"mem_cpy":  MEM_CPY,
+	"nop":      NOP,
+	"rand":     RAND,
+	"read_int": READ_INT,
+	"system":   SYSTEM,
+	"time":     TIME,
+	"trap":     TRAP,
+
+	// directives
+	".meta": META,
+	".bank": BANK,
 }
 
 // LookupIdentifier determines whether identifier is a keyword nor not
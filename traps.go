@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"vm/cpu"
+)
+
+// trapProviderHelp documents -trap-provider in the usage of the commands
+// running programs
+const trapProviderHelp = `
+With -trap-provider the given command is started next to the program
+and implements additional traps, so they can be added without
+recompiling the VM, e.g. -trap-provider "./mytraps -v". It first writes
+the trap numbers it implements as a line of JSON, {"traps": [256]},
+then answers every call, which carries the values of all registers, with
+the registers to change. See cpu.TrapProvider for the protocol.
+`
+
+// startTrapProvider starts the given command and makes the traps it
+// implements available to the program running on c. The returned
+// function stops the command.
+func startTrapProvider(c *cpu.CPU, command string) (func(), error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return func() {}, nil
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	stop := func() {
+		// the provider is expected to exit once its input is closed
+		stdin.Close()
+		cmd.Wait()
+	}
+
+	p, err := cpu.NewTrapProvider(stdout, stdin)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("%s: %w", args[0], err)
+	}
+	verbosef("trap provider %s implements traps %v", args[0], p.Traps())
+	c.AddTrapProvider(p)
+	return stop, nil
+}
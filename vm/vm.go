@@ -0,0 +1,305 @@
+// Package vm is the embeddable interface of the virtual machine.
+//
+// Eval compiles and runs a program given as source in one call, which
+// is what playgrounds, editors, tests and documentation examples need:
+//
+//	stdout, _, _, err := vm.Eval(`store #1, "hi\n"
+//	print_str #1`, "")
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"vm/compiler"
+	"vm/cpu"
+	"vm/header"
+	"vm/lexer"
+)
+
+// Result describes how the evaluated program ended
+type Result struct {
+	// Compiled is set once the program compiled, so err is a runtime
+	// error if it isn't nil
+	Compiled bool
+
+	// Size is the number of bytes of the compiled program
+	Size int
+
+	// TimedOut is set if the program was stopped after the timeout
+	TimedOut bool
+
+	// Duration is the time the program ran
+	Duration time.Duration
+
+	// PeakMemory is the largest number of host bytes used by strings,
+	// hashes and the stacks of the program, see cpu.CPU.MemoryUsage
+	PeakMemory int
+
+	// OutputTruncated is set if the output exceeded the limit set via
+	// WithOutputLimit, so its end was replaced by cpu.TruncationMarker
+	OutputTruncated bool
+
+	// ExitCode is the exit code the program ended with, see
+	// cpu.CPU.ExitCode
+	ExitCode int
+}
+
+// config holds the settings changed by the options
+type config struct {
+	timeout   time.Duration
+	allowed   header.Capability
+	isa       string
+	wordSize  int
+	registers int
+	signed    bool
+	maxMemory int
+	maxOutput int
+	abort     bool
+	clock     cpu.Clock
+	vector    int
+	timer     int
+	seed      *int64
+	overflow  bool
+	protect   bool
+	devices   []device
+}
+
+// device is a memory-mapped device added via WithDevice
+type device struct {
+	start, size int
+	dev         cpu.Device
+}
+
+// Option changes a setting of Eval
+type Option func(*config)
+
+// WithTimeout limits the time the program may run, one second by default
+func WithTimeout(d time.Duration) Option {
+	return func(c *config) { c.timeout = d }
+}
+
+// WithCapabilities sets the sensitive features the program may use,
+// none by default
+func WithCapabilities(caps header.Capability) Option {
+	return func(c *config) { c.allowed = caps }
+}
+
+// WithISA selects the instruction set, "register" by default, see
+// compiler.Compiler.SetISA
+func WithISA(name string) Option {
+	return func(c *config) { c.isa = name }
+}
+
+// WithWordSize sets the size of the machine word in bits, 16 by default
+func WithWordSize(bits int) Option {
+	return func(c *config) { c.wordSize = bits }
+}
+
+// WithRegisters sets the number of registers of the CPU, 32 by default,
+// see cpu.CPU.SetNumRegisters
+func WithRegisters(n int) Option {
+	return func(c *config) { c.registers = n }
+}
+
+// WithSigned makes the registers hold signed integers
+func WithSigned(signed bool) Option {
+	return func(c *config) { c.signed = signed }
+}
+
+// WithClock makes the program read the time from the given clock, e.g.
+// a cpu.FakeClock to make INT_RAND and TIME deterministic, instead of the
+// clock of the host
+func WithClock(clock cpu.Clock) Option {
+	return func(c *config) { c.clock = clock }
+}
+
+// WithMemoryLimit limits the host memory used by strings, hashes and the
+// stacks to the given number of bytes, unlimited by default
+func WithMemoryLimit(bytes int) Option {
+	return func(c *config) { c.maxMemory = bytes }
+}
+
+// WithOutputLimit truncates the output of the program, stdout and
+// stderr together, after the given number of bytes, unlimited by
+// default. With abort the program is then stopped with
+// cpu.ErrOutputLimit, otherwise it keeps running without printing. See
+// Result.OutputTruncated.
+func WithOutputLimit(bytes int, abort bool) Option {
+	return func(c *config) { c.maxOutput, c.abort = bytes, abort }
+}
+
+// WithSeed seeds the random numbers of the program, so they are the
+// same on every run, see cpu.CPU.SetSeed
+func WithSeed(seed int64) Option {
+	return func(c *config) { c.seed = &seed }
+}
+
+// WithTimer raises the interrupt with the given vector every given
+// number of executed instructions, see cpu.CPU.SetTimer
+func WithTimer(vector, instructions int) Option {
+	return func(c *config) { c.vector, c.timer = vector, instructions }
+}
+
+// WithFaultOnOverflow stops the program with cpu.ErrOverflow once an
+// integer doesn't fit in the word, see cpu.CPU.SetFaultOnOverflow
+func WithFaultOnOverflow(enabled bool) Option {
+	return func(c *config) { c.overflow = enabled }
+}
+
+// WithReadOnlyCode stops the program with cpu.ErrReadOnly once it writes
+// into its own code, see cpu.ReadOnlyCode
+func WithReadOnlyCode(enabled bool) Option {
+	return func(c *config) { c.protect = enabled }
+}
+
+// WithDevice maps the device into the size bytes of memory starting at
+// the given address, inside of the window reserved for I/O, see
+// cpu.CPU.MapDevice
+func WithDevice(start, size int, d cpu.Device) Option {
+	return func(c *config) { c.devices = append(c.devices, device{start, size, d}) }
+}
+
+// Eval compiles the program src and runs it on a fresh CPU, reading
+// stdin through the input trap. It returns what the program printed,
+// and on stderr the warnings of the compiler followed by what it printed
+// via PRINT_ERR. err is the compile or
+// runtime error, see Result.Compiled.
+//
+// By default the program may run for one second and may not use any
+// capability, see the options to change this.
+func Eval(src string, stdin string, opts ...Option) (stdout, stderr string, result Result, err error) {
+	cfg := config{
+		timeout:   time.Second,
+		isa:       "register",
+		wordSize:  header.DefaultWordSize,
+		registers: header.DefaultNumRegisters,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	var warnings strings.Builder
+	comp := compiler.New(lexer.New(src))
+	comp.SetWarnings(&warnings)
+	if err = comp.SetISA(cfg.isa); err == nil {
+		if err = comp.SetWordSize(cfg.wordSize); err == nil {
+			if err = comp.SetNumRegisters(cfg.registers); err == nil {
+				err = comp.SetSigned(cfg.signed)
+			}
+		}
+	}
+	if err == nil {
+		err = comp.Compile()
+	}
+	if err != nil {
+		return "", warnings.String(), result, err
+	}
+	result.Compiled = true
+	result.Size = len(comp.Output())
+
+	stdout, err = run(cfg, comp.Header(), comp.Output(), comp.Labels(), stdin, &warnings, &result)
+	return stdout, warnings.String(), result, err
+}
+
+// Exec runs a compiled program, prefixed by its header as written by
+// compile or embedded by embedgen, on a fresh CPU like Eval, which
+// spares applications compiling their programs at runtime. The
+// instruction set and whether the registers are signed are taken from
+// the header rather than the options. stderr is what the program
+// printed via PRINT_ERR.
+func Exec(program []byte, stdin string, opts ...Option) (stdout, stderr string, result Result, err error) {
+	cfg := config{timeout: time.Second, registers: header.DefaultNumRegisters}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	h, code, err := header.Decode(program)
+	if err == nil {
+		err = h.CheckFeatures()
+	}
+	if err != nil {
+		return "", "", result, err
+	}
+	cfg.isa = "register"
+	if h.Features&header.FeatStackISA != 0 {
+		cfg.isa = "stack"
+	}
+	cfg.signed = h.Features&header.FeatSignedInts != 0
+	result.Compiled = true
+	result.Size = len(code)
+
+	var errOut strings.Builder
+	stdout, err = run(cfg, h, code, h.Symbols, stdin, &errOut, &result)
+	return stdout, errOut.String(), result, err
+}
+
+// run runs the compiled program on a fresh CPU, writing what it prints
+// via PRINT_ERR to stderr, and returns what it printed
+func run(cfg config, h *header.Header, code []byte, symbols map[string]int, stdin string, stderr *strings.Builder, result *Result) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	c := cpu.NewCPU()
+	c.SetAllowedCapabilities(cfg.allowed)
+	c.SetMemoryLimit(cfg.maxMemory)
+	c.SetOutputLimit(cfg.maxOutput, cfg.abort)
+	c.SetClock(cfg.clock)
+	c.SetFaultOnOverflow(cfg.overflow)
+	if cfg.seed != nil {
+		c.SetSeed(*cfg.seed)
+	}
+	c.SetContext(ctx)
+	if err := c.SetTimer(cfg.vector, cfg.timer); err != nil {
+		return "", err
+	}
+	for _, d := range cfg.devices {
+		if err := c.MapDevice(d.start, d.size, d.dev); err != nil {
+			return "", err
+		}
+	}
+	c.STDIN = bufio.NewReader(strings.NewReader(stdin))
+	c.STDOUT = bufio.NewWriter(&out)
+	c.STDERR = bufio.NewWriter(stderr)
+
+	if err := c.CheckCapabilities(h.Capabilities); err != nil {
+		return "", err
+	}
+	if err := c.SetWordSize(h.WordSize); err != nil {
+		return "", err
+	}
+	if err := c.SetNumRegisters(cfg.registers); err != nil {
+		return "", err
+	}
+	if err := c.CheckRegisters(h.Registers); err != nil {
+		return "", err
+	}
+	c.SetStackISA(cfg.isa == "stack")
+	c.SetSigned(cfg.signed)
+	c.SetRAMStack(h.Features&header.FeatRAMStack != 0)
+	var load []cpu.LoadOption
+	if cfg.protect {
+		load = append(load, cpu.ReadOnlyCode())
+	}
+	if err := c.LoadBytes(code, load...); err != nil {
+		return "", err
+	}
+	c.SetSymbols(symbols)
+	c.SetStringPool(h.Strings)
+	c.SetBanks(h.Banks)
+
+	start := time.Now()
+	err := c.Run()
+	result.Duration = time.Since(start)
+	result.TimedOut = errors.Is(err, cpu.ErrTimeout)
+	result.PeakMemory = c.PeakMemoryUsage()
+	result.OutputTruncated = c.OutputTruncated()
+	result.ExitCode = c.ExitCode()
+
+	c.STDOUT.Flush()
+	return out.String(), err
+}